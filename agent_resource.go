@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"github.com/Sirupsen/logrus"
 	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"strconv"
 )
@@ -15,15 +20,53 @@ import (
 const tokenBytes = 45
 
 type Agent struct {
-	AgentID         int       `json:"id"`
-	OwnerUserID     int       `json:"ownerUserId"`
-	Name            string    `json:"name" binding:"required"`
-	TokenIterations int       `json:"-"`
-	TokenSalt       []byte    `json:"-"`
-	TokenHash       []byte    `json:"-"`
-	Created         time.Time `json:"created"`
+	AgentID         int               `json:"id"`
+	OwnerUserID     int               `json:"ownerUserId"`
+	Name            string            `json:"name" binding:"required"`
+	TokenIterations int               `json:"-"`
+	TokenSalt       []byte            `json:"-"`
+	TokenHash       []byte            `json:"-"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Created         time.Time         `json:"created"`
+
+	// The agent is marked as offline if it has not been seen for OfflineAfter. Zero means it is never marked as offline.
+	LastSeen     *time.Time `json:"lastSeen,omitempty"`
+	OfflineAfter Interval   `json:"offlineAfter,omitempty"`
+	OfflineSince *time.Time `json:"offlineSince,omitempty"`
+
+	// The previous token is still accepted until PreviousTokenExpires, so that the agent can be updated with the new token.
+	PreviousTokenIterations int       `json:"-"`
+	PreviousTokenSalt       []byte    `json:"-"`
+	PreviousTokenHash       []byte    `json:"-"`
+	PreviousTokenExpires    time.Time `json:"-"`
 }
 
+// PatchAgent is the body of a PATCH request for an agent. Properties that are not present are left unchanged.
+type PatchAgent struct {
+	Name         *string            `json:"name"`
+	Metadata     *map[string]string `json:"metadata"`
+	OfflineAfter *Interval          `json:"offlineAfter"`
+}
+
+// AgentFilter restricts which agents are returned when listing agents. A Limit of zero means no limit.
+type AgentFilter struct {
+	Name   string
+	Limit  int
+	Offset int
+}
+
+const maxAgentNameLength = 100
+const defaultAgentsPageSize = 100
+const maxAgentsPageSize = 1000
+const maxTokenGracePeriod = 7 * 24 * time.Hour
+
+const (
+	deleteModeSoft    = "soft"
+	deleteModeCascade = "cascade"
+)
+
+var deleteModes = []string{deleteModeSoft, deleteModeCascade}
+
 func postAgent(r render.Render, agent Agent, db Database, user User, log *logrus.Entry) {
 	agent.Created = time.Now()
 	agent.OwnerUserID = user.UserID
@@ -74,11 +117,30 @@ func generateAgentToken() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func getAllAgents(r render.Render, db Database, log *logrus.Entry) {
-	agents, err := db.GetAllAgents()
+func getAllAgents(r render.Render, req *http.Request, db Database, user User, log *logrus.Entry) {
+	filter, all, ok := extractAgentListParameters(r, req)
+
+	if !ok {
+		return
+	}
+
+	var agents []Agent
+	var err error
+
+	if all {
+		if !user.IsAdmin {
+			log.Error("User is not an administrator and so cannot list all agents.")
+			r.Error(http.StatusForbidden)
+			return
+		}
+
+		agents, err = db.GetAllAgents(filter)
+	} else {
+		agents, err = db.GetAgentsForUser(user.UserID, filter)
+	}
 
 	if err != nil {
-		log.WithError(err).Error("Could not get all agents.")
+		log.WithError(err).Error("Could not get agents.")
 		r.Error(http.StatusInternalServerError)
 		return
 	}
@@ -86,6 +148,45 @@ func getAllAgents(r render.Render, db Database, log *logrus.Entry) {
 	r.JSON(http.StatusOK, agents)
 }
 
+func extractAgentListParameters(r render.Render, req *http.Request) (AgentFilter, bool, bool) {
+	query := req.URL.Query()
+	filter := AgentFilter{Name: query.Get("name"), Limit: defaultAgentsPageSize}
+	all := false
+
+	if rawAll := query.Get("all"); rawAll != "" {
+		var err error
+
+		if all, err = strconv.ParseBool(rawAll); err != nil {
+			r.Text(http.StatusBadRequest, "Parameter 'all' must be 'true' or 'false'.")
+			return AgentFilter{}, false, false
+		}
+	}
+
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+
+		if err != nil || limit < 1 || limit > maxAgentsPageSize {
+			r.Text(http.StatusBadRequest, fmt.Sprintf("Parameter 'limit' must be a number between 1 and %d.", maxAgentsPageSize))
+			return AgentFilter{}, false, false
+		}
+
+		filter.Limit = limit
+	}
+
+	if rawOffset := query.Get("offset"); rawOffset != "" {
+		offset, err := strconv.Atoi(rawOffset)
+
+		if err != nil || offset < 0 {
+			r.Text(http.StatusBadRequest, "Parameter 'offset' must be a non-negative number.")
+			return AgentFilter{}, false, false
+		}
+
+		filter.Offset = offset
+	}
+
+	return filter, all, true
+}
+
 func getAgent(r render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
 	if err := db.BeginTransaction(); err != nil {
 		log.WithError(err).Error("Could not begin database transaction.")
@@ -106,22 +207,52 @@ func getAgent(r render.Render, params martini.Params, db Database, user User, lo
 		Variables []Variable `json:"variables"`
 	}{}
 
+	if agent.Agent, ok = getOwnedAgent(agentID, r, db, user, log); !ok {
+		return
+	}
+
 	var err error
 
-	if agent.Agent, err = db.GetAgentByID(agentID); err != nil {
-		log.WithError(err).Error("Could not agent info.")
+	if agent.Variables, err = db.GetVariablesForAgent(agentID); err != nil {
+		log.WithError(err).Error("Could not get variables for agent.")
 		r.Error(http.StatusInternalServerError)
 		return
 	}
 
-	if agent.OwnerUserID != user.UserID {
-		log.Error("User does not own this agent.")
-		r.Error(http.StatusForbidden)
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
 		return
 	}
 
-	if agent.Variables, err = db.GetVariablesForAgent(agentID); err != nil {
-		log.WithError(err).Error("Could not get variables for agent.")
+	r.JSON(http.StatusOK, agent)
+}
+
+func patchAgent(r render.Render, patch PatchAgent, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractAgentID(params, r, db, log)
+
+	if !ok {
+		return
+	}
+
+	agent, ok := getOwnedAgent(agentID, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	patch.ApplyTo(&agent)
+
+	if err := db.UpdateAgent(agent); err != nil {
+		log.WithError(err).Error("Could not update agent.")
 		r.Error(http.StatusInternalServerError)
 		return
 	}
@@ -135,6 +266,128 @@ func getAgent(r render.Render, params martini.Params, db Database, user User, lo
 	r.JSON(http.StatusOK, agent)
 }
 
+func deleteAgent(r render.Render, req *http.Request, params martini.Params, db Database, user User, log *logrus.Entry) {
+	mode := req.URL.Query().Get("mode")
+
+	if mode == "" {
+		mode = deleteModeSoft
+	} else if !containsString(deleteModes, mode) {
+		r.Text(http.StatusBadRequest, fmt.Sprintf("Parameter 'mode' must be one of: %v.", strings.Join(deleteModes, ", ")))
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	var err error
+
+	if mode == deleteModeCascade {
+		err = db.DeleteAgent(agentID)
+	} else {
+		err = db.SoftDeleteAgent(agentID, time.Now())
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Could not delete agent.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.Status(http.StatusNoContent)
+}
+
+func rotateAgentToken(r render.Render, req *http.Request, params martini.Params, db Database, user User, log *logrus.Entry) {
+	gracePeriod := time.Duration(0)
+
+	if rawGracePeriod := req.URL.Query().Get("grace_period"); rawGracePeriod != "" {
+		var err error
+
+		if gracePeriod, err = parseInterval(rawGracePeriod); err != nil || gracePeriod < 0 {
+			r.Text(http.StatusBadRequest, "Cannot parse grace period value.")
+			return
+		}
+
+		if gracePeriod > maxTokenGracePeriod {
+			r.Text(http.StatusBadRequest, fmt.Sprintf("Grace period cannot be longer than %v.", maxTokenGracePeriod))
+			return
+		}
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractAgentID(params, r, db, log)
+
+	if !ok {
+		return
+	}
+
+	agent, ok := getOwnedAgent(agentID, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	token, err := generateAgentToken()
+
+	if err != nil {
+		log.WithError(err).Error("Could not generate agent token.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := agent.RotateToken(token, gracePeriod, time.Now()); err != nil {
+		log.WithError(err).Error("Could not set agent token.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.UpdateAgentToken(agent); err != nil {
+		log.WithError(err).Error("Could not save agent token.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	result := map[string]interface{}{
+		"id":    agent.AgentID,
+		"token": token,
+	}
+
+	if !agent.PreviousTokenExpires.IsZero() {
+		result["previousTokenExpires"] = agent.PreviousTokenExpires
+	}
+
+	r.JSON(http.StatusOK, result)
+}
+
 func extractAgentID(params martini.Params, r render.Render, db Database, log *logrus.Entry) (int, bool) {
 	rawAgentID := params["agent_id"]
 	agentID, err := strconv.Atoi(rawAgentID)
@@ -156,6 +409,40 @@ func extractAgentID(params martini.Params, r render.Render, db Database, log *lo
 	return agentID, true
 }
 
+// extractOwnedAgentID returns the ID of the agent in the request, after checking that it exists and that the user owns it.
+func extractOwnedAgentID(params martini.Params, r render.Render, db Database, user User, log *logrus.Entry) (int, bool) {
+	agentID, ok := extractAgentID(params, r, db, log)
+
+	if !ok {
+		return 0, false
+	}
+
+	if _, ok := getOwnedAgent(agentID, r, db, user, log); !ok {
+		return 0, false
+	}
+
+	return agentID, true
+}
+
+// getOwnedAgent returns the agent with the given ID, after checking that the user owns it.
+func getOwnedAgent(agentID int, r render.Render, db Database, user User, log *logrus.Entry) (Agent, bool) {
+	agent, err := db.GetAgentByID(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get agent info.")
+		r.Error(http.StatusInternalServerError)
+		return Agent{}, false
+	}
+
+	if agent.OwnerUserID != user.UserID {
+		log.Error("User does not own this agent.")
+		r.Error(http.StatusForbidden)
+		return Agent{}, false
+	}
+
+	return agent, true
+}
+
 func (agent *Agent) SetToken(token string) error {
 	var err error
 
@@ -172,3 +459,89 @@ func (agent *Agent) SetToken(token string) error {
 func (agent *Agent) ComputeTokenHash(token string) []byte {
 	return computePasswordHash(token, agent.TokenSalt, agent.TokenIterations)
 }
+
+// RotateToken replaces the agent's token. If gracePeriod is non-zero, the current token remains valid until
+// gracePeriod after now, otherwise it is invalidated immediately.
+func (agent *Agent) RotateToken(token string, gracePeriod time.Duration, now time.Time) error {
+	if gracePeriod > 0 {
+		agent.PreviousTokenIterations = agent.TokenIterations
+		agent.PreviousTokenSalt = agent.TokenSalt
+		agent.PreviousTokenHash = agent.TokenHash
+		agent.PreviousTokenExpires = now.Add(gracePeriod)
+	} else {
+		agent.PreviousTokenIterations = 0
+		agent.PreviousTokenSalt = []byte{}
+		agent.PreviousTokenHash = []byte{}
+		agent.PreviousTokenExpires = time.Time{}
+	}
+
+	return agent.SetToken(token)
+}
+
+// CheckToken returns true if token is the agent's current token, or its previous token and the grace period has not
+// yet expired.
+func (agent *Agent) CheckToken(token string, now time.Time) bool {
+	if subtle.ConstantTimeCompare(agent.ComputeTokenHash(token), agent.TokenHash) == 1 {
+		return true
+	}
+
+	if agent.PreviousTokenExpires.IsZero() || !now.Before(agent.PreviousTokenExpires) {
+		return false
+	}
+
+	previousHash := computePasswordHash(token, agent.PreviousTokenSalt, agent.PreviousTokenIterations)
+
+	return subtle.ConstantTimeCompare(previousHash, agent.PreviousTokenHash) == 1
+}
+
+func (patch PatchAgent) ApplyTo(agent *Agent) {
+	if patch.Name != nil {
+		agent.Name = *patch.Name
+	}
+
+	if patch.Metadata != nil {
+		agent.Metadata = *patch.Metadata
+	}
+
+	if patch.OfflineAfter != nil {
+		agent.OfflineAfter = *patch.OfflineAfter
+	}
+}
+
+func (patch PatchAgent) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	if patch.Name != nil && *patch.Name == "" {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"name"},
+			Classification: "InvalidValue",
+			Message:        "Name cannot be empty.",
+		})
+	} else if patch.Name != nil && utf8.RuneCountInString(*patch.Name) > maxAgentNameLength {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"name"},
+			Classification: "InvalidValue",
+			Message:        fmt.Sprintf("Name cannot be longer than %d characters.", maxAgentNameLength),
+		})
+	}
+
+	if patch.OfflineAfter != nil {
+		errors = validateOfflineAfter(*patch.OfflineAfter, errors)
+	}
+
+	return errors
+}
+
+func (agent Agent) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	return validateOfflineAfter(agent.OfflineAfter, errors)
+}
+
+func validateOfflineAfter(offlineAfter Interval, errors binding.Errors) binding.Errors {
+	if offlineAfter < 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"offlineAfter"},
+			Classification: "InvalidValue",
+			Message:        "Offline interval cannot be negative.",
+		})
+	}
+
+	return errors
+}
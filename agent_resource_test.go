@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"crypto/sha256"
@@ -66,6 +67,57 @@ var _ = Describe("Agent resource", func() {
 			})
 		})
 
+		Describe("RotateToken", func() {
+			now := time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)
+
+			It("keeps the previous token until the end of the grace period if one is given", func() {
+				agent := Agent{}
+				agent.SetToken("old")
+				oldHash := agent.TokenHash
+
+				agent.RotateToken("new", time.Hour, now)
+
+				Expect(agent.PreviousTokenHash).To(Equal(oldHash))
+				Expect(agent.PreviousTokenExpires).To(Equal(now.Add(time.Hour)))
+				Expect(agent.ComputeTokenHash("new")).To(Equal(agent.TokenHash))
+			})
+
+			It("discards the previous token if no grace period is given", func() {
+				agent := Agent{}
+				agent.SetToken("old")
+				agent.RotateToken("new", time.Hour, now)
+
+				agent.RotateToken("newer", 0, now)
+
+				Expect(agent.PreviousTokenHash).To(BeEmpty())
+				Expect(agent.PreviousTokenExpires.IsZero()).To(BeTrue())
+				Expect(agent.ComputeTokenHash("newer")).To(Equal(agent.TokenHash))
+			})
+		})
+
+		Describe("CheckToken", func() {
+			now := time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)
+			agent := Agent{}
+			agent.SetToken("old")
+			agent.RotateToken("new", time.Hour, now)
+
+			It("accepts the current token", func() {
+				Expect(agent.CheckToken("new", now.Add(2*time.Hour))).To(BeTrue())
+			})
+
+			It("accepts the previous token during the grace period", func() {
+				Expect(agent.CheckToken("old", now.Add(59*time.Minute))).To(BeTrue())
+			})
+
+			It("rejects the previous token after the grace period", func() {
+				Expect(agent.CheckToken("old", now.Add(time.Hour))).To(BeFalse())
+			})
+
+			It("rejects any other token", func() {
+				Expect(agent.CheckToken("other", now)).To(BeFalse())
+			})
+		})
+
 		It("can be serialised to JSON", func() {
 			agent := Agent{AgentID: 1039, Name: "Cool agent", OwnerUserID: 2456, Created: time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)}
 
@@ -84,6 +136,34 @@ var _ = Describe("Agent resource", func() {
 			Expect(agent).To(Equal(expectedAgent))
 		})
 
+		It("includes metadata when serialised to JSON if the agent has metadata", func() {
+			agent := Agent{AgentID: 1039, Name: "Cool agent", OwnerUserID: 2456, Metadata: map[string]string{"location": "Roof"}, Created: time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)}
+
+			bytes, err := json.Marshal(agent)
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(MatchJSON(`{"id":1039,"name":"Cool agent","ownerUserId":2456,"metadata":{"location":"Roof"},"created":"2015-03-26T14:35:00Z"}`))
+		})
+
+		It("includes when the agent was last seen and its offline status when serialised to JSON", func() {
+			lastSeen := time.Date(2015, 3, 27, 8, 0, 0, 0, time.UTC)
+			offlineSince := time.Date(2015, 3, 27, 9, 0, 0, 0, time.UTC)
+			agent := Agent{AgentID: 1039, Name: "Cool agent", OwnerUserID: 2456, Created: time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC),
+				LastSeen: &lastSeen, OfflineAfter: Interval(time.Hour), OfflineSince: &offlineSince}
+
+			bytes, err := json.Marshal(agent)
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(MatchJSON(`{"id":1039,"name":"Cool agent","ownerUserId":2456,"created":"2015-03-26T14:35:00Z",` +
+				`"lastSeen":"2015-03-27T08:00:00Z","offlineAfter":"1h0m0s","offlineSince":"2015-03-27T09:00:00Z"}`))
+		})
+
+		It("can be deserialised from JSON with an offline interval", func() {
+			var agent Agent
+			err := json.Unmarshal([]byte(`{"name":"Cool agent","offlineAfter":"2d"}`), &agent)
+
+			Expect(err).To(BeNil())
+			Expect(agent.OfflineAfter).To(Equal(Interval(48 * time.Hour)))
+		})
+
 		Describe("validation", func() {
 			It("succeeds if all required properties are set", func() {
 				errors := TestValidation(`{"name": "Test Agent"}`, Agent{})
@@ -99,6 +179,68 @@ var _ = Describe("Agent resource", func() {
 				Entry("because the name property is not present", `{}`, "name"),
 				Entry("because the name property is empty", `{"name": ""}`, "name"),
 			)
+
+			It("fails if the offline interval is negative", func() {
+				errors := TestValidation(`{"name": "Test Agent", "offlineAfter": "-1h"}`, Agent{})
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[0].FieldNames).To(Equal([]string{"offlineAfter"}))
+				Expect(errors[0].Classification).To(Equal("InvalidValue"))
+			})
+		})
+	})
+
+	Describe("patch data structure", func() {
+		Describe("ApplyTo", func() {
+			It("changes only the properties present in the patch", func() {
+				name := "New name"
+				agent := Agent{AgentID: 1039, Name: "Old name", Metadata: map[string]string{"location": "Roof"}}
+
+				PatchAgent{Name: &name}.ApplyTo(&agent)
+
+				Expect(agent).To(Equal(Agent{AgentID: 1039, Name: "New name", Metadata: map[string]string{"location": "Roof"}}))
+			})
+
+			It("replaces the metadata if it is present in the patch", func() {
+				metadata := map[string]string{"model": "v2"}
+				agent := Agent{AgentID: 1039, Name: "Old name", Metadata: map[string]string{"location": "Roof"}}
+
+				PatchAgent{Metadata: &metadata}.ApplyTo(&agent)
+
+				Expect(agent).To(Equal(Agent{AgentID: 1039, Name: "Old name", Metadata: map[string]string{"model": "v2"}}))
+			})
+
+			It("sets the offline interval if it is present", func() {
+				agent := Agent{AgentID: 1039, Name: "Old name", OfflineAfter: Interval(time.Hour)}
+				offlineAfter := Interval(0)
+
+				PatchAgent{OfflineAfter: &offlineAfter}.ApplyTo(&agent)
+
+				Expect(agent).To(Equal(Agent{AgentID: 1039, Name: "Old name", OfflineAfter: 0}))
+			})
+		})
+
+		Describe("validation", func() {
+			DescribeTable("it succeeds if the data is valid", func(body string) {
+				errors := TestValidation(body, PatchAgent{})
+				Expect(errors).To(BeEmpty())
+			},
+				Entry("when the patch is empty", `{}`),
+				Entry("when the patch has a name", `{"name": "New name"}`),
+				Entry("when the patch has metadata", `{"metadata": {"location": "Roof"}}`),
+				Entry("when the patch has an offline interval", `{"offlineAfter": "30m"}`),
+				Entry("when the patch turns off offline detection", `{"offlineAfter": "0s"}`),
+			)
+
+			DescribeTable("it fails if the data is invalid", func(body string, fieldName string) {
+				errors := TestValidation(body, PatchAgent{})
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[0].FieldNames).To(Equal([]string{fieldName}))
+				Expect(errors[0].Classification).To(Equal("InvalidValue"))
+			},
+				Entry("because the name is empty", `{"name": ""}`, "name"),
+				Entry("because the name is too long", `{"name": "`+strings.Repeat("a", 101)+`"}`, "name"),
+				Entry("because the offline interval is negative", `{"offlineAfter": "-30m"}`, "offlineAfter"),
+			)
 		})
 	})
 
@@ -166,12 +308,51 @@ var _ = Describe("Agent resource", func() {
 			db = NewMockDatabase(mockController)
 		})
 
-		It("returns a list of all agents", func() {
-			db.EXPECT().GetAllAgents().Return(agents, nil)
+		makeRequest := func(query string, user User) {
+			request, _ := http.NewRequest("GET", "/blah?"+query, nil)
+
+			getAllAgents(render, request, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		It("returns a list of the agents owned by the user", func() {
+			db.EXPECT().GetAgentsForUser(5678, AgentFilter{Limit: defaultAgentsPageSize}).Return(agents, nil)
+			render.EXPECT().JSON(http.StatusOK, agents)
+
+			makeRequest("", User{UserID: 5678})
+		})
+
+		It("passes the name filter and pagination parameters to the database", func() {
+			db.EXPECT().GetAgentsForUser(5678, AgentFilter{Name: "roof", Limit: 10, Offset: 20}).Return(agents, nil)
+			render.EXPECT().JSON(http.StatusOK, agents)
+
+			makeRequest("name=roof&limit=10&offset=20", User{UserID: 5678})
+		})
+
+		It("returns a list of all agents if the user is an administrator and asks for all agents", func() {
+			db.EXPECT().GetAllAgents(AgentFilter{Limit: defaultAgentsPageSize}).Return(agents, nil)
 			render.EXPECT().JSON(http.StatusOK, agents)
 
-			getAllAgents(render, db, nil)
+			makeRequest("all=true", User{UserID: 5678, IsAdmin: true})
+		})
+
+		It("returns HTTP 403 response if the user is not an administrator and asks for all agents", func() {
+			render.EXPECT().Error(http.StatusForbidden)
+
+			makeRequest("all=true", User{UserID: 5678})
 		})
+
+		DescribeTable("it returns HTTP 400 response if the parameters are invalid", func(query string) {
+			render.EXPECT().Text(http.StatusBadRequest, gomock.Any())
+
+			makeRequest(query, User{UserID: 5678, IsAdmin: true})
+		},
+			Entry("because 'all' is not a boolean", "all=yes please"),
+			Entry("because 'limit' is not a number", "limit=lots"),
+			Entry("because 'limit' is zero", "limit=0"),
+			Entry("because 'limit' is too large", "limit=1001"),
+			Entry("because 'offset' is not a number", "offset=abc"),
+			Entry("because 'offset' is negative", "offset=-1"),
+		)
 	})
 
 	Describe("GET agent request handler", func() {
@@ -276,4 +457,260 @@ var _ = Describe("Agent resource", func() {
 			})
 		})
 	})
+
+	Describe("PATCH agent request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+
+		makeRequest := func(patch PatchAgent, agentID string, user User) {
+			params := martini.Params{
+				"agent_id": agentID,
+			}
+
+			patchAgent(render, patch, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		existingAgent := Agent{AgentID: 1234, Name: "The name", OwnerUserID: 5678, Created: time.Date(2015, 3, 27, 8, 0, 0, 0, time.UTC)}
+		newName := "The new name"
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+		})
+
+		Context("when the request is valid", func() {
+			It("saves the changes and returns HTTP 200 response with the updated agent", func() {
+				updatedAgent := existingAgent
+				updatedAgent.Name = newName
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					db.EXPECT().UpdateAgent(updatedAgent).Return(nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusOK, updatedAgent),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(PatchAgent{Name: &newName}, "1234", User{UserID: 5678})
+			})
+		})
+
+		Context("when the user does not own the agent", func() {
+			It("returns HTTP 403 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					render.EXPECT().Error(http.StatusForbidden),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(PatchAgent{Name: &newName}, "1234", User{UserID: 9000})
+			})
+		})
+
+		Context("when the agent does not exist", func() {
+			It("returns HTTP 404 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(5).Return(false, nil),
+					render.EXPECT().Text(http.StatusNotFound, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(PatchAgent{Name: &newName}, "5", User{UserID: 5678})
+			})
+		})
+	})
+
+	Describe("DELETE agent request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+
+		makeRequest := func(query string, agentID string, user User) {
+			request, _ := http.NewRequest("DELETE", "/blah?"+query, nil)
+			params := martini.Params{
+				"agent_id": agentID,
+			}
+
+			deleteAgent(render, request, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		existingAgent := Agent{AgentID: 1234, Name: "The name", OwnerUserID: 5678, Created: time.Date(2015, 3, 27, 8, 0, 0, 0, time.UTC)}
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+		})
+
+		Context("when no mode is given", func() {
+			It("soft-deletes the agent and returns HTTP 204 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					db.EXPECT().SoftDeleteAgent(1234, gomock.Any()).Return(nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().Status(http.StatusNoContent),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("", "1234", User{UserID: 5678})
+			})
+		})
+
+		Context("when the cascade mode is given", func() {
+			It("deletes the agent and its data and returns HTTP 204 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					db.EXPECT().DeleteAgent(1234).Return(nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().Status(http.StatusNoContent),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("mode=cascade", "1234", User{UserID: 5678})
+			})
+		})
+
+		Context("when the user does not own the agent", func() {
+			It("returns HTTP 403 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					render.EXPECT().Error(http.StatusForbidden),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("mode=cascade", "1234", User{UserID: 9000})
+			})
+		})
+
+		Context("when the mode is not supported", func() {
+			It("returns HTTP 400 response", func() {
+				render.EXPECT().Text(http.StatusBadRequest, "Parameter 'mode' must be one of: soft, cascade.")
+
+				makeRequest("mode=shred", "1234", User{UserID: 5678})
+			})
+		})
+	})
+
+	Describe("POST token request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+
+		makeRequest := func(query string, agentID string, user User) {
+			request, _ := http.NewRequest("POST", "/blah?"+query, nil)
+			params := martini.Params{
+				"agent_id": agentID,
+			}
+
+			rotateAgentToken(render, request, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		var existingAgent Agent
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+
+			existingAgent = Agent{AgentID: 1234, Name: "The name", OwnerUserID: 5678}
+			existingAgent.SetToken("oldtoken")
+		})
+
+		Context("when no grace period is given", func() {
+			It("saves a new token, invalidates the old token immediately and returns the new token", func() {
+				var savedAgent Agent
+
+				updateCall := db.EXPECT().UpdateAgentToken(gomock.Any()).Do(func(agent Agent) {
+					savedAgent = agent
+				})
+
+				jsonCall := render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+					Expect(value).To(HaveKeyWithValue("id", 1234))
+					Expect(value).ToNot(HaveKey("previousTokenExpires"))
+
+					token := value.(map[string]interface{})["token"].(string)
+					Expect(savedAgent.CheckToken(token, time.Now())).To(BeTrue())
+					Expect(savedAgent.CheckToken("oldtoken", time.Now())).To(BeFalse())
+				})
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					updateCall,
+					db.EXPECT().CommitTransaction(),
+					jsonCall,
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("", "1234", User{UserID: 5678})
+			})
+		})
+
+		Context("when a grace period is given", func() {
+			It("saves a new token, keeps the old token valid and returns the new token and when the old token expires", func() {
+				var savedAgent Agent
+
+				updateCall := db.EXPECT().UpdateAgentToken(gomock.Any()).Do(func(agent Agent) {
+					savedAgent = agent
+				})
+
+				jsonCall := render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+					Expect(value).To(HaveKeyWithValue("previousTokenExpires", savedAgent.PreviousTokenExpires))
+					Expect(savedAgent.PreviousTokenExpires).To(BeTemporally("~", time.Now().Add(2*time.Hour), time.Minute))
+
+					token := value.(map[string]interface{})["token"].(string)
+					Expect(savedAgent.CheckToken(token, time.Now())).To(BeTrue())
+					Expect(savedAgent.CheckToken("oldtoken", time.Now())).To(BeTrue())
+				})
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					updateCall,
+					db.EXPECT().CommitTransaction(),
+					jsonCall,
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("grace_period=2h", "1234", User{UserID: 5678})
+			})
+		})
+
+		Context("when the user does not own the agent", func() {
+			It("returns HTTP 403 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+					db.EXPECT().GetAgentByID(1234).Return(existingAgent, nil),
+					render.EXPECT().Error(http.StatusForbidden),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("", "1234", User{UserID: 9000})
+			})
+		})
+
+		Context("when the grace period is invalid", func() {
+			It("returns HTTP 400 response if it cannot be parsed", func() {
+				render.EXPECT().Text(http.StatusBadRequest, "Cannot parse grace period value.")
+
+				makeRequest("grace_period=soon", "1234", User{UserID: 5678})
+			})
+
+			It("returns HTTP 400 response if it is too long", func() {
+				render.EXPECT().Text(http.StatusBadRequest, "Grace period cannot be longer than 168h0m0s.")
+
+				makeRequest("grace_period=8d", "1234", User{UserID: 5678})
+			})
+		})
+	})
 })
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	agentStatusOffline = "offline"
+	agentStatusOnline  = "online"
+)
+
+// AgentStatusEvent is emitted when an agent has not been seen within its offline interval, and again when it is next seen.
+type AgentStatusEvent struct {
+	AgentID  int        `json:"agentId"`
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"lastSeen"`
+	Time     time.Time  `json:"time"`
+}
+
+// AgentStatusChecker periodically marks agents that have stopped reporting as offline, and those that have started
+// reporting again as online, and calls notify with an event for each.
+type AgentStatusChecker struct {
+	db       Database
+	interval time.Duration
+	notify   func(AgentStatusEvent)
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewAgentStatusChecker starts checking agents every interval. db must not be shared with anything else.
+func NewAgentStatusChecker(db Database, interval time.Duration, notify func(AgentStatusEvent)) *AgentStatusChecker {
+	c := &AgentStatusChecker{
+		db:       db,
+		interval: interval,
+		notify:   notify,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Close stops checking agents, and waits for any check in progress to finish.
+func (c *AgentStatusChecker) Close() {
+	close(c.stop)
+	<-c.stopped
+}
+
+func (c *AgentStatusChecker) run() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := c.Check(now); err != nil {
+				logrus.WithError(err).Error("Could not check for offline agents.")
+			}
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Check marks agents as offline or online as of now. Each agent is only marked once, so events are not repeated
+// if several instances of the service are checking the same database.
+func (c *AgentStatusChecker) Check(now time.Time) error {
+	if err := c.db.BeginTransaction(); err != nil {
+		return err
+	}
+
+	defer c.db.RollbackUncommittedTransaction()
+
+	online, err := c.db.MarkAgentsOnline()
+
+	if err != nil {
+		return err
+	}
+
+	offline, err := c.db.MarkAgentsOffline(now)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.db.CommitTransaction(); err != nil {
+		return err
+	}
+
+	for _, agent := range online {
+		c.notify(newAgentStatusEvent(agent, agentStatusOnline, now))
+	}
+
+	for _, agent := range offline {
+		c.notify(newAgentStatusEvent(agent, agentStatusOffline, now))
+	}
+
+	return nil
+}
+
+func newAgentStatusEvent(agent Agent, status string, now time.Time) AgentStatusEvent {
+	return AgentStatusEvent{
+		AgentID:  agent.AgentID,
+		Name:     agent.Name,
+		Status:   status,
+		LastSeen: agent.LastSeen,
+		Time:     now,
+	}
+}
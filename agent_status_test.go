@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Agent status checker", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var events chan AgentStatusEvent
+
+	now := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+	lastSeen := time.Date(2015, 5, 6, 8, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		events = make(chan AgentStatusEvent, 10)
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	notify := func(event AgentStatusEvent) {
+		events <- event
+	}
+
+	Describe("Check", func() {
+		It("marks agents as online and offline, and sends an event for each after committing", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().MarkAgentsOnline().Return([]Agent{{AgentID: 10, Name: "Back again", LastSeen: &lastSeen}}, nil),
+				db.EXPECT().MarkAgentsOffline(now).Return([]Agent{{AgentID: 11, Name: "Gone quiet", LastSeen: &lastSeen}, {AgentID: 12, Name: "Never seen"}}, nil),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			checker := &AgentStatusChecker{db: db, notify: notify}
+			Expect(checker.Check(now)).To(Succeed())
+
+			Expect(events).To(Receive(Equal(AgentStatusEvent{AgentID: 10, Name: "Back again", Status: "online", LastSeen: &lastSeen, Time: now})))
+			Expect(events).To(Receive(Equal(AgentStatusEvent{AgentID: 11, Name: "Gone quiet", Status: "offline", LastSeen: &lastSeen, Time: now})))
+			Expect(events).To(Receive(Equal(AgentStatusEvent{AgentID: 12, Name: "Never seen", Status: "offline", Time: now})))
+		})
+
+		It("does not send any events if the changes cannot be saved", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().MarkAgentsOnline().Return([]Agent{{AgentID: 10}}, nil),
+				db.EXPECT().MarkAgentsOffline(now).Return(nil, errors.New("Something went wrong.")),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			checker := &AgentStatusChecker{db: db, notify: notify}
+			Expect(checker.Check(now)).To(MatchError("Something went wrong."))
+
+			Expect(events).To(BeEmpty())
+		})
+	})
+
+	It("checks agents every interval until it is closed", func() {
+		db.EXPECT().BeginTransaction().MinTimes(2)
+		db.EXPECT().MarkAgentsOnline().Return([]Agent{}, nil).MinTimes(2)
+		db.EXPECT().MarkAgentsOffline(gomock.Any()).Return([]Agent{{AgentID: 11}}, nil).MinTimes(2)
+		db.EXPECT().CommitTransaction().MinTimes(2)
+		db.EXPECT().RollbackUncommittedTransaction().MinTimes(2)
+
+		checker := NewAgentStatusChecker(db, time.Millisecond, notify)
+
+		Eventually(events).Should(Receive())
+		Eventually(events).Should(Receive())
+
+		checker.Close()
+	})
+})
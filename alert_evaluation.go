@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	alertEventFiring   = "firing"
+	alertEventResolved = "resolved"
+)
+
+// AlertEvent is sent to a rule's webhook when the rule starts firing or is resolved.
+type AlertEvent struct {
+	RuleID     int       `json:"ruleId"`
+	AgentID    int       `json:"agentId"`
+	VariableID int       `json:"variableId"`
+	Variable   string    `json:"variable"`
+	Event      string    `json:"event"`
+	Condition  string    `json:"condition"`
+	Threshold  float64   `json:"threshold"`
+	Value      float64   `json:"value"`
+	Time       time.Time `json:"time"`
+	WebhookURL string    `json:"-"`
+}
+
+// evaluateAlertRules updates the state of each of the agent's alert rules with the given points, records each rule
+// that starts firing or is resolved in the rule's history, and returns the events to send for them.
+// It must be called in the same transaction as the one that saves the points.
+func evaluateAlertRules(db Database, agentID int, points []DataEventPoint) ([]AlertEvent, error) {
+	rules, err := db.GetAlertRulesForAgent(agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	// Points can be given in any order, but rules must see them in the order they were recorded.
+	sorted := make([]DataEventPoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	events := []AlertEvent{}
+
+	for _, rule := range rules {
+		evaluated := false
+
+		for _, point := range sorted {
+			if point.VariableID != rule.VariableID {
+				continue
+			}
+
+			evaluated = true
+			event := rule.Evaluate(point.Time, point.Value)
+
+			if event == "" {
+				continue
+			}
+
+			entry := AlertHistoryEntry{RuleID: rule.RuleID, Event: event, Value: point.Value, Time: point.Time, Created: time.Now()}
+
+			if err := db.AddAlertHistory(&entry); err != nil {
+				return nil, err
+			}
+
+			events = append(events, AlertEvent{
+				RuleID:     rule.RuleID,
+				AgentID:    agentID,
+				VariableID: rule.VariableID,
+				Variable:   point.Variable,
+				Event:      event,
+				Condition:  rule.Condition,
+				Threshold:  rule.Threshold,
+				Value:      point.Value,
+				Time:       point.Time,
+				WebhookURL: rule.WebhookURL,
+			})
+		}
+
+		if evaluated {
+			if err := db.UpdateAlertRuleState(rule); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// Evaluate updates the state of the rule with a new value, and returns alertEventFiring or alertEventResolved if the
+// rule starts firing or is resolved, or an empty string otherwise. Values recorded before the last value evaluated are
+// ignored, so that uploading old data does not raise alerts for conditions that have long since passed.
+func (rule *AlertRule) Evaluate(t time.Time, value float64) string {
+	if !rule.LastTime.IsZero() && !t.After(rule.LastTime) {
+		return ""
+	}
+
+	breached, cleared := rule.check(t, value)
+	rule.LastValue = value
+	rule.LastTime = t
+
+	switch rule.State {
+	case alertStateFiring:
+		if cleared {
+			rule.State = alertStateOK
+			rule.PendingSince = time.Time{}
+			return alertEventResolved
+		}
+
+	case alertStatePending:
+		if !breached {
+			rule.State = alertStateOK
+			rule.PendingSince = time.Time{}
+		} else if t.Sub(rule.PendingSince) >= rule.MinDuration {
+			rule.State = alertStateFiring
+			return alertEventFiring
+		}
+
+	default:
+		if breached {
+			rule.PendingSince = t
+
+			if rule.MinDuration <= 0 {
+				rule.State = alertStateFiring
+				return alertEventFiring
+			}
+
+			rule.State = alertStatePending
+		}
+	}
+
+	return ""
+}
+
+// check returns whether the value breaches the rule, and whether it is far enough back past the threshold to resolve it.
+// It must be called before LastValue and LastTime are updated with the value.
+func (rule *AlertRule) check(t time.Time, value float64) (breached bool, cleared bool) {
+	switch rule.Condition {
+	case alertConditionAbove:
+		return value > rule.Threshold, value <= rule.Threshold-rule.Hysteresis
+
+	case alertConditionBelow:
+		return value < rule.Threshold, value >= rule.Threshold+rule.Hysteresis
+
+	case alertConditionRateOfChange:
+		if rule.LastTime.IsZero() {
+			return false, false
+		}
+
+		rate := math.Abs(value-rule.LastValue) / t.Sub(rule.LastTime).Hours()
+		return rate > rule.Threshold, rate <= rule.Threshold-rule.Hysteresis
+	}
+
+	return false, false
+}
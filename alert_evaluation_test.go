@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Alert evaluation", func() {
+	startTime := time.Date(2015, 5, 6, 10, 0, 0, 0, time.UTC)
+
+	minutes := func(n int) time.Time {
+		return startTime.Add(time.Duration(n) * time.Minute)
+	}
+
+	type reading struct {
+		minute        int
+		value         float64
+		expectedEvent string
+	}
+
+	evaluate := func(rule AlertRule, readings ...reading) {
+		rule.State = alertStateOK
+
+		for _, r := range readings {
+			Expect(rule.Evaluate(minutes(r.minute), r.value)).To(Equal(r.expectedEvent), "value %v at minute %v", r.value, r.minute)
+		}
+	}
+
+	Describe("Evaluate", func() {
+		DescribeTable("fires and resolves rules", evaluate,
+			Entry("above, firing as soon as the threshold is crossed",
+				AlertRule{Condition: alertConditionAbove, Threshold: 80},
+				reading{0, 79, ""},
+				reading{1, 80, ""},
+				reading{2, 81, alertEventFiring},
+				reading{3, 90, ""},
+				reading{4, 80, alertEventResolved},
+				reading{5, 85, alertEventFiring},
+			),
+			Entry("below, firing as soon as the threshold is crossed",
+				AlertRule{Condition: alertConditionBelow, Threshold: 0},
+				reading{0, 1, ""},
+				reading{1, -0.5, alertEventFiring},
+				reading{2, 0, alertEventResolved},
+			),
+			Entry("above, with hysteresis",
+				AlertRule{Condition: alertConditionAbove, Threshold: 80, Hysteresis: 5},
+				reading{0, 81, alertEventFiring},
+				reading{1, 76, ""},
+				reading{2, 81, ""},
+				reading{3, 75, alertEventResolved},
+			),
+			Entry("below, with hysteresis",
+				AlertRule{Condition: alertConditionBelow, Threshold: 0, Hysteresis: 2},
+				reading{0, -1, alertEventFiring},
+				reading{1, 1.5, ""},
+				reading{2, 2, alertEventResolved},
+			),
+			Entry("with a minimum duration, once the condition has held for long enough",
+				AlertRule{Condition: alertConditionAbove, Threshold: 80, MinDuration: 10 * time.Minute},
+				reading{0, 81, ""},
+				reading{5, 82, ""},
+				reading{10, 81, alertEventFiring},
+				reading{11, 70, alertEventResolved},
+			),
+			Entry("with a minimum duration, not if the condition stops holding before then",
+				AlertRule{Condition: alertConditionAbove, Threshold: 80, MinDuration: 10 * time.Minute},
+				reading{0, 81, ""},
+				reading{5, 79, ""},
+				reading{10, 81, ""},
+				reading{19, 81, ""},
+				reading{20, 81, alertEventFiring},
+			),
+			Entry("rate of change, in either direction",
+				AlertRule{Condition: alertConditionRateOfChange, Threshold: 6},
+				reading{0, 10, ""},
+				reading{10, 11, ""},
+				reading{20, 13, alertEventFiring},
+				reading{30, 13.5, alertEventResolved},
+				reading{40, 11, alertEventFiring},
+			),
+			Entry("ignoring values recorded before the last value evaluated",
+				AlertRule{Condition: alertConditionAbove, Threshold: 80},
+				reading{10, 79, ""},
+				reading{5, 81, ""},
+				reading{10, 81, ""},
+				reading{11, 81, alertEventFiring},
+			),
+		)
+
+		It("tracks when the condition started to hold", func() {
+			rule := AlertRule{Condition: alertConditionAbove, Threshold: 80, MinDuration: 10 * time.Minute, State: alertStateOK}
+
+			rule.Evaluate(minutes(0), 79)
+			Expect(rule.State).To(Equal(alertStateOK))
+			Expect(rule.PendingSince).To(BeZero())
+
+			rule.Evaluate(minutes(1), 81)
+			Expect(rule.State).To(Equal(alertStatePending))
+			Expect(rule.PendingSince).To(Equal(minutes(1)))
+
+			rule.Evaluate(minutes(11), 81)
+			Expect(rule.State).To(Equal(alertStateFiring))
+			Expect(rule.PendingSince).To(Equal(minutes(1)))
+
+			rule.Evaluate(minutes(12), 79)
+			Expect(rule.State).To(Equal(alertStateOK))
+			Expect(rule.PendingSince).To(BeZero())
+			Expect(rule.LastValue).To(Equal(79.0))
+			Expect(rule.LastTime).To(Equal(minutes(12)))
+		})
+	})
+
+	Describe("evaluateAlertRules", func() {
+		var mockController *gomock.Controller
+		var db *MockDatabase
+
+		BeforeEach(func() {
+			mockController = gomock.NewController(GinkgoT())
+			db = NewMockDatabase(mockController)
+		})
+
+		AfterEach(func() {
+			mockController.Finish()
+		})
+
+		rule := AlertRule{RuleID: 20, AgentID: 10, VariableID: 12, Condition: alertConditionAbove, Threshold: 80, WebhookURL: "http://example.com/hook", State: alertStateOK}
+		otherRule := AlertRule{RuleID: 21, AgentID: 10, VariableID: 13, Condition: alertConditionAbove, Threshold: 80, State: alertStateOK}
+
+		It("evaluates the points for each rule's variable in the order they were recorded, and records each event", func() {
+			points := []DataEventPoint{
+				{VariableID: 12, Variable: "humidity", Time: minutes(2), Value: 70},
+				{VariableID: 12, Variable: "humidity", Time: minutes(1), Value: 85},
+			}
+
+			updatedRule := rule
+			updatedRule.LastValue = 70
+			updatedRule.LastTime = minutes(2)
+
+			gomock.InOrder(
+				db.EXPECT().GetAlertRulesForAgent(10).Return([]AlertRule{rule, otherRule}, nil),
+				db.EXPECT().AddAlertHistory(gomock.Any()).Do(func(entry *AlertHistoryEntry) {
+					Expect(entry.Created).To(BeTemporally("~", time.Now(), time.Minute))
+					entry.Created = time.Time{}
+					Expect(*entry).To(Equal(AlertHistoryEntry{RuleID: 20, Event: alertEventFiring, Value: 85, Time: minutes(1)}))
+				}),
+				db.EXPECT().AddAlertHistory(gomock.Any()).Do(func(entry *AlertHistoryEntry) {
+					entry.Created = time.Time{}
+					Expect(*entry).To(Equal(AlertHistoryEntry{RuleID: 20, Event: alertEventResolved, Value: 70, Time: minutes(2)}))
+				}),
+				db.EXPECT().UpdateAlertRuleState(updatedRule),
+			)
+
+			events, err := evaluateAlertRules(db, 10, points)
+
+			Expect(err).To(BeNil())
+			Expect(events).To(Equal([]AlertEvent{
+				{RuleID: 20, AgentID: 10, VariableID: 12, Variable: "humidity", Event: alertEventFiring, Condition: alertConditionAbove, Threshold: 80, Value: 85, Time: minutes(1), WebhookURL: "http://example.com/hook"},
+				{RuleID: 20, AgentID: 10, VariableID: 12, Variable: "humidity", Event: alertEventResolved, Condition: alertConditionAbove, Threshold: 80, Value: 70, Time: minutes(2), WebhookURL: "http://example.com/hook"},
+			}))
+		})
+
+		It("returns no events if the agent has no rules", func() {
+			db.EXPECT().GetAlertRulesForAgent(10).Return([]AlertRule{}, nil)
+
+			events, err := evaluateAlertRules(db, 10, []DataEventPoint{{VariableID: 12, Time: minutes(1), Value: 85}})
+
+			Expect(err).To(BeNil())
+			Expect(events).To(BeEmpty())
+		})
+
+		It("returns an error if the rules cannot be loaded", func() {
+			db.EXPECT().GetAlertRulesForAgent(10).Return(nil, errors.New("Something went wrong."))
+
+			_, err := evaluateAlertRules(db, 10, []DataEventPoint{{VariableID: 12, Time: minutes(1), Value: 85}})
+
+			Expect(err).To(MatchError("Something went wrong."))
+		})
+	})
+})
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const alertNotifierQueueSize = 100
+const alertWebhookTimeout = 10 * time.Second
+
+// privateNetworks are the IPv4 and IPv6 private address ranges, which webhooks are not allowed to reach unless they're
+// explicitly allowed, so that alert rules can't be used to probe services on the internal network.
+var privateNetworks = []*net.IPNet{
+	mustParseNetwork("10.0.0.0/8"),
+	mustParseNetwork("172.16.0.0/12"),
+	mustParseNetwork("192.168.0.0/16"),
+	mustParseNetwork("100.64.0.0/10"),
+	mustParseNetwork("fc00::/7"),
+}
+
+// AlertNotifier POSTs events to webhooks in the background, one at a time and in the order they were queued, so that
+// a rule's firing and resolved events always arrive in the right order.
+type AlertNotifier struct {
+	client  *http.Client
+	queue   chan webhookDelivery
+	stopped chan struct{}
+	lock    sync.Mutex
+	closed  bool
+}
+
+type webhookDelivery struct {
+	url     string
+	payload interface{}
+	log     *logrus.Entry
+}
+
+// NewAlertNotifier creates a notifier that refuses to send events to loopback, private or link-local addresses, other
+// than those in allowedNetworks. Addresses are checked when connecting rather than when a rule is saved, so that a
+// webhook's host name can't be changed to resolve to an internal address later.
+func NewAlertNotifier(allowedNetworks []*net.IPNet) *AlertNotifier {
+	dialer := &net.Dialer{
+		Timeout: alertWebhookTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+
+			if err != nil {
+				return err
+			}
+
+			return checkWebhookAddress(net.ParseIP(host), allowedNetworks)
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	// A proxy would connect to the webhook on our behalf, bypassing the address check.
+	transport.Proxy = nil
+
+	n := &AlertNotifier{
+		client:  &http.Client{Timeout: alertWebhookTimeout, Transport: transport},
+		queue:   make(chan webhookDelivery, alertNotifierQueueSize),
+		stopped: make(chan struct{}),
+	}
+
+	go n.run()
+
+	return n
+}
+
+// Notify queues alert events to be sent to their rules' webhooks. Events are dropped if the queue is full or the
+// notifier has been closed.
+func (n *AlertNotifier) Notify(events []AlertEvent) {
+	for _, event := range events {
+		n.enqueue(webhookDelivery{url: event.WebhookURL, payload: event, log: logrus.WithField("ruleId", event.RuleID)})
+	}
+}
+
+// NotifyAgentStatus queues an agent status event to be sent to the given webhook.
+func (n *AlertNotifier) NotifyAgentStatus(url string, event AgentStatusEvent) {
+	n.enqueue(webhookDelivery{url: url, payload: event, log: logrus.WithField("agentId", event.AgentID)})
+}
+
+func (n *AlertNotifier) enqueue(delivery webhookDelivery) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.closed {
+		delivery.log.Error("Could not send event because the notifier has been closed.")
+		return
+	}
+
+	select {
+	case n.queue <- delivery:
+	default:
+		delivery.log.Error("Could not send event because too many events are waiting to be sent.")
+	}
+}
+
+// Close stops accepting events, and waits for those already queued to be sent.
+func (n *AlertNotifier) Close() {
+	n.lock.Lock()
+
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+
+	n.lock.Unlock()
+
+	<-n.stopped
+}
+
+func (n *AlertNotifier) run() {
+	defer close(n.stopped)
+
+	for delivery := range n.queue {
+		if err := n.send(delivery); err != nil {
+			delivery.log.WithError(err).Error("Could not send event to webhook.")
+		}
+	}
+}
+
+func (n *AlertNotifier) send(delivery webhookDelivery) error {
+	body, err := json.Marshal(delivery.payload)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := n.client.Post(delivery.url, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned HTTP %v response.", res.StatusCode)
+	}
+
+	return nil
+}
+
+func checkWebhookAddress(ip net.IP, allowedNetworks []*net.IPNet) error {
+	if ip == nil {
+		return errors.New("Webhook address is not an IP address.")
+	}
+
+	if networksContain(allowedNetworks, ip) {
+		return nil
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || networksContain(privateNetworks, ip) {
+		return fmt.Errorf("Webhook address %v is not a public address.", ip)
+	}
+
+	return nil
+}
+
+func networksContain(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseNetworks parses a comma-separated list of networks in CIDR notation, eg. 10.1.0.0/16,fd00::/8.
+func parseNetworks(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+
+		if cidr == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func mustParseNetwork(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Alert notifier", func() {
+	var notifier *AlertNotifier
+	var webhook *httptest.Server
+	var received chan string
+	var status int
+
+	event := AlertEvent{
+		RuleID:     20,
+		AgentID:    10,
+		VariableID: 12,
+		Variable:   "temperature",
+		Event:      alertEventFiring,
+		Condition:  alertConditionBelow,
+		Threshold:  0,
+		Value:      -1.5,
+		Time:       time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC),
+	}
+
+	BeforeEach(func() {
+		received = make(chan string, 10)
+		status = http.StatusOK
+
+		webhook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- string(body)
+			w.WriteHeader(status)
+		}))
+
+		notifier = NewAlertNotifier([]*net.IPNet{mustParseNetwork("127.0.0.0/8")})
+		event.WebhookURL = webhook.URL
+	})
+
+	AfterEach(func() {
+		notifier.Close()
+		webhook.Close()
+	})
+
+	It("POSTs each event to its webhook as JSON", func() {
+		notifier.Notify([]AlertEvent{event})
+
+		Eventually(received).Should(Receive(MatchJSON(`{
+			"ruleId": 20,
+			"agentId": 10,
+			"variableId": 12,
+			"variable": "temperature",
+			"event": "firing",
+			"condition": "below",
+			"threshold": 0,
+			"value": -1.5,
+			"time": "2015-05-06T10:15:30Z"
+		}`)))
+	})
+
+	It("sends events in the order they were queued", func() {
+		resolved := event
+		resolved.Event = alertEventResolved
+
+		notifier.Notify([]AlertEvent{event, resolved})
+
+		for _, expected := range []string{alertEventFiring, alertEventResolved} {
+			var body string
+			Eventually(received).Should(Receive(&body))
+
+			sent := AlertEvent{}
+			Expect(json.Unmarshal([]byte(body), &sent)).To(Succeed())
+			Expect(sent.Event).To(Equal(expected))
+		}
+	})
+
+	It("keeps sending events after a webhook fails", func() {
+		status = http.StatusInternalServerError
+		notifier.Notify([]AlertEvent{event})
+		Eventually(received).Should(Receive())
+
+		notifier.Notify([]AlertEvent{event})
+		Eventually(received).Should(Receive())
+	})
+
+	It("POSTs agent status events to the given webhook as JSON", func() {
+		notifier.NotifyAgentStatus(webhook.URL, AgentStatusEvent{AgentID: 10, Name: "Roof", Status: agentStatusOffline, Time: event.Time})
+
+		Eventually(received).Should(Receive(MatchJSON(`{
+			"agentId": 10,
+			"name": "Roof",
+			"status": "offline",
+			"lastSeen": null,
+			"time": "2015-05-06T10:15:30Z"
+		}`)))
+	})
+
+	It("sends queued events before closing, and drops later ones", func() {
+		notifier.Notify([]AlertEvent{event})
+		notifier.Close()
+
+		Expect(received).To(Receive())
+
+		notifier.Notify([]AlertEvent{event})
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("refuses to send events to webhooks with non-public addresses that aren't allowed", func() {
+		notifier.Close()
+		notifier = NewAlertNotifier(nil)
+
+		notifier.Notify([]AlertEvent{event})
+		Consistently(received).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("Webhook address check", func() {
+	allowed := []*net.IPNet{mustParseNetwork("10.1.0.0/16")}
+
+	DescribeTable("allows public addresses and addresses in allowed networks",
+		func(ip string) {
+			Expect(checkWebhookAddress(net.ParseIP(ip), allowed)).To(Succeed())
+		},
+		Entry("public IPv4 address", "203.0.113.10"),
+		Entry("public IPv6 address", "2001:db8::10"),
+		Entry("allowed private address", "10.1.2.3"),
+	)
+
+	DescribeTable("refuses other non-public addresses",
+		func(ip string) {
+			Expect(checkWebhookAddress(net.ParseIP(ip), allowed)).To(MatchError("Webhook address " + ip + " is not a public address."))
+		},
+		Entry("IPv4 loopback address", "127.0.0.1"),
+		Entry("IPv6 loopback address", "::1"),
+		Entry("unspecified address", "0.0.0.0"),
+		Entry("10.0.0.0/8 private address", "10.2.3.4"),
+		Entry("172.16.0.0/12 private address", "172.20.0.1"),
+		Entry("192.168.0.0/16 private address", "192.168.1.1"),
+		Entry("shared address space address", "100.64.0.1"),
+		Entry("IPv6 unique local address", "fd00::1"),
+		Entry("IPv4 link-local address", "169.254.169.254"),
+		Entry("IPv6 link-local address", "fe80::1"),
+	)
+})
+
+var _ = Describe("Parsing networks", func() {
+	It("parses a comma-separated list of networks", func() {
+		networks, err := parseNetworks(" 10.1.0.0/16, fd00::/8 ")
+		Expect(err).To(BeNil())
+		Expect(networks).To(Equal([]*net.IPNet{mustParseNetwork("10.1.0.0/16"), mustParseNetwork("fd00::/8")}))
+	})
+
+	It("returns no networks for an empty list", func() {
+		Expect(parseNetworks("")).To(BeEmpty())
+	})
+
+	It("returns an error for an invalid network", func() {
+		_, err := parseNetworks("10.1.0.0")
+		Expect(err).To(MatchError("invalid CIDR address: 10.1.0.0"))
+	})
+})
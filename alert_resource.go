@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
+	"github.com/martini-contrib/render"
+)
+
+// AlertRule describes a condition on a single variable reported by an agent. The rule fires once the condition has
+// held for at least MinDuration, and is resolved once the value is back past the threshold by at least Hysteresis.
+// For rateOfChange rules, Threshold is the largest change per hour (in either direction) that does not breach the rule.
+type AlertRule struct {
+	RuleID      int
+	AgentID     int
+	VariableID  int
+	Variable    string
+	Condition   string
+	Threshold   float64
+	Hysteresis  float64
+	MinDuration time.Duration
+	WebhookURL  string
+	Created     time.Time
+
+	// The state of the rule as of the last value evaluated. PendingSince is the time the condition started to hold.
+	State        string
+	PendingSince time.Time
+	LastValue    float64
+	LastTime     time.Time
+}
+
+type PostAlertRule struct {
+	Variable    string   `json:"variable" binding:"required"`
+	Condition   string   `json:"condition" binding:"required"`
+	Threshold   *float64 `json:"threshold"`
+	Hysteresis  float64  `json:"hysteresis"`
+	MinDuration string   `json:"minDuration"`
+	WebhookURL  string   `json:"webhookUrl" binding:"required"`
+}
+
+type AlertRuleResult struct {
+	RuleID      int       `json:"id"`
+	VariableID  int       `json:"variableId"`
+	Variable    string    `json:"variable"`
+	Condition   string    `json:"condition"`
+	Threshold   float64   `json:"threshold"`
+	Hysteresis  float64   `json:"hysteresis"`
+	MinDuration string    `json:"minDuration"`
+	WebhookURL  string    `json:"webhookUrl"`
+	State       string    `json:"state"`
+	Created     time.Time `json:"created"`
+}
+
+// AlertHistoryEntry records a rule starting to fire or being resolved, and the value that caused it.
+type AlertHistoryEntry struct {
+	HistoryID int       `json:"id"`
+	RuleID    int       `json:"-"`
+	Event     string    `json:"event"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	Created   time.Time `json:"created"`
+}
+
+const (
+	alertConditionAbove        = "above"
+	alertConditionBelow        = "below"
+	alertConditionRateOfChange = "rateOfChange"
+)
+
+var alertConditions = []string{alertConditionAbove, alertConditionBelow, alertConditionRateOfChange}
+
+const (
+	alertStateOK      = "ok"
+	alertStatePending = "pending"
+	alertStateFiring  = "firing"
+)
+
+func postAlertRule(r render.Render, posted PostAlertRule, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	variableID, err := db.GetVariableIDForName(posted.Variable)
+
+	if err != nil && variableID != -1 {
+		log.WithError(err).Error("Could not get variable ID.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if variableID == -1 {
+		r.Text(http.StatusBadRequest, fmt.Sprintf("Could not find variable with name '%v'.", posted.Variable))
+		return
+	}
+
+	// Validate has already checked that the minimum duration can be parsed.
+	minDuration, _ := parseMinDuration(posted.MinDuration)
+
+	rule := AlertRule{
+		AgentID:     agentID,
+		VariableID:  variableID,
+		Variable:    posted.Variable,
+		Condition:   posted.Condition,
+		Threshold:   *posted.Threshold,
+		Hysteresis:  posted.Hysteresis,
+		MinDuration: minDuration,
+		WebhookURL:  posted.WebhookURL,
+		Created:     time.Now(),
+		State:       alertStateOK,
+	}
+
+	if err := db.CreateAlertRule(&rule); err != nil {
+		log.WithError(err).Error("Could not create new alert rule.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.JSON(http.StatusCreated, newAlertRuleResult(rule))
+}
+
+func getAlertRules(r render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	rules, err := db.GetAlertRulesForAgent(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get alert rules.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	results := []AlertRuleResult{}
+
+	for _, rule := range rules {
+		results = append(results, newAlertRuleResult(rule))
+	}
+
+	r.JSON(http.StatusOK, results)
+}
+
+func deleteAlertRule(r render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	rule, ok := extractAlertRule(params, agentID, r, db, log)
+
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteAlertRule(rule.RuleID); err != nil {
+		log.WithError(err).Error("Could not delete alert rule.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.Status(http.StatusNoContent)
+}
+
+func getAlertHistory(r render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	rule, ok := extractAlertRule(params, agentID, r, db, log)
+
+	if !ok {
+		return
+	}
+
+	history, err := db.GetAlertHistory(rule.RuleID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get alert history.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.JSON(http.StatusOK, history)
+}
+
+func extractAlertRule(params martini.Params, agentID int, r render.Render, db Database, log *logrus.Entry) (AlertRule, bool) {
+	ruleID, err := strconv.Atoi(params["rule_id"])
+
+	if err != nil {
+		r.Text(http.StatusNotFound, "Invalid alert rule ID.")
+		return AlertRule{}, false
+	}
+
+	rules, err := db.GetAlertRulesForAgent(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get alert rules.")
+		r.Error(http.StatusInternalServerError)
+		return AlertRule{}, false
+	}
+
+	for _, rule := range rules {
+		if rule.RuleID == ruleID {
+			return rule, true
+		}
+	}
+
+	r.Text(http.StatusNotFound, "Alert rule does not exist.")
+	return AlertRule{}, false
+}
+
+func newAlertRuleResult(rule AlertRule) AlertRuleResult {
+	return AlertRuleResult{
+		RuleID:      rule.RuleID,
+		VariableID:  rule.VariableID,
+		Variable:    rule.Variable,
+		Condition:   rule.Condition,
+		Threshold:   rule.Threshold,
+		Hysteresis:  rule.Hysteresis,
+		MinDuration: rule.MinDuration.String(),
+		WebhookURL:  rule.WebhookURL,
+		State:       rule.State,
+		Created:     rule.Created,
+	}
+}
+
+func parseMinDuration(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	return parseInterval(raw)
+}
+
+func (rule PostAlertRule) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	if rule.Condition != "" && !containsString(alertConditions, rule.Condition) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"condition"},
+			Classification: "InvalidValue",
+			Message:        fmt.Sprintf("Condition must be one of: %v.", strings.Join(alertConditions, ", ")),
+		})
+	}
+
+	if rule.Threshold == nil {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"threshold"},
+			Classification: binding.RequiredError,
+			Message:        "Must provide a threshold.",
+		})
+	} else if rule.Condition == alertConditionRateOfChange && *rule.Threshold <= 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"threshold"},
+			Classification: "InvalidValue",
+			Message:        "Threshold must be greater than zero for a rateOfChange rule.",
+		})
+	}
+
+	if rule.Hysteresis < 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"hysteresis"},
+			Classification: "InvalidValue",
+			Message:        "Hysteresis cannot be negative.",
+		})
+	}
+
+	if minDuration, err := parseMinDuration(rule.MinDuration); err != nil || minDuration < 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"minDuration"},
+			Classification: "InvalidValue",
+			Message:        "Minimum duration is not valid.",
+		})
+	}
+
+	if rule.WebhookURL != "" {
+		if u, err := url.Parse(rule.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errors = append(errors, binding.Error{
+				FieldNames:     []string{"webhookUrl"},
+				Classification: "InvalidValue",
+				Message:        "Webhook URL must be an absolute HTTP or HTTPS URL.",
+			})
+		}
+	}
+
+	return errors
+}
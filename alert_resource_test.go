@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/golang/mock/gomock"
+	"github.com/martini-contrib/binding"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Alert resource", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var render *MockRender
+
+	user := User{UserID: 5678}
+	agent := Agent{AgentID: 1234, OwnerUserID: 5678}
+	created := time.Date(2015, 3, 27, 8, 0, 0, 0, time.UTC)
+
+	rule := AlertRule{
+		RuleID:      20,
+		AgentID:     1234,
+		VariableID:  12,
+		Variable:    "temperature",
+		Condition:   alertConditionBelow,
+		Threshold:   0,
+		Hysteresis:  1,
+		MinDuration: 10 * time.Minute,
+		WebhookURL:  "http://example.com/hook",
+		Created:     created,
+		State:       alertStateFiring,
+	}
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		render = NewMockRender(mockController)
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	expectOwnedAgent := func(owner int) []*gomock.Call {
+		return []*gomock.Call{
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+			db.EXPECT().GetAgentByID(1234).Return(Agent{AgentID: 1234, OwnerUserID: owner}, nil),
+		}
+	}
+
+	Describe("result data structure", func() {
+		It("can be serialised to JSON", func() {
+			bytes, err := json.Marshal(newAlertRuleResult(rule))
+
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(MatchJSON(`{
+				"id": 20,
+				"variableId": 12,
+				"variable": "temperature",
+				"condition": "below",
+				"threshold": 0,
+				"hysteresis": 1,
+				"minDuration": "10m0s",
+				"webhookUrl": "http://example.com/hook",
+				"state": "firing",
+				"created": "2015-03-27T08:00:00Z"
+			}`))
+		})
+	})
+
+	Describe("POST data structure", func() {
+		Describe("validation", func() {
+			DescribeTable("it succeeds if the data is valid", func(body string) {
+				errors := TestValidation(body, PostAlertRule{})
+				Expect(errors).To(BeEmpty())
+			},
+				Entry("when only the required properties are set", `{"variable": "temperature", "condition": "below", "threshold": 0, "webhookUrl": "http://example.com/hook"}`),
+				Entry("when all properties are set", `{"variable": "temperature", "condition": "above", "threshold": 30, "hysteresis": 2, "minDuration": "1d", "webhookUrl": "https://example.com/hook"}`),
+				Entry("when the rule is for the rate of change", `{"variable": "temperature", "condition": "rateOfChange", "threshold": 5, "webhookUrl": "http://example.com/hook"}`),
+			)
+
+			DescribeTable("it fails if the data is invalid", func(body string, classification string, fieldName string) {
+				errors := TestValidation(body, PostAlertRule{})
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[0].FieldNames).To(Equal([]string{fieldName}))
+				Expect(errors[0].Classification).To(Equal(classification))
+			},
+				Entry("because the variable is missing", `{"condition": "below", "threshold": 0, "webhookUrl": "http://example.com/hook"}`, binding.RequiredError, "variable"),
+				Entry("because the condition is missing", `{"variable": "temperature", "threshold": 0, "webhookUrl": "http://example.com/hook"}`, binding.RequiredError, "condition"),
+				Entry("because the condition is not known", `{"variable": "temperature", "condition": "equals", "threshold": 0, "webhookUrl": "http://example.com/hook"}`, "InvalidValue", "condition"),
+				Entry("because the threshold is missing", `{"variable": "temperature", "condition": "below", "webhookUrl": "http://example.com/hook"}`, binding.RequiredError, "threshold"),
+				Entry("because the threshold for a rate of change is not positive", `{"variable": "temperature", "condition": "rateOfChange", "threshold": 0, "webhookUrl": "http://example.com/hook"}`, "InvalidValue", "threshold"),
+				Entry("because the hysteresis is negative", `{"variable": "temperature", "condition": "below", "threshold": 0, "hysteresis": -1, "webhookUrl": "http://example.com/hook"}`, "InvalidValue", "hysteresis"),
+				Entry("because the minimum duration cannot be parsed", `{"variable": "temperature", "condition": "below", "threshold": 0, "minDuration": "a while", "webhookUrl": "http://example.com/hook"}`, "InvalidValue", "minDuration"),
+				Entry("because the minimum duration is negative", `{"variable": "temperature", "condition": "below", "threshold": 0, "minDuration": "-5m", "webhookUrl": "http://example.com/hook"}`, "InvalidValue", "minDuration"),
+				Entry("because the webhook URL is missing", `{"variable": "temperature", "condition": "below", "threshold": 0}`, binding.RequiredError, "webhookUrl"),
+				Entry("because the webhook URL is not absolute", `{"variable": "temperature", "condition": "below", "threshold": 0, "webhookUrl": "/hook"}`, "InvalidValue", "webhookUrl"),
+				Entry("because the webhook URL is not HTTP", `{"variable": "temperature", "condition": "below", "threshold": 0, "webhookUrl": "ftp://example.com/hook"}`, "InvalidValue", "webhookUrl"),
+			)
+		})
+	})
+
+	Describe("POST request handler", func() {
+		threshold := 0.0
+		posted := PostAlertRule{Variable: "temperature", Condition: alertConditionBelow, Threshold: &threshold, Hysteresis: 1, MinDuration: "10m", WebhookURL: "http://example.com/hook"}
+
+		makeRequest := func(posted PostAlertRule) {
+			postAlertRule(render, posted, martini.Params{"agent_id": "1234"}, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		It("saves the rule to the database and returns it", func() {
+			createCall := db.EXPECT().CreateAlertRule(gomock.Any()).Do(func(created *AlertRule) {
+				Expect(created.Created).To(BeTemporally("~", time.Now(), time.Minute))
+
+				expected := rule
+				expected.RuleID = 0
+				expected.State = alertStateOK
+				expected.Created = created.Created
+				Expect(*created).To(Equal(expected))
+
+				created.RuleID = 20
+			})
+
+			jsonCall := render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+				result := value.(AlertRuleResult)
+				Expect(result.RuleID).To(Equal(20))
+				Expect(result.MinDuration).To(Equal("10m0s"))
+				Expect(result.State).To(Equal(alertStateOK))
+			})
+
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetVariableIDForName("temperature").Return(12, nil),
+				createCall,
+				db.EXPECT().CommitTransaction(),
+				jsonCall,
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(posted)
+		})
+
+		It("returns HTTP 400 response if the variable does not exist", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetVariableIDForName("temperature").Return(-1, errors.New("Doesn't exist")),
+				render.EXPECT().Text(http.StatusBadRequest, "Could not find variable with name 'temperature'."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(posted)
+		})
+
+		It("returns HTTP 403 response if the user does not own the agent", func() {
+			gomock.InOrder(append(expectOwnedAgent(9999),
+				render.EXPECT().Error(http.StatusForbidden),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(posted)
+		})
+	})
+
+	Describe("GET request handler", func() {
+		It("returns the agent's rules", func() {
+			jsonCall := render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+				Expect(value).To(Equal([]AlertRuleResult{newAlertRuleResult(rule)}))
+			})
+
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetAlertRulesForAgent(1234).Return([]AlertRule{rule}, nil),
+				db.EXPECT().CommitTransaction(),
+				jsonCall,
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			getAlertRules(render, martini.Params{"agent_id": "1234"}, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		})
+	})
+
+	Describe("DELETE request handler", func() {
+		makeRequest := func(ruleID string) {
+			deleteAlertRule(render, martini.Params{"agent_id": "1234", "rule_id": ruleID}, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		It("deletes the rule and returns HTTP 204 response", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetAlertRulesForAgent(1234).Return([]AlertRule{rule}, nil),
+				db.EXPECT().DeleteAlertRule(20),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Status(http.StatusNoContent),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest("20")
+		})
+
+		It("returns HTTP 404 response if the rule does not belong to the agent", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetAlertRulesForAgent(1234).Return([]AlertRule{rule}, nil),
+				render.EXPECT().Text(http.StatusNotFound, "Alert rule does not exist."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest("21")
+		})
+
+		It("returns HTTP 404 response if the rule ID is invalid", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				render.EXPECT().Text(http.StatusNotFound, "Invalid alert rule ID."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest("abc")
+		})
+	})
+
+	Describe("GET history request handler", func() {
+		It("returns the rule's history", func() {
+			history := []AlertHistoryEntry{
+				{HistoryID: 2, RuleID: 20, Event: alertEventResolved, Value: 1, Time: created.Add(time.Hour), Created: created.Add(time.Hour)},
+				{HistoryID: 1, RuleID: 20, Event: alertEventFiring, Value: -1, Time: created, Created: created},
+			}
+
+			gomock.InOrder(append(expectOwnedAgent(agent.OwnerUserID),
+				db.EXPECT().GetAlertRulesForAgent(1234).Return([]AlertRule{rule}, nil),
+				db.EXPECT().GetAlertHistory(20).Return(history, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().JSON(http.StatusOK, history),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			getAlertHistory(render, martini.Params{"agent_id": "1234", "rule_id": "20"}, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		})
+	})
+})
@@ -10,15 +10,24 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const authenticationRealm string = "weather-thingy-data-service"
 const tokenAuthenticationScheme string = "weather-thingy-agent-token"
+const sessionAuthenticationScheme string = "Bearer"
 
+// withAuthenticatedUser accepts either HTTP basic authentication or a session token created with POST /v1/sessions.
+// The session is mapped into the context as a *Session, which is nil if basic authentication was used.
 func withAuthenticatedUser(render render.Render, req *http.Request, db Database, log *logrus.Entry, c martini.Context) {
 	authorizationHeader := req.Header.Get("Authorization")
 	prefix := "Basic "
 
+	if sessionPrefix := sessionAuthenticationScheme + " "; strings.HasPrefix(authorizationHeader, sessionPrefix) {
+		authenticateUserWithSession(render, strings.TrimPrefix(authorizationHeader, sessionPrefix), db, log, c)
+		return
+	}
+
 	if !strings.HasPrefix(authorizationHeader, prefix) {
 		log.Error("Authentication failed because there was no Authorization header or it was not for HTTP basic authentication.")
 		respondWithUserAuthenticationFailed(render, "You must authenticate with a HTTP basic authentication header to access this resource.")
@@ -54,6 +63,34 @@ func withAuthenticatedUser(render render.Render, req *http.Request, db Database,
 	}
 
 	c.Map(user)
+	c.Map((*Session)(nil))
+}
+
+func authenticateUserWithSession(render render.Render, token string, db Database, log *logrus.Entry, c martini.Context) {
+	session, err := db.GetSessionByTokenHash(computeSessionTokenHash(token))
+
+	if err != nil {
+		log.WithError(err).Error("Authentication failed because the session token does not match any known session.")
+		respondWithUserAuthenticationFailed(render, "Session token is invalid or has expired.")
+		return
+	}
+
+	if !time.Now().Before(session.Expires) {
+		log.Error("Authentication failed because the session has expired.")
+		respondWithUserAuthenticationFailed(render, "Session token is invalid or has expired.")
+		return
+	}
+
+	user, err := db.GetUserByID(session.UserID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get user for session.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	c.Map(user)
+	c.Map(&session)
 }
 
 func respondWithUserAuthenticationFailed(render render.Render, message string) {
@@ -76,6 +113,22 @@ func withAuthenticatedAgent(render render.Render, req *http.Request, params mart
 		return
 	}
 
+	authenticateAgent(render, params["agent_id"], strings.TrimPrefix(authorizationHeader, prefix), db, log, c)
+}
+
+// withWeatherStationAgent authenticates consumer weather stations, which can only send an ID and password along with
+// the data they upload. The ID is the agent ID and the password is the agent's token.
+func withWeatherStationAgent(render render.Render, req *http.Request, db Database, log *logrus.Entry, c martini.Context) {
+	if err := req.ParseForm(); err != nil {
+		log.WithError(err).Error("Could not parse form.")
+		render.Text(http.StatusBadRequest, "Cannot parse request.")
+		return
+	}
+
+	authenticateAgent(render, req.Form.Get("ID"), req.Form.Get("PASSWORD"), db, log, c)
+}
+
+func authenticateAgent(render render.Render, rawAgentID string, token string, db Database, log *logrus.Entry, c martini.Context) {
 	if err := db.BeginTransaction(); err != nil {
 		log.WithError(err).Error("Could not begin database transaction.")
 		render.Error(http.StatusInternalServerError)
@@ -84,7 +137,7 @@ func withAuthenticatedAgent(render render.Render, req *http.Request, params mart
 
 	defer db.RollbackUncommittedTransaction()
 
-	agentID, err := strconv.Atoi(params["agent_id"])
+	agentID, err := strconv.Atoi(rawAgentID)
 
 	if err != nil {
 		log.WithError(err).Error("Agent ID is invalid.")
@@ -92,35 +145,57 @@ func withAuthenticatedAgent(render render.Render, req *http.Request, params mart
 		return
 	}
 
-	exists := false
+	agent, ok, err := verifyAgentToken(db, agentID, token, log)
 
-	if exists, err = db.CheckAgentIDExists(agentID); err != nil {
-		log.WithError(err).Error("Could not check if agent exists.")
+	if err != nil {
 		render.Error(http.StatusInternalServerError)
 		return
-	} else if !exists {
-		log.Error("Authentication failed because the agent does not exist.")
+	} else if !ok {
 		respondWithAgentAuthenticationFailed(render, "Agent ID or token are invalid or incorrect.")
 		return
 	}
 
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	c.Map(agent)
+}
+
+// verifyAgentToken checks the token given by an agent, and records that the agent was seen if it is correct. It returns
+// false if the agent does not exist or the token is incorrect. Errors are logged before they are returned.
+func verifyAgentToken(db Database, agentID int, token string, log *logrus.Entry) (Agent, bool, error) {
+	if exists, err := db.CheckAgentIDExists(agentID); err != nil {
+		log.WithError(err).Error("Could not check if agent exists.")
+		return Agent{}, false, err
+	} else if !exists {
+		log.Error("Authentication failed because the agent does not exist.")
+		return Agent{}, false, nil
+	}
+
 	agent, err := db.GetAgentByID(agentID)
 
 	if err != nil {
 		log.WithError(err).Error("Retrieving agent failed.")
-		render.Error(http.StatusInternalServerError)
-		return
+		return Agent{}, false, err
 	}
 
-	token := strings.TrimPrefix(authorizationHeader, prefix)
+	now := time.Now()
 
-	if subtle.ConstantTimeCompare(agent.ComputeTokenHash(token), agent.TokenHash) != 1 {
+	if !agent.CheckToken(token, now) {
 		log.Error("Authentication failed because the token does not match the agent ID given.")
-		respondWithAgentAuthenticationFailed(render, "Agent ID or token are invalid or incorrect.")
-		return
+		return Agent{}, false, nil
 	}
 
-	c.Map(agent)
+	// Any authenticated request shows that the agent is still running, even if the request itself is rejected later on.
+	if err := db.UpdateAgentLastSeen(agentID, now); err != nil {
+		log.WithError(err).Error("Could not update time agent was last seen.")
+		return Agent{}, false, err
+	}
+
+	return agent, true, nil
 }
 
 func respondWithAgentAuthenticationFailed(render render.Render, message string) {
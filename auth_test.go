@@ -10,6 +10,7 @@ import (
 	. "github.com/onsi/gomega"
 	"net/http"
 	"reflect"
+	"time"
 )
 
 var _ = Describe("Authentication", func() {
@@ -116,6 +117,64 @@ var _ = Describe("Authentication", func() {
 				userType := reflect.TypeOf(User{})
 				userFromContext := context.Get(userType)
 				Expect(userFromContext.Interface().(User)).To(Equal(user))
+
+				sessionFromContext := context.Get(reflect.TypeOf((*Session)(nil)))
+				Expect(sessionFromContext.IsNil()).To(BeTrue())
+			})
+		})
+
+		Context("when a session token that matches an active session is provided", func() {
+			It("does not render a response and sets the user and session in the request context", func() {
+				request.Header.Set("Authorization", "Bearer thetoken")
+
+				user := User{UserID: 1019, Email: "user@test.com"}
+				session := Session{SessionID: 2001, UserID: 1019, Expires: time.Now().Add(time.Hour)}
+				context := NewTestContext()
+
+				gomock.InOrder(
+					db.EXPECT().GetSessionByTokenHash(computeSessionTokenHash("thetoken")).Return(session, nil),
+					db.EXPECT().GetUserByID(1019).Return(user, nil),
+				)
+
+				withAuthenticatedUser(render, request, db, logger, context)
+
+				userFromContext := context.Get(reflect.TypeOf(User{}))
+				Expect(userFromContext.Interface().(User)).To(Equal(user))
+
+				sessionFromContext := context.Get(reflect.TypeOf((*Session)(nil)))
+				Expect(sessionFromContext.Interface().(*Session)).To(Equal(&session))
+			})
+		})
+
+		Context("when a session token that does not match any session is provided", func() {
+			It("returns HTTP 401 and sets the WWW-Authenticate header", func() {
+				request.Header.Set("Authorization", "Bearer thetoken")
+				responseHeaders := http.Header{}
+
+				db.EXPECT().GetSessionByTokenHash(computeSessionTokenHash("thetoken")).Return(Session{}, errors.New("Cannot find session with the given token."))
+				render.EXPECT().Header().Return(responseHeaders)
+				render.EXPECT().Text(http.StatusUnauthorized, gomock.Any())
+
+				withAuthenticatedUser(render, request, db, logger, nil)
+
+				Expect(responseHeaders.Get("WWW-Authenticate")).To(Equal(`Basic realm="weather-thingy-data-service"`))
+			})
+		})
+
+		Context("when a session token for an expired session is provided", func() {
+			It("returns HTTP 401 and sets the WWW-Authenticate header", func() {
+				request.Header.Set("Authorization", "Bearer thetoken")
+				responseHeaders := http.Header{}
+
+				session := Session{SessionID: 2001, UserID: 1019, Expires: time.Now().Add(-time.Hour)}
+
+				db.EXPECT().GetSessionByTokenHash(computeSessionTokenHash("thetoken")).Return(session, nil)
+				render.EXPECT().Header().Return(responseHeaders)
+				render.EXPECT().Text(http.StatusUnauthorized, gomock.Any())
+
+				withAuthenticatedUser(render, request, db, logger, nil)
+
+				Expect(responseHeaders.Get("WWW-Authenticate")).To(Equal(`Basic realm="weather-thingy-data-service"`))
 			})
 		})
 	})
@@ -250,6 +309,39 @@ var _ = Describe("Authentication", func() {
 					db.EXPECT().BeginTransaction(),
 					db.EXPECT().CheckAgentIDExists(123).Return(true, nil),
 					db.EXPECT().GetAgentByID(123).Return(agent, nil),
+					db.EXPECT().UpdateAgentLastSeen(123, gomock.Any()).Do(func(agentID int, seen time.Time) {
+						Expect(seen).To(BeTemporally("~", time.Now(), time.Minute))
+					}),
+					db.EXPECT().CommitTransaction(),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				withAuthenticatedAgent(render, request, params, db, logger, context)
+
+				agentType := reflect.TypeOf(Agent{})
+				agentFromContext := context.Get(agentType)
+				Expect(agentFromContext.Interface().(Agent)).To(Equal(agent))
+			})
+		})
+
+		Context("when the token provided matches the agent's previous token and the grace period has not expired", func() {
+			It("does not render a response and sets the agent in the request context", func() {
+				params := martini.Params{"agent_id": "123"}
+				request.Header.Set("Authorization", "weather-thingy-agent-token theoldtoken")
+				context := NewTestContext()
+
+				agent := Agent{}
+				agent.SetToken("theoldtoken")
+				agent.RotateToken("thenewtoken", time.Hour, time.Now())
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(123).Return(true, nil),
+					db.EXPECT().GetAgentByID(123).Return(agent, nil),
+					db.EXPECT().UpdateAgentLastSeen(123, gomock.Any()).Do(func(agentID int, seen time.Time) {
+						Expect(seen).To(BeTemporally("~", time.Now(), time.Minute))
+					}),
+					db.EXPECT().CommitTransaction(),
 					db.EXPECT().RollbackUncommittedTransaction(),
 				)
 
@@ -260,5 +352,92 @@ var _ = Describe("Authentication", func() {
 				Expect(agentFromContext.Interface().(Agent)).To(Equal(agent))
 			})
 		})
+
+		Context("when the token provided matches the agent's previous token and the grace period has expired", func() {
+			It("returns HTTP 401 and sets the WWW-Authenticate header", func() {
+				params := martini.Params{"agent_id": "123"}
+				request.Header.Set("Authorization", "weather-thingy-agent-token theoldtoken")
+				responseHeaders := http.Header{}
+
+				agent := Agent{}
+				agent.SetToken("theoldtoken")
+				agent.RotateToken("thenewtoken", time.Hour, time.Now().Add(-2*time.Hour))
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(123).Return(true, nil),
+					db.EXPECT().GetAgentByID(123).Return(agent, nil),
+					render.EXPECT().Header().Return(responseHeaders),
+					render.EXPECT().Text(http.StatusUnauthorized, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				withAuthenticatedAgent(render, request, params, db, logger, nil)
+
+				Expect(responseHeaders.Get("WWW-Authenticate")).To(Equal(`weather-thingy-agent-token`))
+			})
+		})
+	})
+
+	Context("withWeatherStationAgent", func() {
+		It("authenticates the agent using the ID and password given with the data", func() {
+			var err error
+			request, err = http.NewRequest("GET", "/weatherstation/updateweatherstation.php?ID=123&PASSWORD=thetoken&tempf=71.6", nil)
+			Expect(err).To(BeNil())
+			context := NewTestContext()
+
+			agent := Agent{}
+			agent.SetToken("thetoken")
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(123).Return(true, nil),
+				db.EXPECT().GetAgentByID(123).Return(agent, nil),
+				db.EXPECT().UpdateAgentLastSeen(123, gomock.Any()),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			withWeatherStationAgent(render, request, db, logger, context)
+
+			agentFromContext := context.Get(reflect.TypeOf(Agent{}))
+			Expect(agentFromContext.Interface().(Agent)).To(Equal(agent))
+		})
+
+		It("returns HTTP 401 if the password does not match the agent's token", func() {
+			var err error
+			request, err = http.NewRequest("GET", "/weatherstation/updateweatherstation.php?ID=123&PASSWORD=wrong", nil)
+			Expect(err).To(BeNil())
+			responseHeaders := http.Header{}
+
+			agent := Agent{}
+			agent.SetToken("thetoken")
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(123).Return(true, nil),
+				db.EXPECT().GetAgentByID(123).Return(agent, nil),
+				render.EXPECT().Header().Return(responseHeaders),
+				render.EXPECT().Text(http.StatusUnauthorized, gomock.Any()),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			withWeatherStationAgent(render, request, db, logger, nil)
+		})
+
+		It("returns HTTP 401 if no ID is given", func() {
+			var err error
+			request, err = http.NewRequest("GET", "/weatherstation/updateweatherstation.php?PASSWORD=thetoken", nil)
+			Expect(err).To(BeNil())
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				render.EXPECT().Header().Return(http.Header{}),
+				render.EXPECT().Text(http.StatusUnauthorized, gomock.Any()),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			withWeatherStationAgent(render, request, db, logger, nil)
+		})
 	})
 })
@@ -221,6 +221,266 @@ func db_migrations_0008_agents_table_hash_token_sql() (*asset, error) {
 	return a, nil
 }
 
+var _db_migrations_0009_agents_table_add_metadata_and_deleted_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\xce\xbd\xca\xc2\x30\x18\xc5\xf1\x3d\x57\x71\xb6\x0e\x2f\xbd\x82\x4c\xe9\x9b\x88\x95\x7c\x94\xf6\x09\x82\x5b\x20\x0f\x45\x68\xab\x68\xc0\x41\xbc\x77\xa1\x93\x88\xd0\xf1\xc0\xe1\xc7\xbf\xae\xf1\x37\x9f\xc7\x5b\x2a\x8c\x78\x15\xca\x92\xe9\x41\xaa\xb1\x06\x69\xe4\xa5\xdc\xa1\xb4\xc6\x7f\xb0\xd1\x79\xcc\x5c\x52\x4e\x25\xe1\x30\x04\xdf\xc0\x07\x82\x8f\xd6\x42\x9b\x9d\x8a\x96\x50\x3d\x5f\x95\xdc\x30\x32\x4f\x5c\x38\x83\x5a\x67\x06\x52\xae\xc3\xb1\xa5\xfd\x3a\x71\x0a\xde\xac\xa2\x14\xe2\x33\x4c\x5f\x1e\xcb\x2f\x56\xf7\xa1\xfb\x6e\x93\x5b\xc7\xcc\x13\x17\xce\x52\xbc\x07\x00\x52\xd2\x30\x81\xfb\x00\x00\x00")
+
+func db_migrations_0009_agents_table_add_metadata_and_deleted_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0009_agents_table_add_metadata_and_deleted_sql,
+		"db/migrations/0009_agents_table_add_metadata_and_deleted.sql",
+	)
+}
+
+func db_migrations_0009_agents_table_add_metadata_and_deleted_sql() (*asset, error) {
+	bytes, err := db_migrations_0009_agents_table_add_metadata_and_deleted_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0009_agents_table_add_metadata_and_deleted.sql", size: 251, mode: os.FileMode(420), modTime: time.Unix(1792218396, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0010_agents_table_add_previous_token_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\xd0\xb1\x4a\x43\x31\x14\xc6\xf1\xfd\x3e\xc5\xb7\x55\x91\x82\xfb\x9d\x52\x13\xf1\x42\x6e\x52\xea\x09\xa2\x4b\xc9\x70\x68\x83\x9a\x84\x24\x6a\x1f\x5f\x7a\x27\x41\x41\x02\x1d\xcf\xf0\xfd\x0e\xfc\xd7\x6b\xdc\xbc\x87\x43\xf1\x8d\xe1\xf2\x20\x34\xa9\x1d\x48\x6c\xb4\x82\x3f\x70\x6c\x15\x42\x4a\xdc\x59\xed\x66\x83\x5c\xf8\x33\xa4\x8f\xba\x6f\xe9\x95\xe3\x3e\x34\x2e\xbe\x85\x14\x2b\x26\x43\x30\x96\x60\x9c\xd6\x90\xea\x5e\x38\x4d\xb8\xba\xbd\x1e\xfb\xc4\xea\xdf\x1a\x36\xcf\xa4\xc4\x6f\x6d\xb5\xea\xc4\x8e\xbe\x1e\x2f\x86\xf1\x29\x87\xc2\x15\x34\xcd\xea\x91\xc4\xbc\xc5\xd3\x44\x0f\xcb\x89\x17\x6b\xd4\xc2\x8f\xc3\xf0\xb3\xa7\x4c\x5f\xf1\xaf\x2f\x72\x67\xb7\xff\x26\x1d\x3b\x97\xe7\x74\xbd\x9b\x73\xa1\xde\x0d\x9f\x72\x28\x5c\xc7\xe1\x7b\x00\x25\xf2\x78\x38\x3a\x02\x00\x00")
+
+func db_migrations_0010_agents_table_add_previous_token_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0010_agents_table_add_previous_token_sql,
+		"db/migrations/0010_agents_table_add_previous_token.sql",
+	)
+}
+
+func db_migrations_0010_agents_table_add_previous_token_sql() (*asset, error) {
+	bytes, err := db_migrations_0010_agents_table_add_previous_token_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0010_agents_table_add_previous_token.sql", size: 570, mode: os.FileMode(420), modTime: time.Unix(1792218515, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0011_create_sessions_table_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x90\x41\x4b\xc3\x40\x14\x84\xef\xfb\x2b\xe6\xd8\xa2\xfd\x05\x3d\x6d\x93\x57\x5c\xdc\x6c\xe2\xcb\x5b\x24\x5e\x42\xb0\x8b\x0d\x62\x52\xf2\x22\xfa\xf3\x25\x45\xad\x78\xe9\x71\x98\xf9\xe6\xf0\x6d\x36\xb8\x79\xeb\x5f\xa6\x6e\x4e\x88\x27\x93\x31\x59\x21\x88\xdd\x79\x82\x26\xd5\x7e\x1c\x14\x2b\x83\x9f\xd0\xf6\x07\xd4\xc4\xce\x7a\x54\xec\x0a\xcb\x0d\xee\xa9\xb9\x35\xc0\xbb\xa6\x69\x69\x5d\x10\x84\x52\x10\xa2\xf7\x60\xda\x13\x53\xc8\xa8\x3e\xf7\x8a\xd5\xf7\x6c\xbd\x20\xf3\xf8\x9a\x86\xf6\xd8\xe9\x11\xbb\x46\xc8\x5e\xb8\x18\xdc\x43\xa4\x65\xf3\x3c\xa5\x6e\x4e\x07\x88\x2b\xa8\x16\x5b\x54\x78\x74\x72\x77\x8e\x78\x2a\x03\x5d\x98\x9c\xf6\x36\x7a\x41\x16\x99\x29\x48\xfb\x4b\x2c\x37\xe9\xf3\xd4\x4f\x49\xaf\xdf\x98\xf5\xd6\x98\xbf\x56\xf2\xf1\x63\x30\x39\x97\xd5\x3f\x2b\x5b\xf3\x35\x00\x42\x97\x13\xe9\x3c\x01\x00\x00")
+
+func db_migrations_0011_create_sessions_table_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0011_create_sessions_table_sql,
+		"db/migrations/0011_create_sessions_table.sql",
+	)
+}
+
+func db_migrations_0011_create_sessions_table_sql() (*asset, error) {
+	bytes, err := db_migrations_0011_create_sessions_table_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0011_create_sessions_table.sql", size: 316, mode: os.FileMode(420), modTime: time.Unix(1792218697, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0012_create_alert_tables_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\x94\x4f\x6f\xa3\x30\x10\xc5\xef\x7c\x8a\xb9\x35\x68\x5b\x29\xbb\xd7\x9c\x5c\x98\x6e\xd1\x12\x13\x19\xb3\xdb\xee\x05\xb9\xc1\x0a\x56\x89\xa9\x6c\x93\xaa\xdf\x7e\x65\x36\xb0\x41\xf9\xb7\x87\xde\x1c\xcd\x6f\xde\x4c\xde\x3c\x71\x77\x07\x5f\xb6\x6a\x63\x84\x93\x50\xbc\x05\x11\x43\xc2\x11\x38\xb9\x4f\x11\x44\x23\x8d\x2b\x4d\xd7\x48\x0b\xb3\x00\xc0\xbf\x4a\x55\x41\x8e\x2c\x21\x29\xac\x58\xb2\x24\xec\x19\x7e\xe0\xf3\x6d\x00\x20\x36\x52\x3b\x5f\x4e\x28\x07\x9a\x71\xa0\x45\x9a\x02\xc3\x07\x64\x48\x23\xcc\xff\x02\x16\x66\x03\x18\x42\x46\x21\xc6\x14\x39\x42\x44\xf2\x88\xc4\xe8\x75\x76\xc2\x28\xf1\xd2\xc8\x4b\x52\x03\x63\x61\x76\x80\x87\xbe\x7d\xdd\xea\x4a\x39\xd5\x6a\xf8\x49\x58\xf4\x48\xd8\xec\xdb\x3c\x1c\x45\x3c\xe1\x6a\x23\x6d\xdd\x36\x15\xc4\x59\xe1\xff\xe7\x8a\x61\x94\xe4\x49\x46\x27\x58\xfd\x61\x9d\x34\xd2\x2a\x7b\x9e\x83\x18\x1f\x48\x91\x72\x98\x7b\xe1\xad\xd2\x65\xd5\x19\xd1\x4f\xbf\x4f\xbe\x4f\xb6\x9f\x90\xef\xf2\xa5\x6e\xdb\xd7\xb2\x33\x0d\x70\x7c\xfa\x87\x79\x99\xb5\x91\xc2\xc9\x0a\x78\xb2\xc4\x9c\x93\xe5\x0a\x7e\x25\xfc\xb1\xff\x09\xbf\x33\x8a\xc7\x9a\x51\xc1\x18\x52\x5e\x8e\x1d\x5e\xc6\x3a\x7f\xd3\xc1\x84\xaf\xf3\xf0\xb8\xef\xa6\x7d\xbd\xf1\xe8\x9b\xd4\x95\xd2\x9b\xd2\x2a\xbd\x96\x17\xe6\xee\x17\x6c\x84\x75\xe5\x4e\x34\x9d\xfc\x5f\x67\xfa\x0e\xa7\xb6\x57\xc4\x83\x70\x11\x0c\x11\x4c\x68\x8c\x4f\x87\x11\x2c\xc7\x80\x65\x74\x1a\xcd\x31\x50\x8b\xe0\x54\x80\x6b\x65\x5d\x6b\x3e\xfa\x08\xef\xdf\xe7\x53\x6c\xba\xcb\xc9\x9b\x0c\xde\xc3\x67\x82\x2c\x77\x52\xbb\x93\x07\xf0\x83\xae\xf8\xe7\x91\x2b\x86\x7d\x6e\x64\xce\x79\x3f\x38\x36\x18\x93\xd1\x69\x61\x74\xe1\xb6\xdf\xd7\xab\x1c\x7e\x53\xe2\xf6\x5d\x07\x31\xcb\x56\xa7\x4e\xb2\x38\xae\x98\xae\x91\x76\x11\xfc\x19\x00\xf6\x68\x82\xd2\x97\x04\x00\x00")
+
+func db_migrations_0012_create_alert_tables_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0012_create_alert_tables_sql,
+		"db/migrations/0012_create_alert_tables.sql",
+	)
+}
+
+func db_migrations_0012_create_alert_tables_sql() (*asset, error) {
+	bytes, err := db_migrations_0012_create_alert_tables_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0012_create_alert_tables.sql", size: 1175, mode: os.FileMode(420), modTime: time.Unix(1792219261, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0013_agents_table_add_last_seen_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8f\xc1\x6a\x85\x30\x10\x45\xf7\x7e\xc5\xdd\x17\xa1\x7b\x57\xb1\x49\xdb\x40\x4c\xc4\x4e\x28\x74\x23\x41\x46\x09\xd8\x58\x4c\xa0\xbf\x5f\x70\xd5\xc7\x7b\xa0\xcb\x81\x33\x87\x7b\xea\x1a\x4f\xdf\x71\xd9\x43\x61\xf8\x9f\x4a\x18\x52\x03\x48\xb4\x46\x21\x2c\x9c\x4a\x86\x90\x12\x2f\xce\xf8\xce\x62\x0d\xb9\x8c\x99\x39\x81\x74\xa7\x3e\x48\x74\x3d\x3e\x35\xbd\x1f\x27\xbe\x9c\x55\xb0\xde\x98\xe6\x44\xb3\xcd\xf3\x1a\x13\x8f\x61\x2e\xbc\xa3\xd5\x6f\xda\x12\xac\xa3\xe3\x19\x52\xbd\x0a\x6f\x08\xcf\x57\x35\x39\xa6\x89\xcf\x16\x55\xff\x43\xe5\xf6\x9b\x1e\xc9\xe5\xe0\xfa\xbb\xd6\xe6\x8c\xbc\xc9\xb9\x4c\xe7\x98\x26\x6e\xaa\xbf\x01\x00\xc7\x99\x8c\xdb\x80\x01\x00\x00")
+
+func db_migrations_0013_agents_table_add_last_seen_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0013_agents_table_add_last_seen_sql,
+		"db/migrations/0013_agents_table_add_last_seen.sql",
+	)
+}
+
+func db_migrations_0013_agents_table_add_last_seen_sql() (*asset, error) {
+	bytes, err := db_migrations_0013_agents_table_add_last_seen_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0013_agents_table_add_last_seen.sql", size: 384, mode: os.FileMode(420), modTime: time.Unix(1792219509, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0014_add_variable_limits_and_data_quality_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\x90\xc1\x4a\x03\x31\x14\x45\xf7\xf3\x15\x6f\x37\x8a\x14\xc4\x6d\x56\x71\x12\xb1\x10\x93\x12\x13\xb7\xc3\xd3\xc6\x21\x92\x4e\xda\x34\xa9\xed\xdf\x4b\xbb\x4a\x11\x71\x94\xee\x0f\x87\x7b\xcf\x6c\x06\x37\x2b\x3f\x24\xcc\x0e\xec\xba\xa1\xc2\x70\x0d\x86\xde\x0b\x0e\x3b\x4c\x1e\x5f\x83\xdb\x02\x65\x0c\x3a\x25\xec\x93\x84\x95\x1f\xfb\x1d\x86\xe2\x80\x29\x7b\xa4\x16\x9a\x77\xf3\xe7\xb9\x92\x20\xad\x10\x64\x82\x01\xf7\x17\x30\x6c\xb3\x5b\xff\x5b\x10\x4b\xee\xe3\x7b\x9f\x70\x1c\x1c\xbc\x50\xdd\x3d\x52\x7d\x75\x77\x7b\x0d\x52\x99\xd3\x0d\x60\xfc\x81\x5a\x61\xa0\x4d\xee\xc3\xbd\xe5\xf6\x5c\xba\xc4\x8c\xb5\x6f\x53\x30\xf8\x7c\xf8\x45\x35\xc4\xb8\x6c\x49\xd3\xd4\xc9\x59\xfc\x1c\x7f\xd8\xcb\xb4\x5a\x7c\xab\x4e\xa6\xc0\xb8\xff\x1b\x7c\x6c\x39\x85\xad\xb3\x9d\xf3\xa7\x20\x35\xba\x29\x18\x7c\x3e\x90\xe6\x6b\x00\x69\xee\xd6\x6a\x60\x02\x00\x00")
+
+func db_migrations_0014_add_variable_limits_and_data_quality_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0014_add_variable_limits_and_data_quality_sql,
+		"db/migrations/0014_add_variable_limits_and_data_quality.sql",
+	)
+}
+
+func db_migrations_0014_add_variable_limits_and_data_quality_sql() (*asset, error) {
+	bytes, err := db_migrations_0014_add_variable_limits_and_data_quality_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0014_add_variable_limits_and_data_quality.sql", size: 608, mode: os.FileMode(420), modTime: time.Unix(1792220428, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0015_create_data_corrections_table_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\x03\x8d\x92\x4f\x4f\x83\x30\x18\x87\xef\x7c\x8a\xf7\x38\xe2\x96\x18\xaf\x3b\xd5\xf2\x2e\x6b\xe4\x5f\x4a\x51\xe7\x85\x54\x68\x48\x13\x06\xda\xc1\x8c\xdf\xde\x76\x0a\xce\x4c\x37\x0f\x1c\x9a\xf7\xe9\x43\x7f\xed\x6f\xb1\x80\xab\xad\xae\x8d\xec\x15\xe4\x2f\x1e\xe5\x48\x04\x82\x20\xb7\x21\x42\x25\x7b\x59\x94\x9d\x31\xaa\xec\x75\xd7\xee\x60\xe6\x01\x7c\xaf\x0b\x5d\x41\x86\x9c\x91\x10\x52\xce\x22\xc2\x37\x70\x87\x9b\xb9\x65\x64\xad\xda\xde\x8d\x59\x2c\x20\x4e\xec\x97\x87\x21\x70\x5c\x21\xc7\x98\x62\xf6\x09\x58\xdf\x08\xfa\x90\xc4\x10\x60\x88\xf6\xdf\x94\x64\x94\x04\xe8\x3c\x7b\x69\xb4\x7c\x6e\xd4\x39\xd5\xc8\x58\xdb\x11\xee\xbb\xed\xbd\xde\x2a\x10\x2c\xc2\x4c\x90\x28\x85\x07\x26\xd6\x87\x25\x3c\x25\x31\x4e\x32\x47\x76\x46\xd7\xba\x95\x4d\xb1\x97\xcd\xa0\x20\x48\x72\x97\x3f\xe5\x48\x59\xc6\xec\xd1\x7e\x65\x5f\x07\xd9\xe8\xfe\x1d\xee\x09\xa7\x6b\xc2\x67\x37\xd7\xfe\x0f\xf0\x1f\xae\x4b\x8a\x61\xa7\xcc\xb9\xf0\x6e\x6e\x83\x7f\x61\x87\xd0\xa5\x51\xf6\x2d\xab\xcb\xb9\xed\x7d\xaf\x48\x1e\x0a\xa0\x39\xb7\x3a\x51\x4c\x3b\x3c\x7f\xe9\x8d\x4d\x60\x71\x80\x8f\x27\x4d\x28\xa6\x17\xb6\x81\x4e\x6b\x32\x4e\xe7\xe3\x69\x9c\x70\x71\xd4\xb4\xa0\x7b\x6b\xbd\x80\x27\xe9\x1f\x4d\x5b\x7a\x1f\xac\xfd\x5f\xcd\x98\x02\x00\x00")
+
+func db_migrations_0015_create_data_corrections_table_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0015_create_data_corrections_table_sql,
+		"db/migrations/0015_create_data_corrections_table.sql",
+	)
+}
+
+func db_migrations_0015_create_data_corrections_table_sql() (*asset, error) {
+	bytes, err := db_migrations_0015_create_data_corrections_table_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0015_create_data_corrections_table.sql", size: 664, mode: os.FileMode(420), modTime: time.Unix(1792230900, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0016_data_table_store_values_as_double_precision_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\x03\x8d\x8e\xbb\x0a\xc2\x30\x00\x45\xf7\x7c\xc5\x1d\x15\xad\x28\xb8\x75\xd2\x36\x43\xa1\x2f\x6a\x32\x38\x46\x93\xda\x40\x9a\x88\x49\x2b\xfe\xbd\x2d\x5d\x1c\xdd\x2e\x87\xcb\xe1\x44\x11\x36\xbd\x7e\xbc\x44\x50\xe0\x4f\x72\xca\x19\x6d\xc0\x4e\xe7\x9c\x42\x8a\x20\xb0\x80\xa4\xca\x79\x51\x62\x14\x66\x50\x60\xd7\x9a\x22\xad\xf8\xfc\xa9\x1b\x9a\x64\x97\xac\x2a\x63\x42\xa2\x1f\x55\xea\xde\x76\x06\xac\xd3\x1e\xad\xd0\xc6\x43\xb7\x10\xf6\xb3\x38\x3c\x3a\x31\x2a\xdc\x94\xb2\xf0\xd3\x92\x08\x9d\x08\x90\x0e\xd6\x05\xb4\x3a\x40\xdb\x09\x29\x38\x23\x71\x77\x66\xe8\xed\xee\xff\xb6\x92\x17\xb4\xc9\x92\xd5\x61\xbf\xc5\x71\x1d\x93\x2f\x46\xf0\x4f\x6e\xe4\x00\x00\x00")
+
+func db_migrations_0016_data_table_store_values_as_double_precision_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0016_data_table_store_values_as_double_precision_sql,
+		"db/migrations/0016_data_table_store_values_as_double_precision.sql",
+	)
+}
+
+func db_migrations_0016_data_table_store_values_as_double_precision_sql() (*asset, error) {
+	bytes, err := db_migrations_0016_data_table_store_values_as_double_precision_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0016_data_table_store_values_as_double_precision.sql", size: 228, mode: os.FileMode(420), modTime: time.Unix(1792230900, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_sqlite_migrations_0001_create_tables_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xc4\x97\x51\x73\xa3\x36\x10\xc7\xdf\xfd\x29\xf4\xe6\x78\xea\x9b\xc9\xdd\xb4\x4f\x79\xc2\xb6\xee\xca\xd4\x81\x94\xe0\x4e\xee\x49\xa3\x83\x8d\xad\x06\x24\x2a\x89\xe4\x32\x9d\x7e\xf7\x8e\x30\x60\x04\x82\xd0\x9b\xcc\xf4\x0d\xb3\x7f\xd9\xbb\xff\xfd\xed\x2a\xf9\xf0\x01\xfd\x94\xb3\xa3\xa4\x1a\xd0\xa1\x58\x6c\x23\xec\xc5\x18\xc5\xde\x66\x8f\x51\xa9\x40\x2a\x74\xb5\x40\xd5\x13\x61\x29\xf2\x83\x18\x7f\xc1\x11\xba\x8b\xfc\x5b\x2f\xfa\x8a\x7e\xc3\x5f\x91\x77\x88\x43\x3f\xd8\x46\xf8\x16\x07\xf1\x7a\x81\x10\xe4\x94\x65\xe8\x0f\x2f\xda\xfe\xea\x45\x57\x9f\x7e\xf9\x79\x85\x82\x30\x46\xc1\x61\xbf\x47\x87\xc0\xff\xfd\x80\x8d\xaa\xa0\x4a\xbd\x08\x99\x12\xa6\x41\x52\xcd\x04\x57\xe6\xeb\x5b\xa9\xa5\x51\x34\xd3\x68\xb3\x0f\x37\xee\xf0\x89\xaa\xd3\x30\xcc\x14\xa1\x69\xce\x38\xda\x84\xe1\x1e\x7b\x41\x1b\x44\x3b\xfc\xd9\x3b\xec\x63\x74\x6d\x64\x89\x04\xaa\x21\x45\xb1\x7f\x8b\xef\x63\xef\xf6\xae\xd5\x2d\x56\x37\x0b\xdb\x11\x7a\x04\xae\xcf\x96\x54\x8f\xb3\x3d\xe1\x34\x87\xd6\x92\x8f\xd7\xd7\x17\x4b\x4c\x0a\xe2\x85\x83\x24\x1d\x93\xdb\x28\x8a\xf0\x67\x1c\xe1\x60\x8b\xef\x9b\x76\xd4\xb2\x95\x39\xa8\xc5\x13\xf0\x29\x07\xcf\x02\xb7\x7d\xe7\x98\xdb\xbb\x42\xc2\x33\x13\xa5\x22\x93\xbf\x60\x1b\xd9\x3b\x33\xfc\xd1\x56\xfe\xb0\x5c\x3a\x0e\x0c\x33\x79\xe3\x00\x7c\x2f\x98\x04\xd5\xed\x5c\x9d\x7e\x0e\x9a\xa6\x54\x53\x14\xe3\x07\x47\xba\xcb\xbf\xff\x59\x4e\xb7\xde\x44\x53\xc8\xa0\x17\xad\x23\x19\x55\x9a\x28\x00\xee\x88\x89\xc7\xc7\x8c\x71\x20\xf4\x51\x83\x44\x1b\xff\xcb\xb8\x61\x8d\x54\x31\x9e\x40\xef\xab\x86\xec\x3d\x53\xc9\xe8\xb7\x0c\xce\xf8\x35\x9f\xde\x81\xc0\xce\x50\x96\x9c\x69\xd5\xaa\x3e\xf5\x30\x4d\x99\x2a\x32\xfa\x4a\x52\x48\x58\x4e\x33\x52\x64\x34\x81\x21\x73\xd3\xae\xe6\x8c\x93\x67\x9a\x95\x80\x76\xe1\xc1\xd4\x75\x17\xe1\xad\x7f\xef\x87\xc1\x45\x42\xbf\xcf\x91\x28\x0d\xc5\xb8\x42\x94\x9a\x88\x47\x22\x29\x3f\x82\xb3\xa2\x0b\x0d\x12\xfe\x84\x44\x2f\x87\x96\x57\x08\xf5\x87\xdd\x39\x9b\xcd\x62\x68\x84\xab\xf5\xb0\x47\xce\x83\x8d\x46\xa1\xab\x8e\xbc\x3a\xae\x59\x6e\x51\x51\x9f\x36\xa1\x31\x77\x3a\x92\xbf\x4a\x9a\x31\xfd\xfa\x46\xe9\x47\x21\xd2\x6a\x14\xba\xec\xb4\x55\xac\xbb\x25\xac\xab\x84\x56\x43\x97\x14\x28\x55\xad\x06\xe3\x54\xfd\x61\x36\x96\x3f\xbc\xf4\x1c\xdb\xe2\xc2\xf1\x34\x82\x8e\xbd\x51\x47\x87\xc5\xd1\x0c\xa4\x26\xb2\x6c\xe6\xce\x3c\xcd\x2e\xae\xf1\x71\x3e\x36\x28\x0c\xd0\x0e\xef\x71\x8c\xd1\xd6\xbb\xdf\x7a\x3b\xfc\x0e\x20\x25\x82\xa7\xcc\xdc\x0f\x4e\x18\x8c\x42\x9f\x24\xa8\x93\xc8\xd2\x69\xa6\x4e\xaf\x4a\x83\x04\xc5\xd4\xb8\xae\x65\xeb\xba\x99\xf6\xb4\x3c\xdf\x4e\xd3\xcb\xf0\x05\xbe\x9d\x84\x78\x22\xa5\xcc\xec\xad\xfd\x76\x3f\x95\x36\x7f\xbd\x34\xb5\x7d\x74\x82\x2e\x9e\x2a\xcc\x0b\xe0\x29\xe3\x47\xe7\xca\x6d\x37\xfb\x1b\xd3\x65\xe7\x5d\x9d\xe8\x8f\x6a\x0f\x25\x3f\xd8\xe1\x87\x2e\x4a\xa4\x25\x23\x0c\x6c\xc4\x5a\x12\xdc\x20\x9e\x98\xd2\x42\xbe\x56\x28\xd6\xcf\xb3\x69\xec\xa0\xeb\x24\xc8\xca\xa3\x16\x8f\x00\x09\xcf\xc0\xb5\xd3\xf1\x99\xdb\x69\x62\xb7\x8d\xf7\x7a\xcc\xd2\xc6\x88\xa6\xc0\x30\xb0\x03\x6d\x35\xf5\x0a\xeb\x5b\x6b\xd6\x3c\x49\x84\x94\x90\x18\x4e\xcf\x83\x7e\xf9\x3c\xdb\xe0\xb6\xa9\xff\xef\xb8\x4f\x78\x2b\x24\x3b\x32\x4e\xb3\xd1\xeb\xd5\xa5\x9d\xba\x49\xde\xe1\x32\xfa\xc1\x5b\xe0\xbf\x60\xd2\x6f\xb0\x35\x7e\xc3\xee\x37\xd1\x75\xb3\x77\x0c\x77\xdd\x7f\x94\x76\xe2\x85\x2f\x76\x51\x78\x37\x02\xd0\x4d\x37\x68\xa1\xe8\x88\x18\x36\x95\xf5\xbe\xb9\x50\xad\x97\x26\x4d\xeb\x45\x4b\x80\xf5\xb6\xca\xdd\x3e\x59\x2a\x90\xea\x66\xf1\xef\x00\xa4\xe3\xd5\x08\xea\x0d\x00\x00")
+
+func db_sqlite_migrations_0001_create_tables_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_sqlite_migrations_0001_create_tables_sql,
+		"db/sqlite-migrations/0001_create_tables.sql",
+	)
+}
+
+func db_sqlite_migrations_0001_create_tables_sql() (*asset, error) {
+	bytes, err := db_sqlite_migrations_0001_create_tables_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/sqlite-migrations/0001_create_tables.sql", size: 3562, mode: os.FileMode(420), modTime: time.Unix(1792221015, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0017_create_data_rollup_tables_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xec\x93\xc1\x6e\xf2\x30\x10\x84\xef\x7e\x8a\x3d\x12\xfd\xf0\x04\x39\xf9\xb7\xb7\xaa\x55\xc7\x8e\x1c\x47\x15\xbd\x44\xdb\x26\xa2\x96\x20\x54\x21\x40\x79\xfb\x2a\x49\x0b\x41\xd0\x8a\x53\x4f\x3d\xda\xfa\x66\xb5\x3b\xa3\x99\xcd\xe0\xdf\x2a\x2c\x1a\x6a\x2b\xc8\xdf\x18\xd7\x1e\x1d\x78\xfe\x5f\x23\xec\xa8\x09\xf4\xbc\xac\x36\xc0\xa5\x04\x61\x75\x9e\x18\x68\x68\x5f\x94\xd4\x52\x51\xd2\x61\x03\xca\x78\x30\xb9\xd6\x31\x63\xc2\x21\xf7\xf8\x29\xed\x89\xd7\xf5\xb6\x59\x1e\x60\xc2\x00\x68\x51\xd5\x6d\x11\xca\x41\x60\x07\x11\x38\xbc\x43\x87\x46\x60\x36\x00\x1b\x98\x7c\x81\x11\x58\x03\x12\x35\x7a\x04\xc1\x33\xc1\x25\x4e\x19\x1c\x57\xfa\x69\xd4\x69\xed\xc9\x08\x8f\x3a\x79\x1b\x56\x15\x78\x95\x60\xe6\x79\x92\xc2\xa3\xf2\xf7\xfd\x13\x9e\xac\xc1\xe3\xb0\x8e\x5c\x85\xba\xd8\xd1\x72\x5b\x81\xb4\x79\x67\x46\xea\x50\xa8\x4c\x59\x73\x8e\xd1\xfb\x2d\x18\xed\x16\xb7\x60\x2f\xeb\x6d\xdd\x9e\xdd\xd5\xfd\xa6\x4e\x25\xdc\xcd\xe1\x01\xe7\x27\x7f\xa6\x63\x2b\xa6\xfd\x61\x11\x8b\xae\xc6\x50\x52\xf8\x4b\xe1\x97\x53\x18\xb7\x4a\xae\xf7\x35\x93\xce\xa6\x17\xa1\xc4\x17\xdf\x43\x65\xe2\x6f\x6a\xd8\xd3\xd7\x7a\x18\xb3\x8f\x01\x00\xd6\xb1\x04\x71\xc7\x03\x00\x00")
+
+func db_migrations_0017_create_data_rollup_tables_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0017_create_data_rollup_tables_sql,
+		"db/migrations/0017_create_data_rollup_tables.sql",
+	)
+}
+
+func db_migrations_0017_create_data_rollup_tables_sql() (*asset, error) {
+	bytes, err := db_migrations_0017_create_data_rollup_tables_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0017_create_data_rollup_tables.sql", size: 967, mode: os.FileMode(420), modTime: time.Unix(1792222041, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_sqlite_migrations_0002_create_data_rollup_tables_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xec\x93\xcd\x4e\xc3\x30\x10\x84\xef\x7e\x8a\x3d\x36\xa2\x7d\x82\x9c\x8c\xbd\x48\x11\x8e\x1d\x39\xce\xa1\xa7\x68\x21\x51\xb1\xd4\xa6\x28\x7f\xa5\x6f\x8f\x92\x40\x9b\xaa\x05\xf5\xc2\x8d\xeb\xfa\x1b\x6b\x67\x56\xb3\x5a\xc1\xc3\xce\x6f\x6a\x6a\x4b\xc8\xde\x19\x57\x0e\x2d\x38\xfe\xa8\x10\x7a\xaa\x3d\xbd\x6c\xcb\x06\xb8\x94\x20\x8c\xca\x62\x0d\x35\x1d\xf2\x82\x5a\xca\x0b\x3a\x36\x10\x69\x07\x3a\x53\x2a\x64\x4c\x58\xe4\x0e\xbf\xa4\x23\xf1\xb6\xef\xea\xed\x11\x16\x0c\x80\x36\x65\xd5\xe6\xbe\x98\x04\x66\x12\x81\xc5\x27\xb4\xa8\x05\xa6\x13\xd0\xc0\xe2\x1b\x0c\xc0\x68\x90\xa8\xd0\x21\x08\x9e\x0a\x2e\x71\xc9\xe0\xb4\xd2\x6f\x5f\x9d\xd7\x5e\xcc\xf0\x60\x90\xb7\x7e\x57\x82\x8b\x62\x4c\x1d\x8f\x93\x93\x7a\x78\xda\xf9\x2a\xef\x69\xdb\x95\x20\x4d\x36\xb8\x4f\x2c\x8a\x28\x8d\x8c\xbe\xc4\xe8\xe3\x1e\x8c\xfa\xcd\x3d\xd8\xeb\xbe\xab\xda\x0b\x23\xc3\x34\xb1\x51\xcc\xed\x1a\x9e\x71\x7d\x0e\x64\x39\xf7\xbe\x1c\x9d\x04\x2c\xb8\x99\x7b\x41\xfe\x3f\xf6\xbf\x8e\x7d\xde\x1b\xb9\x3f\x54\x4c\x5a\x93\x5c\x5d\x21\xbc\x1a\x4f\xa5\x08\x7f\x28\xda\x48\xdf\x6a\x5a\xc8\x3e\x07\x00\xed\x38\x97\x9b\xa9\x03\x00\x00")
+
+func db_sqlite_migrations_0002_create_data_rollup_tables_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_sqlite_migrations_0002_create_data_rollup_tables_sql,
+		"db/sqlite-migrations/0002_create_data_rollup_tables.sql",
+	)
+}
+
+func db_sqlite_migrations_0002_create_data_rollup_tables_sql() (*asset, error) {
+	bytes, err := db_sqlite_migrations_0002_create_data_rollup_tables_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/sqlite-migrations/0002_create_data_rollup_tables.sql", size: 937, mode: os.FileMode(420), modTime: time.Unix(1792222041, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_migrations_0018_data_table_add_sequence_number_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x91\x4d\x6e\xc2\x30\x14\x84\xf7\x3e\xc5\xec\x00\x95\xf4\x02\x59\x05\xec\x56\x48\xae\x69\xc1\x91\xba\x8b\x5c\x78\x25\x96\x12\x87\xda\x8f\xbf\xdb\x57\x0a\x42\xaa\x50\xaa\x76\xff\xcd\xe7\x19\xbf\x2c\xc3\x43\xeb\x77\xd1\x31\xa1\xdc\x8b\x2c\x83\x3a\xfb\xc4\x3e\xec\xb0\xef\x7c\xe0\x04\x17\x09\x0d\x7d\x32\x4e\x9e\xeb\xee\xc0\x70\x48\xf4\x75\xa0\xb0\x21\x84\x43\xfb\x41\x71\x8a\x53\xed\x37\x35\xba\xd0\x5c\xd0\x92\x0b\x09\x5c\x3b\x06\xd7\x74\xe9\xe3\x81\x8e\x14\x11\x69\xdf\xb8\x0b\x6d\xc1\x1d\x12\x47\x72\x6d\x7a\x14\xf3\x95\x2a\xac\xc2\x5a\xbd\x95\xca\xcc\x15\xb6\x8e\x5d\x75\xf3\x57\x57\x7f\x2e\x0a\x6d\xd5\x0a\xb6\x98\xe9\x2b\x81\x42\x4a\xcc\x97\xba\x7c\x31\xb8\x83\x31\x5b\x3c\x2f\x8c\x85\x29\xb5\x1e\x0a\xf6\xa6\x5f\xa2\x6b\x65\x21\xd5\x53\x51\x6a\x8b\x40\x67\x3e\xba\x66\x3c\x1a\x6a\x34\x9a\xe4\xb7\xe6\x0b\x23\xd5\x7b\x5f\xaa\x72\x3b\x0a\x5c\xf9\xed\x3d\x8d\xa5\xe9\x01\x8c\x6f\xc4\xf4\xfe\xe9\x49\x2e\xc4\xcf\x53\xc8\xee\x14\x84\x5c\x2d\x5f\xff\xe3\x1f\x98\xd9\x47\x87\x57\xe6\x57\xef\x1f\x3f\xfe\x3d\x00\x0f\x6f\x13\xa5\x18\x02\x00\x00")
+
+func db_migrations_0018_data_table_add_sequence_number_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_migrations_0018_data_table_add_sequence_number_sql,
+		"db/migrations/0018_data_table_add_sequence_number.sql",
+	)
+}
+
+func db_migrations_0018_data_table_add_sequence_number_sql() (*asset, error) {
+	bytes, err := db_migrations_0018_data_table_add_sequence_number_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/migrations/0018_data_table_add_sequence_number.sql", size: 536, mode: os.FileMode(420), modTime: time.Unix(1792229763, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _db_sqlite_migrations_0003_data_table_add_sequence_number_sql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x91\x41\x4f\xc2\x40\x10\x85\xef\xfb\x2b\xde\x11\x22\x35\xde\x7b\xaa\x74\x63\x48\x6a\x6b\x4a\x6b\xbc\x35\x23\x8c\x74\x93\x76\x8b\xbb\x53\x90\x7f\x6f\x28\xc1\x1a\x50\xe3\x79\xdf\xf7\xcd\xcb\xdb\x20\xc0\x4d\x6b\x36\x8e\x84\x51\x6e\x55\x10\x40\x7f\x18\x2f\xc6\x6e\xb0\xed\x8c\x15\x0f\x72\x8c\x86\xdf\x04\x7b\x23\x75\xd7\x0b\x08\x9e\xdf\x7b\xb6\x2b\x86\xed\xdb\x57\x76\x33\xec\x6b\xb3\xaa\xd1\xd9\xe6\x80\x96\xc9\x7a\x48\x4d\x02\xa9\xf9\x30\xe0\x96\x77\xec\xe0\x78\xdb\xd0\x81\xd7\x90\x0e\x5e\x1c\x53\xeb\x6f\x55\x94\x14\x3a\x47\x11\xdd\x27\x1a\x6b\x12\x42\x14\xc7\x98\x67\x49\xf9\x98\x7e\x9d\xa9\x4e\x67\xb0\x48\x0b\xfd\xa0\x73\xa4\x65\x92\x84\x6a\x9e\xeb\xa8\xd0\x58\xa4\xb1\x7e\x19\xd0\x8a\x36\x6c\xa5\x32\xeb\xea\x12\xcc\xd2\x21\x80\xc9\x39\x31\xbb\x74\x4f\x43\x75\x16\x8e\x5d\xae\x3c\x13\x05\x34\xe4\xa5\xda\x51\xd3\xf3\xd8\x27\x2b\x86\x4e\xea\x68\x59\xa4\x4b\x9d\x17\xc7\xb7\xec\x17\xc9\x68\x98\xe2\x39\x4a\x4a\xbd\xc4\xe4\xee\x88\x7e\xff\x8b\xb8\xdb\x5b\x15\xe7\xd9\xd3\x1f\x7d\xc2\x53\xe0\x1f\x0b\x84\xd7\x3b\x0f\xe8\xcf\x43\x87\xea\x73\x00\x77\xf0\xde\x31\x16\x02\x00\x00")
+
+func db_sqlite_migrations_0003_data_table_add_sequence_number_sql_bytes() ([]byte, error) {
+	return bindata_read(
+		_db_sqlite_migrations_0003_data_table_add_sequence_number_sql,
+		"db/sqlite-migrations/0003_data_table_add_sequence_number.sql",
+	)
+}
+
+func db_sqlite_migrations_0003_data_table_add_sequence_number_sql() (*asset, error) {
+	bytes, err := db_sqlite_migrations_0003_data_table_add_sequence_number_sql_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "db/sqlite-migrations/0003_data_table_add_sequence_number.sql", size: 534, mode: os.FileMode(420), modTime: time.Unix(1792229763, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -273,14 +533,27 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
-	"db/migrations/0001_create_agents_table.sql":                        db_migrations_0001_create_agents_table_sql,
-	"db/migrations/0002_create_variables_table.sql":                     db_migrations_0002_create_variables_table_sql,
-	"db/migrations/0003_create_data_table.sql":                          db_migrations_0003_create_data_table_sql,
-	"db/migrations/0004_variables_table_add_display_decimal_places.sql": db_migrations_0004_variables_table_add_display_decimal_places_sql,
-	"db/migrations/0005_agents_table_enforce_name_not_null.sql":         db_migrations_0005_agents_table_enforce_name_not_null_sql,
-	"db/migrations/0006_create_users_table.sql":                         db_migrations_0006_create_users_table_sql,
-	"db/migrations/0007_agents_table_add_token.sql":                     db_migrations_0007_agents_table_add_token_sql,
-	"db/migrations/0008_agents_table_hash_token.sql":                    db_migrations_0008_agents_table_hash_token_sql,
+	"db/migrations/0001_create_agents_table.sql":                         db_migrations_0001_create_agents_table_sql,
+	"db/migrations/0002_create_variables_table.sql":                      db_migrations_0002_create_variables_table_sql,
+	"db/migrations/0003_create_data_table.sql":                           db_migrations_0003_create_data_table_sql,
+	"db/migrations/0004_variables_table_add_display_decimal_places.sql":  db_migrations_0004_variables_table_add_display_decimal_places_sql,
+	"db/migrations/0005_agents_table_enforce_name_not_null.sql":          db_migrations_0005_agents_table_enforce_name_not_null_sql,
+	"db/migrations/0006_create_users_table.sql":                          db_migrations_0006_create_users_table_sql,
+	"db/migrations/0007_agents_table_add_token.sql":                      db_migrations_0007_agents_table_add_token_sql,
+	"db/migrations/0008_agents_table_hash_token.sql":                     db_migrations_0008_agents_table_hash_token_sql,
+	"db/migrations/0009_agents_table_add_metadata_and_deleted.sql":       db_migrations_0009_agents_table_add_metadata_and_deleted_sql,
+	"db/migrations/0010_agents_table_add_previous_token.sql":             db_migrations_0010_agents_table_add_previous_token_sql,
+	"db/migrations/0011_create_sessions_table.sql":                       db_migrations_0011_create_sessions_table_sql,
+	"db/migrations/0012_create_alert_tables.sql":                         db_migrations_0012_create_alert_tables_sql,
+	"db/migrations/0013_agents_table_add_last_seen.sql":                  db_migrations_0013_agents_table_add_last_seen_sql,
+	"db/migrations/0014_add_variable_limits_and_data_quality.sql":        db_migrations_0014_add_variable_limits_and_data_quality_sql,
+	"db/migrations/0015_create_data_corrections_table.sql":               db_migrations_0015_create_data_corrections_table_sql,
+	"db/migrations/0016_data_table_store_values_as_double_precision.sql": db_migrations_0016_data_table_store_values_as_double_precision_sql,
+	"db/migrations/0017_create_data_rollup_tables.sql":                   db_migrations_0017_create_data_rollup_tables_sql,
+	"db/migrations/0018_data_table_add_sequence_number.sql":              db_migrations_0018_data_table_add_sequence_number_sql,
+	"db/sqlite-migrations/0001_create_tables.sql":                        db_sqlite_migrations_0001_create_tables_sql,
+	"db/sqlite-migrations/0002_create_data_rollup_tables.sql":            db_sqlite_migrations_0002_create_data_rollup_tables_sql,
+	"db/sqlite-migrations/0003_data_table_add_sequence_number.sql":       db_sqlite_migrations_0003_data_table_add_sequence_number_sql,
 }
 
 // AssetDir returns the file names below a certain
@@ -326,14 +599,29 @@ type _bintree_t struct {
 var _bintree = &_bintree_t{nil, map[string]*_bintree_t{
 	"db": &_bintree_t{nil, map[string]*_bintree_t{
 		"migrations": &_bintree_t{nil, map[string]*_bintree_t{
-			"0001_create_agents_table.sql":                        &_bintree_t{db_migrations_0001_create_agents_table_sql, map[string]*_bintree_t{}},
-			"0002_create_variables_table.sql":                     &_bintree_t{db_migrations_0002_create_variables_table_sql, map[string]*_bintree_t{}},
-			"0003_create_data_table.sql":                          &_bintree_t{db_migrations_0003_create_data_table_sql, map[string]*_bintree_t{}},
-			"0004_variables_table_add_display_decimal_places.sql": &_bintree_t{db_migrations_0004_variables_table_add_display_decimal_places_sql, map[string]*_bintree_t{}},
-			"0005_agents_table_enforce_name_not_null.sql":         &_bintree_t{db_migrations_0005_agents_table_enforce_name_not_null_sql, map[string]*_bintree_t{}},
-			"0006_create_users_table.sql":                         &_bintree_t{db_migrations_0006_create_users_table_sql, map[string]*_bintree_t{}},
-			"0007_agents_table_add_token.sql":                     &_bintree_t{db_migrations_0007_agents_table_add_token_sql, map[string]*_bintree_t{}},
-			"0008_agents_table_hash_token.sql":                    &_bintree_t{db_migrations_0008_agents_table_hash_token_sql, map[string]*_bintree_t{}},
+			"0001_create_agents_table.sql":                         &_bintree_t{db_migrations_0001_create_agents_table_sql, map[string]*_bintree_t{}},
+			"0002_create_variables_table.sql":                      &_bintree_t{db_migrations_0002_create_variables_table_sql, map[string]*_bintree_t{}},
+			"0003_create_data_table.sql":                           &_bintree_t{db_migrations_0003_create_data_table_sql, map[string]*_bintree_t{}},
+			"0004_variables_table_add_display_decimal_places.sql":  &_bintree_t{db_migrations_0004_variables_table_add_display_decimal_places_sql, map[string]*_bintree_t{}},
+			"0005_agents_table_enforce_name_not_null.sql":          &_bintree_t{db_migrations_0005_agents_table_enforce_name_not_null_sql, map[string]*_bintree_t{}},
+			"0006_create_users_table.sql":                          &_bintree_t{db_migrations_0006_create_users_table_sql, map[string]*_bintree_t{}},
+			"0007_agents_table_add_token.sql":                      &_bintree_t{db_migrations_0007_agents_table_add_token_sql, map[string]*_bintree_t{}},
+			"0008_agents_table_hash_token.sql":                     &_bintree_t{db_migrations_0008_agents_table_hash_token_sql, map[string]*_bintree_t{}},
+			"0009_agents_table_add_metadata_and_deleted.sql":       &_bintree_t{db_migrations_0009_agents_table_add_metadata_and_deleted_sql, map[string]*_bintree_t{}},
+			"0010_agents_table_add_previous_token.sql":             &_bintree_t{db_migrations_0010_agents_table_add_previous_token_sql, map[string]*_bintree_t{}},
+			"0011_create_sessions_table.sql":                       &_bintree_t{db_migrations_0011_create_sessions_table_sql, map[string]*_bintree_t{}},
+			"0012_create_alert_tables.sql":                         &_bintree_t{db_migrations_0012_create_alert_tables_sql, map[string]*_bintree_t{}},
+			"0013_agents_table_add_last_seen.sql":                  &_bintree_t{db_migrations_0013_agents_table_add_last_seen_sql, map[string]*_bintree_t{}},
+			"0014_add_variable_limits_and_data_quality.sql":        &_bintree_t{db_migrations_0014_add_variable_limits_and_data_quality_sql, map[string]*_bintree_t{}},
+			"0015_create_data_corrections_table.sql":               &_bintree_t{db_migrations_0015_create_data_corrections_table_sql, map[string]*_bintree_t{}},
+			"0016_data_table_store_values_as_double_precision.sql": &_bintree_t{db_migrations_0016_data_table_store_values_as_double_precision_sql, map[string]*_bintree_t{}},
+			"0017_create_data_rollup_tables.sql":                   &_bintree_t{db_migrations_0017_create_data_rollup_tables_sql, map[string]*_bintree_t{}},
+			"0018_data_table_add_sequence_number.sql":              &_bintree_t{db_migrations_0018_data_table_add_sequence_number_sql, map[string]*_bintree_t{}},
+		}},
+		"sqlite-migrations": &_bintree_t{nil, map[string]*_bintree_t{
+			"0001_create_tables.sql":                  &_bintree_t{db_sqlite_migrations_0001_create_tables_sql, map[string]*_bintree_t{}},
+			"0002_create_data_rollup_tables.sql":      &_bintree_t{db_sqlite_migrations_0002_create_data_rollup_tables_sql, map[string]*_bintree_t{}},
+			"0003_data_table_add_sequence_number.sql": &_bintree_t{db_sqlite_migrations_0003_data_table_add_sequence_number_sql, map[string]*_bintree_t{}},
 		}},
 	}},
 }}
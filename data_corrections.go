@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/binding"
+	"github.com/martini-contrib/render"
+)
+
+// PatchDataPoint is the body of a request to change a single data point, identified by its variable and time. A point
+// given a new value is marked as corrected, unless a quality is also given.
+type PatchDataPoint struct {
+	Variable string    `json:"variable" binding:"required"`
+	Time     time.Time `json:"time"`
+	Value    *float64  `json:"value"`
+	Quality  *string   `json:"quality"`
+}
+
+// DataCorrection records a change made by hand to a data point, and what the point was before the change.
+type DataCorrection struct {
+	CorrectionID    int       `json:"id"`
+	AgentID         int       `json:"-"`
+	VariableID      int       `json:"variableId"`
+	Time            time.Time `json:"time"`
+	OriginalValue   float64   `json:"originalValue"`
+	OriginalQuality string    `json:"originalQuality"`
+	Value           float64   `json:"value"`
+	Quality         string    `json:"quality"`
+	UserID          int       `json:"userId"`
+	Created         time.Time `json:"created"`
+}
+
+func patchDataPoint(r render.Render, patch PatchDataPoint, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	variableID, err := db.GetVariableIDForName(patch.Variable)
+
+	if err != nil && variableID != -1 {
+		log.WithError(err).Error("Could not get variable ID.")
+		r.Error(http.StatusInternalServerError)
+		return
+	} else if variableID == -1 {
+		r.Text(http.StatusBadRequest, fmt.Sprintf("Could not find variable with name '%v'.", patch.Variable))
+		return
+	}
+
+	point, found, err := db.GetDataPoint(agentID, variableID, patch.Time)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get data point.")
+		r.Error(http.StatusInternalServerError)
+		return
+	} else if !found {
+		r.Text(http.StatusNotFound, "There is no value for this variable at this time.")
+		return
+	}
+
+	correction := DataCorrection{
+		AgentID:         agentID,
+		VariableID:      variableID,
+		Time:            point.Time.UTC(),
+		OriginalValue:   point.Value,
+		OriginalQuality: point.QualityFlag(),
+		UserID:          user.UserID,
+		Created:         time.Now(),
+	}
+
+	patch.ApplyTo(&point)
+	correction.Value = point.Value
+	correction.Quality = point.QualityFlag()
+
+	if _, err := db.AddDataPoint(point, conflictPolicyOverwrite); err != nil {
+		log.WithError(err).Error("Could not save data.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.AddDataCorrection(&correction); err != nil {
+		log.WithError(err).Error("Could not save data correction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.JSON(http.StatusOK, correction)
+}
+
+func getDataCorrections(r render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, r, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	corrections, err := db.GetDataCorrections(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get data corrections.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.JSON(http.StatusOK, corrections)
+}
+
+func (patch PatchDataPoint) ApplyTo(point *DataPoint) {
+	if patch.Value != nil {
+		point.Value = *patch.Value
+		point.Quality = dataQualityCorrected
+	}
+
+	if patch.Quality != nil {
+		point.Quality = *patch.Quality
+	}
+}
+
+func (patch PatchDataPoint) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	if patch.Time.IsZero() {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"time"},
+			Classification: binding.RequiredError,
+			Message:        "Must provide the time of the data point.",
+		})
+	}
+
+	if patch.Value == nil && patch.Quality == nil {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"value", "quality"},
+			Classification: binding.RequiredError,
+			Message:        "Must provide a new value or quality.",
+		})
+	}
+
+	if patch.Quality != nil && !containsString(dataQualities, *patch.Quality) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"quality"},
+			Classification: "InvalidValue",
+			Message:        fmt.Sprintf("quality must be one of: %v.", strings.Join(dataQualities, ", ")),
+		})
+	}
+
+	return errors
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/golang/mock/gomock"
+	"github.com/martini-contrib/binding"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Data corrections", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var render *MockRender
+
+	user := User{UserID: 1000}
+	dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+	params := martini.Params{"agent_id": "1234"}
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		render = NewMockRender(mockController)
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	expectOwnedAgent := func(owner int) []*gomock.Call {
+		return []*gomock.Call{
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().CheckAgentIDExists(1234).Return(true, nil),
+			db.EXPECT().GetAgentByID(1234).Return(Agent{AgentID: 1234, OwnerUserID: owner}, nil),
+		}
+	}
+
+	float64Value := func(value float64) *float64 {
+		return &value
+	}
+
+	stringValue := func(value string) *string {
+		return &value
+	}
+
+	Describe("data structure", func() {
+		It("can be serialised to JSON", func() {
+			correction := DataCorrection{CorrectionID: 7, AgentID: 1234, VariableID: 12, Time: dataTime, OriginalValue: 850, OriginalQuality: "suspect", Value: 21.5, Quality: "corrected", UserID: 1000, Created: dataTime.Add(time.Hour)}
+
+			bytes, err := json.Marshal(correction)
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(MatchJSON(`{"id":7,"variableId":12,"time":"2015-05-06T10:15:30Z","originalValue":850,"originalQuality":"suspect",` +
+				`"value":21.5,"quality":"corrected","userId":1000,"created":"2015-05-06T11:15:30Z"}`))
+		})
+
+		Describe("validation", func() {
+			It("succeeds if a new value is given", func() {
+				errors := TestValidation(`{"variable":"temperature", "time":"2015-05-06T10:15:30Z", "value":21.5}`, PatchDataPoint{})
+				Expect(errors).To(BeEmpty())
+			})
+
+			It("succeeds if a new quality is given", func() {
+				errors := TestValidation(`{"variable":"temperature", "time":"2015-05-06T10:15:30Z", "quality":"bad"}`, PatchDataPoint{})
+				Expect(errors).To(BeEmpty())
+			})
+
+			DescribeTable("it fails if the data is invalid", func(body string, classification string, fieldNames ...string) {
+				errors := TestValidation(body, PatchDataPoint{})
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[0].Classification).To(Equal(classification))
+				Expect(errors[0].FieldNames).To(Equal(fieldNames))
+			},
+				Entry("because the variable is missing", `{"time":"2015-05-06T10:15:30Z", "value":21.5}`, binding.RequiredError, "variable"),
+				Entry("because the time is missing", `{"variable":"temperature", "value":21.5}`, binding.RequiredError, "time"),
+				Entry("because neither a value nor a quality is given", `{"variable":"temperature", "time":"2015-05-06T10:15:30Z"}`, binding.RequiredError, "value", "quality"),
+				Entry("because the quality is not valid", `{"variable":"temperature", "time":"2015-05-06T10:15:30Z", "quality":"great"}`, "InvalidValue", "quality"),
+			)
+		})
+	})
+
+	Describe("PATCH request handler", func() {
+		makeRequest := func(patch PatchDataPoint) {
+			patchDataPoint(render, patch, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		DescribeTable("updates the point and records the original value",
+			func(patch PatchDataPoint, expectedValue float64, expectedQuality string) {
+				var correction DataCorrection
+
+				gomock.InOrder(append(expectOwnedAgent(user.UserID),
+					db.EXPECT().GetVariableIDForName("temperature").Return(12, nil),
+					db.EXPECT().GetDataPoint(1234, 12, dataTime).Return(DataPoint{AgentID: 1234, VariableID: 12, Time: dataTime, Value: 850, Quality: "suspect"}, true, nil),
+					db.EXPECT().AddDataPoint(DataPoint{AgentID: 1234, VariableID: 12, Time: dataTime, Value: expectedValue, Quality: expectedQuality}, "overwrite").Return(true, nil),
+					db.EXPECT().AddDataCorrection(gomock.Any()).Do(func(c *DataCorrection) {
+						c.CorrectionID = 7
+						correction = *c
+					}),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(correction))
+					}),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)...)
+
+				makeRequest(patch)
+
+				Expect(correction.AgentID).To(Equal(1234))
+				Expect(correction.VariableID).To(Equal(12))
+				Expect(correction.Time).To(Equal(dataTime))
+				Expect(correction.OriginalValue).To(Equal(850.0))
+				Expect(correction.OriginalQuality).To(Equal("suspect"))
+				Expect(correction.Value).To(Equal(expectedValue))
+				Expect(correction.Quality).To(Equal(expectedQuality))
+				Expect(correction.UserID).To(Equal(user.UserID))
+				Expect(correction.Created).ToNot(BeZero())
+			},
+			Entry("when a new value is given", PatchDataPoint{Variable: "temperature", Time: dataTime, Value: float64Value(21.5)}, 21.5, "corrected"),
+			Entry("when a new quality is given", PatchDataPoint{Variable: "temperature", Time: dataTime, Quality: stringValue("bad")}, 850.0, "bad"),
+			Entry("when both a new value and quality are given", PatchDataPoint{Variable: "temperature", Time: dataTime, Value: float64Value(21.5), Quality: stringValue("good")}, 21.5, "good"),
+		)
+
+		It("returns HTTP 404 response if there is no point at the time given", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetVariableIDForName("temperature").Return(12, nil),
+				db.EXPECT().GetDataPoint(1234, 12, dataTime).Return(DataPoint{}, false, nil),
+				render.EXPECT().Text(http.StatusNotFound, "There is no value for this variable at this time."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(PatchDataPoint{Variable: "temperature", Time: dataTime, Value: float64Value(21.5)})
+		})
+
+		It("returns HTTP 400 response if the variable does not exist", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetVariableIDForName("nothing").Return(-1, errors.New("Doesn't exist")),
+				render.EXPECT().Text(http.StatusBadRequest, "Could not find variable with name 'nothing'."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(PatchDataPoint{Variable: "nothing", Time: dataTime, Value: float64Value(21.5)})
+		})
+
+		It("returns HTTP 403 response if the user does not own the agent", func() {
+			gomock.InOrder(append(expectOwnedAgent(user.UserID+1),
+				render.EXPECT().Error(http.StatusForbidden),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			makeRequest(PatchDataPoint{Variable: "temperature", Time: dataTime, Value: float64Value(21.5)})
+		})
+	})
+
+	Describe("GET request handler", func() {
+		It("returns the agent's corrections", func() {
+			corrections := []DataCorrection{{CorrectionID: 7, AgentID: 1234, VariableID: 12, Time: dataTime, OriginalValue: 850, OriginalQuality: "suspect", Value: 21.5, Quality: "corrected"}}
+
+			gomock.InOrder(append(expectOwnedAgent(user.UserID),
+				db.EXPECT().GetDataCorrections(1234).Return(corrections, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().JSON(http.StatusOK, corrections),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)...)
+
+			getDataCorrections(render, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		})
+	})
+})
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/martini-contrib/render"
+)
+
+const (
+	responseFormatJSON = "json"
+	responseFormatCSV  = "csv"
+)
+
+const csvContentType = "text/csv"
+
+// extractResponseFormat works out whether data should be returned as JSON (the default) or CSV, either from the
+// 'format' URL parameter or, if that is not given, the Accept header.
+func extractResponseFormat(render render.Render, req *http.Request) (string, bool) {
+	switch format := req.URL.Query().Get("format"); format {
+	case responseFormatJSON, responseFormatCSV:
+		return format, true
+	case "":
+		if strings.Contains(req.Header.Get("Accept"), csvContentType) {
+			return responseFormatCSV, true
+		}
+
+		return responseFormatJSON, true
+	default:
+		render.Text(http.StatusBadRequest, fmt.Sprintf("Format '%v' is not supported, must be one of: %v, %v.", format, responseFormatJSON, responseFormatCSV))
+		return "", false
+	}
+}
+
+// writeDataAsCSV writes a CSV file with a row for each time and a column for each variable. Unaggregated data is
+// streamed from the database a row at a time rather than loaded into memory all at once.
+func writeDataAsCSV(res http.ResponseWriter, render render.Render, db Database, agentID int, variableIDs []int, fromTime time.Time, toTime time.Time, interval time.Duration, aggregate string, includeFlagged bool, log *logrus.Entry) {
+	variables := []Variable{}
+	columns := map[int]int{}
+	header := []string{"time"}
+
+	for _, variableID := range variableIDs {
+		variable, err := db.GetVariableByID(variableID)
+
+		if err != nil {
+			log.WithError(err).Error("Could not get variable info.")
+			render.Error(http.StatusInternalServerError)
+			return
+		}
+
+		columns[variableID] = len(header)
+		header = append(header, fmt.Sprintf("%s (%s)", variable.Name, variable.Units))
+		variables = append(variables, variable)
+	}
+
+	var aggregatedData map[int]map[string]float64
+
+	if interval != 0 {
+		aggregatedData = map[int]map[string]float64{}
+
+		for _, variable := range variables {
+			points, err := db.GetAggregatedData(agentID, variable.VariableID, fromTime, toTime, interval, aggregate, includeFlagged)
+
+			if err != nil {
+				log.WithError(err).Error("Could not retrieve data.")
+				render.Error(http.StatusInternalServerError)
+				return
+			}
+
+			aggregatedData[variable.VariableID] = points
+		}
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="agent-%d.csv"`, agentID))
+	res.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(res)
+	var err error
+
+	if err = writer.Write(header); err == nil {
+		if aggregatedData != nil {
+			err = writeAggregatedCSVRows(writer, variables, columns, aggregatedData)
+		} else {
+			err = writeCSVRows(writer, db, agentID, variableIDs, variables, columns, fromTime, toTime, includeFlagged)
+		}
+	}
+
+	writer.Flush()
+
+	if err == nil {
+		err = writer.Error()
+	}
+
+	// The response has already started by this point, so the best we can do is stop writing it.
+	if err != nil {
+		log.WithError(err).Error("Could not write CSV data.")
+	}
+}
+
+func writeCSVRows(writer *csv.Writer, db Database, agentID int, variableIDs []int, variables []Variable, columns map[int]int, fromTime time.Time, toTime time.Time, includeFlagged bool) error {
+	decimalPlaces := decimalPlacesByVariable(variables)
+	row := make([]string, len(variables)+1)
+	var rowTime time.Time
+	haveRow := false
+
+	writeRow := func() error {
+		row[0] = rowTime.In(time.UTC).Format(time.RFC3339)
+		err := writer.Write(row)
+		row = make([]string, len(variables)+1)
+
+		return err
+	}
+
+	err := db.StreamData(agentID, variableIDs, fromTime, toTime, includeFlagged, func(point DataPoint) error {
+		if haveRow && !point.Time.Equal(rowTime) {
+			if err := writeRow(); err != nil {
+				return err
+			}
+		}
+
+		rowTime = point.Time
+		haveRow = true
+		row[columns[point.VariableID]] = formatValue(point.Value, decimalPlaces[point.VariableID])
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if haveRow {
+		return writeRow()
+	}
+
+	return nil
+}
+
+func writeAggregatedCSVRows(writer *csv.Writer, variables []Variable, columns map[int]int, data map[int]map[string]float64) error {
+	decimalPlaces := decimalPlacesByVariable(variables)
+	rows := map[string][]string{}
+	times := []string{}
+
+	for variableID, points := range data {
+		for t, value := range points {
+			row, ok := rows[t]
+
+			if !ok {
+				row = make([]string, len(variables)+1)
+				row[0] = t
+				rows[t] = row
+				times = append(times, t)
+			}
+
+			row[columns[variableID]] = formatValue(value, decimalPlaces[variableID])
+		}
+	}
+
+	// All times are formatted as RFC3339 in UTC, so sorting them as strings sorts them chronologically.
+	sort.Strings(times)
+
+	for _, t := range times {
+		if err := writer.Write(rows[t]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decimalPlacesByVariable(variables []Variable) map[int]int {
+	decimalPlaces := map[int]int{}
+
+	for _, variable := range variables {
+		decimalPlaces[variable.VariableID] = variable.DisplayDecimalPlaces
+	}
+
+	return decimalPlaces
+}
+
+func formatValue(value float64, decimalPlaces int) string {
+	return strconv.FormatFloat(value, 'f', decimalPlaces, 64)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Data CSV export", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var render *MockRender
+	var recorder *httptest.ResponseRecorder
+
+	user := User{UserID: 1000}
+	fromDate := time.Date(2015, 3, 27, 5, 0, 0, 0, time.UTC)
+	toDate := time.Date(2015, 3, 28, 23, 50, 45, 0, time.UTC)
+
+	variable123 := Variable{VariableID: 123, Name: "temperature", Units: "°C", DisplayDecimalPlaces: 1}
+	variable321 := Variable{VariableID: 321, Name: "humidity", Units: "%", DisplayDecimalPlaces: 2}
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		render = NewMockRender(mockController)
+		recorder = httptest.NewRecorder()
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	makeRequest := func(query string, accept string) {
+		request, _ := http.NewRequest("GET", "/blah?"+query, strings.NewReader(""))
+		request.Header.Set("Accept", accept)
+		params := martini.Params{"agent_id": "1"}
+
+		getData(render, request, recorder, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+	}
+
+	expectStart := func() {
+		db.EXPECT().BeginTransaction()
+		db.EXPECT().CheckAgentIDExists(1).Return(true, nil)
+		db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil)
+		db.EXPECT().GetVariableByID(123).Return(variable123, nil)
+		db.EXPECT().GetVariableByID(321).Return(variable321, nil)
+		db.EXPECT().CommitTransaction()
+		db.EXPECT().RollbackUncommittedTransaction()
+	}
+
+	expectStreamedData := func() {
+		db.EXPECT().StreamData(1, []int{123, 321}, fromDate, toDate, true, gomock.Any()).Do(func(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) {
+			callback(DataPoint{AgentID: 1, VariableID: 123, Time: time.Date(2015, 3, 27, 6, 0, 0, 0, time.UTC), Value: 10.04})
+			callback(DataPoint{AgentID: 1, VariableID: 321, Time: time.Date(2015, 3, 27, 6, 0, 0, 0, time.UTC), Value: 80})
+			callback(DataPoint{AgentID: 1, VariableID: 321, Time: time.Date(2015, 3, 27, 6, 5, 0, 0, time.UTC), Value: 80.456})
+		}).Return(nil)
+	}
+
+	expectedCSV := "time,temperature (°C),humidity (%)\n" +
+		"2015-03-27T06:00:00Z,10.0,80.00\n" +
+		"2015-03-27T06:05:00Z,,80.46\n"
+
+	It("returns the data as CSV when the format parameter is 'csv'", func() {
+		expectStart()
+		expectStreamedData()
+
+		makeRequest("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&format=csv", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/csv; charset=utf-8"))
+		Expect(recorder.Header().Get("Content-Disposition")).To(Equal(`attachment; filename="agent-1.csv"`))
+		Expect(recorder.Body.String()).To(Equal(expectedCSV))
+	})
+
+	It("returns the data as CSV when the Accept header includes 'text/csv'", func() {
+		expectStart()
+		expectStreamedData()
+
+		makeRequest("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z", "text/csv")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal(expectedCSV))
+	})
+
+	It("returns aggregated data as CSV, ordered by time, when an interval is given", func() {
+		expectStart()
+		db.EXPECT().GetAggregatedData(1, 123, fromDate, toDate, time.Hour, "max", true).Return(map[string]float64{
+			"2015-03-27T07:00:00Z": 11,
+			"2015-03-27T06:00:00Z": 10.5,
+		}, nil)
+		db.EXPECT().GetAggregatedData(1, 321, fromDate, toDate, time.Hour, "max", true).Return(map[string]float64{
+			"2015-03-27T06:00:00Z": 80,
+			"2015-03-27T08:00:00Z": 81,
+		}, nil)
+
+		makeRequest("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=1h&aggregate=max&format=csv", "")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("time,temperature (°C),humidity (%)\n" +
+			"2015-03-27T06:00:00Z,10.5,80.00\n" +
+			"2015-03-27T07:00:00Z,11.0,\n" +
+			"2015-03-27T08:00:00Z,,81.00\n"))
+	})
+
+	It("returns only the header when there is no data", func() {
+		expectStart()
+		db.EXPECT().StreamData(1, []int{123, 321}, fromDate, toDate, true, gomock.Any()).Return(nil)
+
+		makeRequest("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&format=csv", "")
+
+		Expect(recorder.Body.String()).To(Equal("time,temperature (°C),humidity (%)\n"))
+	})
+})
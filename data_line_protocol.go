@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/martini-contrib/render"
+)
+
+// lineProtocolValueField is the name of the field that is saved to the variable named after its measurement, rather
+// than to the variable named after the field, eg. 'temperature value=21.5'.
+const lineProtocolValueField = "value"
+
+// maxLineProtocolBodyBytes limits the size of a request body as it is sent, and maxLineProtocolDataBytes limits it
+// once it has been decompressed, so that a small compressed body can't be used to exhaust memory.
+const maxLineProtocolBodyBytes = 10 * 1024 * 1024
+const maxLineProtocolDataBytes = 50 * 1024 * 1024
+
+var errLineProtocolBodyTooLarge = errors.New("Request body is too large.")
+
+var lineProtocolPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// postLineProtocolData accepts data in InfluxDB line protocol, so that collectors such as Telegraf can write to the
+// service directly. Like InfluxDB, points that already have a value are overwritten, a successful write returns HTTP 204
+// and a body that is too large returns HTTP 413.
+func postLineProtocolData(render render.Render, req *http.Request, agent Agent, db Database, hub *DataHub, notifier *AlertNotifier, log *logrus.Entry) {
+	rawPrecision := req.URL.Query().Get("precision")
+	precision, ok := lineProtocolPrecisions[rawPrecision]
+
+	if !ok {
+		render.Text(http.StatusBadRequest, fmt.Sprintf("Precision '%v' is not supported, must be one of: ns, us, ms, s.", rawPrecision))
+		return
+	}
+
+	body := io.Reader(&limitedReader{reader: req.Body, remaining: maxLineProtocolBodyBytes})
+
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(body)
+
+		if err == errLineProtocolBodyTooLarge {
+			render.Text(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		} else if err != nil {
+			render.Text(http.StatusBadRequest, "Cannot decompress request body.")
+			return
+		}
+
+		defer reader.Close()
+		body = &limitedReader{reader: reader, remaining: maxLineProtocolDataBytes}
+	}
+
+	points, err := parseLineProtocol(body, precision)
+
+	if err == errLineProtocolBodyTooLarge {
+		render.Text(http.StatusRequestEntityTooLarge, err.Error())
+		return
+	} else if err != nil {
+		render.Text(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(points) == 0 {
+		render.Text(http.StatusBadRequest, "Must include at least one data point.")
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	// Points without a timestamp are recorded at the time the request was received, as they are by InfluxDB.
+	data := PostDataPoints{Time: time.Now().UTC(), OnConflict: conflictPolicyOverwrite, Data: points}
+	status, result := saveDataPoints(data, agent, db, hub, notifier, log)
+
+	switch status {
+	case http.StatusCreated:
+		render.Status(http.StatusNoContent)
+	case http.StatusInternalServerError:
+		render.Error(status)
+	default:
+		render.Text(status, firstDataPointMessage(result))
+	}
+}
+
+func firstDataPointMessage(result PostDataPointsResult) string {
+	for _, point := range result.Results {
+		if point.Message != "" {
+			return point.Message
+		}
+	}
+
+	return ""
+}
+
+// parseLineProtocol reads each line of the form 'measurement[,tag=value...] field=value[,field=value...] [timestamp]',
+// and returns a data point for each numeric or boolean field. Tags and string fields are ignored, as there is nowhere
+// to save them. Because of that, more than one value for the same variable and time is an error, rather than letting
+// points with different tags (eg. from two sensors) silently overwrite each other. Points without a timestamp have a
+// zero time.
+func parseLineProtocol(r io.Reader, precision time.Duration) ([]PostDataPoint, error) {
+	type pointKey struct {
+		Variable string
+		Time     time.Time
+	}
+
+	reader := bufio.NewReader(r)
+	points := []PostDataPoint{}
+	seen := map[pointKey]int{}
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadString('\n')
+
+		if readErr != nil && readErr != io.EOF {
+			return nil, readErr
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			linePoints, err := parseLineProtocolLine(line, precision)
+
+			if err != nil {
+				return nil, fmt.Errorf("Line %v: %v", lineNumber, err.Error())
+			}
+
+			for _, point := range linePoints {
+				key := pointKey{point.Variable, point.Time}
+
+				if previousLineNumber, ok := seen[key]; ok {
+					return nil, fmt.Errorf("Line %v: Variable '%v' already has a value at the same time on line %v.", lineNumber, point.Variable, previousLineNumber)
+				}
+
+				seen[key] = lineNumber
+			}
+
+			points = append(points, linePoints...)
+		}
+
+		if readErr == io.EOF {
+			return points, nil
+		}
+	}
+}
+
+func parseLineProtocolLine(line string, precision time.Duration) ([]PostDataPoint, error) {
+	sections := splitLineProtocol(line, ' ')
+
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, errors.New("Expected a measurement, fields and an optional timestamp separated by spaces.")
+	}
+
+	key := splitLineProtocol(sections[0], ',')
+	measurement := unescapeLineProtocol(key[0])
+
+	if measurement == "" {
+		return nil, errors.New("Measurement name is missing.")
+	}
+
+	for _, tag := range key[1:] {
+		if len(splitLineProtocol(tag, '=')) != 2 {
+			return nil, fmt.Errorf("Tag '%v' is not in the format 'key=value'.", unescapeLineProtocol(tag))
+		}
+	}
+
+	pointTime := time.Time{}
+
+	if len(sections) == 3 {
+		timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse timestamp '%v'.", sections[2])
+		}
+
+		unitsPerSecond := int64(time.Second / precision)
+		pointTime = time.Unix(timestamp/unitsPerSecond, (timestamp%unitsPerSecond)*int64(precision)).UTC()
+	}
+
+	points := []PostDataPoint{}
+
+	for _, field := range splitLineProtocol(sections[1], ',') {
+		parts := splitLineProtocol(field, '=')
+
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Field '%v' is not in the format 'key=value'.", unescapeLineProtocol(field))
+		}
+
+		name := unescapeLineProtocol(parts[0])
+
+		if strings.HasPrefix(parts[1], `"`) {
+			continue
+		}
+
+		value, err := parseLineProtocolValue(parts[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse value '%v' of field '%v'.", parts[1], name)
+		}
+
+		variable := name
+
+		if name == lineProtocolValueField {
+			variable = measurement
+		}
+
+		points = append(points, PostDataPoint{Variable: variable, Value: value, Time: pointTime})
+	}
+
+	return points, nil
+}
+
+func parseLineProtocolValue(raw string) (float64, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+
+	if strings.HasSuffix(raw, "i") {
+		value, err := strconv.ParseInt(strings.TrimSuffix(raw, "i"), 10, 64)
+		return float64(value), err
+	}
+
+	if strings.HasSuffix(raw, "u") {
+		value, err := strconv.ParseUint(strings.TrimSuffix(raw, "u"), 10, 64)
+		return float64(value), err
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+
+	if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+		return 0, errors.New("Value must be a finite number.")
+	}
+
+	return value, err
+}
+
+// splitLineProtocol splits s at each separator that is not escaped with a backslash or inside a quoted string.
+func splitLineProtocol(s string, separator byte) []string {
+	parts := []string{}
+	start := 0
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == separator && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+var lineProtocolUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func unescapeLineProtocol(s string) string {
+	return lineProtocolUnescaper.Replace(s)
+}
+
+// limitedReader reads from reader until more than remaining bytes have been read, and then returns
+// errLineProtocolBodyTooLarge. Unlike io.LimitReader, it tells the caller that the data was cut short.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.reader.Read(p)
+
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+		r.remaining = 0
+		return n, errLineProtocolBodyTooLarge
+	}
+
+	r.remaining -= int64(n)
+
+	return n, err
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Line protocol", func() {
+	Describe("parseLineProtocol", func() {
+		dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+		DescribeTable("parses valid lines",
+			func(body string, precision time.Duration, expected ...PostDataPoint) {
+				points, err := parseLineProtocol(strings.NewReader(body), precision)
+				Expect(err).To(BeNil())
+				Expect(points).To(Equal(expected))
+			},
+			Entry("a field named after a variable", "weather temperature=21.5 1430907330000000000", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime}),
+			Entry("a 'value' field, which is saved to the variable named after the measurement", "temperature value=21.5 1430907330000000000", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime}),
+			Entry("multiple fields and tags", "weather,location=roof,sensor=bme280 temperature=21.5,humidity=60i 1430907330000000000", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime},
+				PostDataPoint{Variable: "humidity", Value: 60, Time: dataTime}),
+			Entry("a line without a timestamp", "temperature value=21.5", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5}),
+			Entry("multiple lines, blank lines and comments", "# from the roof\ntemperature value=21.5 1430907330000000000\n\r\ntemperature value=22 1430907331000000000\n", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime},
+				PostDataPoint{Variable: "temperature", Value: 22, Time: dataTime.Add(time.Second)}),
+			Entry("a timestamp in seconds", "temperature value=21.5 1430907330", time.Second,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime}),
+			Entry("a timestamp in milliseconds", "temperature value=21.5 1430907330250", time.Millisecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime.Add(250 * time.Millisecond)}),
+			Entry("a timestamp with nanoseconds", "temperature value=21.5 1430907330000000123", time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5, Time: dataTime.Add(123)}),
+			Entry("unsigned integer, negative and exponent values", "weather a=3u,b=-1.5,c=1e3", time.Nanosecond,
+				PostDataPoint{Variable: "a", Value: 3},
+				PostDataPoint{Variable: "b", Value: -1.5},
+				PostDataPoint{Variable: "c", Value: 1000}),
+			Entry("boolean values", "weather raining=t,windy=false", time.Nanosecond,
+				PostDataPoint{Variable: "raining", Value: 1},
+				PostDataPoint{Variable: "windy", Value: 0}),
+			Entry("string values, which are ignored", `weather summary="clear, with a high of 25",temperature=21.5`, time.Nanosecond,
+				PostDataPoint{Variable: "temperature", Value: 21.5}),
+			Entry("escaped characters", `outside\ temperature,location=back\ yard value=21.5`, time.Nanosecond,
+				PostDataPoint{Variable: "outside temperature", Value: 21.5}),
+			Entry("an empty body", "", time.Nanosecond),
+		)
+
+		DescribeTable("rejects invalid lines",
+			func(body string, expectedError string) {
+				_, err := parseLineProtocol(strings.NewReader(body), time.Nanosecond)
+				Expect(err).To(MatchError(expectedError))
+			},
+			Entry("missing fields", "temperature", "Line 1: Expected a measurement, fields and an optional timestamp separated by spaces."),
+			Entry("too many sections", "temperature value=21.5 1430907330 extra", "Line 1: Expected a measurement, fields and an optional timestamp separated by spaces."),
+			Entry("missing measurement", ",location=roof value=21.5", "Line 1: Measurement name is missing."),
+			Entry("invalid tag", "temperature,roof value=21.5", "Line 1: Tag 'roof' is not in the format 'key=value'."),
+			Entry("invalid field", "temperature value", "Line 1: Field 'value' is not in the format 'key=value'."),
+			Entry("invalid value", "temperature value=warm", "Line 1: Cannot parse value 'warm' of field 'value'."),
+			Entry("invalid integer value", "temperature value=21.5i", "Line 1: Cannot parse value '21.5i' of field 'value'."),
+			Entry("non-finite value", "temperature value=NaN", "Line 1: Cannot parse value 'NaN' of field 'value'."),
+			Entry("invalid timestamp", "temperature value=21.5 yesterday", "Line 1: Cannot parse timestamp 'yesterday'."),
+			Entry("an error on a later line", "temperature value=21.5\ntemperature value=", "Line 2: Cannot parse value '' of field 'value'."),
+			Entry("values for the same variable and time with different tags", "temperature,sensor=a value=1 1430907330\ntemperature,sensor=b value=2 1430907330",
+				"Line 2: Variable 'temperature' already has a value at the same time on line 1."),
+			Entry("values for the same variable without timestamps", "temperature value=1\ntemperature value=2",
+				"Line 2: Variable 'temperature' already has a value at the same time on line 1."),
+			Entry("a value for the same variable and time in one line", "weather temperature=1,temperature=2",
+				"Line 1: Variable 'temperature' already has a value at the same time on line 1."),
+		)
+	})
+
+	Describe("limitedReader", func() {
+		It("reads data up to the limit", func() {
+			data, err := ioutil.ReadAll(&limitedReader{reader: strings.NewReader("abcde"), remaining: 5})
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("abcde"))
+		})
+
+		It("returns an error if there is more data than the limit", func() {
+			_, err := ioutil.ReadAll(&limitedReader{reader: strings.NewReader("abcdef"), remaining: 5})
+			Expect(err).To(Equal(errLineProtocolBodyTooLarge))
+		})
+	})
+
+	Describe("POST request handler", func() {
+		var mockController *gomock.Controller
+		var db *MockDatabase
+		var render *MockRender
+		var hub *DataHub
+		var notifier *AlertNotifier
+
+		agent := Agent{AgentID: 10}
+		dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+		BeforeEach(func() {
+			mockController = gomock.NewController(GinkgoT())
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+			hub = NewDataHub()
+			notifier = NewAlertNotifier(nil)
+		})
+
+		AfterEach(func() {
+			notifier.Close()
+			mockController.Finish()
+		})
+
+		var makeRequest = func(url string, body string) {
+			req, err := http.NewRequest("POST", url, strings.NewReader(body))
+			Expect(err).To(BeNil())
+
+			postLineProtocolData(render, req, agent, db, hub, notifier, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		It("saves the data points, overwriting any existing values, and returns HTTP 204 response", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature", "humidity"}).Return(map[string]Variable{"temperature": {VariableID: 12}, "humidity": {VariableID: 13}}, nil),
+				db.EXPECT().AddDataPoints([]DataPoint{
+					{AgentID: agent.AgentID, VariableID: 12, Value: 21.5, Time: dataTime},
+					{AgentID: agent.AgentID, VariableID: 13, Value: 60, Time: dataTime},
+				}, "overwrite").Return([]bool{true, false}, nil),
+				db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+				db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Status(http.StatusNoContent),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("/v1/agents/10/write?precision=s", "weather,location=roof temperature=21.5,humidity=60i 1430907330")
+		})
+
+		It("saves data points without a timestamp at the time the request was received", func() {
+			before := time.Now()
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+				db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Do(func(points []DataPoint, _ string) {
+					Expect(points[0].Time).To(BeTemporally(">=", before))
+					Expect(points[0].Time).To(BeTemporally("<=", time.Now()))
+				}).Return([]bool{false}, nil),
+				db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+				db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Status(http.StatusNoContent),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("/v1/agents/10/write", "temperature value=21.5")
+		})
+
+		It("accepts a gzip-compressed body", func() {
+			compressed := &bytes.Buffer{}
+			writer := gzip.NewWriter(compressed)
+			writer.Write([]byte("temperature value=21.5 1430907330"))
+			writer.Close()
+
+			req, err := http.NewRequest("POST", "/v1/agents/10/write?precision=s", compressed)
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Encoding", "gzip")
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+				db.EXPECT().AddDataPoints([]DataPoint{{AgentID: agent.AgentID, VariableID: 12, Value: 21.5, Time: dataTime}}, "overwrite").Return([]bool{false}, nil),
+				db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+				db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Status(http.StatusNoContent),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			postLineProtocolData(render, req, agent, db, hub, notifier, logrus.NewEntry(logrus.StandardLogger()))
+		})
+
+		It("returns HTTP 413 response without saving anything if the body is too large", func() {
+			render.EXPECT().Text(http.StatusRequestEntityTooLarge, "Request body is too large.")
+
+			makeRequest("/v1/agents/10/write", lineProtocolOfSize(maxLineProtocolBodyBytes+1))
+		})
+
+		It("returns HTTP 413 response without saving anything if the body is too large once decompressed", func() {
+			compressed := &bytes.Buffer{}
+			writer := gzip.NewWriter(compressed)
+			writer.Write([]byte(lineProtocolOfSize(maxLineProtocolDataBytes + 1)))
+			writer.Close()
+
+			Expect(compressed.Len()).To(BeNumerically("<", maxLineProtocolBodyBytes))
+
+			req, err := http.NewRequest("POST", "/v1/agents/10/write", compressed)
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Encoding", "gzip")
+
+			render.EXPECT().Text(http.StatusRequestEntityTooLarge, "Request body is too large.")
+
+			postLineProtocolData(render, req, agent, db, hub, notifier, logrus.NewEntry(logrus.StandardLogger()))
+		})
+
+		It("does not save any data points and returns HTTP 400 response if a variable does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature", "pressure"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+				render.EXPECT().Text(http.StatusBadRequest, "Could not find variable with name 'pressure'."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("/v1/agents/10/write", "weather temperature=21.5,pressure=1013.2")
+		})
+
+		It("returns HTTP 500 response if the data cannot be saved", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+				db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Return(nil, errors.New("Something went wrong.")),
+				render.EXPECT().Error(http.StatusInternalServerError),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("/v1/agents/10/write", "temperature value=21.5")
+		})
+
+		DescribeTable("returns HTTP 400 response without saving anything if the request is invalid",
+			func(url string, body string, expectedMessage string) {
+				render.EXPECT().Text(http.StatusBadRequest, expectedMessage)
+
+				makeRequest(url, body)
+			},
+			Entry("because the body cannot be parsed", "/v1/agents/10/write", "temperature value=warm", "Line 1: Cannot parse value 'warm' of field 'value'."),
+			Entry("because it includes two values for the same variable and time", "/v1/agents/10/write", "temperature,sensor=a value=1 1430907330000000000\ntemperature,sensor=b value=2 1430907330000000000",
+				"Line 2: Variable 'temperature' already has a value at the same time on line 1."),
+			Entry("because the body is empty", "/v1/agents/10/write", "", "Must include at least one data point."),
+			Entry("because the precision is not supported", "/v1/agents/10/write?precision=h", "temperature value=21.5", "Precision 'h' is not supported, must be one of: ns, us, ms, s."),
+		)
+	})
+})
+
+// lineProtocolOfSize returns at least size bytes of valid line protocol, with a different time on each line.
+func lineProtocolOfSize(size int) string {
+	builder := strings.Builder{}
+
+	for i := 0; builder.Len() < size; i++ {
+		fmt.Fprintf(&builder, "temperature value=21.5 %d\n", i)
+	}
+
+	return builder.String()
+}
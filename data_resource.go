@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-martini/martini"
@@ -17,18 +20,77 @@ type DataPoint struct {
 	VariableID int
 	Time       time.Time
 	Value      float64
+	Quality    string
+
+	// SequenceNumber is given to the point each time it is saved, and is only read by StreamDataSavedAfter.
+	SequenceNumber int
 }
 
+// The quality of a data point. Suspect and bad points are flagged, and can be left out when data is retrieved.
+// Corrected points have had their value changed by hand, and are not flagged.
+const (
+	dataQualityGood      = "good"
+	dataQualitySuspect   = "suspect"
+	dataQualityBad       = "bad"
+	dataQualityCorrected = "corrected"
+)
+
+var dataQualities = []string{dataQualityGood, dataQualitySuspect, dataQualityBad, dataQualityCorrected}
+
+const (
+	flaggedDataInclude = "include"
+	flaggedDataExclude = "exclude"
+)
+
+var flaggedDataOptions = []string{flaggedDataInclude, flaggedDataExclude}
+
+// PostDataPoints is the body of a request to save data. Each point is recorded at Time, unless the point gives its own
+// time, which allows an agent to upload readings taken at many different times in a single request.
+// OnConflict controls what happens when there is already a value for a point (eg. because an upload is retried).
 type PostDataPoints struct {
-	Time time.Time `json:"time" binding:"required"`
-	Data []PostDataPoint
+	Time       time.Time `json:"time"`
+	OnConflict string    `json:"onConflict"`
+	Data       []PostDataPoint
 }
 
 type PostDataPoint struct {
 	Variable string `json:"variable" binding:"required"`
 	Value    float64
+	Time     time.Time `json:"time"`
+}
+
+type PostDataPointsResult struct {
+	Results []PostDataPointResult `json:"results"`
+}
+
+type PostDataPointResult struct {
+	Variable string    `json:"variable"`
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Quality  string    `json:"quality,omitempty"`
+	Message  string    `json:"message,omitempty"`
 }
 
+const (
+	dataPointStatusCreated         = "created"
+	dataPointStatusIgnored         = "ignored"
+	dataPointStatusOverwritten     = "overwritten"
+	dataPointStatusConflict        = "conflict"
+	dataPointStatusNotSaved        = "notSaved"
+	dataPointStatusUnknownVariable = "unknownVariable"
+	dataPointStatusOutOfRange      = "outOfRange"
+	dataPointStatusDropped         = "dropped"
+	dataPointStatusInvalidValue    = "invalidValue"
+)
+
+const (
+	conflictPolicyReject    = "reject"
+	conflictPolicyIgnore    = "ignore"
+	conflictPolicyOverwrite = "overwrite"
+)
+
+var conflictPolicies = []string{conflictPolicyReject, conflictPolicyIgnore, conflictPolicyOverwrite}
+
 type GetDataResult struct {
 	Data []GetDataResultVariable `json:"data"`
 }
@@ -38,10 +100,29 @@ type GetDataResultVariable struct {
 	Name                 string             `json:"name"`
 	Units                string             `json:"units"`
 	DisplayDecimalPlaces int                `json:"displayDecimalPlaces"`
+	Interval             string             `json:"interval,omitempty"`
+	Aggregate            string             `json:"aggregate,omitempty"`
 	Points               map[string]float64 `json:"points"`
 }
 
-func postDataPoints(render render.Render, data PostDataPoints, agent Agent, db Database, log *logrus.Entry) {
+type GetLatestDataResult struct {
+	Data []GetLatestDataResultVariable `json:"data"`
+}
+
+type GetLatestDataResultVariable struct {
+	VariableID           int       `json:"id"`
+	Name                 string    `json:"name"`
+	Units                string    `json:"units"`
+	DisplayDecimalPlaces int       `json:"displayDecimalPlaces"`
+	Time                 time.Time `json:"time"`
+	Value                float64   `json:"value"`
+}
+
+const defaultAggregate = "avg"
+
+var supportedAggregates = []string{"min", "max", "avg", "sum", "count", "first", "last"}
+
+func postDataPoints(render render.Render, data PostDataPoints, agent Agent, db Database, hub *DataHub, notifier *AlertNotifier, log *logrus.Entry) {
 	if err := db.BeginTransaction(); err != nil {
 		log.WithError(err).Error("Could not begin database transaction.")
 		render.Error(http.StatusInternalServerError)
@@ -50,33 +131,345 @@ func postDataPoints(render render.Render, data PostDataPoints, agent Agent, db D
 
 	defer db.RollbackUncommittedTransaction()
 
-	for _, point := range data.Data {
-		variableID, err := db.GetVariableIDForName(point.Variable)
+	status, result := saveDataPoints(data, agent, db, hub, notifier, log)
+
+	if status == http.StatusInternalServerError {
+		render.Error(status)
+		return
+	}
+
+	render.JSON(status, result)
+}
+
+// saveDataPoints saves data in the current transaction, and commits it if every point was saved. It returns the HTTP
+// status code to respond with, along with the result for each point. The variables are looked up with a single query,
+// and the points are saved in batches rather than one at a time.
+func saveDataPoints(data PostDataPoints, agent Agent, db Database, hub *DataHub, notifier *AlertNotifier, log *logrus.Entry) (int, PostDataPointsResult) {
+	result := PostDataPointsResult{Results: []PostDataPointResult{}}
+	conflictPolicy := data.ConflictPolicy()
+	failureStatus := 0
+	savedPoints := []DataEventPoint{}
+	alertPoints := []DataEventPoint{}
+
+	variables, err := db.GetVariablesByName(data.VariableNames())
+
+	if err != nil {
+		log.WithError(err).Error("Could not get variables.")
+		return http.StatusInternalServerError, result
+	}
+
+	// Points waiting to be saved in the next batch, along with the index of their results.
+	pending := []DataPoint{}
+	pendingResults := []int{}
+	previousValues := newBatchPreviousValues(db, agent.AgentID)
+
+	savePending := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+
+		existed, err := db.AddDataPoints(pending, conflictPolicy)
 
 		if err != nil {
-			if variableID == -1 {
-				render.Text(http.StatusBadRequest, fmt.Sprintf("Could not find variable with name '%v'.", point.Variable))
-			} else {
-				render.Error(http.StatusInternalServerError)
+			return err
+		}
+
+		for i, dataPoint := range pending {
+			pointResult := &result.Results[pendingResults[i]]
+
+			switch {
+			case !existed[i]:
+				pointResult.Status = dataPointStatusCreated
+			case conflictPolicy == conflictPolicyIgnore:
+				pointResult.Status = dataPointStatusIgnored
+			case conflictPolicy == conflictPolicyOverwrite:
+				pointResult.Status = dataPointStatusOverwritten
+			default:
+				// Keep going after a conflict so that every conflicting point is reported.
+				failureStatus = http.StatusConflict
+				pointResult.Status = dataPointStatusConflict
+				pointResult.Message = "There is already a value for this variable at this time."
 			}
 
-			return
+			if pointResult.Status == dataPointStatusCreated || pointResult.Status == dataPointStatusOverwritten {
+				savedPoint := DataEventPoint{VariableID: dataPoint.VariableID, Variable: pointResult.Variable, Time: pointResult.Time, Value: dataPoint.Value}
+				savedPoints = append(savedPoints, savedPoint)
+
+				// A reading from a broken sensor should not set off an alert.
+				if dataPoint.Quality != dataQualitySuspect {
+					alertPoints = append(alertPoints, savedPoint)
+				}
+			}
 		}
 
-		if err := db.AddDataPoint(DataPoint{AgentID: agent.AgentID, VariableID: variableID, Value: point.Value, Time: data.Time}); err != nil {
+		pending = []DataPoint{}
+		pendingResults = []int{}
+
+		return nil
+	}
+
+	for _, point := range data.Data {
+		pointResult := PostDataPointResult{Variable: point.Variable, Time: data.TimeFor(point)}
+		variable, known := variables[point.Variable]
+
+		dataPoint := DataPoint{AgentID: agent.AgentID, VariableID: variable.VariableID, Value: point.Value, Time: pointResult.Time}
+		outOfRangeMessage := ""
+
+		// Values are stored as double precision, which can hold any finite value but should not be used to hold anything else.
+		finite := !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0)
+
+		if known && failureStatus != http.StatusBadRequest && finite {
+			outOfRangeMessage = variable.Check(point.Value, nil)
+
+			if outOfRangeMessage == "" && variable.MaxStep != nil {
+				previous, found, ok, err := previousValues.Get(dataPoint)
+
+				if err != nil {
+					log.WithError(err).Error("Could not get latest data.")
+					return http.StatusInternalServerError, result
+				}
+
+				if !ok {
+					// The previous value has to come from the database, and might be waiting to be saved.
+					if changesPreviousValue(pending, dataPoint, conflictPolicy) {
+						if err := savePending(); err != nil {
+							log.WithError(err).Error("Could not save data.")
+							return http.StatusInternalServerError, result
+						}
+					}
+
+					if previous, found, err = db.GetPreviousValue(dataPoint.AgentID, dataPoint.VariableID, dataPoint.Time); err != nil {
+						log.WithError(err).Error("Could not check data against variable limits.")
+						return http.StatusInternalServerError, result
+					}
+
+					previousValues.Start(dataPoint, previous, found)
+				}
+
+				if found {
+					outOfRangeMessage = variable.Check(point.Value, &previous)
+				}
+			}
+		}
+
+		if !known {
+			failureStatus = http.StatusBadRequest
+			pointResult.Status = dataPointStatusUnknownVariable
+			pointResult.Message = fmt.Sprintf("Could not find variable with name '%v'.", point.Variable)
+		} else if failureStatus == http.StatusBadRequest {
+			pointResult.Status = dataPointStatusNotSaved
+		} else if !finite {
+			failureStatus = http.StatusBadRequest
+			pointResult.Status = dataPointStatusInvalidValue
+			pointResult.Message = fmt.Sprintf("Value %v is not a finite number, and cannot be stored.", point.Value)
+		} else if outOfRangeMessage != "" && variable.OutOfRangePolicy() == outOfRangePolicyReject {
+			failureStatus = http.StatusBadRequest
+			pointResult.Status = dataPointStatusOutOfRange
+			pointResult.Message = outOfRangeMessage
+		} else if outOfRangeMessage != "" && variable.OutOfRangePolicy() == outOfRangePolicyDrop {
+			pointResult.Status = dataPointStatusDropped
+			pointResult.Message = outOfRangeMessage
+		} else {
+			if outOfRangeMessage != "" {
+				// The point is kept so that it can be reviewed later, but marked so that it can be told apart from good data.
+				dataPoint.Quality = dataQualitySuspect
+				pointResult.Quality = dataQualitySuspect
+				pointResult.Message = outOfRangeMessage
+			}
+
+			if variable.MaxStep != nil && dataPoint.QualityFlag() == dataQualityGood {
+				previousValues.Saved(dataPoint)
+			}
+
+			// The point is not saved until its batch is, which does not happen if another point is rejected first.
+			pointResult.Status = dataPointStatusNotSaved
+			pending = append(pending, dataPoint)
+			pendingResults = append(pendingResults, len(result.Results))
+		}
+
+		result.Results = append(result.Results, pointResult)
+	}
+
+	if failureStatus != http.StatusBadRequest {
+		if err := savePending(); err != nil {
 			log.WithError(err).Error("Could not save data.")
-			render.Error(http.StatusInternalServerError)
-			return
+			return http.StatusInternalServerError, result
+		}
+	}
+
+	if failureStatus != 0 {
+		// Nothing from this request is committed, so points saved before the failure was found were not saved after all.
+		for i := range result.Results {
+			switch result.Results[i].Status {
+			case dataPointStatusCreated, dataPointStatusIgnored, dataPointStatusOverwritten:
+				result.Results[i].Status = dataPointStatusNotSaved
+			}
+		}
+
+		return failureStatus, result
+	}
+
+	alertEvents := []AlertEvent{}
+
+	if len(alertPoints) > 0 {
+		var err error
+
+		// Evaluate the rules in the same transaction, so that their state always matches the data that was saved.
+		if alertEvents, err = evaluateAlertRules(db, agent.AgentID, alertPoints); err != nil {
+			log.WithError(err).Error("Could not evaluate alert rules.")
+			return http.StatusInternalServerError, result
+		}
+	}
+
+	sequenceNumber := 0
+
+	if len(savedPoints) > 0 {
+		var err error
+
+		if sequenceNumber, err = db.GetLastDataSequenceNumber(agent.AgentID); err != nil {
+			log.WithError(err).Error("Could not get sequence number of data.")
+			return http.StatusInternalServerError, result
 		}
 	}
 
 	if err := db.CommitTransaction(); err != nil {
 		log.WithError(err).Error("Could not commit transaction.")
-		render.Error(http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, result
+	}
+
+	if len(savedPoints) > 0 {
+		hub.Publish(newDataEvent(agent.AgentID, sequenceNumber, savedPoints))
+	}
+
+	if len(alertEvents) > 0 {
+		notifier.Notify(alertEvents)
+	}
+
+	return http.StatusCreated, result
+}
+
+// changesPreviousValue returns whether saving the pending points could change the previous value of the given point,
+// which is the case if one of them is for the same variable at an earlier time and is either good or overwrites a value.
+func changesPreviousValue(pending []DataPoint, point DataPoint, conflictPolicy string) bool {
+	for _, other := range pending {
+		if other.VariableID == point.VariableID && other.Time.Before(point.Time) &&
+			(other.Quality != dataQualitySuspect || conflictPolicy == conflictPolicyOverwrite) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// batchPreviousValues keeps track of the previous good value of each variable while an upload is saved, so that step
+// limits can be checked without querying the database for every point. A variable's previous value is only known once
+// it has been looked up in the database, and only for points after both the agent's latest stored point and the
+// points already checked, as in an upload in time order. Otherwise, it has to be looked up again.
+type batchPreviousValues struct {
+	db       Database
+	agentID  int
+	latest   map[int]DataPoint
+	previous map[int]batchPreviousValue
+}
+
+type batchPreviousValue struct {
+	Time  time.Time
+	Value float64
+	Found bool
+
+	// LookedUp is true if the value was looked up for the point at Time, rather than being that point's value.
+	LookedUp bool
+}
+
+func newBatchPreviousValues(db Database, agentID int) *batchPreviousValues {
+	return &batchPreviousValues{db: db, agentID: agentID, previous: map[int]batchPreviousValue{}}
+}
+
+// Get returns the previous good value before the point, and whether there is one. ok is false if the previous value is
+// not known and has to be looked up in the database.
+func (b *batchPreviousValues) Get(point DataPoint) (value float64, found bool, ok bool, err error) {
+	if b.latest == nil {
+		if b.latest, err = b.db.GetLatestData(b.agentID); err != nil {
+			return 0, false, false, err
+		}
+	}
+
+	previous, known := b.previous[point.VariableID]
+
+	if !known || !b.follows(point, previous) {
+		return 0, false, false, nil
+	}
+
+	return previous.Value, previous.Found, true, nil
+}
+
+// Start records the previous value of the point, as looked up in the database.
+func (b *batchPreviousValues) Start(point DataPoint, value float64, found bool) {
+	if b.follows(point, batchPreviousValue{}) {
+		b.previous[point.VariableID] = batchPreviousValue{Time: point.Time, Value: value, Found: found, LookedUp: true}
+	}
+}
+
+// Saved records that the point will be saved as a good value.
+func (b *batchPreviousValues) Saved(point DataPoint) {
+	previous, known := b.previous[point.VariableID]
+
+	if known && (b.follows(point, previous) || (previous.LookedUp && point.Time.Equal(previous.Time))) {
+		b.previous[point.VariableID] = batchPreviousValue{Time: point.Time, Value: point.Value, Found: true}
+	} else {
+		// The point could be the previous value of the points after it, but it is simpler to look that up again.
+		delete(b.previous, point.VariableID)
+	}
+}
+
+func (b *batchPreviousValues) follows(point DataPoint, previous batchPreviousValue) bool {
+	if latest, stored := b.latest[point.VariableID]; stored && !point.Time.After(latest.Time) {
+		return false
+	}
+
+	return point.Time.After(previous.Time)
+}
+
+// QualityFlag returns the quality of the point, which is good unless it has been marked otherwise.
+func (point DataPoint) QualityFlag() string {
+	if point.Quality == "" {
+		return dataQualityGood
+	}
+
+	return point.Quality
+}
+
+// ConflictPolicy returns the policy to use for points that already have a value, which defaults to rejecting the request.
+func (data PostDataPoints) ConflictPolicy() string {
+	if data.OnConflict == "" {
+		return conflictPolicyReject
+	}
+
+	return data.OnConflict
+}
+
+// VariableNames returns the names of the variables the points are for, without repeats, in the order they first appear.
+func (data PostDataPoints) VariableNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, point := range data.Data {
+		if !seen[point.Variable] {
+			seen[point.Variable] = true
+			names = append(names, point.Variable)
+		}
+	}
+
+	return names
+}
+
+// TimeFor returns the time the given point was recorded at.
+func (data PostDataPoints) TimeFor(point PostDataPoint) time.Time {
+	if !point.Time.IsZero() {
+		return point.Time
 	}
 
-	render.Status(http.StatusCreated)
+	return data.Time
 }
 
 func (data PostDataPoints) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
@@ -99,10 +492,30 @@ func (data PostDataPoints) Validate(errors binding.Errors, _ *http.Request) bind
 		}
 	}
 
+	if !containsString(conflictPolicies, data.ConflictPolicy()) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"onConflict"},
+			Classification: "InvalidValue",
+			Message:        fmt.Sprintf("onConflict must be one of: %v.", strings.Join(conflictPolicies, ", ")),
+		})
+	}
+
+	for _, point := range data.Data {
+		if data.TimeFor(point).IsZero() {
+			errors = append(errors, binding.Error{
+				FieldNames:     []string{"time"},
+				Classification: binding.RequiredError,
+				Message:        "Must provide a time for the request or for each data point.",
+			})
+
+			break
+		}
+	}
+
 	return errors
 }
 
-func getData(render render.Render, req *http.Request, params martini.Params, db Database, user User, log *logrus.Entry) {
+func getData(render render.Render, req *http.Request, res http.ResponseWriter, params martini.Params, db Database, user User, log *logrus.Entry) {
 	if err := db.BeginTransaction(); err != nil {
 		log.WithError(err).Error("Could not begin database transaction.")
 		render.Error(http.StatusInternalServerError)
@@ -111,32 +524,41 @@ func getData(render render.Render, req *http.Request, params martini.Params, db
 
 	defer db.RollbackUncommittedTransaction()
 
-	agentID, ok := extractAgentID(params, render, db, log)
+	agentID, ok := extractOwnedAgentID(params, render, db, user, log)
 
 	if !ok {
 		return
 	}
 
-	agent, err := db.GetAgentByID(agentID)
+	variables, fromTime, toTime, ok := extractGetParameters(render, req)
 
-	if err != nil {
-		log.WithError(err).Error("Could not get agent.")
-		render.Error(http.StatusInternalServerError)
+	if !ok {
 		return
 	}
 
-	if agent.OwnerUserID != user.UserID {
-		log.WithError(err).Error("User does not own this agent.")
-		render.Error(http.StatusForbidden)
+	interval, aggregate, ok := extractAggregationParameters(render, req)
+
+	if !ok {
 		return
 	}
 
-	variables, fromTime, toTime, ok := extractGetParameters(render, req)
+	includeFlagged, ok := extractFlaggedDataParameter(render, req)
 
 	if !ok {
 		return
 	}
 
+	format, ok := extractResponseFormat(render, req)
+
+	if !ok {
+		return
+	}
+
+	if format == responseFormatCSV {
+		writeDataAsCSV(res, render, db, agentID, variables, fromTime, toTime, interval, aggregate, includeFlagged, log)
+		return
+	}
+
 	result := GetDataResult{}
 
 	for _, variableID := range variables {
@@ -149,7 +571,14 @@ func getData(render render.Render, req *http.Request, params martini.Params, db
 		}
 
 		variableResult := GetDataResultVariable{VariableID: variableID, Name: variable.Name, Units: variable.Units, DisplayDecimalPlaces: variable.DisplayDecimalPlaces}
-		variableResult.Points, err = db.GetData(agentID, variableID, fromTime, toTime)
+
+		if interval == 0 {
+			variableResult.Points, err = db.GetData(agentID, variableID, fromTime, toTime, includeFlagged)
+		} else {
+			variableResult.Interval = req.URL.Query().Get("interval")
+			variableResult.Aggregate = aggregate
+			variableResult.Points, err = db.GetAggregatedData(agentID, variableID, fromTime, toTime, interval, aggregate, includeFlagged)
+		}
 
 		if err != nil {
 			log.WithError(err).Error("Could not retrieve data.")
@@ -169,6 +598,65 @@ func getData(render render.Render, req *http.Request, params martini.Params, db
 	render.JSON(http.StatusOK, result)
 }
 
+func getLatestData(render render.Render, params martini.Params, db Database, user User, log *logrus.Entry) {
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, render, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	variables, err := db.GetVariablesForAgent(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get variables for agent.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	latest, err := db.GetLatestData(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not retrieve latest data.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	result := GetLatestDataResult{Data: []GetLatestDataResultVariable{}}
+
+	for _, variable := range variables {
+		point, ok := latest[variable.VariableID]
+
+		if !ok {
+			continue
+		}
+
+		result.Data = append(result.Data, GetLatestDataResultVariable{
+			VariableID:           variable.VariableID,
+			Name:                 variable.Name,
+			Units:                variable.Units,
+			DisplayDecimalPlaces: variable.DisplayDecimalPlaces,
+			Time:                 point.Time.In(time.UTC),
+			Value:                point.Value,
+		})
+	}
+
+	render.JSON(http.StatusOK, result)
+}
+
 func extractGetParameters(render render.Render, req *http.Request) ([]int, time.Time, time.Time, bool) {
 	if req.URL.Query().Get("variable") == "" {
 		render.Text(http.StatusBadRequest, "Must specify variable with 'variable' URL parameter.")
@@ -219,3 +707,112 @@ func extractGetParameters(render render.Render, req *http.Request) ([]int, time.
 
 	return variables, fromDate, toDate, true
 }
+
+func extractAggregationParameters(render render.Render, req *http.Request) (time.Duration, string, bool) {
+	rawInterval := req.URL.Query().Get("interval")
+	aggregate := req.URL.Query().Get("aggregate")
+
+	if rawInterval == "" {
+		if aggregate != "" {
+			render.Text(http.StatusBadRequest, "Must specify interval with 'interval' URL parameter when using 'aggregate'.")
+			return 0, "", false
+		}
+
+		return 0, "", true
+	}
+
+	interval, err := parseInterval(rawInterval)
+
+	if err != nil {
+		render.Text(http.StatusBadRequest, "Cannot parse interval value.")
+		return 0, "", false
+	}
+
+	if interval < time.Second {
+		render.Text(http.StatusBadRequest, "Interval must be at least one second.")
+		return 0, "", false
+	}
+
+	if aggregate == "" {
+		aggregate = defaultAggregate
+	}
+
+	if containsString(supportedAggregates, aggregate) {
+		return interval, aggregate, true
+	}
+
+	render.Text(http.StatusBadRequest, fmt.Sprintf("Aggregate '%v' is not supported, must be one of: %v.", aggregate, strings.Join(supportedAggregates, ", ")))
+	return 0, "", false
+}
+
+// extractFlaggedDataParameter returns whether suspect and bad points should be included, which they are by default.
+func extractFlaggedDataParameter(render render.Render, req *http.Request) (bool, bool) {
+	switch flagged := req.URL.Query().Get("flagged"); flagged {
+	case "", flaggedDataInclude:
+		return true, true
+	case flaggedDataExclude:
+		return false, true
+	default:
+		render.Text(http.StatusBadRequest, fmt.Sprintf("Parameter 'flagged' must be one of: %v.", strings.Join(flaggedDataOptions, ", ")))
+		return false, false
+	}
+}
+
+// parseInterval accepts anything time.ParseDuration does (eg. '15m' or '1h'), as well as a whole number of days (eg. '1d').
+func parseInterval(raw string) (time.Duration, error) {
+	if strings.HasSuffix(raw, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(raw)
+}
+
+// Interval is a duration that is written to and read from JSON in the same format as the interval query parameter, eg. "90s" or "2d".
+type Interval time.Duration
+
+func (i Interval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(i).String())
+}
+
+func (i *Interval) UnmarshalJSON(data []byte) error {
+	var raw string
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	d, err := parseInterval(raw)
+
+	if err != nil {
+		return err
+	}
+
+	*i = Interval(d)
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"time"
 
@@ -50,6 +52,37 @@ var _ = Describe("Data resource", func() {
 			Expect(postData).To(Equal(expectedPostData))
 		})
 
+		It("can be deserialised from JSON with a time for each data point", func() {
+			jsonString := `{"data":[` +
+				`{"variable":"temperature","value":10.675,"time":"2015-03-26T14:35:00Z"},` +
+				`{"variable":"temperature","value":10.9,"time":"2015-03-26T14:40:00Z"}` +
+				`]}`
+			var postData PostDataPoints
+			err := json.Unmarshal([]byte(jsonString), &postData)
+
+			expectedPostData := PostDataPoints{
+				Data: []PostDataPoint{
+					PostDataPoint{Variable: "temperature", Value: 10.675, Time: time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)},
+					PostDataPoint{Variable: "temperature", Value: 10.9, Time: time.Date(2015, 3, 26, 14, 40, 0, 0, time.UTC)},
+				},
+			}
+			Expect(err).To(BeNil())
+			Expect(postData).To(Equal(expectedPostData))
+		})
+
+		Describe("TimeFor", func() {
+			data := PostDataPoints{Time: time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)}
+
+			It("returns the time of the data point if it has one", func() {
+				point := PostDataPoint{Time: time.Date(2015, 3, 25, 9, 0, 0, 0, time.UTC)}
+				Expect(data.TimeFor(point)).To(Equal(time.Date(2015, 3, 25, 9, 0, 0, 0, time.UTC)))
+			})
+
+			It("returns the time of the request if the data point does not have a time", func() {
+				Expect(data.TimeFor(PostDataPoint{})).To(Equal(time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC)))
+			})
+		})
+
 		Describe("validation", func() {
 			It("succeeds if all required properties are set", func() {
 				json := `{
@@ -66,6 +99,21 @@ var _ = Describe("Data resource", func() {
 				Expect(errors).To(BeEmpty())
 			})
 
+			It("succeeds if every data point has a time and the time property is not set", func() {
+				json := `{
+						"data": [
+							{
+								"variable": "temperature",
+								"value": 10.5,
+								"time": "2015-05-06T10:15:30Z"
+							}
+						]
+					}`
+
+				errors := TestValidation(json, PostDataPoints{})
+				Expect(errors).To(BeEmpty())
+			})
+
 			DescribeTable("it fails if the data is invalid", func(body string, classification string, missingFieldNames ...string) {
 				errors := TestValidation(body, PostDataPoints{})
 				Expect(errors).To(HaveLen(1))
@@ -74,12 +122,14 @@ var _ = Describe("Data resource", func() {
 			},
 				Entry("because the time property is not set", `{"data":[{"variable":"temperature", "value":10.5}]}`, binding.RequiredError, "time"),
 				Entry("because the time field is missing", `{"data":[{"variable":"temperature","value":10}]}`, binding.RequiredError, "time"),
+				Entry("because the time field is missing and only some data points have a time", `{"data":[{"variable":"temperature","value":10,"time":"2015-05-06T10:15:30Z"},{"variable":"temperature","value":11}]}`, binding.RequiredError, "time"),
 				Entry("because the data field is missing", `{"time":"2015-05-06T10:15:30Z"}`, binding.RequiredError, "data"),
 				Entry("because no data is provided", `{"time":"2015-05-06T10:15:30Z", "data":[]}`, binding.RequiredError, "data"),
 				Entry("because the variable field is empty", `{"time":"2015-05-06T10:15:30Z", "data":[{"variable":"", "value":10.5}]}`, binding.RequiredError, "variable"),
 				Entry("because the variable field is missing", `{"time":"2015-01-02T03:04:05Z","data":[{"value":10}]}`, binding.RequiredError, "variable"),
 				Entry("because the value field is empty", `{"time":"2015-01-02T03:04:05Z","data":[{"variable":"temperature","value":""}]}`, binding.DeserializationError),
 				Entry("because the value field is not a number", `{"time":"2015-01-02T03:04:05Z","data":[{"variable":"temperature","value":"abc"}]}`, binding.DeserializationError),
+				Entry("because the conflict policy is not supported", `{"time":"2015-01-02T03:04:05Z","onConflict":"blah","data":[{"variable":"temperature","value":10}]}`, "InvalidValue", "onConflict"),
 			)
 
 			DescribeTable("it fails if the time field is invalid", func(body string) {
@@ -120,23 +170,62 @@ var _ = Describe("Data resource", func() {
 			Expect(err).To(BeNil())
 			Expect(string(json)).To(MatchJSON(expectedJSON))
 		})
+
+		It("includes the interval and aggregate when the data is aggregated", func() {
+			data := GetDataResult{
+				Data: []GetDataResultVariable{
+					GetDataResultVariable{
+						VariableID:           10,
+						Name:                 "distance",
+						Units:                "metres",
+						DisplayDecimalPlaces: 1,
+						Interval:             "1h",
+						Aggregate:            "max",
+						Points: map[string]float64{
+							"2015-03-26T14:00:00Z": 15.3,
+							"2015-03-26T15:00:00Z": 15.0},
+					},
+				},
+			}
+
+			expectedJSON := `{"data":[{` +
+				`"id":10,` +
+				`"name":"distance",` +
+				`"units":"metres",` +
+				`"displayDecimalPlaces":1,` +
+				`"interval":"1h",` +
+				`"aggregate":"max",` +
+				`"points":{"2015-03-26T14:00:00Z":15.3,"2015-03-26T15:00:00Z":15}` +
+				`}]}`
+			json, err := json.Marshal(data)
+			Expect(err).To(BeNil())
+			Expect(string(json)).To(MatchJSON(expectedJSON))
+		})
 	})
 
 	Describe("POST request handler", func() {
 		var db *MockDatabase
 		var render *MockRender
+		var hub *DataHub
+		var notifier *AlertNotifier
 
 		agent := Agent{
 			AgentID: 10,
 		}
 
 		var makeRequest = func(data PostDataPoints) {
-			postDataPoints(render, data, agent, db, nil)
+			postDataPoints(render, data, agent, db, hub, notifier, nil)
 		}
 
 		BeforeEach(func() {
 			db = NewMockDatabase(mockController)
 			render = NewMockRender(mockController)
+			hub = NewDataHub()
+			notifier = NewAlertNotifier([]*net.IPNet{mustParseNetwork("127.0.0.0/8")})
+		})
+
+		AfterEach(func() {
+			notifier.Close()
 		})
 
 		Describe("when the request is valid", func() {
@@ -148,27 +237,424 @@ var _ = Describe("Data resource", func() {
 					},
 				}
 
-				createCall := db.EXPECT().AddDataPoint(DataPoint{
+				createCall := db.EXPECT().AddDataPoints([]DataPoint{{
 					AgentID:    agent.AgentID,
 					VariableID: 12,
 					Value:      10.5,
 					Time:       time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC),
+				}}, "reject").Return([]bool{false}, nil)
+
+				jsonCall := render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+					Expect(value).To(Equal(PostDataPointsResult{
+						Results: []PostDataPointResult{
+							{Variable: "temperature", Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC), Status: "created"},
+						},
+					}))
 				})
 
 				gomock.InOrder(
 					db.EXPECT().BeginTransaction(),
-					db.EXPECT().GetVariableIDForName("temperature").Return(12, nil),
+					db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
 					createCall,
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
 					db.EXPECT().CommitTransaction(),
-					render.EXPECT().Status(http.StatusCreated),
+					jsonCall,
 					db.EXPECT().RollbackUncommittedTransaction(),
 				)
 
 				makeRequest(data)
 			})
+
+			It("publishes an event for the saved data points to subscribers to the agent", func() {
+				events, unsubscribe := hub.Subscribe(agent.AgentID)
+				defer unsubscribe()
+
+				otherAgentEvents, unsubscribeOtherAgent := hub.Subscribe(agent.AgentID + 1)
+				defer unsubscribeOtherAgent()
+
+				data := PostDataPoints{
+					Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC),
+					Data: []PostDataPoint{
+						{Variable: "temperature", Value: 10.5, Time: time.Date(2015, 5, 6, 9, 0, 0, 0, time.UTC)},
+						{Variable: "temperature", Value: 10.7},
+					},
+				}
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+					db.EXPECT().AddDataPoints(gomock.Any(), "reject").Return([]bool{false, false}, nil),
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(data)
+
+				Expect(events).To(Receive(Equal(DataEvent{
+					ID:      5,
+					AgentID: agent.AgentID,
+					Points: []DataEventPoint{
+						{VariableID: 12, Variable: "temperature", Time: time.Date(2015, 5, 6, 9, 0, 0, 0, time.UTC), Value: 10.5},
+						{VariableID: 12, Variable: "temperature", Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC), Value: 10.7},
+					},
+				})))
+
+				Expect(otherAgentEvents).To(BeEmpty())
+			})
+
+			It("evaluates the agent's alert rules and sends an event to the webhook of each rule that starts firing", func() {
+				received := make(chan AlertEvent, 1)
+				webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					event := AlertEvent{}
+					json.NewDecoder(r.Body).Decode(&event)
+					received <- event
+				}))
+				defer webhook.Close()
+
+				dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+				data := PostDataPoints{
+					Time: dataTime,
+					Data: []PostDataPoint{
+						{Variable: "temperature", Value: -1.5},
+					},
+				}
+
+				rule := AlertRule{RuleID: 20, AgentID: agent.AgentID, VariableID: 12, Condition: "below", Threshold: 0, WebhookURL: webhook.URL, State: "ok"}
+				firingRule := rule
+				firingRule.State = "firing"
+				firingRule.PendingSince = dataTime
+				firingRule.LastValue = -1.5
+				firingRule.LastTime = dataTime
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+					db.EXPECT().AddDataPoints(gomock.Any(), "reject").Return([]bool{false}, nil),
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{rule}, nil),
+					db.EXPECT().AddAlertHistory(gomock.Any()).Do(func(entry *AlertHistoryEntry) {
+						Expect(entry.RuleID).To(Equal(20))
+						Expect(entry.Event).To(Equal("firing"))
+						Expect(entry.Value).To(Equal(-1.5))
+						Expect(entry.Time).To(Equal(dataTime))
+					}),
+					db.EXPECT().UpdateAlertRuleState(firingRule),
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(data)
+
+				Eventually(received).Should(Receive(Equal(AlertEvent{
+					RuleID:     20,
+					AgentID:    agent.AgentID,
+					VariableID: 12,
+					Variable:   "temperature",
+					Event:      "firing",
+					Condition:  "below",
+					Threshold:  0,
+					Value:      -1.5,
+					Time:       dataTime,
+				})))
+			})
+
+			It("saves each data point at its own time if it has one, looking up the variables and saving the points in one batch", func() {
+				data := PostDataPoints{
+					Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC),
+					Data: []PostDataPoint{
+						{Variable: "temperature", Value: 10.5, Time: time.Date(2015, 5, 6, 9, 0, 0, 0, time.UTC)},
+						{Variable: "temperature", Value: 10.7},
+					},
+				}
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+					db.EXPECT().AddDataPoints([]DataPoint{
+						{AgentID: agent.AgentID, VariableID: 12, Value: 10.5, Time: time.Date(2015, 5, 6, 9, 0, 0, 0, time.UTC)},
+						{AgentID: agent.AgentID, VariableID: 12, Value: 10.7, Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)},
+					}, "reject").Return([]bool{false, false}, nil),
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest(data)
+			})
+
+			Context("and some of the data points already have a value", func() {
+				dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+				var makeRequestWithConflictPolicy = func(onConflict string) {
+					data := PostDataPoints{
+						Time:       dataTime,
+						OnConflict: onConflict,
+						Data: []PostDataPoint{
+							{Variable: "temperature", Value: 10.5},
+							{Variable: "humidity", Value: 80},
+						},
+					}
+
+					makeRequest(data)
+				}
+
+				var expectDataPointsAdded = func(conflictPolicy string) {
+					db.EXPECT().BeginTransaction()
+					db.EXPECT().GetVariablesByName([]string{"temperature", "humidity"}).Return(map[string]Variable{"temperature": {VariableID: 12}, "humidity": {VariableID: 13}}, nil)
+					db.EXPECT().AddDataPoints([]DataPoint{
+						{AgentID: agent.AgentID, VariableID: 12, Value: 10.5, Time: dataTime},
+						{AgentID: agent.AgentID, VariableID: 13, Value: 80, Time: dataTime},
+					}, conflictPolicy).Return([]bool{true, false}, nil)
+					db.EXPECT().RollbackUncommittedTransaction()
+				}
+
+				It("does not save any data points and returns HTTP 409 response with the conflicting points if the conflict policy is not set", func() {
+					expectDataPointsAdded("reject")
+
+					render.EXPECT().JSON(http.StatusConflict, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime, Status: "conflict", Message: "There is already a value for this variable at this time."},
+								{Variable: "humidity", Time: dataTime, Status: "notSaved"},
+							},
+						}))
+					})
+
+					makeRequestWithConflictPolicy("")
+				})
+
+				It("does not publish an event if the request is rejected", func() {
+					events, unsubscribe := hub.Subscribe(agent.AgentID)
+					defer unsubscribe()
+
+					expectDataPointsAdded("reject")
+					render.EXPECT().JSON(http.StatusConflict, gomock.Any())
+
+					makeRequestWithConflictPolicy("")
+
+					Expect(events).To(BeEmpty())
+				})
+
+				It("publishes an event with only the points that were saved if the conflict policy is 'ignore'", func() {
+					events, unsubscribe := hub.Subscribe(agent.AgentID)
+					defer unsubscribe()
+
+					expectDataPointsAdded("ignore")
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil)
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil)
+					db.EXPECT().CommitTransaction()
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any())
+
+					makeRequestWithConflictPolicy("ignore")
+
+					Expect(events).To(Receive(Equal(DataEvent{
+						ID:      5,
+						AgentID: agent.AgentID,
+						Points: []DataEventPoint{
+							{VariableID: 13, Variable: "humidity", Time: dataTime, Value: 80},
+						},
+					})))
+				})
+
+				It("ignores the conflicting points and returns HTTP 201 response if the conflict policy is 'ignore'", func() {
+					expectDataPointsAdded("ignore")
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil)
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil)
+					db.EXPECT().CommitTransaction()
+
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime, Status: "ignored"},
+								{Variable: "humidity", Time: dataTime, Status: "created"},
+							},
+						}))
+					})
+
+					makeRequestWithConflictPolicy("ignore")
+				})
+
+				It("overwrites the conflicting points and returns HTTP 201 response if the conflict policy is 'overwrite'", func() {
+					expectDataPointsAdded("overwrite")
+					db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil)
+					db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil)
+					db.EXPECT().CommitTransaction()
+
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime, Status: "overwritten"},
+								{Variable: "humidity", Time: dataTime, Status: "created"},
+							},
+						}))
+					})
+
+					makeRequestWithConflictPolicy("overwrite")
+				})
+			})
+
+			Context("and some of the data points are outside their variable's limits", func() {
+				dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+				minValue, maxValue, maxStep := -40.0, 60.0, 5.0
+
+				var makeRequestWithPolicy = func(outOfRange string) {
+					temperature := Variable{VariableID: 12, VariableLimits: VariableLimits{MinValue: &minValue, MaxValue: &maxValue, MaxStep: &maxStep, OutOfRange: outOfRange}}
+
+					db.EXPECT().BeginTransaction()
+					db.EXPECT().GetVariablesByName([]string{"temperature", "humidity"}).Return(map[string]Variable{"temperature": temperature, "humidity": {VariableID: 13}}, nil)
+					db.EXPECT().RollbackUncommittedTransaction()
+
+					makeRequest(PostDataPoints{
+						Time: dataTime,
+						Data: []PostDataPoint{
+							{Variable: "temperature", Value: 850, Time: dataTime.Add(-2 * time.Minute)},
+							{Variable: "temperature", Value: 21, Time: dataTime.Add(-time.Minute)},
+							{Variable: "temperature", Value: 30},
+							{Variable: "humidity", Value: 80},
+						},
+					})
+				}
+
+				It("does not save any data points and returns HTTP 400 response with the points that are out of range if the policy is not set", func() {
+					render.EXPECT().JSON(http.StatusBadRequest, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime.Add(-2 * time.Minute), Status: "outOfRange", Message: "Value 850 is greater than the maximum of 60."},
+								{Variable: "temperature", Time: dataTime.Add(-time.Minute), Status: "notSaved"},
+								{Variable: "temperature", Time: dataTime, Status: "notSaved"},
+								{Variable: "humidity", Time: dataTime, Status: "notSaved"},
+							},
+						}))
+					})
+
+					makeRequestWithPolicy("")
+				})
+
+				It("saves the other data points and returns HTTP 201 response with the points that were dropped if the policy is 'drop'", func() {
+					// Only the first point checked needs the previous value from the database, the earlier point is used for the later one.
+					gomock.InOrder(
+						db.EXPECT().GetLatestData(agent.AgentID).Return(map[int]DataPoint{}, nil),
+						db.EXPECT().GetPreviousValue(agent.AgentID, 12, dataTime.Add(-time.Minute)).Return(20.0, true, nil),
+						db.EXPECT().AddDataPoints([]DataPoint{
+							{AgentID: agent.AgentID, VariableID: 12, Value: 21, Time: dataTime.Add(-time.Minute)},
+							{AgentID: agent.AgentID, VariableID: 13, Value: 80, Time: dataTime},
+						}, "reject").Return([]bool{false, false}, nil),
+						db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+						db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+						db.EXPECT().CommitTransaction(),
+					)
+
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime.Add(-2 * time.Minute), Status: "dropped", Message: "Value 850 is greater than the maximum of 60."},
+								{Variable: "temperature", Time: dataTime.Add(-time.Minute), Status: "created"},
+								{Variable: "temperature", Time: dataTime, Status: "dropped", Message: "Value 30 changed by more than 5 from the previous value of 21."},
+								{Variable: "humidity", Time: dataTime, Status: "created"},
+							},
+						}))
+					})
+
+					makeRequestWithPolicy("drop")
+				})
+
+				It("saves every data point, marking the points that are out of range as suspect, if the policy is 'flag'", func() {
+					gomock.InOrder(
+						db.EXPECT().GetLatestData(agent.AgentID).Return(map[int]DataPoint{}, nil),
+						db.EXPECT().GetPreviousValue(agent.AgentID, 12, dataTime.Add(-time.Minute)).Return(20.0, true, nil),
+						db.EXPECT().AddDataPoints([]DataPoint{
+							{AgentID: agent.AgentID, VariableID: 12, Value: 850, Time: dataTime.Add(-2 * time.Minute), Quality: "suspect"},
+							{AgentID: agent.AgentID, VariableID: 12, Value: 21, Time: dataTime.Add(-time.Minute)},
+							{AgentID: agent.AgentID, VariableID: 12, Value: 30, Time: dataTime, Quality: "suspect"},
+							{AgentID: agent.AgentID, VariableID: 13, Value: 80, Time: dataTime},
+						}, "reject").Return([]bool{false, false, false, false}, nil),
+						db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+						db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+						db.EXPECT().CommitTransaction(),
+					)
+
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime.Add(-2 * time.Minute), Status: "created", Quality: "suspect", Message: "Value 850 is greater than the maximum of 60."},
+								{Variable: "temperature", Time: dataTime.Add(-time.Minute), Status: "created"},
+								{Variable: "temperature", Time: dataTime, Status: "created", Quality: "suspect", Message: "Value 30 changed by more than 5 from the previous value of 21."},
+								{Variable: "humidity", Time: dataTime, Status: "created"},
+							},
+						}))
+					})
+
+					makeRequestWithPolicy("flag")
+				})
+
+				It("looks up the previous value of each point in the database if the points are not after the latest data already saved", func() {
+					// The earlier point is saved before the later one is checked, so that it is used as the previous value.
+					gomock.InOrder(
+						db.EXPECT().GetLatestData(agent.AgentID).Return(map[int]DataPoint{12: {AgentID: agent.AgentID, VariableID: 12, Value: 20, Time: dataTime}}, nil),
+						db.EXPECT().GetPreviousValue(agent.AgentID, 12, dataTime.Add(-time.Minute)).Return(20.0, true, nil),
+						db.EXPECT().AddDataPoints([]DataPoint{{AgentID: agent.AgentID, VariableID: 12, Value: 21, Time: dataTime.Add(-time.Minute)}}, "reject").Return([]bool{false}, nil),
+						db.EXPECT().GetPreviousValue(agent.AgentID, 12, dataTime).Return(21.0, true, nil),
+						db.EXPECT().AddDataPoints([]DataPoint{{AgentID: agent.AgentID, VariableID: 13, Value: 80, Time: dataTime}}, "reject").Return([]bool{false}, nil),
+						db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+						db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+						db.EXPECT().CommitTransaction(),
+					)
+
+					render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime.Add(-2 * time.Minute), Status: "dropped", Message: "Value 850 is greater than the maximum of 60."},
+								{Variable: "temperature", Time: dataTime.Add(-time.Minute), Status: "created"},
+								{Variable: "temperature", Time: dataTime, Status: "dropped", Message: "Value 30 changed by more than 5 from the previous value of 21."},
+								{Variable: "humidity", Time: dataTime, Status: "created"},
+							},
+						}))
+					})
+
+					makeRequestWithPolicy("drop")
+				})
+			})
 		})
 
 		Describe("when the request is invalid", func() {
+			Describe("because a value is not a finite number", func() {
+				It("does not save any data points and returns HTTP 400 response with the points that cannot be stored", func() {
+					dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+					data := PostDataPoints{
+						Time: dataTime,
+						Data: []PostDataPoint{
+							{Variable: "temperature", Value: 10.5},
+							{Variable: "temperature", Value: math.Inf(1), Time: dataTime.Add(time.Minute)},
+						},
+					}
+
+					jsonCall := render.EXPECT().JSON(http.StatusBadRequest, gomock.Any()).Do(func(status int, value interface{}) {
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime, Status: "notSaved"},
+								{Variable: "temperature", Time: dataTime.Add(time.Minute), Status: "invalidValue", Message: "Value +Inf is not a finite number, and cannot be stored."},
+							},
+						}))
+					})
+
+					gomock.InOrder(
+						db.EXPECT().BeginTransaction(),
+						db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+						jsonCall,
+						db.EXPECT().RollbackUncommittedTransaction(),
+					)
+
+					makeRequest(data)
+				})
+			})
+
 			Describe("because the variable name does not match any known variable", func() {
 				It("does not save the variable to the database and returns HTTP 400 response", func() {
 					data := PostDataPoints{
@@ -180,8 +666,40 @@ var _ = Describe("Data resource", func() {
 
 					gomock.InOrder(
 						db.EXPECT().BeginTransaction(),
-						db.EXPECT().GetVariableIDForName("nothing").Return(-1, errors.New("Doesn't exisit")),
-						render.EXPECT().Text(http.StatusBadRequest, gomock.Any()),
+						db.EXPECT().GetVariablesByName([]string{"nothing"}).Return(map[string]Variable{}, nil),
+						render.EXPECT().JSON(http.StatusBadRequest, gomock.Any()),
+						db.EXPECT().RollbackUncommittedTransaction(),
+					)
+
+					makeRequest(data)
+				})
+
+				It("reports the result for each data point", func() {
+					data := PostDataPoints{
+						Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC),
+						Data: []PostDataPoint{
+							{Variable: "temperature", Value: 10.5},
+							{Variable: "nothing", Value: 10.5},
+							{Variable: "humidity", Value: 80},
+						},
+					}
+
+					jsonCall := render.EXPECT().JSON(http.StatusBadRequest, gomock.Any()).Do(func(status int, value interface{}) {
+						dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+						Expect(value).To(Equal(PostDataPointsResult{
+							Results: []PostDataPointResult{
+								{Variable: "temperature", Time: dataTime, Status: "notSaved"},
+								{Variable: "nothing", Time: dataTime, Status: "unknownVariable", Message: "Could not find variable with name 'nothing'."},
+								{Variable: "humidity", Time: dataTime, Status: "notSaved"},
+							},
+						}))
+					})
+
+					gomock.InOrder(
+						db.EXPECT().BeginTransaction(),
+						db.EXPECT().GetVariablesByName([]string{"temperature", "nothing", "humidity"}).Return(map[string]Variable{"temperature": {VariableID: 12}, "humidity": {VariableID: 13}}, nil),
+						jsonCall,
 						db.EXPECT().RollbackUncommittedTransaction(),
 					)
 
@@ -196,7 +714,7 @@ var _ = Describe("Data resource", func() {
 			request, _ := http.NewRequest("GET", "/blah?"+query, strings.NewReader(""))
 			params := martini.Params{"agent_id": agentID}
 
-			getData(render, request, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+			getData(render, request, httptest.NewRecorder(), params, db, user, logrus.NewEntry(logrus.StandardLogger()))
 		}
 
 		var db *MockDatabase
@@ -242,9 +760,9 @@ var _ = Describe("Data resource", func() {
 					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
 					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil),
 					db.EXPECT().GetVariableByID(123).Return(variable123, nil),
-					db.EXPECT().GetData(1, 123, fromDate, toDate).Return(variable123Data, nil),
+					db.EXPECT().GetData(1, 123, fromDate, toDate, true).Return(variable123Data, nil),
 					db.EXPECT().GetVariableByID(321).Return(variable321, nil),
-					db.EXPECT().GetData(1, 321, fromDate, toDate).Return(variable321Data, nil),
+					db.EXPECT().GetData(1, 321, fromDate, toDate, true).Return(variable321Data, nil),
 					db.EXPECT().CommitTransaction(),
 					jsonCall,
 					db.EXPECT().RollbackUncommittedTransaction(),
@@ -252,6 +770,73 @@ var _ = Describe("Data resource", func() {
 
 				makeRequest("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z", "1", render, user, db)
 			})
+
+			It("returns aggregated data when an interval and aggregate are given", func() {
+				jsonCall := render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+					bytes, err := json.Marshal(value)
+					Expect(err).To(BeNil())
+
+					json := string(bytes)
+					Expect(json).To(MatchJSON(`{"data":[` +
+						`{"id":123,"name":"temperature","units":"°C","displayDecimalPlaces":1,"interval":"3h","aggregate":"min","points":{"2015-03-27T06:00:00Z":100,"2015-03-27T09:00:00Z":105}}` +
+						`]}`))
+				})
+
+				fromDate := time.Date(2015, 3, 27, 5, 0, 0, 0, time.UTC)
+				toDate := time.Date(2015, 3, 28, 23, 50, 45, 0, time.UTC)
+				user := User{UserID: 1000}
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
+					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil),
+					db.EXPECT().GetVariableByID(123).Return(variable123, nil),
+					db.EXPECT().GetAggregatedData(1, 123, fromDate, toDate, 3*time.Hour, "min", true).Return(variable123Data, nil),
+					db.EXPECT().CommitTransaction(),
+					jsonCall,
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=3h&aggregate=min", "1", render, user, db)
+			})
+
+			It("averages the data when an interval is given without an aggregate", func() {
+				fromDate := time.Date(2015, 3, 27, 5, 0, 0, 0, time.UTC)
+				toDate := time.Date(2015, 3, 28, 23, 50, 45, 0, time.UTC)
+				user := User{UserID: 1000}
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
+					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil),
+					db.EXPECT().GetVariableByID(123).Return(variable123, nil),
+					db.EXPECT().GetAggregatedData(1, 123, fromDate, toDate, 24*time.Hour, "avg", true).Return(variable123Data, nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusOK, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=1d", "1", render, user, db)
+			})
+
+			It("leaves out flagged points when asked to", func() {
+				fromDate := time.Date(2015, 3, 27, 5, 0, 0, 0, time.UTC)
+				toDate := time.Date(2015, 3, 28, 23, 50, 45, 0, time.UTC)
+				user := User{UserID: 1000}
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
+					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil),
+					db.EXPECT().GetVariableByID(123).Return(variable123, nil),
+					db.EXPECT().GetData(1, 123, fromDate, toDate, false).Return(variable123Data, nil),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().JSON(http.StatusOK, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&flagged=exclude", "1", render, user, db)
+			})
 		})
 
 		Context("when the user is not the owner of the agent", func() {
@@ -325,6 +910,112 @@ var _ = Describe("Data resource", func() {
 			Context("because the to date is before the from date", func() {
 				TheRequestFails("variable=123&variable=321&date_from=2015-03-27T05:00:00Z&date_to=2015-03-26T23:50:45Z", "1")
 			})
+
+			Context("because the interval is in an invalid format", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=blah", "1")
+			})
+
+			Context("because the interval is less than one second", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=10ms", "1")
+			})
+
+			Context("because an aggregate is given without an interval", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&aggregate=max", "1")
+			})
+
+			Context("because the flagged option is not supported", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&flagged=only", "1")
+			})
+
+			Context("because the format is not supported", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&format=xml", "1")
+			})
+
+			Context("because the aggregate is not supported", func() {
+				TheRequestFails("variable=123&date_from=2015-03-27T05:00:00Z&date_to=2015-03-28T23:50:45Z&interval=1h&aggregate=median", "1")
+			})
+		})
+	})
+	Describe("GET latest request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+
+		var makeRequest = func(agentID string, user User) {
+			params := martini.Params{"agent_id": agentID}
+
+			getLatestData(render, params, db, user, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+		})
+
+		Context("when the request is valid", func() {
+			It("returns the most recent value of each variable", func() {
+				user := User{UserID: 1000}
+
+				variables := []Variable{
+					Variable{VariableID: 123, Name: "temperature", Units: "°C", DisplayDecimalPlaces: 1},
+					Variable{VariableID: 321, Name: "humidity", Units: "%", DisplayDecimalPlaces: 2},
+				}
+
+				latest := map[int]DataPoint{
+					123: DataPoint{AgentID: 1, VariableID: 123, Time: time.Date(2015, 3, 27, 9, 0, 0, 0, time.UTC), Value: 10.5},
+					321: DataPoint{AgentID: 1, VariableID: 321, Time: time.Date(2015, 3, 27, 8, 0, 0, 0, time.UTC), Value: 80.9},
+				}
+
+				jsonCall := render.EXPECT().JSON(http.StatusOK, gomock.Any()).Do(func(status int, value interface{}) {
+					bytes, err := json.Marshal(value)
+					Expect(err).To(BeNil())
+
+					json := string(bytes)
+					Expect(json).To(MatchJSON(`{"data":[` +
+						`{"id":123,"name":"temperature","units":"°C","displayDecimalPlaces":1,"time":"2015-03-27T09:00:00Z","value":10.5},` +
+						`{"id":321,"name":"humidity","units":"%","displayDecimalPlaces":2,"time":"2015-03-27T08:00:00Z","value":80.9}` +
+						`]}`))
+				})
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
+					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: user.UserID}, nil),
+					db.EXPECT().GetVariablesForAgent(1).Return(variables, nil),
+					db.EXPECT().GetLatestData(1).Return(latest, nil),
+					db.EXPECT().CommitTransaction(),
+					jsonCall,
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("1", user)
+			})
+		})
+
+		Context("when the user is not the owner of the agent", func() {
+			It("returns a HTTP 403 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1).Return(true, nil),
+					db.EXPECT().GetAgentByID(1).Return(Agent{OwnerUserID: 1000}, nil),
+					render.EXPECT().Error(http.StatusForbidden),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("1", User{UserID: 1234})
+			})
+		})
+
+		Context("when the agent does not exist", func() {
+			It("returns a HTTP 404 response", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(909090).Return(false, nil),
+					render.EXPECT().Text(http.StatusNotFound, gomock.Any()),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				makeRequest("909090", User{UserID: 1234})
+			})
 		})
 	})
 })
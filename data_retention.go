@@ -0,0 +1,191 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	rollupResolutionHourly = time.Hour
+	rollupResolutionDaily  = 24 * time.Hour
+)
+
+// rollupResolutions are the resolutions data is rolled up into once it is older than its variable's retention period.
+var rollupResolutions = []time.Duration{rollupResolutionHourly, rollupResolutionDaily}
+
+// DataRollup summarises the good data points for an agent and variable in the hour or day starting at Time.
+type DataRollup struct {
+	AgentID    int
+	VariableID int
+	Time       time.Time
+	Min        float64
+	Max        float64
+	Avg        float64
+	Count      int
+}
+
+// rollupOfValue returns a rollup that summarises a single data point.
+func rollupOfValue(t time.Time, value float64) DataRollup {
+	return DataRollup{Time: t, Min: value, Max: value, Avg: value, Count: 1}
+}
+
+// Sum returns the total of the values the rollup summarises.
+func (rollup DataRollup) Sum() float64 {
+	return rollup.Avg * float64(rollup.Count)
+}
+
+// Merge returns a rollup that summarises the values summarised by both rollups, keeping the time of this rollup.
+func (rollup DataRollup) Merge(other DataRollup) DataRollup {
+	merged := rollup
+	merged.Count = rollup.Count + other.Count
+	merged.Avg = (rollup.Sum() + other.Sum()) / float64(merged.Count)
+
+	if other.Min < merged.Min {
+		merged.Min = other.Min
+	}
+
+	if other.Max > merged.Max {
+		merged.Max = other.Max
+	}
+
+	return merged
+}
+
+// rollUpPoints summarises points into rollups of the given resolution, aligned to the Unix epoch. Flagged points are
+// not included.
+func rollUpPoints(points []DataPoint, resolution time.Duration) []DataRollup {
+	type rollupKey struct {
+		AgentID    int
+		VariableID int
+		Time       int64
+	}
+
+	rollups := []DataRollup{}
+	indexes := map[rollupKey]int{}
+
+	for _, point := range points {
+		if point.Quality != dataQualityGood && point.Quality != dataQualityCorrected {
+			continue
+		}
+
+		start := bucketStart(point.Time, resolution)
+		key := rollupKey{point.AgentID, point.VariableID, start.UnixNano()}
+		rollup := rollupOfValue(start, point.Value)
+		rollup.AgentID = point.AgentID
+		rollup.VariableID = point.VariableID
+
+		if index, ok := indexes[key]; ok {
+			rollups[index] = rollups[index].Merge(rollup)
+		} else {
+			indexes[key] = len(rollups)
+			rollups = append(rollups, rollup)
+		}
+	}
+
+	return rollups
+}
+
+// rollupResolutionFor returns the resolution of the rollups to use for aggregating data into buckets of interval:
+// daily rollups if each bucket is a whole number of days, and hourly rollups otherwise. An interval of 0 means the
+// data is not being aggregated, and so the hourly rollups are used in place of the points they summarise.
+func rollupResolutionFor(interval time.Duration) time.Duration {
+	if interval > 0 && interval%rollupResolutionDaily == 0 {
+		return rollupResolutionDaily
+	}
+
+	return rollupResolutionHourly
+}
+
+// rawDataCutoff returns the time before which data is rolled up if it is kept at full resolution for the given number
+// of days. It is the start of a day, so that every hourly and daily rollup is made from a whole hour or day of data.
+func rawDataCutoff(now time.Time, days int) time.Time {
+	cutoff := now.UTC().AddDate(0, 0, -days)
+
+	return time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// DataRetentionJob periodically rolls up and removes the data that is older than its variable's retention period.
+type DataRetentionJob struct {
+	db                 Database
+	interval           time.Duration
+	defaultRawDataDays int
+	stop               chan struct{}
+	stopped            chan struct{}
+}
+
+// NewDataRetentionJob starts rolling up data every interval, keeping data for variables without their own retention
+// policy for defaultRawDataDays (or forever, if it is 0). db must not be shared with anything else.
+func NewDataRetentionJob(db Database, interval time.Duration, defaultRawDataDays int) *DataRetentionJob {
+	j := &DataRetentionJob{
+		db:                 db,
+		interval:           interval,
+		defaultRawDataDays: defaultRawDataDays,
+		stop:               make(chan struct{}),
+		stopped:            make(chan struct{}),
+	}
+
+	go j.run()
+
+	return j
+}
+
+// Close stops rolling up data, and waits for any run in progress to finish.
+func (j *DataRetentionJob) Close() {
+	close(j.stop)
+	<-j.stopped
+}
+
+func (j *DataRetentionJob) run() {
+	defer close(j.stopped)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := j.Run(now); err != nil {
+				logrus.WithError(err).Error("Could not roll up data.")
+			}
+
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Run rolls up and removes the data that is older than its variable's retention period as of now.
+func (j *DataRetentionJob) Run(now time.Time) error {
+	if err := j.db.BeginTransaction(); err != nil {
+		return err
+	}
+
+	defer j.db.RollbackUncommittedTransaction()
+
+	variables, err := j.db.GetAllVariables()
+
+	if err != nil {
+		return err
+	}
+
+	for _, variable := range variables {
+		days := variable.RawDataDaysOrDefault(j.defaultRawDataDays)
+
+		if days == 0 {
+			continue
+		}
+
+		count, err := j.db.RollUpData(variable.VariableID, rawDataCutoff(now, days))
+
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			logrus.WithFields(logrus.Fields{"variableId": variable.VariableID, "count": count}).Infof("Rolled up data for variable '%s'.", variable.Name)
+		}
+	}
+
+	return j.db.CommitTransaction()
+}
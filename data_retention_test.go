@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Data retention", func() {
+	Describe("DataRollup", func() {
+		It("merges the values summarised by two rollups", func() {
+			t := time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)
+			first := DataRollup{AgentID: 1001, VariableID: 2002, Time: t, Min: 101, Max: 105, Avg: 103, Count: 4}
+			second := DataRollup{AgentID: 1001, VariableID: 2002, Time: t.Add(time.Minute), Min: 99, Max: 104, Avg: 100, Count: 2}
+
+			Expect(first.Merge(second)).To(Equal(DataRollup{AgentID: 1001, VariableID: 2002, Time: t, Min: 99, Max: 105, Avg: 102, Count: 6}))
+			Expect(first.Sum()).To(Equal(float64(412)))
+		})
+	})
+
+	Describe("rollUpPoints", func() {
+		It("summarises the good and corrected points for each agent and variable in each period", func() {
+			points := []DataPoint{
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), Value: 101, Quality: dataQualityGood},
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 59, 0, 0, time.UTC), Value: 103, Quality: dataQualityCorrected},
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 30, 0, 0, time.UTC), Value: 500, Quality: dataQualityBad},
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 104, Quality: dataQualityGood},
+				{AgentID: 1002, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 10, 0, 0, time.UTC), Value: 90, Quality: dataQualityGood},
+			}
+
+			Expect(rollUpPoints(points, time.Hour)).To(Equal([]DataRollup{
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), Min: 101, Max: 103, Avg: 102, Count: 2},
+				{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Min: 104, Max: 104, Avg: 104, Count: 1},
+				{AgentID: 1002, VariableID: 2002, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), Min: 90, Max: 90, Avg: 90, Count: 1},
+			}))
+		})
+	})
+
+	DescribeTable("rollupResolutionFor uses daily rollups only for whole numbers of days", func(interval time.Duration, expected time.Duration) {
+		Expect(rollupResolutionFor(interval)).To(Equal(expected))
+	},
+		Entry("not aggregated", time.Duration(0), time.Hour),
+		Entry("minutes", 5*time.Minute, time.Hour),
+		Entry("hours", 6*time.Hour, time.Hour),
+		Entry("a day and a half", 36*time.Hour, time.Hour),
+		Entry("a day", 24*time.Hour, 24*time.Hour),
+		Entry("a week", 7*24*time.Hour, 24*time.Hour),
+	)
+
+	It("rawDataCutoff returns the start of the day the given number of days ago", func() {
+		now := time.Date(2015, 5, 6, 10, 15, 30, 0, time.FixedZone("AEST", 10*60*60))
+
+		Expect(rawDataCutoff(now, 30)).To(Equal(time.Date(2015, 4, 6, 0, 0, 0, 0, time.UTC)))
+	})
+
+	Describe("DataRetentionJob", func() {
+		var mockController *gomock.Controller
+		var db *MockDatabase
+
+		now := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+		sevenDays := 7
+
+		BeforeEach(func() {
+			mockController = gomock.NewController(GinkgoT())
+			db = NewMockDatabase(mockController)
+		})
+
+		AfterEach(func() {
+			mockController.Finish()
+		})
+
+		Describe("Run", func() {
+			It("rolls up the data for each variable using its own retention policy or the default one", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetAllVariables().Return([]Variable{
+						{VariableID: 2001, Name: "distance"},
+						{VariableID: 2002, Name: "humidity", VariableRetention: VariableRetention{RawDataDays: &sevenDays}},
+					}, nil),
+					db.EXPECT().RollUpData(2001, time.Date(2015, 4, 6, 0, 0, 0, 0, time.UTC)).Return(10, nil),
+					db.EXPECT().RollUpData(2002, time.Date(2015, 4, 29, 0, 0, 0, 0, time.UTC)).Return(0, nil),
+					db.EXPECT().CommitTransaction(),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				job := &DataRetentionJob{db: db, defaultRawDataDays: 30}
+				Expect(job.Run(now)).To(Succeed())
+			})
+
+			It("keeps the data for variables without their own retention policy if there is no default", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetAllVariables().Return([]Variable{
+						{VariableID: 2001, Name: "distance"},
+						{VariableID: 2002, Name: "humidity", VariableRetention: VariableRetention{RawDataDays: &sevenDays}},
+					}, nil),
+					db.EXPECT().RollUpData(2002, time.Date(2015, 4, 29, 0, 0, 0, 0, time.UTC)).Return(3, nil),
+					db.EXPECT().CommitTransaction(),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				job := &DataRetentionJob{db: db}
+				Expect(job.Run(now)).To(Succeed())
+			})
+
+			It("keeps the data for variables whose own retention policy is to keep it forever, even if there is a default", func() {
+				forever := 0
+
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetAllVariables().Return([]Variable{
+						{VariableID: 2001, Name: "distance", VariableRetention: VariableRetention{RawDataDays: &forever}},
+						{VariableID: 2002, Name: "humidity"},
+					}, nil),
+					db.EXPECT().RollUpData(2002, time.Date(2015, 4, 6, 0, 0, 0, 0, time.UTC)).Return(3, nil),
+					db.EXPECT().CommitTransaction(),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				job := &DataRetentionJob{db: db, defaultRawDataDays: 30}
+				Expect(job.Run(now)).To(Succeed())
+			})
+
+			It("does not commit if the data cannot be rolled up", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().GetAllVariables().Return([]Variable{{VariableID: 2001}}, nil),
+					db.EXPECT().RollUpData(2001, gomock.Any()).Return(0, errors.New("Something went wrong.")),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				job := &DataRetentionJob{db: db, defaultRawDataDays: 30}
+				Expect(job.Run(now)).To(MatchError("Something went wrong."))
+			})
+		})
+
+		It("rolls up data every interval until it is closed", func() {
+			rolledUp := make(chan time.Time, 10)
+
+			db.EXPECT().BeginTransaction().MinTimes(2)
+			db.EXPECT().GetAllVariables().Return([]Variable{{VariableID: 2001}}, nil).MinTimes(2)
+			db.EXPECT().RollUpData(2001, gomock.Any()).Do(func(variableID int, before time.Time) {
+				rolledUp <- before
+			}).Return(0, nil).MinTimes(2)
+			db.EXPECT().CommitTransaction().MinTimes(2)
+			db.EXPECT().RollbackUncommittedTransaction().MinTimes(2)
+
+			job := NewDataRetentionJob(db, time.Millisecond, 30)
+
+			Eventually(rolledUp).Should(Receive())
+			Eventually(rolledUp).Should(Receive())
+
+			job.Close()
+		})
+	})
+})
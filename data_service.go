@@ -1,14 +1,29 @@
 package main
 
 import (
+	"database/sql"
 	"flag"
 	log "github.com/Sirupsen/logrus"
 	"os"
+	"time"
 )
 
 type Config struct {
-	ServerAddress  string
-	DataSourceName string
+	ServerAddress          string
+	DataSourceName         string
+	MaxOpenConnections     int
+	MaxIdleConnections     int
+	ConnectionMaxLifetime  time.Duration
+	AgentStatusInterval    time.Duration
+	AgentStatusWebhookURL  string
+	WebhookAllowedNetworks string
+	RawDataRetentionDays   int
+	RollupInterval         time.Duration
+	MQTTBrokerURL          string
+	MQTTClientID           string
+	MQTTUsername           string
+	MQTTPassword           string
+	MQTTTopicPrefix        string
 }
 
 func readOptions() Config {
@@ -16,7 +31,20 @@ func readOptions() Config {
 
 	flagSet := flag.NewFlagSet("weather-thingy-data-service", flag.ExitOnError)
 	flagSet.StringVar(&args.ServerAddress, "address", ":8080", "The port (and optional address) the server should listen on.")
-	flagSet.StringVar(&args.DataSourceName, "dataSource", "postgres://weatherthingy@localhost/weatherthingy?sslmode=disable", "The data source URL to use.")
+	flagSet.StringVar(&args.DataSourceName, "dataSource", "postgres://weatherthingy@localhost/weatherthingy?sslmode=disable", "The data source URL to use: a PostgreSQL URL, sqlite:<path> for a SQLite database file, or memory: for a database kept in memory that is lost when the service stops.")
+	flagSet.IntVar(&args.MaxOpenConnections, "maxOpenConnections", 20, "The maximum number of open connections to the database (0 for no limit).")
+	flagSet.IntVar(&args.MaxIdleConnections, "maxIdleConnections", 10, "The maximum number of idle connections to the database to keep open.")
+	flagSet.DurationVar(&args.ConnectionMaxLifetime, "connectionMaxLifetime", 30*time.Minute, "The maximum amount of time a connection to the database can be reused for (0 for no limit).")
+	flagSet.DurationVar(&args.AgentStatusInterval, "agentStatusInterval", time.Minute, "How often to check for agents that have gone offline (0 to disable).")
+	flagSet.StringVar(&args.AgentStatusWebhookURL, "agentStatusWebhook", "", "The URL to POST an event to when an agent goes offline or comes back online (optional).")
+	flagSet.StringVar(&args.WebhookAllowedNetworks, "webhookAllowedNetworks", "", "A comma-separated list of networks in CIDR notation, eg. 10.1.0.0/16, that webhooks may be sent to even though they're loopback, private or link-local addresses (optional). Webhooks to other non-public addresses are refused.")
+	flagSet.IntVar(&args.RawDataRetentionDays, "rawDataRetentionDays", 0, "The number of days to keep data at full resolution before rolling it up into hourly and daily summaries, for variables without their own retention policy (0 to keep it forever).")
+	flagSet.DurationVar(&args.RollupInterval, "rollupInterval", time.Hour, "How often to roll up data that is older than its retention period (0 to disable).")
+	flagSet.StringVar(&args.MQTTBrokerURL, "mqttBroker", "", "The URL of the MQTT broker to receive data from, eg. tcp://localhost:1883 (optional). The broker must check agents' credentials and access to topics with POST /v1/mqtt/auth/user, /v1/mqtt/auth/superuser and /v1/mqtt/auth/acl.")
+	flagSet.StringVar(&args.MQTTClientID, "mqttClientID", "weather-thingy-data-service", "The client ID to use when connecting to the MQTT broker.")
+	flagSet.StringVar(&args.MQTTUsername, "mqttUsername", "", "The username to use when connecting to the MQTT broker (optional).")
+	flagSet.StringVar(&args.MQTTPassword, "mqttPassword", "", "The password to use when connecting to the MQTT broker (optional). The broker only accepts the bridge's username if a password is set.")
+	flagSet.StringVar(&args.MQTTTopicPrefix, "mqttTopicPrefix", "weather", "The prefix of the topics agents publish data to, which are in the format <prefix>/<agent ID>/<variable>.")
 	flagSet.Parse(os.Args[1:])
 
 	return args
@@ -43,6 +71,17 @@ func runMigrations(config Config) {
 	}
 }
 
+func configureConnectionPool(db *sql.DB, config Config) {
+	if db == nil {
+		// The database does not use a connection pool.
+		return
+	}
+
+	db.SetMaxOpenConns(config.MaxOpenConnections)
+	db.SetMaxIdleConns(config.MaxIdleConnections)
+	db.SetConnMaxLifetime(config.ConnectionMaxLifetime)
+}
+
 func main() {
 	config := readOptions()
 
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+)
+
+const socketPingInterval = 30 * time.Second
+const socketReadTimeout = 2 * socketPingInterval
+const socketWriteTimeout = 10 * time.Second
+
+const (
+	socketActionSubscribe   = "subscribe"
+	socketActionUnsubscribe = "unsubscribe"
+)
+
+const (
+	socketMessageSubscribed   = "subscribed"
+	socketMessageUnsubscribed = "unsubscribed"
+	socketMessageData         = "data"
+	socketMessageError        = "error"
+)
+
+// SocketRequest is a message sent by a client over the data socket. An empty VariableIDs subscribes to all variables.
+type SocketRequest struct {
+	Action      string `json:"action"`
+	AgentID     int    `json:"agentId"`
+	VariableIDs []int  `json:"variableIds"`
+}
+
+// SocketMessage is a message sent to a client over the data socket.
+type SocketMessage struct {
+	Type    string           `json:"type"`
+	AgentID int              `json:"agentId,omitempty"`
+	Message string           `json:"message,omitempty"`
+	Points  []DataEventPoint `json:"points,omitempty"`
+}
+
+type socketSubscription struct {
+	variableIDs map[int]bool
+	unsubscribe func()
+}
+
+type socketEvent struct {
+	subscription *socketSubscription
+	event        DataEvent
+	ok           bool
+}
+
+var socketUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// getDataSocket lets a client subscribe to data from many agents over a single WebSocket. Each subscription is checked
+// against the same ownership rule as getAgent.
+func getDataSocket(res http.ResponseWriter, req *http.Request, db Database, user User, hub *DataHub, log *logrus.Entry) {
+	conn, err := socketUpgrader.Upgrade(res, req, nil)
+
+	if err != nil {
+		// Upgrade has already responded to the client.
+		log.WithError(err).Error("Could not upgrade connection to a WebSocket.")
+		return
+	}
+
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	requests := readSocketRequests(conn, done, log)
+	events := make(chan socketEvent)
+	subscriptions := map[int]*socketSubscription{}
+
+	defer func() {
+		for _, subscription := range subscriptions {
+			subscription.unsubscribe()
+		}
+	}()
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		var message *SocketMessage
+
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				return
+			}
+
+			message = handleSocketRequest(request, subscriptions, events, done, db, user, hub, log)
+
+		case e := <-events:
+			if subscriptions[e.event.AgentID] != e.subscription {
+				// This event is for a subscription that has since been replaced or removed.
+				continue
+			}
+
+			if !e.ok {
+				delete(subscriptions, e.event.AgentID)
+				message = &SocketMessage{Type: socketMessageUnsubscribed, AgentID: e.event.AgentID, Message: "The subscription has ended. Subscribe again to continue receiving data."}
+			} else if points := e.subscription.filter(e.event.Points); len(points) > 0 {
+				message = &SocketMessage{Type: socketMessageData, AgentID: e.event.AgentID, Points: points}
+			}
+
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout)); err != nil {
+				log.WithError(err).Info("Could not send ping, closing WebSocket.")
+				return
+			}
+		}
+
+		if message != nil {
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+
+			if err := conn.WriteJSON(message); err != nil {
+				log.WithError(err).Info("Could not write to WebSocket, closing it.")
+				return
+			}
+		}
+	}
+}
+
+func readSocketRequests(conn *websocket.Conn, done <-chan struct{}, log *logrus.Entry) <-chan SocketRequest {
+	requests := make(chan SocketRequest)
+
+	conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	go func() {
+		defer close(requests)
+
+		for {
+			request := SocketRequest{}
+
+			if err := conn.ReadJSON(&request); err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.WithError(err).Info("Could not read from WebSocket, closing it.")
+				}
+
+				return
+			}
+
+			select {
+			case requests <- request:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return requests
+}
+
+func handleSocketRequest(request SocketRequest, subscriptions map[int]*socketSubscription, events chan<- socketEvent, done <-chan struct{}, db Database, user User, hub *DataHub, log *logrus.Entry) *SocketMessage {
+	switch request.Action {
+	case socketActionSubscribe:
+		if message := checkSocketSubscriptionAllowed(request.AgentID, db, user, log); message != nil {
+			return message
+		}
+
+		if existing, ok := subscriptions[request.AgentID]; ok {
+			existing.unsubscribe()
+		}
+
+		subscriptions[request.AgentID] = subscribeForSocket(request, events, done, hub)
+		return &SocketMessage{Type: socketMessageSubscribed, AgentID: request.AgentID}
+
+	case socketActionUnsubscribe:
+		if existing, ok := subscriptions[request.AgentID]; ok {
+			existing.unsubscribe()
+			delete(subscriptions, request.AgentID)
+		}
+
+		return &SocketMessage{Type: socketMessageUnsubscribed, AgentID: request.AgentID}
+
+	default:
+		return &SocketMessage{Type: socketMessageError, AgentID: request.AgentID, Message: fmt.Sprintf("Unknown action '%s'.", request.Action)}
+	}
+}
+
+// checkSocketSubscriptionAllowed returns nil if the user can subscribe to the agent, or the message to send otherwise.
+func checkSocketSubscriptionAllowed(agentID int, db Database, user User, log *logrus.Entry) *SocketMessage {
+	failed := &SocketMessage{Type: socketMessageError, AgentID: agentID, Message: "Could not subscribe to agent."}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		return failed
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	if exists, err := db.CheckAgentIDExists(agentID); err != nil {
+		log.WithError(err).Error("Could not check if agent exists.")
+		return failed
+	} else if !exists {
+		return &SocketMessage{Type: socketMessageError, AgentID: agentID, Message: "Agent does not exist."}
+	}
+
+	agent, err := db.GetAgentByID(agentID)
+
+	if err != nil {
+		log.WithError(err).Error("Could not get agent info.")
+		return failed
+	}
+
+	if agent.OwnerUserID != user.UserID {
+		log.Error("User does not own this agent.")
+		return &SocketMessage{Type: socketMessageError, AgentID: agentID, Message: "You do not own this agent."}
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		return failed
+	}
+
+	return nil
+}
+
+// subscribeForSocket subscribes to the hub, and forwards each event to events until the subscription ends or done is closed.
+func subscribeForSocket(request SocketRequest, events chan<- socketEvent, done <-chan struct{}, hub *DataHub) *socketSubscription {
+	subscription := &socketSubscription{}
+
+	if len(request.VariableIDs) > 0 {
+		subscription.variableIDs = map[int]bool{}
+
+		for _, variableID := range request.VariableIDs {
+			subscription.variableIDs[variableID] = true
+		}
+	}
+
+	hubEvents, unsubscribe := hub.Subscribe(request.AgentID)
+	subscription.unsubscribe = unsubscribe
+
+	go func() {
+		for {
+			event, ok := <-hubEvents
+
+			if !ok {
+				event.AgentID = request.AgentID
+			}
+
+			select {
+			case events <- socketEvent{subscription: subscription, event: event, ok: ok}:
+			case <-done:
+				return
+			}
+
+			if !ok {
+				return
+			}
+		}
+	}()
+
+	return subscription
+}
+
+func (subscription *socketSubscription) filter(points []DataEventPoint) []DataEventPoint {
+	if subscription.variableIDs == nil {
+		return points
+	}
+
+	filtered := []DataEventPoint{}
+
+	for _, point := range points {
+		if subscription.variableIDs[point.VariableID] {
+			filtered = append(filtered, point)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Data socket", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var hub *DataHub
+	var server *httptest.Server
+	var conn *websocket.Conn
+
+	user := User{UserID: 1000}
+	dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		hub = NewDataHub()
+
+		server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			getDataSocket(res, req, db, user, hub, logrus.NewEntry(logrus.StandardLogger()))
+		}))
+
+		var err error
+		conn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Close()
+		mockController.Finish()
+	})
+
+	expectAgentOwnedBy := func(agentID int, ownerUserID int) {
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().CheckAgentIDExists(agentID).Return(true, nil),
+			db.EXPECT().GetAgentByID(agentID).Return(Agent{AgentID: agentID, OwnerUserID: ownerUserID}, nil),
+		)
+	}
+
+	send := func(request SocketRequest) {
+		Expect(conn.WriteJSON(request)).To(Succeed())
+	}
+
+	receive := func() SocketMessage {
+		message := SocketMessage{}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		Expect(conn.ReadJSON(&message)).To(Succeed())
+
+		return message
+	}
+
+	subscribe := func(agentID int, variableIDs ...int) {
+		expectAgentOwnedBy(agentID, user.UserID)
+		db.EXPECT().CommitTransaction()
+		db.EXPECT().RollbackUncommittedTransaction()
+
+		send(SocketRequest{Action: "subscribe", AgentID: agentID, VariableIDs: variableIDs})
+		Expect(receive()).To(Equal(SocketMessage{Type: "subscribed", AgentID: agentID}))
+	}
+
+	points := []DataEventPoint{
+		{VariableID: 12, Variable: "temperature", Time: dataTime, Value: 10.5},
+		{VariableID: 13, Variable: "humidity", Time: dataTime, Value: 80},
+	}
+
+	It("sends data for each agent subscribed to", func() {
+		subscribe(10)
+		subscribe(11)
+
+		hub.Publish(newDataEvent(10, 1, points))
+		Expect(receive()).To(Equal(SocketMessage{Type: "data", AgentID: 10, Points: points}))
+
+		hub.Publish(newDataEvent(11, 1, points))
+		Expect(receive()).To(Equal(SocketMessage{Type: "data", AgentID: 11, Points: points}))
+	})
+
+	It("sends only the variables subscribed to", func() {
+		subscribe(10, 13)
+
+		hub.Publish(newDataEvent(10, 1, points[:1]))
+		hub.Publish(newDataEvent(10, 2, points))
+
+		Expect(receive()).To(Equal(SocketMessage{Type: "data", AgentID: 10, Points: points[1:]}))
+	})
+
+	It("replaces the variables subscribed to when subscribing to the same agent again", func() {
+		subscribe(10, 13)
+		subscribe(10)
+
+		Expect(hub.subscriberCount(10)).To(Equal(1))
+
+		hub.Publish(newDataEvent(10, 1, points))
+		Expect(receive()).To(Equal(SocketMessage{Type: "data", AgentID: 10, Points: points}))
+	})
+
+	It("stops sending data for an agent after unsubscribing", func() {
+		subscribe(10)
+
+		send(SocketRequest{Action: "unsubscribe", AgentID: 10})
+		Expect(receive()).To(Equal(SocketMessage{Type: "unsubscribed", AgentID: 10}))
+		Expect(hub.subscriberCount(10)).To(Equal(0))
+	})
+
+	It("does not subscribe to an agent the user does not own", func() {
+		expectAgentOwnedBy(10, 2000)
+		db.EXPECT().RollbackUncommittedTransaction()
+
+		send(SocketRequest{Action: "subscribe", AgentID: 10})
+		Expect(receive()).To(Equal(SocketMessage{Type: "error", AgentID: 10, Message: "You do not own this agent."}))
+		Expect(hub.subscriberCount(10)).To(Equal(0))
+	})
+
+	It("does not subscribe to an agent that does not exist", func() {
+		db.EXPECT().BeginTransaction()
+		db.EXPECT().CheckAgentIDExists(10).Return(false, nil)
+		db.EXPECT().RollbackUncommittedTransaction()
+
+		send(SocketRequest{Action: "subscribe", AgentID: 10})
+		Expect(receive()).To(Equal(SocketMessage{Type: "error", AgentID: 10, Message: "Agent does not exist."}))
+	})
+
+	It("reports unknown actions", func() {
+		send(SocketRequest{Action: "dance", AgentID: 10})
+		Expect(receive()).To(Equal(SocketMessage{Type: "error", AgentID: 10, Message: "Unknown action 'dance'."}))
+	})
+
+	It("tells the client when a subscription ends", func() {
+		subscribe(10)
+
+		hub.Close()
+		Expect(receive()).To(Equal(SocketMessage{Type: "unsubscribed", AgentID: 10, Message: "The subscription has ended. Subscribe again to continue receiving data."}))
+	})
+})
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+const dataHubSubscriberBufferSize = 32
+const streamHeartbeatInterval = 30 * time.Second
+
+// maxReplayedPoints is the most points replayed to a client that reconnects with the Last-Event-ID header. A client
+// that has missed more than this has to load the data again instead, so that the replay does not need to be held in
+// memory.
+const maxReplayedPoints = 10000
+
+var errReplayTooLarge = errors.New("Too much data has been saved since the event in the Last-Event-ID header to replay it. " +
+	"Load the data again, then reconnect without the Last-Event-ID header.")
+
+// DataEvent is a batch of newly saved data points for a single agent, as sent to clients of the stream endpoint.
+// Its ID is the sequence number of the last point saved in the batch, so that a client that reconnects with the
+// Last-Event-ID header can be sent everything saved after that, whatever the time of the data.
+type DataEvent struct {
+	ID      int              `json:"-"`
+	AgentID int              `json:"agentId"`
+	Points  []DataEventPoint `json:"points"`
+}
+
+type DataEventPoint struct {
+	VariableID int       `json:"variableId"`
+	Variable   string    `json:"variable"`
+	Time       time.Time `json:"time"`
+	Value      float64   `json:"value"`
+}
+
+func newDataEvent(agentID int, sequenceNumber int, points []DataEventPoint) DataEvent {
+	return DataEvent{
+		ID:      sequenceNumber,
+		AgentID: agentID,
+		Points:  points,
+	}
+}
+
+// DataHub fans out data events to every client subscribed to the agent they are for.
+type DataHub struct {
+	lock        sync.Mutex
+	subscribers map[int]map[chan DataEvent]struct{}
+	closed      bool
+}
+
+func NewDataHub() *DataHub {
+	return &DataHub{subscribers: map[int]map[chan DataEvent]struct{}{}}
+}
+
+// Subscribe returns a channel that receives each event published for the agent, and a function to call to stop
+// receiving them. The channel is closed when unsubscribing, when the hub is closed, or if the subscriber falls too far
+// behind, in which case it should reconnect and replay the events it missed.
+func (h *DataHub) Subscribe(agentID int) (<-chan DataEvent, func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	events := make(chan DataEvent, dataHubSubscriberBufferSize)
+
+	if h.closed {
+		close(events)
+		return events, func() {}
+	}
+
+	if h.subscribers[agentID] == nil {
+		h.subscribers[agentID] = map[chan DataEvent]struct{}{}
+	}
+
+	h.subscribers[agentID][events] = struct{}{}
+
+	return events, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+
+		h.removeSubscriber(agentID, events)
+	}
+}
+
+func (h *DataHub) Publish(event DataEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for events := range h.subscribers[event.AgentID] {
+		select {
+		case events <- event:
+		default:
+			h.removeSubscriber(event.AgentID, events)
+		}
+	}
+}
+
+// Close disconnects all subscribers. Any later subscriptions are closed immediately.
+func (h *DataHub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for agentID, subscribers := range h.subscribers {
+		for events := range subscribers {
+			h.removeSubscriber(agentID, events)
+		}
+	}
+
+	h.closed = true
+}
+
+func (h *DataHub) subscriberCount(agentID int) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.subscribers[agentID])
+}
+
+// removeSubscriber must only be called while holding the lock.
+func (h *DataHub) removeSubscriber(agentID int, events chan DataEvent) {
+	if _, ok := h.subscribers[agentID][events]; !ok {
+		return
+	}
+
+	delete(h.subscribers[agentID], events)
+	close(events)
+
+	if len(h.subscribers[agentID]) == 0 {
+		delete(h.subscribers, agentID)
+	}
+}
+
+func getDataStream(res http.ResponseWriter, req *http.Request, render render.Render, params martini.Params, db Database, user User, hub *DataHub, log *logrus.Entry) {
+	flusher, ok := res.(http.Flusher)
+
+	if !ok {
+		log.Error("Response does not support streaming.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := 0
+	header := req.Header.Get("Last-Event-ID")
+
+	if header != "" {
+		var err error
+
+		if lastEventID, err = strconv.Atoi(header); err != nil || lastEventID < 0 {
+			render.Text(http.StatusBadRequest, "Last-Event-ID header is not a valid event ID.")
+			return
+		}
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	agentID, ok := extractOwnedAgentID(params, render, db, user, log)
+
+	if !ok {
+		return
+	}
+
+	// Subscribe before reading the events to replay, so that nothing saved in between is missed.
+	events, unsubscribe := hub.Subscribe(agentID)
+	defer unsubscribe()
+
+	replay := []DataEvent{}
+	var err error
+
+	if header != "" {
+		if replay, err = getEventsSince(db, agentID, lastEventID); err == errReplayTooLarge {
+			render.Text(http.StatusConflict, err.Error())
+			return
+		} else if err != nil {
+			log.WithError(err).Error("Could not get data to replay.")
+			render.Error(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Don't hold on to the transaction (and its connection) for the life of the stream.
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	for _, event := range replay {
+		if err := writeDataEvent(res, event); err != nil {
+			log.WithError(err).Error("Could not write event.")
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	// Anything saved between subscribing and reading the events to replay has already been sent.
+	lastReplayedID := lastEventID
+
+	if len(replay) > 0 {
+		lastReplayedID = replay[len(replay)-1].ID
+	}
+
+	var closed <-chan bool
+
+	if notifier, ok := res.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if event.ID <= lastReplayedID {
+				continue
+			}
+
+			if err := writeDataEvent(res, event); err != nil {
+				log.WithError(err).Error("Could not write event.")
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := io.WriteString(res, ": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case <-closed:
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// getEventsSince returns the agent's data saved after the point with the given sequence number, in the order it was
+// saved. Points saved one after another for the same time are sent in the same event. errReplayTooLarge is returned if
+// more than maxReplayedPoints points have been saved since.
+func getEventsSince(db Database, agentID int, sequenceNumber int) ([]DataEvent, error) {
+	variables, err := db.GetVariablesForAgent(agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int]string{}
+
+	for _, variable := range variables {
+		names[variable.VariableID] = variable.Name
+	}
+
+	points := [][]DataEventPoint{}
+	sequenceNumbers := []int{}
+	count := 0
+
+	// Ask for one more point than can be replayed, to find out if there are too many.
+	err = db.StreamDataSavedAfter(agentID, sequenceNumber, maxReplayedPoints+1, func(point DataPoint) error {
+		if count++; count > maxReplayedPoints {
+			return errReplayTooLarge
+		}
+
+		if len(points) == 0 || !points[len(points)-1][0].Time.Equal(point.Time) {
+			points = append(points, []DataEventPoint{})
+			sequenceNumbers = append(sequenceNumbers, 0)
+		}
+
+		last := len(points) - 1
+		points[last] = append(points[last], DataEventPoint{
+			VariableID: point.VariableID,
+			Variable:   names[point.VariableID],
+			Time:       point.Time.UTC(),
+			Value:      point.Value,
+		})
+
+		sequenceNumbers[last] = point.SequenceNumber
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]DataEvent, len(points))
+
+	for i, eventPoints := range points {
+		events[i] = newDataEvent(agentID, sequenceNumbers[i], eventPoints)
+	}
+
+	return events, nil
+}
+
+func writeDataEvent(w io.Writer, event DataEvent) error {
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: data\ndata: %s\n\n", event.ID, data)
+	return err
+}
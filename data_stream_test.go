@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Data streaming", func() {
+	var mockController *gomock.Controller
+	var hub *DataHub
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		hub = NewDataHub()
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	event := func(agentID int, sequenceNumber int, value float64) DataEvent {
+		return newDataEvent(agentID, sequenceNumber, []DataEventPoint{
+			{VariableID: 12, Variable: "temperature", Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC), Value: value},
+		})
+	}
+
+	Describe("hub", func() {
+		It("sends published events to every subscriber to the agent", func() {
+			events1, unsubscribe1 := hub.Subscribe(10)
+			defer unsubscribe1()
+			events2, unsubscribe2 := hub.Subscribe(10)
+			defer unsubscribe2()
+			otherEvents, unsubscribeOther := hub.Subscribe(11)
+			defer unsubscribeOther()
+
+			hub.Publish(event(10, 1, 1))
+
+			Expect(events1).To(Receive(Equal(event(10, 1, 1))))
+			Expect(events2).To(Receive(Equal(event(10, 1, 1))))
+			Expect(otherEvents).To(BeEmpty())
+		})
+
+		It("stops sending events and closes the channel after unsubscribing", func() {
+			events, unsubscribe := hub.Subscribe(10)
+			unsubscribe()
+
+			hub.Publish(event(10, 1, 1))
+
+			Expect(events).To(BeClosed())
+			Expect(hub.subscriberCount(10)).To(Equal(0))
+		})
+
+		It("disconnects subscribers that fall too far behind", func() {
+			events, unsubscribe := hub.Subscribe(10)
+			defer unsubscribe()
+
+			for i := 0; i <= dataHubSubscriberBufferSize; i++ {
+				hub.Publish(event(10, i, float64(i)))
+			}
+
+			for i := 0; i < dataHubSubscriberBufferSize; i++ {
+				Expect(events).To(Receive())
+			}
+
+			Expect(events).To(BeClosed())
+		})
+
+		It("closes all subscriptions, including later ones, when closed", func() {
+			events, unsubscribe := hub.Subscribe(10)
+			defer unsubscribe()
+
+			hub.Close()
+
+			Expect(events).To(BeClosed())
+
+			laterEvents, unsubscribeLater := hub.Subscribe(10)
+			defer unsubscribeLater()
+
+			Expect(laterEvents).To(BeClosed())
+		})
+	})
+
+	Describe("GET request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+		var recorder *httptest.ResponseRecorder
+
+		user := User{UserID: 1000}
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+			recorder = httptest.NewRecorder()
+		})
+
+		makeRequest := func(lastEventID string) {
+			request, _ := http.NewRequest("GET", "/blah", nil)
+
+			if lastEventID != "" {
+				request.Header.Set("Last-Event-ID", lastEventID)
+			}
+
+			params := martini.Params{"agent_id": "10"}
+
+			getDataStream(recorder, request, render, params, db, user, hub, logrus.NewEntry(logrus.StandardLogger()))
+		}
+
+		// startRequest runs the handler until the hub is closed, which it does once the handler has subscribed and
+		// then published the given events.
+		startRequest := func(lastEventID string, events ...DataEvent) {
+			done := make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+
+				makeRequest(lastEventID)
+			}()
+
+			Eventually(func() int { return hub.subscriberCount(10) }).Should(Equal(1))
+
+			for _, e := range events {
+				hub.Publish(e)
+			}
+
+			hub.Close()
+			Eventually(done).Should(BeClosed())
+		}
+
+		It("sends each event published for the agent until the hub is closed", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(10).Return(true, nil),
+				db.EXPECT().GetAgentByID(10).Return(Agent{AgentID: 10, OwnerUserID: user.UserID}, nil),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			startRequest("", event(10, 3, 10.5))
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+			Expect(recorder.Body.String()).To(Equal("id: 3\n" +
+				"event: data\n" +
+				`data: {"agentId":10,"points":[{"variableId":12,"variable":"temperature","time":"2015-05-06T10:15:30Z","value":10.5}]}` + "\n\n"))
+		})
+
+		expectReplay := func() {
+			streamCall := db.EXPECT().StreamDataSavedAfter(10, 40, maxReplayedPoints+1, gomock.Any()).Do(func(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) {
+				// Points are replayed in the order they were saved, whatever their time.
+				callback(DataPoint{AgentID: 10, VariableID: 12, Time: time.Date(2015, 5, 6, 9, 1, 0, 0, time.UTC), Value: 10, SequenceNumber: 41})
+				callback(DataPoint{AgentID: 10, VariableID: 13, Time: time.Date(2015, 5, 6, 9, 1, 0, 0, time.UTC), Value: 80, SequenceNumber: 42})
+				callback(DataPoint{AgentID: 10, VariableID: 12, Time: time.Date(2015, 5, 6, 8, 0, 0, 0, time.UTC), Value: 11, SequenceNumber: 45})
+			}).Return(nil)
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(10).Return(true, nil),
+				db.EXPECT().GetAgentByID(10).Return(Agent{AgentID: 10, OwnerUserID: user.UserID}, nil),
+				db.EXPECT().GetVariablesForAgent(10).Return([]Variable{{VariableID: 12, Name: "temperature"}, {VariableID: 13, Name: "humidity"}}, nil),
+				streamCall,
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+		}
+
+		replayed := "id: 42\n" +
+			"event: data\n" +
+			`data: {"agentId":10,"points":[{"variableId":12,"variable":"temperature","time":"2015-05-06T09:01:00Z","value":10},{"variableId":13,"variable":"humidity","time":"2015-05-06T09:01:00Z","value":80}]}` + "\n\n" +
+			"id: 45\n" +
+			"event: data\n" +
+			`data: {"agentId":10,"points":[{"variableId":12,"variable":"temperature","time":"2015-05-06T08:00:00Z","value":11}]}` + "\n\n"
+
+		It("replays the data saved after the event in the Last-Event-ID header, grouping points saved together for the same time", func() {
+			expectReplay()
+
+			startRequest("40")
+
+			Expect(recorder.Body.String()).To(Equal(replayed))
+		})
+
+		It("does not send events that were saved before the last event replayed again", func() {
+			expectReplay()
+
+			startRequest("40", event(10, 45, 11), event(10, 46, 12.5))
+
+			Expect(recorder.Body.String()).To(Equal(replayed +
+				"id: 46\n" +
+				"event: data\n" +
+				`data: {"agentId":10,"points":[{"variableId":12,"variable":"temperature","time":"2015-05-06T10:15:30Z","value":12.5}]}` + "\n\n"))
+		})
+
+		It("returns HTTP 409 response if too much data has been saved after the event in the Last-Event-ID header to replay it", func() {
+			streamCall := db.EXPECT().StreamDataSavedAfter(10, 0, maxReplayedPoints+1, gomock.Any()).Do(func(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) {
+				// The callback stops the replay once there are too many points.
+				for i := 1; i <= limit; i++ {
+					if err := callback(DataPoint{AgentID: 10, VariableID: 12, Time: time.Date(2015, 5, 6, 9, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute), Value: 10, SequenceNumber: i}); err != nil {
+						Expect(err).To(Equal(errReplayTooLarge))
+						Expect(i).To(Equal(maxReplayedPoints + 1))
+						return
+					}
+				}
+			}).Return(errReplayTooLarge)
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(10).Return(true, nil),
+				db.EXPECT().GetAgentByID(10).Return(Agent{AgentID: 10, OwnerUserID: user.UserID}, nil),
+				db.EXPECT().GetVariablesForAgent(10).Return([]Variable{{VariableID: 12, Name: "temperature"}}, nil),
+				streamCall,
+				render.EXPECT().Text(http.StatusConflict, errReplayTooLarge.Error()),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("0")
+
+			Expect(hub.subscriberCount(10)).To(Equal(0))
+		})
+
+		It("returns HTTP 403 response if the user does not own the agent", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(10).Return(true, nil),
+				db.EXPECT().GetAgentByID(10).Return(Agent{AgentID: 10, OwnerUserID: 2000}, nil),
+				render.EXPECT().Error(http.StatusForbidden),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest("")
+
+			Expect(hub.subscriberCount(10)).To(Equal(0))
+		})
+
+		It("returns HTTP 400 response if the Last-Event-ID header is invalid", func() {
+			render.EXPECT().Text(http.StatusBadRequest, gomock.Any())
+
+			makeRequest("yesterday")
+		})
+	})
+})
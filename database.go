@@ -2,6 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -11,6 +14,7 @@ import (
 type Database interface {
 	RunMigrations() (int, error)
 	Close()
+	NewSession() Database
 	BeginTransaction() error
 	CommitTransaction() error
 	RollbackTransaction() error
@@ -20,17 +24,115 @@ type Database interface {
 	Transaction() *sql.Tx
 
 	CreateAgent(agent *Agent) error
-	GetAllAgents() ([]Agent, error)
+	GetAllAgents(filter AgentFilter) ([]Agent, error)
+	GetAgentsForUser(userID int, filter AgentFilter) ([]Agent, error)
+	UpdateAgent(agent Agent) error
+	UpdateAgentToken(agent Agent) error
+	DeleteAgent(agentID int) error
+	SoftDeleteAgent(agentID int, deleted time.Time) error
+	UpdateAgentLastSeen(agentID int, seen time.Time) error
+	MarkAgentsOffline(now time.Time) ([]Agent, error)
+	MarkAgentsOnline() ([]Agent, error)
 	CreateVariable(variable *Variable) error
-	AddDataPoint(dataPoint DataPoint) error
+	UpdateVariableLimits(variableID int, limits VariableLimits) (bool, error)
+	UpdateVariableRetention(variableID int, retention VariableRetention) (bool, error)
+	GetAllVariables() ([]Variable, error)
+	AddDataPoint(dataPoint DataPoint, conflictPolicy string) (bool, error)
+	AddDataPoints(dataPoints []DataPoint, conflictPolicy string) ([]bool, error)
 	CheckAgentIDExists(agentID int) (bool, error)
 	GetVariableIDForName(name string) (int, error)
-	GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time) (map[string]float64, error)
+	GetVariablesByName(names []string) (map[string]Variable, error)
+	GetPreviousValue(agentID int, variableID int, before time.Time) (float64, bool, error)
+	GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool) (map[string]float64, error)
+	GetAggregatedData(agentID int, variableID int, fromDate time.Time, toDate time.Time, interval time.Duration, aggregate string, includeFlagged bool) (map[string]float64, error)
+	GetLatestData(agentID int) (map[int]DataPoint, error)
+	StreamData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) error
+	GetLastDataSequenceNumber(agentID int) (int, error)
+	StreamDataSavedAfter(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) error
+	GetDataPoint(agentID int, variableID int, t time.Time) (DataPoint, bool, error)
+	AddDataCorrection(correction *DataCorrection) error
+	GetDataCorrections(agentID int) ([]DataCorrection, error)
+	RollUpData(variableID int, before time.Time) (int, error)
 	GetVariableByID(variableID int) (Variable, error)
 	GetVariablesForAgent(agentID int) ([]Variable, error)
 	GetAgentByID(agentID int) (Agent, error)
 	CreateUser(user *User) error
 	GetUserByEmail(email string) (User, error)
+	GetUserByID(userID int) (User, error)
+	CreateSession(session *Session) error
+	GetSessionByTokenHash(tokenHash []byte) (Session, error)
+	DeleteSession(sessionID int) error
+	CreateAlertRule(rule *AlertRule) error
+	GetAlertRulesForAgent(agentID int) ([]AlertRule, error)
+	UpdateAlertRuleState(rule AlertRule) error
+	DeleteAlertRule(ruleID int) error
+	AddAlertHistory(entry *AlertHistoryEntry) error
+	GetAlertHistory(ruleID int) ([]AlertHistoryEntry, error)
+}
+
+// dataPointKey identifies a data point: there is at most one value for an agent and variable at a time.
+type dataPointKey struct {
+	AgentID    int
+	VariableID int
+	Time       int64
+}
+
+func (point DataPoint) key() dataPointKey {
+	return dataPointKey{point.AgentID, point.VariableID, point.Time.UnixNano()}
+}
+
+// batchDataPoints prepares points to be saved all at once with the same result as saving each in turn, where a point
+// for the same agent, variable and time as an earlier point finds that there is already a value. It returns the
+// distinct points to save (each with the value that would end up saved), the position in points of each of them, and
+// whether there was already a value for each point, which is only known so far for the repeated points.
+func batchDataPoints(points []DataPoint, conflictPolicy string) ([]DataPoint, []int, []bool) {
+	distinct := []DataPoint{}
+	positions := []int{}
+	existed := make([]bool, len(points))
+	indexes := map[dataPointKey]int{}
+
+	for i, point := range points {
+		if index, ok := indexes[point.key()]; ok {
+			existed[i] = true
+
+			if conflictPolicy == conflictPolicyOverwrite {
+				distinct[index] = point
+			}
+
+			continue
+		}
+
+		indexes[point.key()] = len(distinct)
+		distinct = append(distinct, point)
+		positions = append(positions, i)
+	}
+
+	return distinct, positions, existed
+}
+
+// connectToDatabase opens the database given by dataSourceName, choosing the implementation from its scheme:
+// sqlite:<path> for a SQLite database file, memory:[<name>] for a database kept in memory, or a PostgreSQL connection
+// string otherwise.
+func connectToDatabase(dataSourceName string) (Database, error) {
+	switch dataSourceScheme(dataSourceName) {
+	case "sqlite", "sqlite3":
+		path := dataSourceName[strings.Index(dataSourceName, ":")+1:]
+		return connectToSQLiteDatabase(strings.TrimPrefix(path, "//"))
+	case "memory":
+		return connectToMemoryDatabase(dataSourceName[strings.Index(dataSourceName, ":")+1:])
+	default:
+		return connectToPostgresDatabase(dataSourceName)
+	}
+}
+
+func dataSourceScheme(dataSourceName string) string {
+	i := strings.Index(dataSourceName, ":")
+
+	if i == -1 {
+		return ""
+	}
+
+	return strings.ToLower(dataSourceName[:i])
 }
 
 func getMigrationSource() migrate.MigrationSource {
@@ -40,3 +142,112 @@ func getMigrationSource() migrate.MigrationSource {
 		Dir:      "db/migrations",
 	}
 }
+
+func getSQLiteMigrationSource() migrate.MigrationSource {
+	return &migrate.AssetMigrationSource{
+		Asset:    Asset,
+		AssetDir: AssetDir,
+		Dir:      "db/sqlite-migrations",
+	}
+}
+
+// aggregateFunctions computes each aggregate supported by GetAggregatedData from the rollups in a bucket, in order of
+// time. A single data point is given as a rollup of just that point. As rollups do not keep the first and last values
+// they summarise, the first and last aggregates use the average of the first and last rollups instead.
+var aggregateFunctions = map[string]func(rollups []DataRollup) float64{
+	"min": func(rollups []DataRollup) float64 {
+		min := rollups[0].Min
+
+		for _, rollup := range rollups[1:] {
+			if rollup.Min < min {
+				min = rollup.Min
+			}
+		}
+
+		return min
+	},
+	"max": func(rollups []DataRollup) float64 {
+		max := rollups[0].Max
+
+		for _, rollup := range rollups[1:] {
+			if rollup.Max > max {
+				max = rollup.Max
+			}
+		}
+
+		return max
+	},
+	"avg": func(rollups []DataRollup) float64 {
+		return sumRollups(rollups) / countRollups(rollups)
+	},
+	"sum":   sumRollups,
+	"count": countRollups,
+	"first": func(rollups []DataRollup) float64 { return rollups[0].Avg },
+	"last":  func(rollups []DataRollup) float64 { return rollups[len(rollups)-1].Avg },
+}
+
+// aggregateData groups the rollups query gives, in order of time, into buckets of the given interval (aligned to the
+// Unix epoch) and returns the aggregate of each bucket, keyed by the start time of the bucket. It is for implementations
+// that cannot group the data themselves.
+func aggregateData(interval time.Duration, aggregate string, query func(callback func(DataRollup)) error) (map[string]float64, error) {
+	aggregateFunction, ok := aggregateFunctions[aggregate]
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown aggregate '%s'.", aggregate)
+	}
+
+	if interval < time.Second {
+		return nil, errors.New("Interval must be at least one second.")
+	}
+
+	buckets := map[string][]DataRollup{}
+
+	err := query(func(rollup DataRollup) {
+		key := bucketStart(rollup.Time, interval).Format(time.RFC3339)
+		buckets[key] = append(buckets[key], rollup)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]float64{}
+
+	for key, rollups := range buckets {
+		m[key] = aggregateFunction(rollups)
+	}
+
+	return m, nil
+}
+
+func sumRollups(rollups []DataRollup) float64 {
+	sum := 0.0
+
+	for _, rollup := range rollups {
+		sum += rollup.Sum()
+	}
+
+	return sum
+}
+
+func countRollups(rollups []DataRollup) float64 {
+	count := 0
+
+	for _, rollup := range rollups {
+		count += rollup.Count
+	}
+
+	return float64(count)
+}
+
+// bucketStart returns the start of the bucket of the given interval, aligned to the Unix epoch, that t falls into.
+func bucketStart(t time.Time, interval time.Duration) time.Time {
+	sinceEpoch := time.Duration(t.UnixNano())
+	offset := sinceEpoch % interval
+
+	if offset < 0 {
+		offset += interval
+	}
+
+	return time.Unix(0, int64(sinceEpoch-offset)).UTC()
+}
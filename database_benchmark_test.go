@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// benchmarkBatchSize is the number of points in each upload, which is about a week of readings from a station that
+// reports every minute.
+const benchmarkBatchSize = 10000
+
+// benchmarkDatabase is a database to benchmark, with an agent and a variable to save data for.
+type benchmarkDatabase struct {
+	db         Database
+	agentID    int
+	variable   string
+	variableID int
+	close      func()
+}
+
+var benchmarkBackends = []struct {
+	name    string
+	connect func(b *testing.B) *benchmarkDatabase
+}{
+	{"memory", connectToMemoryBenchmarkDatabase},
+	{"SQLite", connectToSQLiteBenchmarkDatabase},
+	{"PostgreSQL", connectToPostgresBenchmarkDatabase},
+}
+
+// BenchmarkAddDataPoints compares saving each point of a large upload with its own queries, as uploads were saved
+// before AddDataPoints was added, with saving the upload in a single batch. Run it with make benchmark. The PostgreSQL
+// benchmarks use the test database, and are skipped if it is not available.
+func BenchmarkAddDataPoints(b *testing.B) {
+	for _, backend := range benchmarkBackends {
+		backend := backend
+
+		b.Run(backend.name+"/one at a time", func(b *testing.B) {
+			benchmarkDataPoints(b, backend.connect(b), func(db Database, points []PostDataPoint, dataPoints []DataPoint) error {
+				for i, point := range points {
+					if _, err := db.GetVariableIDForName(point.Variable); err != nil {
+						return err
+					}
+
+					if _, err := db.AddDataPoint(dataPoints[i], conflictPolicyReject); err != nil {
+						return err
+					}
+				}
+
+				return db.CommitTransaction()
+			})
+		})
+
+		b.Run(backend.name+"/batch", func(b *testing.B) {
+			benchmarkDataPoints(b, backend.connect(b), func(db Database, points []PostDataPoint, dataPoints []DataPoint) error {
+				if _, err := db.GetVariablesByName(PostDataPoints{Data: points}.VariableNames()); err != nil {
+					return err
+				}
+
+				if _, err := db.AddDataPoints(dataPoints, conflictPolicyReject); err != nil {
+					return err
+				}
+
+				return db.CommitTransaction()
+			})
+		})
+	}
+}
+
+// BenchmarkSaveDataPoints saves large uploads as the data resource does, for a variable with a step limit, which needs
+// the previous value of each point to check it. Run it with make benchmark.
+func BenchmarkSaveDataPoints(b *testing.B) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	for _, backend := range benchmarkBackends {
+		backend := backend
+
+		b.Run(backend.name+"/with a step limit", func(b *testing.B) {
+			bench := backend.connect(b)
+			maxStep := 5.0
+
+			if err := setBenchmarkVariableLimits(bench, VariableLimits{MaxStep: &maxStep}); err != nil {
+				bench.close()
+				b.Fatal(err)
+			}
+
+			hub := NewDataHub()
+			notifier := NewAlertNotifier(nil)
+			defer hub.Close()
+
+			benchmarkDataPoints(b, bench, func(db Database, points []PostDataPoint, dataPoints []DataPoint) error {
+				// saveDataPoints commits the transaction itself.
+				if status, result := saveDataPoints(PostDataPoints{Data: points}, Agent{AgentID: bench.agentID}, db, hub, notifier, logrus.NewEntry(logger)); status != http.StatusCreated {
+					return fmt.Errorf("Could not save data, got HTTP %v: %+v", status, result.Results[0])
+				}
+
+				return nil
+			})
+		})
+	}
+}
+
+// benchmarkDataPoints saves a new upload of benchmarkBatchSize points in its own transaction for each iteration, and
+// reports the number of points saved each second. save is responsible for committing the transaction.
+func benchmarkDataPoints(b *testing.B, bench *benchmarkDatabase, save func(db Database, points []PostDataPoint, dataPoints []DataPoint) error) {
+	defer bench.close()
+
+	start := time.Date(2015, 5, 6, 0, 0, 0, 0, time.UTC)
+	elapsed := time.Duration(0)
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		points := make([]PostDataPoint, benchmarkBatchSize)
+		dataPoints := make([]DataPoint, benchmarkBatchSize)
+
+		for i := range points {
+			// The value never changes by more than the step limit used by BenchmarkSaveDataPoints.
+			t := start.Add(time.Duration(n*benchmarkBatchSize+i) * time.Minute)
+			value := float64(i % 5)
+			points[i] = PostDataPoint{Variable: bench.variable, Value: value, Time: t}
+			dataPoints[i] = DataPoint{AgentID: bench.agentID, VariableID: bench.variableID, Value: value, Time: t}
+		}
+
+		began := time.Now()
+		b.StartTimer()
+
+		if err := bench.db.BeginTransaction(); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := save(bench.db, points, dataPoints); err != nil {
+			b.Fatal(err)
+		}
+
+		elapsed += time.Since(began)
+	}
+
+	b.ReportMetric(float64(b.N*benchmarkBatchSize)/elapsed.Seconds(), "points/s")
+}
+
+func connectToMemoryBenchmarkDatabase(b *testing.B) *benchmarkDatabase {
+	db, err := connectToDatabase("memory:benchmark")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return setUpBenchmarkDatabase(b, db, db.Close)
+}
+
+func connectToSQLiteBenchmarkDatabase(b *testing.B) *benchmarkDatabase {
+	directory, err := ioutil.TempDir("", "weather-thingy-data-service-benchmark")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	db, err := connectToDatabase("sqlite:" + filepath.Join(directory, "benchmark.db"))
+
+	if err != nil {
+		os.RemoveAll(directory)
+		b.Fatal(err)
+	}
+
+	return setUpBenchmarkDatabase(b, db, func() {
+		db.Close()
+		os.RemoveAll(directory)
+	})
+}
+
+func connectToPostgresBenchmarkDatabase(b *testing.B) *benchmarkDatabase {
+	db, err := connectToDatabase(getTestDataSourceName())
+
+	if err != nil {
+		b.Skip("PostgreSQL test database is not available: " + err.Error())
+	}
+
+	if err := db.DB().Ping(); err != nil {
+		db.Close()
+		b.Skip("PostgreSQL test database is not available: " + err.Error())
+	}
+
+	bench := setUpBenchmarkDatabase(b, db, nil)
+
+	// The test database is shared, so remove the data saved by the benchmark.
+	bench.close = func() {
+		if err := db.BeginTransaction(); err == nil {
+			db.DeleteAgent(bench.agentID)
+			db.CommitTransaction()
+		}
+
+		db.Close()
+	}
+
+	return bench
+}
+
+// setUpBenchmarkDatabase applies the migrations to db and creates a user, agent and variable to save data for.
+func setUpBenchmarkDatabase(b *testing.B, db Database, close func()) *benchmarkDatabase {
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	user := User{Email: "benchmark-" + suffix + "@example.com", Created: time.Now()}
+	agent := Agent{Name: "Benchmark", Created: time.Now()}
+	variable := Variable{Name: "benchmark" + suffix, Units: "metres", Created: time.Now()}
+
+	err := func() error {
+		if _, err := db.RunMigrations(); err != nil {
+			return err
+		}
+
+		if err := db.BeginTransaction(); err != nil {
+			return err
+		}
+
+		defer db.RollbackUncommittedTransaction()
+
+		if err := db.CreateUser(&user); err != nil {
+			return err
+		}
+
+		agent.OwnerUserID = user.UserID
+
+		if err := db.CreateAgent(&agent); err != nil {
+			return err
+		}
+
+		if err := db.CreateVariable(&variable); err != nil {
+			return err
+		}
+
+		return db.CommitTransaction()
+	}()
+
+	if err != nil {
+		if close != nil {
+			close()
+		}
+
+		b.Fatal(err)
+	}
+
+	return &benchmarkDatabase{db: db, agentID: agent.AgentID, variable: variable.Name, variableID: variable.VariableID, close: close}
+}
+
+// setBenchmarkVariableLimits sets the limits of the benchmark's variable.
+func setBenchmarkVariableLimits(bench *benchmarkDatabase, limits VariableLimits) error {
+	if err := bench.db.BeginTransaction(); err != nil {
+		return err
+	}
+
+	defer bench.db.RollbackUncommittedTransaction()
+
+	if _, err := bench.db.UpdateVariableLimits(bench.variableID, limits); err != nil {
+		return err
+	}
+
+	return bench.db.CommitTransaction()
+}
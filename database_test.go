@@ -1,29 +1,1983 @@
 package main
 
 import (
+	"errors"
 	"io/ioutil"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/rubenv/sql-migrate"
 )
 
+var _ = Describe("connectToDatabase", func() {
+	DescribeTable("chooses the database implementation from the scheme of the data source", func(dataSourceName string, expectedType Database) {
+		db, err := connectToDatabase(dataSourceName)
+		Expect(err).To(BeNil())
+		defer db.Close()
+
+		Expect(db).To(BeAssignableToTypeOf(expectedType))
+	},
+		Entry("for a PostgreSQL URL", "postgres://weatherthingy@localhost/weatherthingy?sslmode=disable", &PostgresDatabase{}),
+		Entry("for a PostgreSQL connection string", "host=localhost dbname=weatherthingy", &PostgresDatabase{}),
+		Entry("for a SQLite database", "sqlite:weatherthingy.db", &SQLiteDatabase{}),
+		Entry("for a SQLite database with an absolute path", "sqlite:///var/lib/weatherthingy.db", &SQLiteDatabase{}),
+		Entry("for an in-memory database", "memory:", &MemoryDatabase{}),
+		Entry("for a named in-memory database", "memory:demo", &MemoryDatabase{}),
+	)
+})
+
 var _ = Describe("PostgresDatabase", func() {
 	Describe("getMigrationSource", func() {
 		It("returns all of the migrations", func() {
-			expectedMigrations, _ := ioutil.ReadDir("db/migrations")
-			expectedMigrationFileNames := make([]string, len(expectedMigrations))
-			for i, m := range expectedMigrations {
-				expectedMigrationFileNames[i] = m.Name()
+			expectMigrationsFromDirectory(getMigrationSource(), "db/migrations")
+		})
+	})
+})
+
+var _ = Describe("SQLiteDatabase", func() {
+	Describe("getSQLiteMigrationSource", func() {
+		It("returns all of the migrations", func() {
+			expectMigrationsFromDirectory(getSQLiteMigrationSource(), "db/sqlite-migrations")
+		})
+	})
+})
+
+func expectMigrationsFromDirectory(source migrate.MigrationSource, directory string) {
+	expectedMigrations, _ := ioutil.ReadDir(directory)
+	expectedMigrationFileNames := make([]string, len(expectedMigrations))
+	for i, m := range expectedMigrations {
+		expectedMigrationFileNames[i] = m.Name()
+	}
+
+	migrations, _ := source.FindMigrations()
+	migrationNames := make([]string, len(migrations))
+	for i, m := range migrations {
+		migrationNames[i] = m.Id
+	}
+
+	// If this test fails, you probably need to run 'make generate'.
+	Expect(migrationNames).To(Equal(expectedMigrationFileNames))
+}
+
+// databaseTestBackend is a Database implementation for describeDatabaseBehaviour to test.
+type databaseTestBackend struct {
+	// database returns the database under test, which is connected to an empty database before each test.
+	database func() Database
+
+	// createTestData adds the data described by CreateTestData to the database, with the same IDs.
+	createTestData func(db Database)
+}
+
+// describeDatabaseBehaviour describes the behaviour every Database implementation must have. It only uses the
+// Database interface, so that it can be used with implementations that are not backed by SQL.
+func describeDatabaseBehaviour(backend databaseTestBackend) {
+	var db Database
+
+	BeforeEach(func() {
+		db = backend.database()
+	})
+
+	Describe("NewSession", func() {
+		It("returns a database with its own transaction state", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+
+			session := db.NewSession()
+			Expect(session.CommitTransaction()).ToNot(BeNil())
+			Expect(db.RollbackTransaction()).To(BeNil())
+
+			Expect(session.BeginTransaction()).To(BeNil())
+			Expect(db.CommitTransaction()).ToNot(BeNil())
+			Expect(session.RollbackTransaction()).To(BeNil())
+		})
+	})
+
+	Describe("BeginTransaction", func() {
+		Context("when there is no active transaction", func() {
+			It("does not return an error", func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+		})
+
+		Context("when there is already an active transaction", func() {
+			It("returns an error", func() {
+				db.BeginTransaction()
+				Expect(db.BeginTransaction()).ToNot(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+		})
+	})
+
+	Describe("CommitTransaction", func() {
+		Context("when there is no active transaction", func() {
+			It("returns an error", func() {
+				Expect(db.CommitTransaction()).ToNot(BeNil())
+			})
+		})
+
+		Context("when there is an active transaction", func() {
+			BeforeEach(func() {
+				db.RunMigrations()
+
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			It("does not return an error", func() {
+				Expect(db.CommitTransaction()).To(BeNil())
+			})
+
+			It("ends the transaction", func() {
+				db.CommitTransaction()
+				Expect(db.CommitTransaction()).ToNot(BeNil())
+			})
+
+			It("applies changes made to the database", func() {
+				Expect(db.CreateVariable(&Variable{Name: "test", Units: "metres", Created: time.Now()})).To(Succeed())
+
+				err := db.CommitTransaction()
+				Expect(err).To(BeNil())
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackTransaction()
+
+				_, err = db.GetVariableIDForName("test")
+				Expect(err).To(BeNil())
+			})
+		})
+	})
+
+	Describe("RollbackTransaction", func() {
+		Context("when there is no active transaction", func() {
+			It("returns an error", func() {
+				Expect(db.RollbackTransaction()).ToNot(BeNil())
+			})
+		})
+
+		Context("when there is an active transaction", func() {
+			BeforeEach(func() {
+				db.RunMigrations()
+
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			It("does not return an error", func() {
+				Expect(db.RollbackTransaction()).To(BeNil())
+			})
+
+			It("ends the transaction", func() {
+				db.RollbackTransaction()
+				Expect(db.RollbackTransaction()).ToNot(BeNil())
+			})
+
+			It("reverts changes made to the database", func() {
+				Expect(db.CreateVariable(&Variable{Name: "test", Units: "metres", Created: time.Now()})).To(Succeed())
+
+				_, err := db.GetVariableIDForName("test")
+				Expect(err).To(BeNil())
+
+				err = db.RollbackTransaction()
+				Expect(err).To(BeNil())
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackTransaction()
+
+				_, err = db.GetVariableIDForName("test")
+				Expect(err).ToNot(BeNil())
+			})
+		})
+	})
+
+	Describe("RollbackUncommittedTransaction", func() {
+		Context("when there is no active transaction", func() {
+			It("does not return an error", func() {
+				Expect(db.RollbackUncommittedTransaction()).To(BeNil())
+			})
+		})
+
+		Context("when there is an active transaction", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			It("does not return an error", func() {
+				Expect(db.RollbackUncommittedTransaction()).To(BeNil())
+			})
+
+			It("ends the transaction", func() {
+				db.RollbackUncommittedTransaction()
+				Expect(db.RollbackTransaction()).ToNot(BeNil())
+			})
+		})
+	})
+
+	Context("when connected to a database with all migrations applied", func() {
+		BeforeEach(func() {
+			db.RunMigrations()
+
+			backend.createTestData(db)
+		})
+
+		// setQuality changes the quality of the test data point for agent 1001 and variable 2002 at the given time, in
+		// the transaction in progress.
+		setQuality := func(t time.Time, quality string) {
+			point, found, err := db.GetDataPoint(1001, 2002, t)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+
+			point.Quality = quality
+			_, err = db.AddDataPoint(point, conflictPolicyOverwrite)
+			Expect(err).To(BeNil())
+		}
+
+		// setQualities is setQuality for tests that are not in a transaction.
+		setQualities := func(qualities map[time.Time]string) {
+			Expect(db.BeginTransaction()).To(Succeed())
+
+			for t, quality := range qualities {
+				setQuality(t, quality)
+			}
+
+			Expect(db.CommitTransaction()).To(Succeed())
+		}
+
+		Describe("CreateAgent", func() {
+			It("saves new agents to the database", func() {
+				created := time.Now().Round(time.Millisecond)
+				agent := &Agent{Name: "Test agent", OwnerUserID: 3001, Created: created}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				err := db.CreateAgent(agent)
+				Expect(err).To(BeNil())
+				Expect(agent.AgentID).ToNot(Equal(0))
+
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackTransaction()
+
+				actual, err := db.GetAgentByID(agent.AgentID)
+
+				Expect(err).To(BeNil())
+				Expect(actual.Name).To(Equal("Test agent"))
+				Expect(actual.Created).To(BeTemporally("==", created))
+				Expect(actual.OwnerUserID).To(Equal(3001))
+			})
+		})
+
+		Describe("GetAllAgents", func() {
+			It("returns an empty list if there are no agents in the database", func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.DeleteAgent(1001)).To(Succeed())
+				Expect(db.DeleteAgent(1002)).To(Succeed())
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				agents, err := db.GetAllAgents(AgentFilter{})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(BeEmpty())
+			})
+
+			It("gets all agents from the database", func() {
+				agents, err := db.GetAllAgents(AgentFilter{})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(HaveLen(2))
+
+				Expect(agents[0].AgentID).To(Equal(1001))
+				Expect(agents[0].Name).To(Equal("First agent"))
+				Expect(agents[0].OwnerUserID).To(Equal(3001))
+				Expect(agents[0].TokenIterations).To(Equal(12301))
+				Expect(agents[0].TokenSalt).To(Equal([]byte("salt1001")))
+				Expect(agents[0].TokenHash).To(Equal([]byte("hash1001")))
+				Expect(agents[0].Created).To(BeTemporally("==", time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)))
+
+				Expect(agents[1].AgentID).To(Equal(1002))
+				Expect(agents[1].Name).To(Equal("Second agent"))
+				Expect(agents[1].OwnerUserID).To(Equal(3001))
+				Expect(agents[1].TokenIterations).To(Equal(12302))
+				Expect(agents[1].TokenSalt).To(Equal([]byte("salt1002")))
+				Expect(agents[1].TokenHash).To(Equal([]byte("hash1002")))
+				Expect(agents[1].Created).To(BeTemporally("==", time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC)))
+			})
+
+			It("does not return soft-deleted agents", func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.SoftDeleteAgent(1001, time.Now())).To(Succeed())
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				agents, err := db.GetAllAgents(AgentFilter{})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(HaveLen(1))
+				Expect(agents[0].AgentID).To(Equal(1002))
+			})
+
+			DescribeTable("filters and pages the agents", func(filter AgentFilter, expectedAgentIDs []int) {
+				agents, err := db.GetAllAgents(filter)
+				Expect(err).To(BeNil())
+
+				agentIDs := []int{}
+
+				for _, agent := range agents {
+					agentIDs = append(agentIDs, agent.AgentID)
+				}
+
+				Expect(agentIDs).To(Equal(expectedAgentIDs))
+			},
+				Entry("by name, ignoring case", AgentFilter{Name: "SECOND"}, []int{1002}),
+				Entry("by name, treating wildcard characters literally", AgentFilter{Name: "%"}, []int{}),
+				Entry("with a limit", AgentFilter{Limit: 1}, []int{1001}),
+				Entry("with a limit and offset", AgentFilter{Limit: 1, Offset: 1}, []int{1002}),
+				Entry("with an offset past the last agent", AgentFilter{Offset: 2}, []int{}),
+			)
+		})
+
+		Describe("GetAgentsForUser", func() {
+			var otherUser User
+			var otherAgent Agent
+
+			BeforeEach(func() {
+				otherUser = User{Email: "other@blah.com", Created: time.Date(2015, 3, 30, 1, 58, 0, 0, time.UTC)}
+				otherAgent = Agent{Name: "Other user's agent", TokenIterations: 12303, TokenSalt: []byte("salt1003"), TokenHash: []byte("hash1003"), Created: time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.CreateUser(&otherUser)).To(Succeed())
+				otherAgent.OwnerUserID = otherUser.UserID
+				Expect(db.CreateAgent(&otherAgent)).To(Succeed())
+				Expect(db.CommitTransaction()).To(BeNil())
+			})
+
+			It("returns only the agents owned by the user", func() {
+				agents, err := db.GetAgentsForUser(otherUser.UserID, AgentFilter{})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(HaveLen(1))
+				Expect(agents[0].AgentID).To(Equal(otherAgent.AgentID))
+				Expect(agents[0].Name).To(Equal("Other user's agent"))
+				Expect(agents[0].OwnerUserID).To(Equal(otherUser.UserID))
+			})
+
+			It("applies the filter", func() {
+				agents, err := db.GetAgentsForUser(3001, AgentFilter{Name: "agent", Limit: 1, Offset: 1})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(HaveLen(1))
+				Expect(agents[0].AgentID).To(Equal(1002))
+			})
+
+			It("returns an empty list if the user has no agents", func() {
+				agents, err := db.GetAgentsForUser(9001, AgentFilter{})
+
+				Expect(err).To(BeNil())
+				Expect(agents).To(BeEmpty())
+			})
+		})
+
+		Describe("UpdateAgent", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("saves the name and metadata of the agent", func() {
+				agent := Agent{AgentID: 1001, Name: "Renamed agent", Metadata: map[string]string{"location": "Roof"}}
+
+				Expect(db.UpdateAgent(agent)).To(Succeed())
+
+				updated, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(updated.Name).To(Equal("Renamed agent"))
+				Expect(updated.Metadata).To(Equal(map[string]string{"location": "Roof"}))
+				Expect(updated.TokenHash).To(Equal([]byte("hash1001")))
+			})
+
+			It("saves the offline interval of the agent", func() {
+				agent := Agent{AgentID: 1001, Name: "First agent", OfflineAfter: Interval(90 * time.Minute)}
+
+				Expect(db.UpdateAgent(agent)).To(Succeed())
+
+				updated, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(updated.OfflineAfter).To(Equal(Interval(90 * time.Minute)))
+			})
+
+			It("returns an error if the agent does not exist", func() {
+				Expect(db.UpdateAgent(Agent{AgentID: 9001, Name: "Missing agent"})).ToNot(Succeed())
+			})
+		})
+
+		Describe("UpdateAgentToken", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("saves the current and previous tokens of the agent", func() {
+				expires := time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)
+				agent := Agent{
+					AgentID:                 1001,
+					TokenIterations:         1,
+					TokenSalt:               []byte("newsalt"),
+					TokenHash:               []byte("newhash"),
+					PreviousTokenIterations: 12301,
+					PreviousTokenSalt:       []byte("salt1001"),
+					PreviousTokenHash:       []byte("hash1001"),
+					PreviousTokenExpires:    expires,
+				}
+
+				Expect(db.UpdateAgentToken(agent)).To(Succeed())
+
+				updated, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(updated.Name).To(Equal("First agent"))
+				Expect(updated.TokenIterations).To(Equal(1))
+				Expect(updated.TokenSalt).To(Equal([]byte("newsalt")))
+				Expect(updated.TokenHash).To(Equal([]byte("newhash")))
+				Expect(updated.PreviousTokenIterations).To(Equal(12301))
+				Expect(updated.PreviousTokenSalt).To(Equal([]byte("salt1001")))
+				Expect(updated.PreviousTokenHash).To(Equal([]byte("hash1001")))
+				Expect(updated.PreviousTokenExpires).To(BeTemporally("==", expires))
+			})
+
+			It("clears the previous token expiry if there is no previous token", func() {
+				Expect(db.UpdateAgentToken(Agent{AgentID: 1001, TokenIterations: 1, TokenSalt: []byte("newsalt"), TokenHash: []byte("newhash"), PreviousTokenSalt: []byte{}, PreviousTokenHash: []byte{}})).To(Succeed())
+
+				updated, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(updated.PreviousTokenExpires.IsZero()).To(BeTrue())
+			})
+		})
+
+		Describe("DeleteAgent", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("removes the agent and all of its data", func() {
+				Expect(db.DeleteAgent(1001)).To(Succeed())
+
+				exists, err := db.CheckAgentIDExists(1001)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeFalse())
+				Expect(db.SoftDeleteAgent(1001, time.Now())).ToNot(Succeed())
+
+				latest, err := db.GetLatestData(1001)
+				Expect(err).To(BeNil())
+				Expect(latest).To(BeEmpty())
+				latest, err = db.GetLatestData(1002)
+				Expect(err).To(BeNil())
+				Expect(latest).To(HaveLen(1))
+			})
+
+			It("returns an error if the agent does not exist", func() {
+				Expect(db.DeleteAgent(9001)).ToNot(Succeed())
+			})
+		})
+
+		Describe("SoftDeleteAgent", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("hides the agent but keeps it and its data", func() {
+				Expect(db.SoftDeleteAgent(1001, time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC))).To(Succeed())
+
+				exists, err := db.CheckAgentIDExists(1001)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeFalse())
+
+				latest, err := db.GetLatestData(1001)
+				Expect(err).To(BeNil())
+				Expect(latest).To(HaveLen(2))
+				Expect(db.DeleteAgent(1001)).To(Succeed())
+			})
+
+			It("returns an error if the agent has already been deleted", func() {
+				Expect(db.SoftDeleteAgent(1001, time.Now())).To(Succeed())
+				Expect(db.SoftDeleteAgent(1001, time.Now())).ToNot(Succeed())
+			})
+		})
+
+		Describe("agent status", func() {
+			created := time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)
+
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(Succeed())
+				Expect(db.UpdateAgent(Agent{AgentID: 1001, Name: "First agent", OfflineAfter: Interval(time.Hour)})).To(Succeed())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			agentIDs := func(agents []Agent) []int {
+				ids := []int{}
+
+				for _, agent := range agents {
+					ids = append(ids, agent.AgentID)
+				}
+
+				return ids
+			}
+
+			It("records when an agent was last seen, keeping the latest time", func() {
+				Expect(db.UpdateAgentLastSeen(1001, created.Add(2*time.Hour))).To(Succeed())
+				Expect(db.UpdateAgentLastSeen(1001, created.Add(time.Hour))).To(Succeed())
+
+				agent, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(*agent.LastSeen).To(BeTemporally("==", created.Add(2*time.Hour)))
+			})
+
+			It("returns an error when recording that an agent that does not exist was seen", func() {
+				Expect(db.UpdateAgentLastSeen(9001, created)).ToNot(Succeed())
+			})
+
+			It("marks an agent that has never been seen as offline once its offline interval has passed since it was created", func() {
+				offline, err := db.MarkAgentsOffline(created.Add(59 * time.Minute))
+				Expect(err).To(BeNil())
+				Expect(offline).To(BeEmpty())
+
+				offline, err = db.MarkAgentsOffline(created.Add(61 * time.Minute))
+				Expect(err).To(BeNil())
+				Expect(agentIDs(offline)).To(Equal([]int{1001}))
+				Expect(*offline[0].OfflineSince).To(BeTemporally("==", created.Add(61*time.Minute)))
+			})
+
+			It("marks agents as offline once their offline interval has passed since they were last seen, and only once", func() {
+				Expect(db.UpdateAgentLastSeen(1001, created.Add(3*time.Hour))).To(Succeed())
+
+				offline, err := db.MarkAgentsOffline(created.Add(3*time.Hour + 59*time.Minute))
+				Expect(err).To(BeNil())
+				Expect(offline).To(BeEmpty())
+
+				offline, err = db.MarkAgentsOffline(created.Add(4*time.Hour + time.Minute))
+				Expect(err).To(BeNil())
+				Expect(agentIDs(offline)).To(Equal([]int{1001}))
+
+				offline, err = db.MarkAgentsOffline(created.Add(5 * time.Hour))
+				Expect(err).To(BeNil())
+				Expect(offline).To(BeEmpty())
+			})
+
+			It("does not mark agents without an offline interval as offline", func() {
+				offline, err := db.MarkAgentsOffline(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(agentIDs(offline)).To(Equal([]int{1001}))
+			})
+
+			It("marks offline agents that have been seen since as online", func() {
+				_, err := db.MarkAgentsOffline(created.Add(2 * time.Hour))
+				Expect(err).To(BeNil())
+
+				online, err := db.MarkAgentsOnline()
+				Expect(err).To(BeNil())
+				Expect(online).To(BeEmpty())
+
+				Expect(db.UpdateAgentLastSeen(1001, created.Add(3*time.Hour))).To(Succeed())
+
+				online, err = db.MarkAgentsOnline()
+				Expect(err).To(BeNil())
+				Expect(agentIDs(online)).To(Equal([]int{1001}))
+				Expect(online[0].OfflineSince).To(BeNil())
+			})
+		})
+
+		Describe("CheckAgentIDExists", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns true if the agent exists", func() {
+				exists, err := db.CheckAgentIDExists(1002)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeTrue())
+			})
+
+			It("returns false if the agent does not exist", func() {
+				exists, err := db.CheckAgentIDExists(9001)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeFalse())
+			})
+		})
+
+		Describe("CreateVariable", func() {
+			It("saves new variables to the database", func() {
+				created := time.Now().Round(time.Millisecond)
+				variable := &Variable{Name: "Test variable", Units: "metres (m)", DisplayDecimalPlaces: 2, Created: created}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				err := db.CreateVariable(variable)
+				Expect(err).To(BeNil())
+				Expect(variable.VariableID).ToNot(Equal(0))
+
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackTransaction()
+
+				actual, err := db.GetVariableByID(variable.VariableID)
+
+				Expect(err).To(BeNil())
+				Expect(actual.Name).To(Equal("Test variable"))
+				Expect(actual.Units).To(Equal("metres (m)"))
+				Expect(actual.DisplayDecimalPlaces).To(Equal(2))
+				Expect(actual.Created).To(BeTemporally("==", created))
+			})
+
+			It("saves the limits of new variables", func() {
+				minValue, maxStep := -40.0, 5.0
+				variable := &Variable{Name: "Test variable", Units: "degrees (°C)", Created: time.Now(), VariableLimits: VariableLimits{MinValue: &minValue, MaxStep: &maxStep}}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.CreateVariable(variable)).To(Succeed())
+
+				saved, err := db.GetVariableByID(variable.VariableID)
+				Expect(err).To(BeNil())
+				Expect(saved.VariableLimits).To(Equal(VariableLimits{MinValue: &minValue, MaxStep: &maxStep, OutOfRange: "reject"}))
+
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("saves the retention policy of new variables", func() {
+				rawDataDays := 30
+				variable := &Variable{Name: "Test variable", Units: "metres (m)", Created: time.Now(), VariableRetention: VariableRetention{RawDataDays: &rawDataDays}}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.CreateVariable(variable)).To(Succeed())
+
+				saved, err := db.GetVariableByID(variable.VariableID)
+				Expect(err).To(BeNil())
+				Expect(saved.VariableRetention).To(Equal(VariableRetention{RawDataDays: &rawDataDays}))
+
+				db.RollbackUncommittedTransaction()
+			})
+		})
+
+		Describe("UpdateVariableLimits", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("replaces the limits of the variable", func() {
+				maxValue := 3.0
+				limits := VariableLimits{MaxValue: &maxValue, OutOfRange: "flag"}
+
+				exists, err := db.UpdateVariableLimits(2001, limits)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeTrue())
+
+				variable, err := db.GetVariableByID(2001)
+				Expect(err).To(BeNil())
+				Expect(variable.VariableLimits).To(Equal(limits))
+			})
+
+			It("returns false if the variable does not exist", func() {
+				exists, err := db.UpdateVariableLimits(9002, VariableLimits{})
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeFalse())
+			})
+		})
+
+		Describe("UpdateVariableRetention", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("replaces the retention policy of the variable", func() {
+				rawDataDays := 30
+				retention := VariableRetention{RawDataDays: &rawDataDays}
+
+				exists, err := db.UpdateVariableRetention(2001, retention)
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeTrue())
+
+				variable, err := db.GetVariableByID(2001)
+				Expect(err).To(BeNil())
+				Expect(variable.VariableRetention).To(Equal(retention))
+
+				exists, err = db.UpdateVariableRetention(2001, VariableRetention{})
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeTrue())
+
+				variable, err = db.GetVariableByID(2001)
+				Expect(err).To(BeNil())
+				Expect(variable.RawDataDays).To(BeNil())
+			})
+
+			It("returns false if the variable does not exist", func() {
+				exists, err := db.UpdateVariableRetention(9002, VariableRetention{})
+				Expect(err).To(BeNil())
+				Expect(exists).To(BeFalse())
+			})
+		})
+
+		Describe("GetAllVariables", func() {
+			It("returns every variable in order of ID", func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackUncommittedTransaction()
+
+				variables, err := db.GetAllVariables()
+				Expect(err).To(BeNil())
+				Expect(variables).To(HaveLen(2))
+				Expect(variables[0].VariableID).To(Equal(2001))
+				Expect(variables[0].Name).To(Equal("distance"))
+				Expect(variables[1].VariableID).To(Equal(2002))
+				Expect(variables[1].Name).To(Equal("humidity"))
+			})
+		})
+
+		Describe("AddDataPoint", func() {
+			It("adds the data point to the database", func() {
+				dataTime := time.Now().Round(time.Millisecond)
+				dataPoint := DataPoint{AgentID: 1002, VariableID: 2002, Time: dataTime, Value: 100.67}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				existed, err := db.AddDataPoint(dataPoint, conflictPolicyReject)
+				Expect(err).To(BeNil())
+				Expect(existed).To(BeFalse())
+
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackTransaction()
+
+				actual, found, err := db.GetDataPoint(1002, 2002, dataTime)
+
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+				Expect(actual.AgentID).To(Equal(1002))
+				Expect(actual.VariableID).To(Equal(2002))
+				Expect(actual.Time).To(BeTemporally("==", dataTime))
+				Expect(actual.Value).To(Equal(100.67))
+			})
+
+			Context("when there is already a value for the same agent, variable and time", func() {
+				existingTime := time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)
+				dataPoint := DataPoint{AgentID: 1001, VariableID: 2002, Time: existingTime, Value: 200}
+
+				BeforeEach(func() {
+					Expect(db.BeginTransaction()).To(BeNil())
+				})
+
+				AfterEach(func() {
+					db.RollbackUncommittedTransaction()
+				})
+
+				getValue := func() float64 {
+					point, found, err := db.GetDataPoint(1001, 2002, existingTime)
+					Expect(err).To(BeNil())
+					Expect(found).To(BeTrue())
+
+					return point.Value
+				}
+
+				DescribeTable("keeps the existing value and reports the conflict", func(conflictPolicy string) {
+					existed, err := db.AddDataPoint(dataPoint, conflictPolicy)
+					Expect(err).To(BeNil())
+					Expect(existed).To(BeTrue())
+					Expect(getValue()).To(Equal(float64(103)))
+				},
+					Entry("when the conflict policy is 'reject'", conflictPolicyReject),
+					Entry("when the conflict policy is 'ignore'", conflictPolicyIgnore),
+				)
+
+				It("replaces the existing value and reports the conflict when the conflict policy is 'overwrite'", func() {
+					existed, err := db.AddDataPoint(dataPoint, conflictPolicyOverwrite)
+					Expect(err).To(BeNil())
+					Expect(existed).To(BeTrue())
+					Expect(getValue()).To(Equal(float64(200)))
+				})
+
+				It("does not report a conflict for a new point when the conflict policy is 'overwrite'", func() {
+					existed, err := db.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2002, Time: existingTime.Add(time.Hour), Value: 200}, conflictPolicyOverwrite)
+					Expect(err).To(BeNil())
+					Expect(existed).To(BeFalse())
+				})
+
+				It("returns an error if the conflict policy is not supported", func() {
+					_, err := db.AddDataPoint(dataPoint, "blah")
+					Expect(err).ToNot(BeNil())
+				})
+			})
+
+			It("saves values at full precision", func() {
+				values := []float64{101325.25, 1013250.125, 54321.123456789, 0.000001234, -1.5e-300, 1.7976931348623157e308}
+				dataTime := time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC)
+
+				Expect(db.BeginTransaction()).To(BeNil())
+
+				for i, value := range values {
+					_, err := db.AddDataPoint(DataPoint{AgentID: 1002, VariableID: 2002, Time: dataTime.Add(time.Duration(i) * time.Minute), Value: value}, conflictPolicyReject)
+					Expect(err).To(BeNil())
+				}
+
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				saved := []float64{}
+				err := db.StreamData(1002, []int{2002}, dataTime, dataTime.Add(time.Hour), true, func(point DataPoint) error {
+					saved = append(saved, point.Value)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(saved).To(Equal(values))
+			})
+
+			It("saves the quality of the data point, which defaults to good", func() {
+				dataTime := time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC)
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				defer db.RollbackUncommittedTransaction()
+
+				_, err := db.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2001, Time: dataTime, Value: 1}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+				_, err = db.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2002, Time: dataTime, Value: 2, Quality: dataQualitySuspect}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+
+				qualities := []string{}
+
+				for _, variableID := range []int{2001, 2002} {
+					point, found, err := db.GetDataPoint(1001, variableID, dataTime)
+					Expect(err).To(BeNil())
+					Expect(found).To(BeTrue())
+					qualities = append(qualities, point.Quality)
+				}
+
+				Expect(qualities).To(Equal([]string{"good", "suspect"}))
+			})
+		})
+
+		Describe("AddDataPoints", func() {
+			existingTime := time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)
+			newTime := time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC)
+
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			getPoint := func(variableID int, t time.Time) DataPoint {
+				point, found, err := db.GetDataPoint(1001, variableID, t)
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+
+				return point
+			}
+
+			It("saves every point and reports which points already had a value", func() {
+				existed, err := db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2001, Time: newTime, Value: 1},
+					{AgentID: 1001, VariableID: 2002, Time: existingTime, Value: 200},
+					{AgentID: 1001, VariableID: 2002, Time: newTime, Value: 2, Quality: dataQualitySuspect},
+				}, conflictPolicyReject)
+
+				Expect(err).To(BeNil())
+				Expect(existed).To(Equal([]bool{false, true, false}))
+				Expect(getPoint(2001, newTime).Value).To(Equal(float64(1)))
+				Expect(getPoint(2002, existingTime).Value).To(Equal(float64(103)))
+				Expect(getPoint(2002, newTime).Quality).To(Equal(dataQualitySuspect))
+			})
+
+			It("replaces the existing values when the conflict policy is 'overwrite'", func() {
+				existed, err := db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2002, Time: existingTime, Value: 200},
+					{AgentID: 1001, VariableID: 2002, Time: newTime, Value: 2},
+				}, conflictPolicyOverwrite)
+
+				Expect(err).To(BeNil())
+				Expect(existed).To(Equal([]bool{true, false}))
+				Expect(getPoint(2002, existingTime).Value).To(Equal(float64(200)))
+			})
+
+			DescribeTable("treats a repeated point the same as if it had been saved by itself", func(conflictPolicy string, expectedValue float64) {
+				existed, err := db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2002, Time: newTime, Value: 1},
+					{AgentID: 1001, VariableID: 2002, Time: newTime, Value: 2},
+				}, conflictPolicy)
+
+				Expect(err).To(BeNil())
+				Expect(existed).To(Equal([]bool{false, true}))
+				Expect(getPoint(2002, newTime).Value).To(Equal(expectedValue))
+			},
+				Entry("when the conflict policy is 'ignore'", conflictPolicyIgnore, float64(1)),
+				Entry("when the conflict policy is 'overwrite'", conflictPolicyOverwrite, float64(2)),
+			)
+
+			It("saves large batches", func() {
+				points := []DataPoint{}
+
+				for i := 0; i < 1234; i++ {
+					points = append(points, DataPoint{AgentID: 1001, VariableID: 2001, Time: newTime.Add(time.Duration(i) * time.Second), Value: float64(i)})
+				}
+
+				existed, err := db.AddDataPoints(points, conflictPolicyReject)
+				Expect(err).To(BeNil())
+				Expect(existed).To(HaveLen(1234))
+				Expect(existed).ToNot(ContainElement(true))
+
+				existed, err = db.AddDataPoints(points, conflictPolicyIgnore)
+				Expect(err).To(BeNil())
+				Expect(existed).ToNot(ContainElement(false))
+
+				Expect(getPoint(2001, newTime.Add(1233*time.Second)).Value).To(Equal(float64(1233)))
+			})
+
+			It("does nothing if there are no points", func() {
+				existed, err := db.AddDataPoints([]DataPoint{}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+				Expect(existed).To(BeEmpty())
+			})
+
+			It("returns an error if the conflict policy is not supported", func() {
+				_, err := db.AddDataPoints([]DataPoint{{AgentID: 1001, VariableID: 2002, Time: newTime, Value: 1}}, "blah")
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
+		Describe("GetPreviousValue", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("returns the most recent value from before the given time", func() {
+				value, found, err := db.GetPreviousValue(1001, 2002, time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+				Expect(value).To(Equal(float64(103)))
+			})
+
+			It("skips values that are not good", func() {
+				setQuality(time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC), "suspect")
+
+				value, found, err := db.GetPreviousValue(1001, 2002, time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+				Expect(value).To(Equal(float64(101)))
+			})
+
+			It("returns false if there is no earlier value", func() {
+				_, found, err := db.GetPreviousValue(1001, 2002, time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(found).To(BeFalse())
+			})
+		})
+
+		Describe("GetVariableIDForName", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the variable ID if the variable exists", func() {
+				id, err := db.GetVariableIDForName("distance")
+				Expect(err).To(BeNil())
+				Expect(id).To(Equal(2001))
+			})
+
+			It("returns -1 if the variable does not exist", func() {
+				id, err := db.GetVariableIDForName("temperature")
+				Expect(err).ToNot(BeNil())
+				Expect(id).To(Equal(-1))
+			})
+		})
+
+		Describe("GetVariablesByName", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the variables that exist, keyed by name", func() {
+				variables, err := db.GetVariablesByName([]string{"distance", "temperature", "humidity"})
+				Expect(err).To(BeNil())
+				Expect(variables).To(HaveLen(2))
+				Expect(variables["distance"].VariableID).To(Equal(2001))
+				Expect(variables["distance"].Units).To(Equal("metres"))
+				Expect(variables["humidity"].VariableID).To(Equal(2002))
+			})
+
+			It("returns no variables if no names are given", func() {
+				variables, err := db.GetVariablesByName([]string{})
+				Expect(err).To(BeNil())
+				Expect(variables).To(BeEmpty())
+			})
+		})
+
+		Describe("GetData", func() {
+			It("returns the data matching the criteria given", func() {
+				data, err := db.GetData(1001, 2002, time.Date(2015, 4, 7, 15, 0, 30, 0, time.UTC), time.Date(2015, 4, 7, 15, 2, 30, 0, time.UTC), true)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(2))
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:01:00Z", float64(103)))
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:02:00Z", float64(104)))
+			})
+
+			It("leaves out suspect and bad points if flagged points are not included", func() {
+				setQualities(map[time.Time]string{
+					time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC): "bad",
+					time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC): "corrected",
+				})
+
+				data, err := db.GetData(1001, 2002, time.Date(2015, 4, 7, 15, 0, 30, 0, time.UTC), time.Date(2015, 4, 7, 15, 2, 30, 0, time.UTC), false)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T15:02:00Z": 104}))
+			})
+		})
+
+		Describe("GetAggregatedData", func() {
+			fromDate := time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)
+			toDate := time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC)
+
+			DescribeTable("returns the aggregate of each bucket", func(aggregate string, firstBucketValue float64, secondBucketValue float64) {
+				data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, 2*time.Minute, aggregate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(2))
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:00:00Z", firstBucketValue))
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:02:00Z", secondBucketValue))
+			},
+				Entry("min", "min", float64(101), float64(104)),
+				Entry("max", "max", float64(103), float64(105)),
+				Entry("avg", "avg", float64(102), float64(104.5)),
+				Entry("sum", "sum", float64(204), float64(209)),
+				Entry("count", "count", float64(2), float64(2)),
+				Entry("first", "first", float64(101), float64(104)),
+				Entry("last", "last", float64(103), float64(105)),
+			)
+
+			It("only includes points within the date range given", func() {
+				data, err := db.GetAggregatedData(1001, 2002, time.Date(2015, 4, 7, 15, 0, 30, 0, time.UTC), toDate, time.Hour, "count", true)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(1))
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:00:00Z", float64(3)))
+			})
+
+			It("leaves out suspect and bad points if flagged points are not included", func() {
+				setQualities(map[time.Time]string{time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC): "suspect"})
+
+				data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, time.Hour, "count", false)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveKeyWithValue("2015-04-07T15:00:00Z", float64(3)))
+			})
+
+			It("returns an error if the aggregate is not supported", func() {
+				_, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, time.Hour, "median", true)
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
+		Describe("RollUpData", func() {
+			fromDate := time.Date(2015, 4, 7, 0, 0, 0, 0, time.UTC)
+			toDate := time.Date(2015, 4, 8, 0, 0, 0, 0, time.UTC)
+
+			rollUp := func(variableID int, before time.Time) int {
+				Expect(db.BeginTransaction()).To(Succeed())
+				defer db.RollbackUncommittedTransaction()
+
+				count, err := db.RollUpData(variableID, before)
+				Expect(err).To(BeNil())
+				Expect(db.CommitTransaction()).To(Succeed())
+
+				return count
+			}
+
+			addPoint := func(t time.Time, value float64) {
+				Expect(db.BeginTransaction()).To(Succeed())
+				defer db.RollbackUncommittedTransaction()
+
+				_, err := db.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2002, Time: t, Value: value}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+				Expect(db.CommitTransaction()).To(Succeed())
+			}
+
+			It("removes the data from before the given time and returns the number of points removed", func() {
+				Expect(rollUp(2002, toDate)).To(Equal(4))
+
+				points := []DataPoint{}
+				err := db.StreamData(1001, []int{2002}, fromDate, toDate, true, func(point DataPoint) error {
+					points = append(points, point)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(points).To(BeEmpty())
+			})
+
+			It("keeps the data from after the given time", func() {
+				Expect(rollUp(2002, time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))).To(Equal(0))
+
+				data, err := db.GetData(1001, 2002, fromDate, toDate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(4))
+			})
+
+			It("does not change the data for other variables", func() {
+				rollUp(2002, toDate)
+
+				data, err := db.GetData(1001, 2001, fromDate, toDate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T15:00:00Z": 100}))
+			})
+
+			It("gives the average of each hour in place of the data when it is read without being aggregated", func() {
+				rollUp(2002, toDate)
+
+				data, err := db.GetData(1001, 2002, fromDate, toDate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T15:00:00Z": 103.25}))
+			})
+
+			DescribeTable("aggregates the rolled up data", func(interval time.Duration, aggregate string, expectedBucket string, expectedValue float64) {
+				rollUp(2002, toDate)
+
+				data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, interval, aggregate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(1))
+				Expect(data).To(HaveKeyWithValue(expectedBucket, BeNumerically("~", expectedValue)))
+			},
+				Entry("min by hour", time.Hour, "min", "2015-04-07T15:00:00Z", float64(101)),
+				Entry("max by hour", time.Hour, "max", "2015-04-07T15:00:00Z", float64(105)),
+				Entry("avg by hour", time.Hour, "avg", "2015-04-07T15:00:00Z", float64(103.25)),
+				Entry("sum by hour", time.Hour, "sum", "2015-04-07T15:00:00Z", float64(413)),
+				Entry("count by hour", time.Hour, "count", "2015-04-07T15:00:00Z", float64(4)),
+				Entry("min by day", 24*time.Hour, "min", "2015-04-07T00:00:00Z", float64(101)),
+				Entry("avg by day", 24*time.Hour, "avg", "2015-04-07T00:00:00Z", float64(103.25)),
+				Entry("count by day", 24*time.Hour, "count", "2015-04-07T00:00:00Z", float64(4)),
+			)
+
+			It("leaves suspect and bad points out of the rollups", func() {
+				setQualities(map[time.Time]string{time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC): "bad"})
+				Expect(rollUp(2002, toDate)).To(Equal(3))
+
+				data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, time.Hour, "max", false)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T15:00:00Z": 104}))
+			})
+
+			It("keeps suspect and bad points, so that they can still be corrected", func() {
+				setQualities(map[time.Time]string{
+					time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC): "bad",
+					time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC): "suspect",
+				})
+
+				Expect(rollUp(2002, toDate)).To(Equal(2))
+
+				points := []DataPoint{}
+				err := db.StreamData(1001, []int{2002}, fromDate, toDate, true, func(point DataPoint) error {
+					points = append(points, point)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(points).To(HaveLen(2))
+				Expect(points[0].Quality).To(Equal("bad"))
+				Expect(points[1].Quality).To(Equal("suspect"))
+			})
+
+			It("adds data that arrives after its hour has been rolled up to the existing rollups", func() {
+				rollUp(2002, toDate)
+				addPoint(time.Date(2015, 4, 7, 15, 30, 0, 0, time.UTC), 110)
+				Expect(rollUp(2002, toDate)).To(Equal(1))
+
+				for _, interval := range []time.Duration{time.Hour, 24 * time.Hour} {
+					data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, interval, "count", true)
+					Expect(err).To(BeNil())
+					Expect(data).To(ConsistOf(float64(5)))
+
+					data, err = db.GetAggregatedData(1001, 2002, fromDate, toDate, interval, "avg", true)
+					Expect(err).To(BeNil())
+					Expect(data).To(ConsistOf(BeNumerically("~", 104.6)))
+
+					data, err = db.GetAggregatedData(1001, 2002, fromDate, toDate, interval, "max", true)
+					Expect(err).To(BeNil())
+					Expect(data).To(ConsistOf(float64(110)))
+				}
+			})
+
+			It("combines the rollups with data that has not been rolled up yet", func() {
+				rollUp(2002, toDate)
+				addPoint(time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), 90)
+
+				data, err := db.GetData(1001, 2002, fromDate, toDate, true)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T15:00:00Z": 103.25, "2015-04-07T16:00:00Z": 90}))
+
+				data, err = db.GetAggregatedData(1001, 2002, fromDate, toDate, 24*time.Hour, "min", true)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T00:00:00Z": 90}))
+
+				data, err = db.GetAggregatedData(1001, 2002, fromDate, toDate, 24*time.Hour, "last", true)
+				Expect(err).To(BeNil())
+				Expect(data).To(Equal(map[string]float64{"2015-04-07T00:00:00Z": 90}))
+			})
+
+			It("removes the rollups of an agent when the agent is deleted", func() {
+				rollUp(2002, toDate)
+
+				Expect(db.BeginTransaction()).To(Succeed())
+				Expect(db.DeleteAgent(1001)).To(Succeed())
+				Expect(db.CommitTransaction()).To(Succeed())
+
+				data, err := db.GetAggregatedData(1001, 2002, fromDate, toDate, time.Hour, "count", true)
+				Expect(err).To(BeNil())
+				Expect(data).To(BeEmpty())
+			})
+		})
+
+		Describe("StreamData", func() {
+			It("calls the callback with each data point in order of time and variable", func() {
+				points := []DataPoint{}
+
+				err := db.StreamData(1001, []int{2001, 2002}, time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), time.Date(2015, 4, 7, 15, 1, 30, 0, time.UTC), true, func(point DataPoint) error {
+					points = append(points, point)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(points).To(HaveLen(3))
+				Expect(points[0].VariableID).To(Equal(2001))
+				Expect(points[0].Value).To(Equal(float64(100)))
+				Expect(points[0].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
+				Expect(points[1].VariableID).To(Equal(2002))
+				Expect(points[1].Value).To(Equal(float64(101)))
+				Expect(points[1].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
+				Expect(points[2].VariableID).To(Equal(2002))
+				Expect(points[2].Value).To(Equal(float64(103)))
+				Expect(points[2].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)))
+			})
+
+			It("stops and returns the error if the callback returns an error", func() {
+				count := 0
+
+				err := db.StreamData(1001, []int{2002}, time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC), true, func(point DataPoint) error {
+					count++
+					return errors.New("Something went wrong.")
+				})
+
+				Expect(err).To(MatchError("Something went wrong."))
+				Expect(count).To(Equal(1))
+			})
+
+			It("includes the quality of each point, and leaves out suspect and bad points if flagged points are not included", func() {
+				setQualities(map[time.Time]string{
+					time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC): "bad",
+					time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC): "corrected",
+				})
+				qualities := []string{}
+
+				err := db.StreamData(1001, []int{2002}, time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC), time.Date(2015, 4, 7, 15, 1, 30, 0, time.UTC), false, func(point DataPoint) error {
+					qualities = append(qualities, point.Quality)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(qualities).To(Equal([]string{"corrected"}))
+			})
+		})
+
+		Describe("StreamDataSavedAfter", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			savedAfter := func(sequenceNumber int) []DataPoint {
+				points := []DataPoint{}
+
+				err := db.StreamDataSavedAfter(1001, sequenceNumber, 100, func(point DataPoint) error {
+					points = append(points, point)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+
+				return points
 			}
 
-			migrations, _ := getMigrationSource().FindMigrations()
-			migrationNames := make([]string, len(migrations))
-			for i, m := range migrations {
-				migrationNames[i] = m.Id
+			lastSequenceNumber := func() int {
+				sequenceNumber, err := db.GetLastDataSequenceNumber(1001)
+				Expect(err).To(BeNil())
+
+				return sequenceNumber
 			}
 
-			// If this test fails, you probably need to run 'make generate'.
-			Expect(migrationNames).To(Equal(expectedMigrationFileNames))
+			It("calls the callback with each point saved after the given sequence number in the order they were saved, whatever their time", func() {
+				last := lastSequenceNumber()
+
+				_, err := db.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 17, 0, 0, 0, time.UTC), Value: 1}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+
+				_, err = db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2001, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 2},
+					{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 14, 0, 0, 0, time.UTC), Value: 3, Quality: dataQualitySuspect},
+				}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+
+				points := savedAfter(last)
+				Expect(points).To(HaveLen(3))
+				Expect([]float64{points[0].Value, points[1].Value, points[2].Value}).To(Equal([]float64{1, 2, 3}))
+				Expect(points[1].VariableID).To(Equal(2001))
+				Expect(points[2].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 14, 0, 0, 0, time.UTC)))
+				Expect(points[2].Quality).To(Equal(dataQualitySuspect))
+				Expect(points[0].SequenceNumber).To(BeNumerically(">", last))
+				Expect(points[1].SequenceNumber).To(BeNumerically(">", points[0].SequenceNumber))
+				Expect(points[2].SequenceNumber).To(BeNumerically(">", points[1].SequenceNumber))
+				Expect(lastSequenceNumber()).To(Equal(points[2].SequenceNumber))
+
+				Expect(savedAfter(points[1].SequenceNumber)).To(HaveLen(1))
+			})
+
+			It("gives a point a new sequence number when it is overwritten, but not when it is kept", func() {
+				existingTime := time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)
+				last := lastSequenceNumber()
+
+				_, err := db.AddDataPoints([]DataPoint{{AgentID: 1001, VariableID: 2002, Time: existingTime, Value: 200}}, conflictPolicyIgnore)
+				Expect(err).To(BeNil())
+				Expect(savedAfter(last)).To(BeEmpty())
+
+				_, err = db.AddDataPoints([]DataPoint{{AgentID: 1001, VariableID: 2002, Time: existingTime, Value: 200}}, conflictPolicyOverwrite)
+				Expect(err).To(BeNil())
+
+				points := savedAfter(last)
+				Expect(points).To(HaveLen(1))
+				Expect(points[0].Value).To(Equal(float64(200)))
+				Expect(points[0].Time).To(BeTemporally("==", existingTime))
+			})
+
+			It("stops after the given number of points", func() {
+				last := lastSequenceNumber()
+
+				_, err := db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2001, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 2},
+					{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 3},
+					{AgentID: 1001, VariableID: 2001, Time: time.Date(2015, 4, 7, 16, 1, 0, 0, time.UTC), Value: 4},
+				}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+
+				points := []DataPoint{}
+
+				err = db.StreamDataSavedAfter(1001, last, 2, func(point DataPoint) error {
+					points = append(points, point)
+					return nil
+				})
+
+				Expect(err).To(BeNil())
+				Expect(points).To(HaveLen(2))
+				Expect([]float64{points[0].Value, points[1].Value}).To(Equal([]float64{2, 3}))
+			})
+
+			It("stops and returns the error if the callback returns an error", func() {
+				last := lastSequenceNumber()
+
+				_, err := db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2001, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 2},
+					{AgentID: 1001, VariableID: 2002, Time: time.Date(2015, 4, 7, 16, 0, 0, 0, time.UTC), Value: 3},
+				}, conflictPolicyReject)
+				Expect(err).To(BeNil())
+
+				count := 0
+
+				err = db.StreamDataSavedAfter(1001, last, 100, func(point DataPoint) error {
+					count++
+					return errors.New("Something went wrong.")
+				})
+
+				Expect(err).To(MatchError("Something went wrong."))
+				Expect(count).To(Equal(1))
+			})
+		})
+
+		Describe("data corrections", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("gets a single data point", func() {
+				point, found, err := db.GetDataPoint(1001, 2002, time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+				Expect(point.AgentID).To(Equal(1001))
+				Expect(point.VariableID).To(Equal(2002))
+				Expect(point.Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)))
+				Expect(point.Value).To(Equal(float64(103)))
+				Expect(point.Quality).To(Equal(dataQualityGood))
+			})
+
+			It("returns false if there is no data point at the time given", func() {
+				_, found, err := db.GetDataPoint(1001, 2002, time.Date(2015, 4, 7, 15, 1, 30, 0, time.UTC))
+				Expect(err).To(BeNil())
+				Expect(found).To(BeFalse())
+			})
+
+			It("saves and retrieves corrections, most recent first", func() {
+				created := time.Now().Round(time.Millisecond)
+				dataTime := time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)
+				first := DataCorrection{AgentID: 1001, VariableID: 2002, Time: dataTime, OriginalValue: 103, OriginalQuality: "good", Value: 103, Quality: "bad", UserID: 3001, Created: created}
+				second := DataCorrection{AgentID: 1001, VariableID: 2002, Time: dataTime, OriginalValue: 103, OriginalQuality: "bad", Value: 102.5, Quality: "corrected", UserID: 3001, Created: created.Add(time.Minute)}
+
+				Expect(db.AddDataCorrection(&first)).To(Succeed())
+				Expect(db.AddDataCorrection(&second)).To(Succeed())
+				Expect(first.CorrectionID).ToNot(Equal(0))
+
+				corrections, err := db.GetDataCorrections(1001)
+				Expect(err).To(BeNil())
+				Expect(corrections).To(HaveLen(2))
+				Expect(corrections[0].CorrectionID).To(Equal(second.CorrectionID))
+				Expect(corrections[0].OriginalQuality).To(Equal("bad"))
+				Expect(corrections[0].Value).To(Equal(102.5))
+				Expect(corrections[0].Quality).To(Equal("corrected"))
+				Expect(corrections[0].Time).To(BeTemporally("==", dataTime))
+				Expect(corrections[0].Created).To(BeTemporally("==", created.Add(time.Minute)))
+				Expect(corrections[1].CorrectionID).To(Equal(first.CorrectionID))
+
+				otherCorrections, err := db.GetDataCorrections(1002)
+				Expect(err).To(BeNil())
+				Expect(otherCorrections).To(BeEmpty())
+			})
+		})
+
+		Describe("GetLatestData", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the most recent data point for each variable", func() {
+				data, err := db.GetLatestData(1001)
+				Expect(err).To(BeNil())
+				Expect(data).To(HaveLen(2))
+				Expect(data).To(HaveKey(2001))
+				Expect(data).To(HaveKey(2002))
+				Expect(data[2001].Value).To(Equal(float64(100)))
+				Expect(data[2001].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
+				Expect(data[2002].Value).To(Equal(float64(105)))
+				Expect(data[2002].Time).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC)))
+			})
+
+			It("returns an empty map if the agent has no data", func() {
+				data, err := db.GetLatestData(9001)
+				Expect(err).To(BeNil())
+				Expect(data).To(BeEmpty())
+			})
+		})
+
+		Describe("GetVariableByID", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the variable if it exists", func() {
+				variable, err := db.GetVariableByID(2001)
+				Expect(err).To(BeNil())
+				Expect(variable.VariableID).To(Equal(2001))
+				Expect(variable.Name).To(Equal("distance"))
+				Expect(variable.Units).To(Equal("metres"))
+				Expect(variable.DisplayDecimalPlaces).To(Equal(2))
+				Expect(variable.Created).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
+			})
+
+			It("fails if the variable does not exist", func() {
+				variable, err := db.GetVariableByID(9002)
+				Expect(err).ToNot(BeNil())
+				Expect(variable).To(Equal(Variable{}))
+			})
+		})
+
+		Describe("GetVariablesForAgent", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the details of every variable associated with the agent", func() {
+				variables, err := db.GetVariablesForAgent(1002)
+				Expect(err).To(BeNil())
+				Expect(variables).To(HaveLen(1))
+				Expect(variables[0].VariableID).To(Equal(2001))
+				Expect(variables[0].Name).To(Equal("distance"))
+				Expect(variables[0].Units).To(Equal("metres"))
+				Expect(variables[0].DisplayDecimalPlaces).To(Equal(2))
+				Expect(variables[0].Created).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
+			})
+		})
+
+		Describe("GetAgentByID", func() {
+			BeforeEach(func() {
+				err := db.BeginTransaction()
+				Expect(err).To(BeNil())
+			})
+
+			AfterEach(func() {
+				db.RollbackTransaction()
+			})
+
+			It("returns the agent if it exists", func() {
+				agent, err := db.GetAgentByID(1001)
+				Expect(err).To(BeNil())
+				Expect(agent.AgentID).To(Equal(1001))
+				Expect(agent.Name).To(Equal("First agent"))
+				Expect(agent.OwnerUserID).To(Equal(3001))
+				Expect(agent.TokenIterations).To(Equal(12301))
+				Expect(agent.TokenSalt).To(Equal([]byte("salt1001")))
+				Expect(agent.TokenHash).To(Equal([]byte("hash1001")))
+				Expect(agent.Created).To(BeTemporally("==", time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)))
+			})
+
+			It("fails if the variable does not exist", func() {
+				agent, err := db.GetAgentByID(2)
+				Expect(err).ToNot(BeNil())
+				Expect(agent).To(Equal(Agent{}))
+			})
+		})
+
+		Describe("CreateUser", func() {
+			It("saves new users to the database", func() {
+				created := time.Now().Round(time.Millisecond)
+				user := &User{
+					UserID:             0,
+					Email:              "test@example.com",
+					PasswordIterations: 1000,
+					PasswordSalt:       []byte("salty"),
+					PasswordHash:       []byte("pass"),
+					IsAdmin:            true,
+					Created:            created,
+				}
+
+				Expect(db.BeginTransaction()).To(BeNil())
+				err := db.CreateUser(user)
+				Expect(err).To(BeNil())
+				Expect(user.UserID).ToNot(Equal(0))
+
+				Expect(db.CommitTransaction()).To(BeNil())
+
+				actual, err := db.GetUserByID(user.UserID)
+
+				Expect(err).To(BeNil())
+				Expect(actual.Email).To(Equal("test@example.com"))
+				Expect(actual.PasswordIterations).To(Equal(1000))
+				Expect(actual.PasswordSalt).To(Equal([]byte("salty")))
+				Expect(actual.PasswordHash).To(Equal([]byte("pass")))
+				Expect(actual.IsAdmin).To(Equal(true))
+				Expect(actual.Created).To(BeTemporally("==", created))
+			})
+		})
+
+		Describe("GetUserByEmail", func() {
+			BeforeEach(func() {
+				Expect(db.BeginTransaction()).To(BeNil())
+				Expect(db.CreateUser(&User{
+					Email:              "test@testing.com",
+					PasswordIterations: 12345,
+					PasswordSalt:       []byte("salty"),
+					PasswordHash:       []byte("pass"),
+					IsAdmin:            true,
+					Created:            time.Date(2015, 3, 30, 12, 0, 0, 0, time.UTC),
+				})).To(Succeed())
+				Expect(db.CommitTransaction()).To(BeNil())
+			})
+
+			It("retrieves the user if they exist", func() {
+				user, err := db.GetUserByEmail("test@testing.com")
+
+				Expect(err).To(BeNil())
+				Expect(user.Email).To(Equal("test@testing.com"))
+				Expect(user.PasswordIterations).To(Equal(12345))
+				Expect(user.PasswordSalt).To(Equal([]byte("salty")))
+				Expect(user.PasswordHash).To(Equal([]byte("pass")))
+				Expect(user.IsAdmin).To(Equal(true))
+				Expect(user.Created).To(BeTemporally("==", time.Date(2015, 3, 30, 12, 0, 0, 0, time.UTC)))
+			})
+
+			It("returns an error if they do not exist", func() {
+				user, err := db.GetUserByEmail("test@example.com")
+
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(HavePrefix("Cannot find user"))
+				Expect(user).To(Equal(User{}))
+			})
+		})
+
+		Describe("GetUserByID", func() {
+			It("retrieves the user if they exist", func() {
+				user, err := db.GetUserByID(3001)
+
+				Expect(err).To(BeNil())
+				Expect(user.UserID).To(Equal(3001))
+				Expect(user.Email).To(Equal("blah@blah.com"))
+				Expect(user.IsAdmin).To(Equal(false))
+				Expect(user.Created).To(BeTemporally("==", time.Date(2015, 3, 30, 1, 58, 0, 0, time.UTC)))
+			})
+
+			It("returns an error if they do not exist", func() {
+				user, err := db.GetUserByID(9001)
+
+				Expect(err).ToNot(BeNil())
+				Expect(user).To(Equal(User{}))
+			})
+		})
+
+		Describe("sessions", func() {
+			created := time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)
+			expires := time.Date(2015, 5, 31, 0, 0, 0, 0, time.UTC)
+			var session Session
+
+			BeforeEach(func() {
+				session = Session{UserID: 3001, TokenHash: []byte("tokenhash"), Created: created, Expires: expires}
+
+				Expect(db.BeginTransaction()).To(Succeed())
+				Expect(db.CreateSession(&session)).To(Succeed())
+				Expect(db.CommitTransaction()).To(Succeed())
+			})
+
+			It("saves new sessions to the database", func() {
+				Expect(session.SessionID).ToNot(Equal(0))
+			})
+
+			It("retrieves a session by the hash of its token", func() {
+				retrieved, err := db.GetSessionByTokenHash([]byte("tokenhash"))
+
+				Expect(err).To(BeNil())
+				Expect(retrieved.SessionID).To(Equal(session.SessionID))
+				Expect(retrieved.UserID).To(Equal(3001))
+				Expect(retrieved.TokenHash).To(Equal([]byte("tokenhash")))
+				Expect(retrieved.Created).To(BeTemporally("==", created))
+				Expect(retrieved.Expires).To(BeTemporally("==", expires))
+			})
+
+			It("returns an error if no session has the token hash", func() {
+				_, err := db.GetSessionByTokenHash([]byte("otherhash"))
+
+				Expect(err).ToNot(BeNil())
+			})
+
+			It("deletes sessions", func() {
+				Expect(db.BeginTransaction()).To(Succeed())
+				Expect(db.DeleteSession(session.SessionID)).To(Succeed())
+				Expect(db.CommitTransaction()).To(Succeed())
+
+				_, err := db.GetSessionByTokenHash([]byte("tokenhash"))
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
+		Describe("alert rules", func() {
+			created := time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)
+			var rule AlertRule
+
+			BeforeEach(func() {
+				rule = AlertRule{
+					AgentID:     1001,
+					VariableID:  2001,
+					Condition:   alertConditionBelow,
+					Threshold:   -1.5,
+					Hysteresis:  0.5,
+					MinDuration: 10 * time.Minute,
+					WebhookURL:  "http://example.com/hook",
+					Created:     created,
+					State:       alertStateOK,
+				}
+
+				Expect(db.BeginTransaction()).To(Succeed())
+				Expect(db.CreateAlertRule(&rule)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				db.RollbackUncommittedTransaction()
+			})
+
+			It("saves new rules to the database", func() {
+				Expect(rule.RuleID).ToNot(Equal(0))
+			})
+
+			It("retrieves the rules for an agent, with the name of the variable", func() {
+				rules, err := db.GetAlertRulesForAgent(1001)
+
+				Expect(err).To(BeNil())
+				Expect(rules).To(HaveLen(1))
+				Expect(rules[0].RuleID).To(Equal(rule.RuleID))
+				Expect(rules[0].Variable).To(Equal("distance"))
+				Expect(rules[0].Condition).To(Equal(alertConditionBelow))
+				Expect(rules[0].Threshold).To(Equal(-1.5))
+				Expect(rules[0].Hysteresis).To(Equal(0.5))
+				Expect(rules[0].MinDuration).To(Equal(10 * time.Minute))
+				Expect(rules[0].WebhookURL).To(Equal("http://example.com/hook"))
+				Expect(rules[0].Created).To(BeTemporally("==", created))
+				Expect(rules[0].State).To(Equal(alertStateOK))
+				Expect(rules[0].PendingSince).To(BeZero())
+				Expect(rules[0].LastTime).To(BeZero())
+
+				otherRules, err := db.GetAlertRulesForAgent(1002)
+
+				Expect(err).To(BeNil())
+				Expect(otherRules).To(BeEmpty())
+			})
+
+			It("saves the state of a rule", func() {
+				rule.State = alertStateFiring
+				rule.PendingSince = created.Add(time.Hour)
+				rule.LastValue = -2
+				rule.LastTime = created.Add(2 * time.Hour)
+
+				Expect(db.UpdateAlertRuleState(rule)).To(Succeed())
+
+				rules, err := db.GetAlertRulesForAgent(1001)
+
+				Expect(err).To(BeNil())
+				Expect(rules[0].State).To(Equal(alertStateFiring))
+				Expect(rules[0].PendingSince).To(BeTemporally("==", rule.PendingSince))
+				Expect(rules[0].LastValue).To(Equal(-2.0))
+				Expect(rules[0].LastTime).To(BeTemporally("==", rule.LastTime))
+			})
+
+			It("saves and retrieves the history of a rule, most recent first", func() {
+				first := AlertHistoryEntry{RuleID: rule.RuleID, Event: alertEventFiring, Value: -2, Time: created.Add(time.Hour), Created: created.Add(time.Hour)}
+				second := AlertHistoryEntry{RuleID: rule.RuleID, Event: alertEventResolved, Value: 0, Time: created.Add(2 * time.Hour), Created: created.Add(2 * time.Hour)}
+
+				Expect(db.AddAlertHistory(&first)).To(Succeed())
+				Expect(db.AddAlertHistory(&second)).To(Succeed())
+
+				history, err := db.GetAlertHistory(rule.RuleID)
+
+				Expect(err).To(BeNil())
+				Expect(history).To(HaveLen(2))
+				Expect(history[0].HistoryID).To(Equal(second.HistoryID))
+				Expect(history[0].Event).To(Equal(alertEventResolved))
+				Expect(history[0].Time).To(BeTemporally("==", second.Time))
+				Expect(history[1].HistoryID).To(Equal(first.HistoryID))
+				Expect(history[1].Value).To(Equal(-2.0))
+			})
+
+			It("deletes rules and their history", func() {
+				entry := AlertHistoryEntry{RuleID: rule.RuleID, Event: alertEventFiring, Value: -2, Time: created, Created: created}
+				Expect(db.AddAlertHistory(&entry)).To(Succeed())
+
+				Expect(db.DeleteAlertRule(rule.RuleID)).To(Succeed())
+
+				rules, err := db.GetAlertRulesForAgent(1001)
+				Expect(err).To(BeNil())
+				Expect(rules).To(BeEmpty())
+
+				history, err := db.GetAlertHistory(rule.RuleID)
+				Expect(err).To(BeNil())
+				Expect(history).To(BeEmpty())
+			})
+
+			It("returns an error when deleting a rule that does not exist", func() {
+				Expect(db.DeleteAlertRule(rule.RuleID + 1)).ToNot(Succeed())
+			})
 		})
 	})
-})
+}
+
+// describeSQLDatabaseBehaviour describes the behaviour Database implementations that are backed by a SQL database must
+// have, in addition to that described by describeDatabaseBehaviour.
+func describeSQLDatabaseBehaviour(backend databaseTestBackend) {
+	var db Database
+
+	BeforeEach(func() {
+		db = backend.database()
+	})
+
+	Describe("NewSession", func() {
+		It("returns a database that shares the same connection pool", func() {
+			session := db.NewSession()
+			Expect(session.DB()).To(BeIdenticalTo(db.DB()))
+		})
+
+		It("returns a database with its own transaction", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+
+			session := db.NewSession()
+			Expect(session.Transaction()).To(BeNil())
+			Expect(db.RollbackTransaction()).To(BeNil())
+
+			Expect(session.BeginTransaction()).To(BeNil())
+			Expect(db.Transaction()).To(BeNil())
+			Expect(session.RollbackTransaction()).To(BeNil())
+		})
+	})
+
+	Describe("BeginTransaction", func() {
+		It("sets Transaction", func() {
+			db.BeginTransaction()
+			defer db.RollbackTransaction()
+
+			Expect(db.Transaction()).ToNot(BeNil())
+		})
+	})
+
+	Describe("CommitTransaction", func() {
+		BeforeEach(func() {
+			ExpectSucceeded(db.DB().Exec("CREATE TABLE temp (name VARCHAR(100));"))
+			err := db.BeginTransaction()
+			Expect(err).To(BeNil())
+		})
+
+		It("sets Transaction to nil", func() {
+			db.CommitTransaction()
+			Expect(db.Transaction()).To(BeNil())
+		})
+
+		It("applies changes made to the database", func() {
+			ExpectSucceeded(db.Transaction().Exec("INSERT INTO temp (name) VALUES ('test');"))
+			var count int
+			err := db.Transaction().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(1))
+
+			err = db.CommitTransaction()
+			Expect(err).To(BeNil())
+
+			err = db.DB().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(1))
+		})
+	})
+
+	Describe("RollbackTransaction", func() {
+		BeforeEach(func() {
+			ExpectSucceeded(db.DB().Exec("CREATE TABLE temp (name VARCHAR(100));"))
+			err := db.BeginTransaction()
+			Expect(err).To(BeNil())
+		})
+
+		It("sets Transaction to nil", func() {
+			db.RollbackTransaction()
+			Expect(db.Transaction()).To(BeNil())
+		})
+
+		It("reverts changes made to the database", func() {
+			ExpectSucceeded(db.Transaction().Exec("INSERT INTO temp (name) VALUES ('test');"))
+			var count int
+			err := db.Transaction().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(1))
+
+			err = db.RollbackTransaction()
+			Expect(err).To(BeNil())
+
+			err = db.DB().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("RollbackUncommittedTransaction", func() {
+		It("sets Transaction to nil", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+
+			db.RollbackUncommittedTransaction()
+			Expect(db.Transaction()).To(BeNil())
+		})
+	})
+
+	Describe("SoftDeleteAgent", func() {
+		BeforeEach(func() {
+			db.RunMigrations()
+
+			backend.createTestData(db)
+		})
+
+		It("keeps the agent's row and data", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+			defer db.RollbackTransaction()
+
+			Expect(db.SoftDeleteAgent(1001, time.Now())).To(Succeed())
+
+			var count int
+			Expect(db.Transaction().QueryRow("SELECT COUNT(*) FROM agents WHERE agent_id = 1001 AND deleted IS NOT NULL;").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(1))
+			Expect(db.Transaction().QueryRow("SELECT COUNT(*) FROM data WHERE agent_id = 1001;").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(5))
+		})
+	})
+}
+
+func CreateTestData(db Database, timestamp func(t time.Time) interface{}) {
+	ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false, timestamp(time.Date(2015, 3, 30, 1, 58, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1001, "First agent", 3001, 12301, []byte("salt1001"), []byte("hash1001"), timestamp(time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1002, "Second agent", 3001, 12302, []byte("salt1002"), []byte("hash1002"), timestamp(time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2001, "distance", "metres", 2, timestamp(time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2002, "humidity", "%", 2, timestamp(time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2001, 100, timestamp(time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 101, timestamp(time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 103, timestamp(time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 104, timestamp(time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 105, timestamp(time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC))))
+	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1002, 2001, 102, timestamp(time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC))))
+}
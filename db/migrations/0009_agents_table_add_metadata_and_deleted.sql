@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE agents ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE agents ADD COLUMN deleted TIMESTAMP WITH TIME ZONE NULL;
+
+-- +migrate Down
+ALTER TABLE agents DROP COLUMN metadata;
+ALTER TABLE agents DROP COLUMN deleted;
@@ -0,0 +1,11 @@
+-- +migrate Up
+ALTER TABLE agents ADD COLUMN previous_token_iterations INT NOT NULL DEFAULT (0);
+ALTER TABLE agents ADD COLUMN previous_token_salt BYTEA NOT NULL DEFAULT '';
+ALTER TABLE agents ADD COLUMN previous_token_hash BYTEA NOT NULL DEFAULT '';
+ALTER TABLE agents ADD COLUMN previous_token_expires TIMESTAMP WITH TIME ZONE NULL;
+
+-- +migrate Down
+ALTER TABLE agents DROP COLUMN previous_token_iterations;
+ALTER TABLE agents DROP COLUMN previous_token_salt;
+ALTER TABLE agents DROP COLUMN previous_token_hash;
+ALTER TABLE agents DROP COLUMN previous_token_expires;
@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE sessions (
+  session_id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users (user_id),
+  token_hash BYTEA NOT NULL UNIQUE,
+  created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE sessions;
@@ -0,0 +1,33 @@
+-- +migrate Up
+CREATE TABLE alert_rules (
+  rule_id SERIAL PRIMARY KEY,
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  condition VARCHAR(20) NOT NULL,
+  threshold DOUBLE PRECISION NOT NULL,
+  hysteresis DOUBLE PRECISION NOT NULL DEFAULT 0,
+  min_duration BIGINT NOT NULL DEFAULT 0,
+  webhook_url TEXT NOT NULL,
+  created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  state VARCHAR(10) NOT NULL DEFAULT 'ok',
+  pending_since TIMESTAMP WITH TIME ZONE NULL,
+  last_value DOUBLE PRECISION NOT NULL DEFAULT 0,
+  last_time TIMESTAMP WITH TIME ZONE NULL
+);
+
+CREATE INDEX alert_rules_agent_id ON alert_rules (agent_id);
+
+CREATE TABLE alert_history (
+  history_id SERIAL PRIMARY KEY,
+  rule_id INT NOT NULL REFERENCES alert_rules (rule_id) ON DELETE CASCADE,
+  event VARCHAR(10) NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  time TIMESTAMP WITH TIME ZONE NOT NULL,
+  created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX alert_history_rule_id ON alert_history (rule_id, time);
+
+-- +migrate Down
+DROP TABLE alert_history;
+DROP TABLE alert_rules;
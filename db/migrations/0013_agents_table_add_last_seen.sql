@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE agents ADD COLUMN last_seen TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE agents ADD COLUMN offline_after BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE agents ADD COLUMN offline_since TIMESTAMP WITH TIME ZONE NULL;
+
+-- +migrate Down
+ALTER TABLE agents DROP COLUMN last_seen;
+ALTER TABLE agents DROP COLUMN offline_after;
+ALTER TABLE agents DROP COLUMN offline_since;
@@ -0,0 +1,13 @@
+-- +migrate Up
+ALTER TABLE variables ADD COLUMN min_value DOUBLE PRECISION NULL;
+ALTER TABLE variables ADD COLUMN max_value DOUBLE PRECISION NULL;
+ALTER TABLE variables ADD COLUMN max_step DOUBLE PRECISION NULL;
+ALTER TABLE variables ADD COLUMN out_of_range VARCHAR(20) NOT NULL DEFAULT 'reject';
+ALTER TABLE data ADD COLUMN quality VARCHAR(20) NOT NULL DEFAULT 'good';
+
+-- +migrate Down
+ALTER TABLE variables DROP COLUMN min_value;
+ALTER TABLE variables DROP COLUMN max_value;
+ALTER TABLE variables DROP COLUMN max_step;
+ALTER TABLE variables DROP COLUMN out_of_range;
+ALTER TABLE data DROP COLUMN quality;
@@ -0,0 +1,18 @@
+-- +migrate Up
+CREATE TABLE data_corrections (
+  correction_id SERIAL PRIMARY KEY,
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  time TIMESTAMP WITH TIME ZONE NOT NULL,
+  original_value DOUBLE PRECISION NOT NULL,
+  original_quality VARCHAR(20) NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  quality VARCHAR(20) NOT NULL,
+  user_id INT NOT NULL REFERENCES users (user_id),
+  created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX data_corrections_agent_id ON data_corrections (agent_id, created);
+
+-- +migrate Down
+DROP TABLE data_corrections;
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE data ALTER COLUMN value TYPE DOUBLE PRECISION;
+
+-- +migrate Down
+-- This fails if any values have been saved that do not fit in the old column.
+ALTER TABLE data ALTER COLUMN value TYPE NUMERIC(10, 4);
@@ -0,0 +1,29 @@
+-- +migrate Up
+ALTER TABLE variables ADD COLUMN raw_data_days INT NULL;
+
+CREATE TABLE data_hourly (
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  time TIMESTAMP WITH TIME ZONE NOT NULL,
+  min_value DOUBLE PRECISION NOT NULL,
+  max_value DOUBLE PRECISION NOT NULL,
+  avg_value DOUBLE PRECISION NOT NULL,
+  count INT NOT NULL,
+  PRIMARY KEY (agent_id, variable_id, time)
+);
+
+CREATE TABLE data_daily (
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  time TIMESTAMP WITH TIME ZONE NOT NULL,
+  min_value DOUBLE PRECISION NOT NULL,
+  max_value DOUBLE PRECISION NOT NULL,
+  avg_value DOUBLE PRECISION NOT NULL,
+  count INT NOT NULL,
+  PRIMARY KEY (agent_id, variable_id, time)
+);
+
+-- +migrate Down
+DROP TABLE data_daily;
+DROP TABLE data_hourly;
+ALTER TABLE variables DROP COLUMN raw_data_days;
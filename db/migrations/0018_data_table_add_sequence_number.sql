@@ -0,0 +1,11 @@
+-- +migrate Up
+-- Existing points are left without a sequence number, which only means that they are never replayed to streams.
+CREATE SEQUENCE data_sequence_number;
+ALTER TABLE data ADD COLUMN sequence_number BIGINT NULL;
+ALTER TABLE data ALTER COLUMN sequence_number SET DEFAULT nextval('data_sequence_number');
+CREATE INDEX data_agent_id_sequence_number ON data (agent_id, sequence_number);
+
+-- +migrate Down
+DROP INDEX data_agent_id_sequence_number;
+ALTER TABLE data DROP COLUMN sequence_number;
+DROP SEQUENCE data_sequence_number;
@@ -0,0 +1,112 @@
+-- +migrate Up
+CREATE TABLE users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  email VARCHAR(254) NOT NULL UNIQUE,
+  password_iterations INT NOT NULL,
+  password_salt BLOB NOT NULL,
+  password_hash BLOB NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT 0,
+  created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE agents (
+  agent_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(100) NOT NULL,
+  owner_user_id INT NOT NULL REFERENCES users (user_id),
+  token_iterations INT NOT NULL,
+  token_salt BLOB NOT NULL,
+  token_hash BLOB NOT NULL,
+  previous_token_iterations INT NOT NULL DEFAULT 0,
+  previous_token_salt BLOB NOT NULL DEFAULT X'',
+  previous_token_hash BLOB NOT NULL DEFAULT X'',
+  previous_token_expires TIMESTAMP NULL,
+  metadata TEXT NOT NULL DEFAULT '{}',
+  created TIMESTAMP NOT NULL,
+  deleted TIMESTAMP NULL,
+  last_seen TIMESTAMP NULL,
+  offline_after BIGINT NOT NULL DEFAULT 0,
+  offline_since TIMESTAMP NULL
+);
+
+CREATE TABLE variables (
+  variable_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(100) NOT NULL UNIQUE,
+  units VARCHAR(20) NOT NULL,
+  display_decimal_places INT NOT NULL,
+  created TIMESTAMP NOT NULL,
+  min_value DOUBLE PRECISION NULL,
+  max_value DOUBLE PRECISION NULL,
+  max_step DOUBLE PRECISION NULL,
+  out_of_range VARCHAR(20) NOT NULL DEFAULT 'reject'
+);
+
+CREATE TABLE data (
+  agent_id INT NOT NULL REFERENCES agents (agent_id),
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  time TIMESTAMP NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  quality VARCHAR(20) NOT NULL DEFAULT 'good',
+  PRIMARY KEY (agent_id, variable_id, time)
+);
+
+CREATE TABLE sessions (
+  session_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INT NOT NULL REFERENCES users (user_id),
+  token_hash BLOB NOT NULL UNIQUE,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL
+);
+
+CREATE TABLE alert_rules (
+  rule_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  condition VARCHAR(20) NOT NULL,
+  threshold DOUBLE PRECISION NOT NULL,
+  hysteresis DOUBLE PRECISION NOT NULL DEFAULT 0,
+  min_duration BIGINT NOT NULL DEFAULT 0,
+  webhook_url TEXT NOT NULL,
+  created TIMESTAMP NOT NULL,
+  state VARCHAR(10) NOT NULL DEFAULT 'ok',
+  pending_since TIMESTAMP NULL,
+  last_value DOUBLE PRECISION NOT NULL DEFAULT 0,
+  last_time TIMESTAMP NULL
+);
+
+CREATE INDEX alert_rules_agent_id ON alert_rules (agent_id);
+
+CREATE TABLE alert_history (
+  history_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rule_id INT NOT NULL REFERENCES alert_rules (rule_id) ON DELETE CASCADE,
+  event VARCHAR(10) NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  time TIMESTAMP NOT NULL,
+  created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX alert_history_rule_id ON alert_history (rule_id, time);
+
+CREATE TABLE data_corrections (
+  correction_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  agent_id INT NOT NULL REFERENCES agents (agent_id) ON DELETE CASCADE,
+  variable_id INT NOT NULL REFERENCES variables (variable_id),
+  time TIMESTAMP NOT NULL,
+  original_value DOUBLE PRECISION NOT NULL,
+  original_quality VARCHAR(20) NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  quality VARCHAR(20) NOT NULL,
+  user_id INT NOT NULL REFERENCES users (user_id),
+  created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX data_corrections_agent_id ON data_corrections (agent_id, created);
+
+-- +migrate Down
+DROP TABLE data_corrections;
+DROP TABLE alert_history;
+DROP TABLE alert_rules;
+DROP TABLE sessions;
+DROP TABLE data;
+DROP TABLE variables;
+DROP TABLE agents;
+DROP TABLE users;
@@ -0,0 +1,15 @@
+-- +migrate Up
+-- Existing points are left without a sequence number, which only means that they are never replayed to streams.
+ALTER TABLE data ADD COLUMN sequence_number INTEGER NULL;
+CREATE INDEX data_agent_id_sequence_number ON data (agent_id, sequence_number);
+
+CREATE TABLE data_sequence_number (
+  last_value INTEGER NOT NULL
+);
+
+INSERT INTO data_sequence_number (last_value) VALUES (0);
+
+-- +migrate Down
+DROP TABLE data_sequence_number;
+DROP INDEX data_agent_id_sequence_number;
+ALTER TABLE data DROP COLUMN sequence_number;
@@ -17,8 +17,56 @@ import (
 const ShutdownTimeout = 2 * time.Second
 
 var server *graceful.Server
+var dataHub *DataHub
+var alertNotifier *AlertNotifier
+var serverStopped chan struct{}
 
 func startServer(config Config) {
+	serverStopped = make(chan struct{})
+	defer close(serverStopped)
+
+	db, err := connectToDatabase(config.DataSourceName)
+
+	if err != nil {
+		logrus.WithError(err).Error("Could not connect to database.")
+		return
+	}
+
+	defer db.Close()
+	configureConnectionPool(db.DB(), config)
+
+	dataHub = NewDataHub()
+	defer dataHub.Close()
+
+	allowedNetworks, err := parseNetworks(config.WebhookAllowedNetworks)
+
+	if err != nil {
+		logrus.WithError(err).Error("Could not parse allowed webhook networks.")
+		return
+	}
+
+	alertNotifier = NewAlertNotifier(allowedNetworks)
+	defer alertNotifier.Close()
+
+	if config.MQTTUsername != "" && config.MQTTPassword == "" {
+		logrus.Warn("MQTT username is set without a password, so the broker will not accept the bridge's credentials.")
+	}
+
+	if config.MQTTBrokerURL != "" {
+		bridge := NewMQTTBridge(config, db, dataHub, alertNotifier)
+		defer bridge.Close()
+	}
+
+	if config.AgentStatusInterval > 0 {
+		checker := NewAgentStatusChecker(db.NewSession(), config.AgentStatusInterval, notifyAgentStatus(config, alertNotifier))
+		defer checker.Close()
+	}
+
+	if config.RollupInterval > 0 {
+		job := NewDataRetentionJob(db.NewSession(), config.RollupInterval, config.RawDataRetentionDays)
+		defer job.Close()
+	}
+
 	m := martini.New()
 	m.Use(Log())
 	m.Use(martini.Recovery())
@@ -31,26 +79,57 @@ func startServer(config Config) {
 
 		r.Group("", func(g martini.Router) {
 			r.Group("", func(g martini.Router) {
+				g.Get("/agents", getAllAgents)
 				g.Post("/agents", binding.Bind(Agent{}), postAgent)
 				g.Get("/agents/:agent_id", getAgent)
+				g.Patch("/agents/:agent_id", binding.Bind(PatchAgent{}), patchAgent)
+				g.Delete("/agents/:agent_id", deleteAgent)
+				g.Post("/agents/:agent_id/token", rotateAgentToken)
 				g.Get("/agents/:agent_id/data", getData)
+				g.Patch("/agents/:agent_id/data", binding.Bind(PatchDataPoint{}), patchDataPoint)
+				g.Get("/agents/:agent_id/data/corrections", getDataCorrections)
+				g.Get("/agents/:agent_id/latest", getLatestData)
+				g.Get("/agents/:agent_id/stream", getDataStream)
+				g.Get("/agents/:agent_id/alerts", getAlertRules)
+				g.Post("/agents/:agent_id/alerts", binding.Bind(PostAlertRule{}), postAlertRule)
+				g.Delete("/agents/:agent_id/alerts/:rule_id", deleteAlertRule)
+				g.Get("/agents/:agent_id/alerts/:rule_id/history", getAlertHistory)
+				g.Get("/socket", getDataSocket)
 
 				g.Post("/variables", requireAdminUser, binding.Bind(Variable{}), postVariable)
+				g.Put("/variables/:variable_id/limits", requireAdminUser, binding.Bind(VariableLimits{}), putVariableLimits)
+				g.Put("/variables/:variable_id/retention", requireAdminUser, binding.Bind(VariableRetention{}), putVariableRetention)
+
+				g.Delete("/sessions/current", deleteCurrentSession)
 			}, withAuthenticatedUser)
 
 			g.Post("/agents/:agent_id/data", withAuthenticatedAgent, binding.Bind(PostDataPoints{}), postDataPoints)
+			g.Post("/agents/:agent_id/write", withAuthenticatedAgent, postLineProtocolData)
+
+			// The MQTT broker checks agents' credentials and access to topics with these.
+			g.Post("/mqtt/auth/user", binding.Bind(MQTTAuthRequest{}), authenticateMQTTUser)
+			g.Post("/mqtt/auth/superuser", binding.Bind(MQTTAuthRequest{}), checkMQTTSuperuser)
+			g.Post("/mqtt/auth/acl", binding.Bind(MQTTAuthRequest{}), checkMQTTAccess)
 
-			g.Get("/agents", getAllAgents)
 			g.Post("/users", binding.Bind(PostUser{}), postUser)
-		}, withDatabaseConnection)
+			g.Post("/sessions", binding.Bind(PostSession{}), postSession)
+		}, withDatabaseConnection(db))
 	})
 
+	// Consumer weather stations can only upload to these fixed paths.
+	r.Group("", func(g martini.Router) {
+		g.Get("/weatherstation/updateweatherstation.php", withWeatherStationAgent, uploadWeatherStationData)
+		g.Post("/data/report", withWeatherStationAgent, uploadWeatherStationData)
+	}, withDatabaseConnection(db))
+
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 
 	r.NotFound(strict.MethodNotAllowed, strict.NotFound)
 
 	m.Map(config)
+	m.Map(dataHub)
+	m.Map(alertNotifier)
 
 	server = &graceful.Server{
 		Timeout: ShutdownTimeout,
@@ -64,21 +143,31 @@ func startServer(config Config) {
 	}
 }
 
-func withDatabaseConnection(config Config, context martini.Context, r render.Render, log *logrus.Entry) {
-	db, err := connectToDatabase(config.DataSourceName)
+// withDatabaseConnection gives each request its own session on the shared connection pool, so that requests
+// do not share transaction state.
+func withDatabaseConnection(pool Database) martini.Handler {
+	return func(context martini.Context) {
+		db := pool.NewSession()
+		defer db.RollbackUncommittedTransaction()
 
-	if err != nil {
-		log.WithError(err).Error("Could not connect to database.")
-		r.Error(http.StatusInternalServerError)
-		return
+		context.Map(db)
+		context.Next()
 	}
+}
 
-	defer db.Close()
+func notifyAgentStatus(config Config, notifier *AlertNotifier) func(AgentStatusEvent) {
+	return func(event AgentStatusEvent) {
+		logrus.WithFields(logrus.Fields{"agentId": event.AgentID, "status": event.Status}).Warnf("Agent '%s' is %s.", event.Name, event.Status)
 
-	context.Map(db)
-	context.Next()
+		if config.AgentStatusWebhookURL != "" {
+			notifier.NotifyAgentStatus(config.AgentStatusWebhookURL, event)
+		}
+	}
 }
 
 func stopServer() {
+	// Streaming responses never finish by themselves, so end them before waiting for requests to finish.
+	dataHub.Close()
 	server.Stop(ShutdownTimeout)
+	<-serverStopped
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"time"
 
@@ -28,7 +31,9 @@ func haveJSONContentType() types.GomegaMatcher {
 }
 
 var _ = Describe("HTTP endpoints", func() {
-	var testDataSourceName string
+	// The server connects to the same in-memory database, so the tests can see and set up what it stores.
+	const testDataSourceName string = "memory:http-test"
+
 	var db Database
 	var testUser User
 	var adminUser User
@@ -36,16 +41,13 @@ var _ = Describe("HTTP endpoints", func() {
 	const adminUserPassword string = "AdminPassword123"
 
 	BeforeEach(func() {
-		testDataSourceName = getTestDataSourceName()
-		removeTestDatabase(testDataSourceName, true)
-
 		var err error
 		db, err = connectToDatabase(testDataSourceName)
 		Expect(err).To(BeNil())
 		_, err = db.RunMigrations()
 		Expect(err).To(BeNil())
 
-		go startServer(Config{ServerAddress: TestingAddress, DataSourceName: testDataSourceName})
+		go startServer(Config{ServerAddress: TestingAddress, DataSourceName: testDataSourceName, WebhookAllowedNetworks: "127.0.0.0/8"})
 
 		testUser = User{
 			Email:   "validuser@testing.com",
@@ -70,11 +72,30 @@ var _ = Describe("HTTP endpoints", func() {
 	})
 
 	AfterEach(func() {
-		db.Close()
 		stopServer()
-		removeTestDatabase(testDataSourceName, false)
+		db.Close()
 	})
 
+	// getAgent returns the agent with the given ID as it is stored in the database.
+	getAgent := func(agentID int) (Agent, error) {
+		Expect(db.BeginTransaction()).To(Succeed())
+		defer db.RollbackTransaction()
+
+		return db.GetAgentByID(agentID)
+	}
+
+	// getData returns every data point stored for the agent and variables, ordered by time and then variable.
+	getData := func(agentID int, variableIDs ...int) []DataPoint {
+		points := []DataPoint{}
+
+		Expect(db.StreamData(agentID, variableIDs, time.Time{}, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), true, func(point DataPoint) error {
+			points = append(points, point)
+			return nil
+		})).To(Succeed())
+
+		return points
+	}
+
 	doRequestWithAuthentication := func(request *http.Request, username string, password string) *http.Response {
 		request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
 
@@ -122,6 +143,61 @@ var _ = Describe("HTTP endpoints", func() {
 		return doRequestWithAuthentication(request, testUser.Email, testUserPassword)
 	}
 
+	patchWithAuthentication := func(url string, contentType string, body io.Reader) *http.Response {
+		request, err := http.NewRequest("PATCH", url, body)
+		Expect(err).To(BeNil())
+
+		request.Header.Set("Content-Type", contentType)
+
+		return doRequestWithAuthentication(request, testUser.Email, testUserPassword)
+	}
+
+	deleteWithAuthentication := func(url string) *http.Response {
+		request, err := http.NewRequest("DELETE", url, nil)
+		Expect(err).To(BeNil())
+
+		return doRequestWithAuthentication(request, testUser.Email, testUserPassword)
+	}
+
+	Describe("/v1/sessions", func() {
+		createSession := func() string {
+			resp, err := http.Post(urlFor("/v1/sessions"), "application/json", strings.NewReader(`{"email":"`+testUser.Email+`","password":"`+testUserPassword+`"}`))
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			var response map[string]interface{}
+			Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+			Expect(response).To(HaveKey("expires"))
+
+			return response["token"].(string)
+		}
+
+		doRequestWithSession := func(method string, url string, token string) *http.Response {
+			request, err := http.NewRequest(method, url, nil)
+			Expect(err).To(BeNil())
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := http.DefaultClient.Do(request)
+			Expect(err).To(BeNil())
+
+			return resp
+		}
+
+		It("returns HTTP 401 if the password is incorrect", func() {
+			resp, err := http.Post(urlFor("/v1/sessions"), "application/json", strings.NewReader(`{"email":"`+testUser.Email+`","password":"wrong"}`))
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("issues a token that can be used to authenticate until it is revoked", func() {
+			token := createSession()
+
+			Expect(doRequestWithSession("GET", urlFor("/v1/agents"), token).StatusCode).To(Equal(http.StatusOK))
+			Expect(doRequestWithSession("DELETE", urlFor("/v1/sessions/current"), token).StatusCode).To(Equal(http.StatusNoContent))
+			Expect(doRequestWithSession("GET", urlFor("/v1/agents"), token).StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
 	Describe("/v1/ping", func() {
 		Context("GET", func() {
 			It("responds with 'pong'", func() {
@@ -158,28 +234,34 @@ var _ = Describe("HTTP endpoints", func() {
 				Expect(response).To(HaveKey("id"))
 				Expect(response).To(HaveKey("token"))
 
-				id := int(response["id"].(float64))
-				var name string
-				var ownerUserId int
-				var created time.Time
-
-				err = db.DB().QueryRow("SELECT name, owner_user_id, created FROM agents WHERE agent_id = $1;", id).Scan(&name, &ownerUserId, &created)
+				agent, err := getAgent(int(response["id"].(float64)))
 				Expect(err).To(BeNil())
-				Expect(name).To(Equal("New agent name"))
-				Expect(ownerUserId).To(Equal(testUser.UserID))
-				Expect(created).To(BeTemporally("~", time.Now(), 1000*time.Millisecond))
+				Expect(agent.Name).To(Equal("New agent name"))
+				Expect(agent.OwnerUserID).To(Equal(testUser.UserID))
+				Expect(agent.Created).To(BeTemporally("~", time.Now(), 1000*time.Millisecond))
 			})
 		})
 
 		Context("GET", func() {
-			It("returns all agents", func() {
-				ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin) VALUES ($1, $2, $3, $4, $5, $6)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7);", 1, "Test Agent 1", 3001, 0, []byte{}, []byte{}, "2015-03-30 12:00:00+10:00"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7);", 2, "Test Agent 2", 3001, 0, []byte{}, []byte{}, "2015-02-17 08:00:00+12:00"))
+			BeforeEach(func() {
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1, Name: "Test Agent 1", OwnerUserID: testUser.UserID, Created: time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)},
+					Agent{AgentID: 2, Name: "Test Agent 2", OwnerUserID: testUser.UserID, Created: time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC)},
+					Agent{AgentID: 3, Name: "Other user's agent", OwnerUserID: 3001, Created: time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC)},
+				)
+			})
 
+			It("returns HTTP 401 when not authenticated", func() {
 				resp, err := http.Get(urlFor("/v1/agents"))
 
 				Expect(err).To(BeNil())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("returns all agents owned by the user", func() {
+				resp := getWithAuthentication(urlFor("/v1/agents"))
+
 				Expect(resp.StatusCode).To(Equal(http.StatusOK))
 				Expect(resp.Header).To(haveJSONContentType())
 
@@ -193,25 +275,54 @@ var _ = Describe("HTTP endpoints", func() {
 				Expect(response).To(HaveLen(2))
 				Expect(response[0]).To(HaveKeyWithValue("id", float64(1)))
 				Expect(response[0]).To(HaveKeyWithValue("name", "Test Agent 1"))
-				Expect(response[0]).To(HaveKeyWithValue("ownerUserId", float64(3001)))
+				Expect(response[0]).To(HaveKeyWithValue("ownerUserId", float64(testUser.UserID)))
 				Expect(response[0]).To(HaveKeyWithValue("created", BeParsableAndEqualTo(time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC))))
 				Expect(response[1]).To(HaveKeyWithValue("id", float64(2)))
 				Expect(response[1]).To(HaveKeyWithValue("name", "Test Agent 2"))
-				Expect(response[1]).To(HaveKeyWithValue("ownerUserId", float64(3001)))
+				Expect(response[1]).To(HaveKeyWithValue("ownerUserId", float64(testUser.UserID)))
 				Expect(response[1]).To(HaveKeyWithValue("created", BeParsableAndEqualTo(time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC))))
 			})
+
+			It("filters and pages the agents owned by the user", func() {
+				resp := getWithAuthentication(urlFor("/v1/agents?name=agent&limit=1&offset=1"))
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+				Expect(response).To(HaveLen(1))
+				Expect(response[0]).To(HaveKeyWithValue("id", float64(2)))
+			})
+
+			It("returns HTTP 403 when a user that is not an administrator asks for all agents", func() {
+				resp := getWithAuthentication(urlFor("/v1/agents?all=true"))
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+
+			It("returns all agents when an administrator asks for all agents", func() {
+				request, err := http.NewRequest("GET", urlFor("/v1/agents?all=true"), nil)
+				Expect(err).To(BeNil())
+
+				resp := doRequestWithAuthentication(request, adminUser.Email, adminUserPassword)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var response []map[string]interface{}
+				Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+				Expect(response).To(HaveLen(3))
+			})
 		})
 	})
 
 	Describe("/v1/agents/:agent_id", func() {
 		Context("GET", func() {
 			BeforeEach(func() {
-				ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin) VALUES ($1, $2, $3, $4, $5, $6)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1001, "First agent", testUser.UserID, 0, []byte{}, []byte{}, "2015-04-05T03:00:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1002, "Other agent", 3001, 0, []byte{}, []byte{}, "2015-04-05T03:00:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2001, "distance", "metres", 1, "2015-04-07T15:00:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2002, "humidity", "%", 1, "2015-04-07T15:00:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2001, 100, "2015-04-07T15:00:00Z"))
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1001, Name: "First agent", OwnerUserID: testUser.UserID, Created: time.Date(2015, 4, 5, 3, 0, 0, 0, time.UTC)},
+					Agent{AgentID: 1002, Name: "Other agent", OwnerUserID: 3001, Created: time.Date(2015, 4, 5, 3, 0, 0, 0, time.UTC)},
+					Variable{VariableID: 2001, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1, Created: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					Variable{VariableID: 2002, Name: "humidity", Units: "%", DisplayDecimalPlaces: 1, Created: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1001, VariableID: 2001, Value: 100, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+				)
 			})
 
 			Context("when not authenticated", func() {
@@ -261,6 +372,101 @@ var _ = Describe("HTTP endpoints", func() {
 				})
 			})
 		})
+
+		Context("PATCH and DELETE", func() {
+			BeforeEach(func() {
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1001, Name: "First agent", OwnerUserID: testUser.UserID, Created: time.Date(2015, 4, 5, 3, 0, 0, 0, time.UTC)},
+					Agent{AgentID: 1002, Name: "Other agent", OwnerUserID: 3001, Created: time.Date(2015, 4, 5, 3, 0, 0, 0, time.UTC)},
+					Variable{VariableID: 2001, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1, Created: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1001, VariableID: 2001, Value: 100, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+				)
+			})
+
+			It("renames the agent and sets its metadata", func() {
+				resp := patchWithAuthentication(urlFor("/v1/agents/1001"), "application/json", strings.NewReader(`{"name":"Renamed agent","metadata":{"location":"Roof"}}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				agent, err := getAgent(1001)
+				Expect(err).To(BeNil())
+				Expect(agent.Name).To(Equal("Renamed agent"))
+				Expect(agent.Metadata).To(Equal(map[string]string{"location": "Roof"}))
+			})
+
+			It("returns HTTP 403 when patching an agent owned by another user", func() {
+				resp := patchWithAuthentication(urlFor("/v1/agents/1002"), "application/json", strings.NewReader(`{"name":"Renamed agent"}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+
+			It("hides a soft-deleted agent but keeps its data", func() {
+				resp := deleteWithAuthentication(urlFor("/v1/agents/1001"))
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+				resp = getWithAuthentication(urlFor("/v1/agents/1001"))
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+				Expect(getData(1001, 2001)).To(HaveLen(1))
+			})
+
+			It("removes the agent and its data when deleting with the cascade mode", func() {
+				resp := deleteWithAuthentication(urlFor("/v1/agents/1001?mode=cascade"))
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+				Expect(getData(1001, 2001)).To(BeEmpty())
+
+				_, err := getAgent(1001)
+				Expect(err).ToNot(BeNil())
+			})
+
+			It("returns HTTP 403 when deleting an agent owned by another user", func() {
+				resp := deleteWithAuthentication(urlFor("/v1/agents/1002"))
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+	})
+
+	Describe("/v1/agents/:agent_id/token", func() {
+		Context("POST", func() {
+			BeforeEach(func() {
+				agent := Agent{}
+				agent.SetToken("oldtoken")
+
+				insertFixtures(db,
+					Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: testUser.UserID, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+					Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+				)
+			})
+
+			postDataWithToken := func(token string) *http.Response {
+				return postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","onConflict":"ignore","data":[{"variable":"distance","value":10.5}]}`), token)
+			}
+
+			rotateToken := func(query string) string {
+				resp := postWithAuthentication(urlFor("/v1/agents/1004/token"+query), "application/json", nil)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+				Expect(response).To(HaveKey("token"))
+
+				return response["token"].(string)
+			}
+
+			It("issues a new token and invalidates the old token", func() {
+				newToken := rotateToken("")
+
+				Expect(postDataWithToken(newToken).StatusCode).To(Equal(http.StatusCreated))
+				Expect(postDataWithToken("oldtoken").StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("accepts both tokens during the grace period", func() {
+				newToken := rotateToken("?grace_period=1h")
+
+				Expect(postDataWithToken(newToken).StatusCode).To(Equal(http.StatusCreated))
+				Expect(postDataWithToken("oldtoken").StatusCode).To(Equal(http.StatusCreated))
+			})
+		})
 	})
 
 	Describe("/v1/agents/:agent_id/data", func() {
@@ -269,41 +475,105 @@ var _ = Describe("HTTP endpoints", func() {
 				agent := Agent{}
 				agent.SetToken("agent1token")
 
-				ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin) VALUES ($1, $2, $3, $4, $5, $6)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, token_iterations, token_salt, token_hash, owner_user_id) VALUES ($1, $2, $3, $4, $5, $6);", 1004, "Test Agent 1", agent.TokenIterations, agent.TokenSalt, agent.TokenHash, 3001))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places) VALUES ($1, $2, $3, $4);", 1005, "distance", "metres", 1))
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: 3001, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+					Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+				)
 			})
 
 			It("saves the data to the database", func() {
 				resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","data":[{"variable":"distance","value":10.5}]}`), "agent1token")
 				Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+				Expect(resp.Header).To(haveJSONContentType())
 
 				responseBytes, err := ioutil.ReadAll(resp.Body)
 				Expect(err).To(BeNil())
-				Expect(string(responseBytes)).To(Equal(""))
+				Expect(string(responseBytes)).To(MatchJSON(`{"results":[{"variable":"distance","time":"2015-05-06T10:15:30Z","status":"created"}]}`))
+
+				data := getData(1004, 1005)
+				Expect(data).To(HaveLen(1))
+				Expect(data[0].AgentID).To(Equal(1004))
+				Expect(data[0].VariableID).To(Equal(1005))
+				Expect(data[0].Value).To(Equal(10.5))
+				Expect(data[0].Time).To(BeTemporally("==", time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)))
+			})
+
+			It("saves a batch of data points with their own times to the database", func() {
+				resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"data":[`+
+					`{"variable":"distance","value":10.5,"time":"2015-05-06T10:15:30Z"},`+
+					`{"variable":"distance","value":11.5,"time":"2015-05-06T10:20:30Z"}`+
+					`]}`), "agent1token")
+				Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+				Expect(getData(1004, 1005)).To(HaveLen(2))
+			})
+
+			Context("when the data has already been saved", func() {
+				BeforeEach(func() {
+					insertFixtures(db,
+						DataPoint{AgentID: 1004, VariableID: 1005, Value: 10.5, Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)},
+					)
+				})
+
+				It("returns HTTP 409 with the conflicting data points if no conflict policy is given", func() {
+					resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","data":[{"variable":"distance","value":12.5}]}`), "agent1token")
+					Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+
+					responseBytes, err := ioutil.ReadAll(resp.Body)
+					Expect(err).To(BeNil())
+					Expect(string(responseBytes)).To(MatchJSON(`{"results":[{"variable":"distance","time":"2015-05-06T10:15:30Z","status":"conflict","message":"There is already a value for this variable at this time."}]}`))
+				})
 
-				var agentID, variableID int
-				var value float64
-				var actualTime time.Time
-				err = db.DB().QueryRow("SELECT agent_id, variable_id, value, time FROM data;").Scan(&agentID, &variableID, &value, &actualTime)
+				It("returns HTTP 201 and keeps the existing value if the conflict policy is 'ignore'", func() {
+					resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","onConflict":"ignore","data":[{"variable":"distance","value":12.5}]}`), "agent1token")
+					Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+					data := getData(1004, 1005)
+					Expect(data).To(HaveLen(1))
+					Expect(data[0].Value).To(Equal(10.5))
+				})
+
+				It("returns HTTP 201 and replaces the existing value if the conflict policy is 'overwrite'", func() {
+					resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","onConflict":"overwrite","data":[{"variable":"distance","value":12.5}]}`), "agent1token")
+					Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+					data := getData(1004, 1005)
+					Expect(data).To(HaveLen(1))
+					Expect(data[0].Value).To(Equal(12.5))
+				})
+			})
+
+			It("does not save any of the data points if one of them is invalid", func() {
+				resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","data":[`+
+					`{"variable":"distance","value":10.5},`+
+					`{"variable":"nothing","value":11.5}`+
+					`]}`), "agent1token")
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+				responseBytes, err := ioutil.ReadAll(resp.Body)
 				Expect(err).To(BeNil())
-				Expect(agentID).To(Equal(1004))
-				Expect(variableID).To(Equal(1005))
-				Expect(value).To(Equal(10.5))
-				Expect(actualTime).To(BeTemporally("==", time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)))
+				Expect(string(responseBytes)).To(MatchJSON(`{"results":[` +
+					`{"variable":"distance","time":"2015-05-06T10:15:30Z","status":"notSaved"},` +
+					`{"variable":"nothing","time":"2015-05-06T10:15:30Z","status":"unknownVariable","message":"Could not find variable with name 'nothing'."}` +
+					`]}`))
+
+				Expect(getData(1004, 1005)).To(BeEmpty())
 			})
 		})
 
 		Context("GET", func() {
 			BeforeEach(func() {
-				ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin) VALUES ($1, $2, $3, $4, $5, $6)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, token_iterations, token_salt, token_hash, owner_user_id) VALUES ($1, $2, $3, $4, $5, $6);", 1004, "Test Agent 1", 0, []byte{}, []byte{}, testUser.UserID))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, token_iterations, token_salt, token_hash, owner_user_id) VALUES ($1, $2, $3, $4, $5, $6);", 1005, "Test Agent 2", 0, []byte{}, []byte{}, 3001))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places) VALUES ($1, $2, $3, $4);", 1005, "distance", "metres", 1))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1004, 1005, 103, "2015-04-07T15:00:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1004, 1005, 104, "2015-04-07T15:01:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1004, 1005, 105, "2015-04-07T15:02:00Z"))
-				ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1004, 1005, 106, "2015-04-07T15:03:00Z"))
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: testUser.UserID},
+					Agent{AgentID: 1005, Name: "Test Agent 2", OwnerUserID: 3001},
+					Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 103, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 104, Time: time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 105, Time: time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 106, Time: time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC)},
+				)
 			})
 
 			Context("when not authenticated", func() {
@@ -335,6 +605,273 @@ var _ = Describe("HTTP endpoints", func() {
 						`{"id":1005,"name":"distance","units":"metres","displayDecimalPlaces":1,"points":{"2015-04-07T15:01:00Z":104,"2015-04-07T15:02:00Z":105}}` +
 						`]}`))
 				})
+
+				It("retrieves the data from the database as CSV", func() {
+					resp := getWithAuthentication(urlFor("/v1/agents/1004/data?variable=1005&date_from=2015-04-07T15:00:30Z&date_to=2015-04-07T15:02:30Z&format=csv"))
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Header).To(HaveKeyWithValue("Content-Type", []string{"text/csv; charset=utf-8"}))
+
+					responseBytes, err := ioutil.ReadAll(resp.Body)
+					Expect(err).To(BeNil())
+					Expect(string(responseBytes)).To(Equal("time,distance (metres)\n" +
+						"2015-04-07T15:01:00Z,104.0\n" +
+						"2015-04-07T15:02:00Z,105.0\n"))
+				})
+			})
+		})
+	})
+
+	Describe("/v1/agents/:agent_id/write", func() {
+		BeforeEach(func() {
+			agent := Agent{}
+			agent.SetToken("agent1token")
+
+			insertFixtures(db,
+				User{UserID: 3001, Email: "blah@blah.com"},
+				Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: 3001, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+				Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+				Variable{VariableID: 1006, Name: "humidity", Units: "%", DisplayDecimalPlaces: 0},
+				DataPoint{AgentID: 1004, VariableID: 1005, Value: 9.5, Time: time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)},
+			)
+		})
+
+		It("saves the data in line protocol to the database, overwriting existing values", func() {
+			resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/write?precision=s"), "text/plain", strings.NewReader("weather,location=roof distance=10.5,humidity=60i 1430907330\ndistance value=11.5 1430907630\n"), "agent1token")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+			saved := []string{}
+
+			for _, point := range getData(1004, 1005, 1006) {
+				saved = append(saved, fmt.Sprintf("%v %v %v", point.VariableID, point.Value, point.Time.UTC().Format(time.RFC3339)))
+			}
+
+			Expect(saved).To(Equal([]string{
+				"1005 10.5 2015-05-06T10:15:30Z",
+				"1006 60 2015-05-06T10:15:30Z",
+				"1005 11.5 2015-05-06T10:20:30Z",
+			}))
+		})
+
+		It("returns HTTP 400 and does not save anything if a variable does not exist", func() {
+			resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/write"), "text/plain", strings.NewReader("weather distance=10.5,pressure=1013.2"), "agent1token")
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+			responseBytes, err := ioutil.ReadAll(resp.Body)
+			Expect(err).To(BeNil())
+			Expect(string(responseBytes)).To(Equal("Could not find variable with name 'pressure'."))
+
+			Expect(getData(1004, 1005, 1006)).To(HaveLen(1))
+		})
+
+		It("returns HTTP 401 if the agent's token is not given", func() {
+			resp, err := http.Post(urlFor("/v1/agents/1004/write"), "text/plain", strings.NewReader("distance value=10.5"))
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("/weatherstation/updateweatherstation.php", func() {
+		BeforeEach(func() {
+			agent := Agent{}
+			agent.SetToken("agent1token")
+
+			insertFixtures(db,
+				User{UserID: 3001, Email: "blah@blah.com"},
+				Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: 3001, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+				Variable{VariableID: 1005, Name: "temperature", Units: "°C", DisplayDecimalPlaces: 1},
+			)
+		})
+
+		It("saves the data in metric units and responds with 'success'", func() {
+			resp, err := http.Get(urlFor("/weatherstation/updateweatherstation.php?ID=1004&PASSWORD=agent1token&dateutc=2015-05-06+10%3A15%3A30&tempf=50&humidity=65&action=updateraw"))
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			responseBytes, err := ioutil.ReadAll(resp.Body)
+			Expect(err).To(BeNil())
+			Expect(string(responseBytes)).To(Equal("success"))
+
+			data := getData(1004, 1005)
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].Value).To(Equal(10.0))
+			Expect(data[0].Time).To(BeTemporally("==", time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)))
+		})
+
+		It("returns HTTP 401 if the password is incorrect", func() {
+			resp, err := http.Get(urlFor("/weatherstation/updateweatherstation.php?ID=1004&PASSWORD=wrong&dateutc=now&tempf=50"))
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("/v1/agents/:agent_id/stream", func() {
+		Context("GET", func() {
+			BeforeEach(func() {
+				agent := Agent{}
+				agent.SetToken("agent1token")
+
+				insertFixtures(db,
+					Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: testUser.UserID, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+					Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 100, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 101, Time: time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)},
+				)
+			})
+
+			readLines := func(body io.Reader) <-chan string {
+				lines := make(chan string, 100)
+
+				go func() {
+					defer close(lines)
+					scanner := bufio.NewScanner(body)
+
+					for scanner.Scan() {
+						lines <- scanner.Text()
+					}
+				}()
+
+				return lines
+			}
+
+			It("replays data after the last event ID and then sends newly saved data", func() {
+				request, err := http.NewRequest("GET", urlFor("/v1/agents/1004/stream"), nil)
+				Expect(err).To(BeNil())
+				// The fixtures were saved in order, so the second has sequence number 2.
+				request.Header.Set("Last-Event-ID", "1")
+
+				resp := doRequestWithAuthentication(request, testUser.Email, testUserPassword)
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+				lines := readLines(resp.Body)
+				Eventually(lines).Should(Receive(Equal("id: 2")))
+				Eventually(lines).Should(Receive(Equal("event: data")))
+				Eventually(lines).Should(Receive(HavePrefix(`data: {"agentId":1004,"points":[{"variableId":1005,"variable":"distance","time":"2015-04-07T15:01:00Z","value":101}]}`)))
+				Eventually(lines).Should(Receive(Equal("")))
+
+				postResp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"2015-05-06T10:15:30Z","data":[{"variable":"distance","value":10.5}]}`), "agent1token")
+				Expect(postResp.StatusCode).To(Equal(http.StatusCreated))
+
+				Eventually(lines).Should(Receive(Equal("id: 3")))
+				Eventually(lines).Should(Receive(Equal("event: data")))
+				Eventually(lines).Should(Receive(Equal(`data: {"agentId":1004,"points":[{"variableId":1005,"variable":"distance","time":"2015-05-06T10:15:30Z","value":10.5}]}`)))
+			})
+		})
+	})
+
+	Describe("/v1/agents/:agent_id/alerts", func() {
+		var webhook *httptest.Server
+		var received chan AlertEvent
+
+		BeforeEach(func() {
+			agent := Agent{}
+			agent.SetToken("agent1token")
+
+			insertFixtures(db,
+				Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: testUser.UserID, TokenIterations: agent.TokenIterations, TokenSalt: agent.TokenSalt, TokenHash: agent.TokenHash},
+				Variable{VariableID: 1005, Name: "temperature", Units: "degrees", DisplayDecimalPlaces: 1},
+			)
+
+			received = make(chan AlertEvent, 10)
+			webhook = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event := AlertEvent{}
+				json.NewDecoder(r.Body).Decode(&event)
+				received <- event
+			}))
+		})
+
+		AfterEach(func() {
+			webhook.Close()
+		})
+
+		postTemperature := func(t string, value string) {
+			resp := postWithAgentAuthentication(urlFor("/v1/agents/1004/data"), "application/json", strings.NewReader(`{"time":"`+t+`","data":[{"variable":"temperature","value":`+value+`}]}`), "agent1token")
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		}
+
+		It("sends firing and resolved events to the webhook and records them in the rule's history", func() {
+			resp := postWithAuthentication(urlFor("/v1/agents/1004/alerts"), "application/json", strings.NewReader(`{"variable":"temperature","condition":"below","threshold":0,"hysteresis":1,"webhookUrl":"`+webhook.URL+`"}`))
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			rule := AlertRuleResult{}
+			Expect(json.NewDecoder(resp.Body).Decode(&rule)).To(Succeed())
+			Expect(rule.State).To(Equal("ok"))
+
+			postTemperature("2015-05-06T10:00:00Z", "2")
+			postTemperature("2015-05-06T10:05:00Z", "-0.5")
+			postTemperature("2015-05-06T10:10:00Z", "0.5")
+			postTemperature("2015-05-06T10:15:00Z", "1.5")
+
+			var event AlertEvent
+			Eventually(received).Should(Receive(&event))
+			Expect(event.RuleID).To(Equal(rule.RuleID))
+			Expect(event.Event).To(Equal("firing"))
+			Expect(event.Value).To(Equal(-0.5))
+
+			Eventually(received).Should(Receive(&event))
+			Expect(event.Event).To(Equal("resolved"))
+			Expect(event.Value).To(Equal(1.5))
+
+			Consistently(received).ShouldNot(Receive())
+
+			historyResp := getWithAuthentication(urlFor(fmt.Sprintf("/v1/agents/1004/alerts/%d/history", rule.RuleID)))
+			Expect(historyResp.StatusCode).To(Equal(http.StatusOK))
+
+			history := []AlertHistoryEntry{}
+			Expect(json.NewDecoder(historyResp.Body).Decode(&history)).To(Succeed())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Event).To(Equal("resolved"))
+			Expect(history[1].Event).To(Equal("firing"))
+		})
+
+		It("returns HTTP 403 when creating a rule for an agent owned by another user", func() {
+			resp := postWithAdminAuthentication(urlFor("/v1/agents/1004/alerts"), "application/json", strings.NewReader(`{"variable":"temperature","condition":"below","threshold":0,"webhookUrl":"`+webhook.URL+`"}`))
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Describe("/v1/agents/:agent_id/latest", func() {
+		Context("GET", func() {
+			BeforeEach(func() {
+				insertFixtures(db,
+					User{UserID: 3001, Email: "blah@blah.com"},
+					Agent{AgentID: 1004, Name: "Test Agent 1", OwnerUserID: testUser.UserID},
+					Agent{AgentID: 1005, Name: "Test Agent 2", OwnerUserID: 3001},
+					Variable{VariableID: 1005, Name: "distance", Units: "metres", DisplayDecimalPlaces: 1},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 103, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+					DataPoint{AgentID: 1004, VariableID: 1005, Value: 104, Time: time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)},
+				)
+			})
+
+			Context("when not authenticated", func() {
+				It("returns HTTP 401", func() {
+					resp, err := http.Get(urlFor("/v1/agents/1004/latest"))
+					Expect(err).To(BeNil())
+					Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+
+			Context("when authenticated as a user that does not own the agent", func() {
+				It("returns HTTP 403", func() {
+					resp := getWithAuthentication(urlFor("/v1/agents/1005/latest"))
+					Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("when authenticated as the user that owns the agent", func() {
+				It("returns the latest value of each variable", func() {
+					resp := getWithAuthentication(urlFor("/v1/agents/1004/latest"))
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Header).To(haveJSONContentType())
+
+					responseBytes, err := ioutil.ReadAll(resp.Body)
+					Expect(err).To(BeNil())
+					Expect(string(responseBytes)).To(MatchJSON(`{"data":[` +
+						`{"id":1005,"name":"distance","units":"metres","displayDecimalPlaces":1,"time":"2015-04-07T15:01:00Z","value":104}` +
+						`]}`))
+				})
 			})
 		})
 	})
@@ -357,17 +894,15 @@ var _ = Describe("HTTP endpoints", func() {
 					Expect(err).To(BeNil())
 					Expect(response).To(HaveKey("id"))
 
-					id := int(response["id"].(float64))
-					var name, units string
-					var created time.Time
-					var displayDecimalPlaces int
-					row := db.DB().QueryRow("SELECT name, units, display_decimal_places, created FROM variables WHERE variable_id = $1;", id)
-					err = row.Scan(&name, &units, &displayDecimalPlaces, &created)
+					Expect(db.BeginTransaction()).To(Succeed())
+					defer db.RollbackTransaction()
+
+					variable, err := db.GetVariableByID(int(response["id"].(float64)))
 					Expect(err).To(BeNil())
-					Expect(name).To(Equal("New variable name"))
-					Expect(units).To(Equal("seconds (s)"))
-					Expect(displayDecimalPlaces).To(Equal(2))
-					Expect(created).To(BeTemporally("~", time.Now(), 100*time.Millisecond))
+					Expect(variable.Name).To(Equal("New variable name"))
+					Expect(variable.Units).To(Equal("seconds (s)"))
+					Expect(variable.DisplayDecimalPlaces).To(Equal(2))
+					Expect(variable.Created).To(BeTemporally("~", time.Now(), 100*time.Millisecond))
 				})
 			})
 
@@ -399,16 +934,11 @@ var _ = Describe("HTTP endpoints", func() {
 				Expect(err).To(BeNil())
 				Expect(response).To(HaveKey("id"))
 
-				id := int(response["id"].(float64))
-				var email string
-				var isAdmin bool
-				var created time.Time
-				row := db.DB().QueryRow("SELECT email, is_admin, created FROM users WHERE user_id = $1;", id)
-				err = row.Scan(&email, &isAdmin, &created)
+				user, err := db.GetUserByID(int(response["id"].(float64)))
 				Expect(err).To(BeNil())
-				Expect(email).To(Equal("test@testing.com"))
-				Expect(isAdmin).To(Equal(false))
-				Expect(created).To(BeTemporally("~", time.Now(), 1000*time.Millisecond))
+				Expect(user.Email).To(Equal("test@testing.com"))
+				Expect(user.IsAdmin).To(Equal(false))
+				Expect(user.Created).To(BeTemporally("~", time.Now(), 1000*time.Millisecond))
 			})
 		})
 	})
@@ -5,16 +5,22 @@ import (
 	"github.com/go-martini/martini"
 	"github.com/twinj/uuid"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// secretParameters are the query parameters that can hold an agent's token, such as those consumer weather stations
+// send their password in.
+var secretParameters = []string{"PASSWORD", "PASSKEY", "token"}
+
 func Log() martini.Handler {
 	return func(res http.ResponseWriter, req *http.Request, c martini.Context) {
 		requestId := uuid.NewV4().String()
 
 		logger := logrus.WithFields(logrus.Fields{
 			"method":        req.Method,
-			"url":           req.URL.String(),
+			"url":           redactURL(req.URL),
 			"remoteAddress": req.RemoteAddr,
 			"requestLength": req.ContentLength,
 			"requestId":     requestId,
@@ -37,3 +43,27 @@ func Log() martini.Handler {
 		}).Info("Request processing complete.")
 	}
 }
+
+// redactURL returns the URL with the value of any parameter that can hold a secret replaced, so that it can be logged.
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+
+	for name := range query {
+		for _, secret := range secretParameters {
+			if strings.EqualFold(name, secret) {
+				query.Set(name, "REDACTED")
+				redacted = true
+			}
+		}
+	}
+
+	if !redacted {
+		return u.String()
+	}
+
+	withoutSecrets := *u
+	withoutSecrets.RawQuery = query.Encode()
+
+	return withoutSecrets.String()
+}
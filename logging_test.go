@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	Describe("Log", func() {
+		var output *bytes.Buffer
+
+		BeforeEach(func() {
+			output = &bytes.Buffer{}
+			logrus.SetOutput(output)
+		})
+
+		AfterEach(func() {
+			logrus.SetOutput(os.Stderr)
+		})
+
+		It("does not log the token sent by a weather station in the URL", func() {
+			m := martini.New()
+			m.Use(Log())
+			m.Action(func(res http.ResponseWriter) {
+				res.WriteHeader(http.StatusOK)
+			})
+
+			req, err := http.NewRequest("GET", "/weatherstation/updateweatherstation.php?ID=10&PASSWORD=thesecrettoken&tempf=71.6", nil)
+			Expect(err).To(BeNil())
+
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(output.String()).To(ContainSubstring("tempf=71.6"))
+			Expect(output.String()).ToNot(ContainSubstring("thesecrettoken"))
+		})
+	})
+
+	DescribeTable("redactURL replaces the value of each parameter that can hold a secret", func(raw string, expected string) {
+		u, err := url.Parse(raw)
+		Expect(err).To(BeNil())
+
+		Expect(redactURL(u)).To(Equal(expected))
+	},
+		Entry("a Weather Underground password", "/weatherstation/updateweatherstation.php?ID=10&PASSWORD=secret&tempf=71.6", "/weatherstation/updateweatherstation.php?ID=10&PASSWORD=REDACTED&tempf=71.6"),
+		Entry("an Ecowitt passkey", "/data/report?PASSKEY=secret", "/data/report?PASSKEY=REDACTED"),
+		Entry("a token", "/v1/agents/10/stream?token=secret", "/v1/agents/10/stream?token=REDACTED"),
+		Entry("a parameter name in a different case", "/data/report?password=secret", "/data/report?password=REDACTED"),
+		Entry("a URL without secrets", "/v1/agents/10/data?variable=1&from=2015-05-06", "/v1/agents/10/data?variable=1&from=2015-05-06"),
+	)
+})
@@ -0,0 +1,1261 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStores holds the named in-memory databases that are open, so that each connection to the same name shares the
+// same data. A store is discarded when the last connection to it is closed.
+var memoryStores = struct {
+	sync.Mutex
+	stores map[string]*memoryStore
+}{stores: map[string]*memoryStore{}}
+
+// memoryStore holds the contents of an in-memory database, shared by every session on it.
+type memoryStore struct {
+	name        string
+	connections int
+
+	// Each transaction holds the write lock until it ends, so transactions run one at a time, like SERIALIZABLE
+	// transactions that never conflict. Reads outside of a transaction take the read lock.
+	lock sync.RWMutex
+
+	users           map[int]User
+	agents          map[int]Agent
+	agentsDeleted   map[int]time.Time
+	variables       map[int]Variable
+	data            map[dataPointKey]DataPoint
+	rollups         map[memoryRollupKey]DataRollup
+	dataCorrections map[int]DataCorrection
+	sessions        map[int]Session
+	alertRules      map[int]AlertRule
+	alertHistory    map[int]AlertHistoryEntry
+
+	// dataSequenceNumbers holds the sequence number of each data point apart from the point itself, so that it is
+	// only seen by StreamDataSavedAfter.
+	dataSequenceNumbers map[dataPointKey]int
+
+	userIDs       memorySequence
+	agentIDs      memorySequence
+	variableIDs   memorySequence
+	correctionIDs memorySequence
+	sessionIDs    memorySequence
+	ruleIDs       memorySequence
+	historyIDs    memorySequence
+	dataSequence  memorySequence
+}
+
+type memoryRollupKey struct {
+	Resolution time.Duration
+	dataPointKey
+}
+
+// memorySequence generates IDs the way a SERIAL column does: each ID is one more than the last, and an ID is never
+// handed out again, even if the transaction that used it is rolled back.
+type memorySequence struct {
+	last int
+}
+
+func (s *memorySequence) next() int {
+	s.last++
+	return s.last
+}
+
+// MemoryDatabase is a Database that keeps everything in memory, for demonstrations and for tests that should not
+// need a database server. It has no SQL connection, so DB and Transaction always return nil.
+type MemoryDatabase struct {
+	store         *memoryStore
+	inTransaction bool
+
+	// undo holds the changes needed to revert each change made in the current transaction, in the order they were made.
+	undo []func()
+}
+
+func newMemoryStore(name string) *memoryStore {
+	return &memoryStore{
+		name:            name,
+		users:           map[int]User{},
+		agents:          map[int]Agent{},
+		agentsDeleted:   map[int]time.Time{},
+		variables:       map[int]Variable{},
+		data:            map[dataPointKey]DataPoint{},
+		rollups:         map[memoryRollupKey]DataRollup{},
+		dataCorrections: map[int]DataCorrection{},
+		sessions:        map[int]Session{},
+		alertRules:      map[int]AlertRule{},
+		alertHistory:    map[int]AlertHistoryEntry{},
+
+		dataSequenceNumbers: map[dataPointKey]int{},
+	}
+}
+
+// connectToMemoryDatabase opens the in-memory database with the given name, creating it if it is not already open.
+// Every connection to an unnamed database gets a database of its own.
+func connectToMemoryDatabase(name string) (Database, error) {
+	if name == "" {
+		return &MemoryDatabase{store: newMemoryStore(name)}, nil
+	}
+
+	memoryStores.Lock()
+	defer memoryStores.Unlock()
+
+	store, ok := memoryStores.stores[name]
+
+	if !ok {
+		store = newMemoryStore(name)
+		memoryStores.stores[name] = store
+	}
+
+	store.connections++
+
+	return &MemoryDatabase{store: store}, nil
+}
+
+func (d *MemoryDatabase) RunMigrations() (int, error) {
+	// There is no schema to migrate.
+	return 0, nil
+}
+
+func (d *MemoryDatabase) Close() {
+	if d.store.name == "" {
+		return
+	}
+
+	memoryStores.Lock()
+	defer memoryStores.Unlock()
+
+	d.store.connections--
+
+	if d.store.connections == 0 {
+		delete(memoryStores.stores, d.store.name)
+	}
+}
+
+// NewSession returns a Database that shares this database's data, but has its own transaction state.
+// Sessions should not be closed, as doing so closes the shared database.
+func (d *MemoryDatabase) NewSession() Database {
+	return &MemoryDatabase{store: d.store}
+}
+
+func (d *MemoryDatabase) DB() *sql.DB {
+	return nil
+}
+
+func (d *MemoryDatabase) Transaction() *sql.Tx {
+	return nil
+}
+
+func (d *MemoryDatabase) BeginTransaction() error {
+	if d.inTransaction {
+		return errors.New("Cannot call BeginTransaction when there is already a transaction in progress.")
+	}
+
+	d.store.lock.Lock()
+	d.inTransaction = true
+	d.undo = nil
+
+	return nil
+}
+
+func (d *MemoryDatabase) CommitTransaction() error {
+	if !d.inTransaction {
+		return errors.New("Cannot call CommitTransaction when there is no transaction in progress.")
+	}
+
+	d.endTransaction()
+	return nil
+}
+
+func (d *MemoryDatabase) RollbackTransaction() error {
+	if !d.inTransaction {
+		return errors.New("Cannot call RollbackTransaction when there is no transaction in progress.")
+	}
+
+	for i := len(d.undo) - 1; i >= 0; i-- {
+		d.undo[i]()
+	}
+
+	d.endTransaction()
+	return nil
+}
+
+func (d *MemoryDatabase) RollbackUncommittedTransaction() error {
+	if !d.inTransaction {
+		return nil
+	}
+
+	return d.RollbackTransaction()
+}
+
+func (d *MemoryDatabase) endTransaction() {
+	d.undo = nil
+	d.inTransaction = false
+	d.store.lock.Unlock()
+}
+
+func (d *MemoryDatabase) ensureTransaction() error {
+	if !d.inTransaction {
+		return errors.New("An active transaction is required to call this method.")
+	}
+
+	return nil
+}
+
+// read calls f with the store locked for reading, unless this session's transaction already holds the lock.
+func (d *MemoryDatabase) read(f func()) {
+	if d.inTransaction {
+		f()
+		return
+	}
+
+	d.store.lock.RLock()
+	defer d.store.lock.RUnlock()
+
+	f()
+}
+
+// set stores value under key in table, which must be one of the store's maps, and records how to undo the change.
+// A nil value removes key from the table.
+func (d *MemoryDatabase) set(table interface{}, key interface{}, value interface{}) {
+	m := reflect.ValueOf(table)
+	k := reflect.ValueOf(key)
+	previous := m.MapIndex(k)
+
+	// Setting the zero Value removes the key, so this also undoes adding a key that was not there before.
+	d.undo = append(d.undo, func() { m.SetMapIndex(k, previous) })
+
+	if value == nil {
+		m.SetMapIndex(k, reflect.Value{})
+	} else {
+		m.SetMapIndex(k, reflect.ValueOf(value))
+	}
+}
+
+// copyBytes returns a copy of value, so that changes to either do not affect the other. Like a SQL database, it never
+// returns nil.
+func copyBytes(value []byte) []byte {
+	return append([]byte{}, value...)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	copied := *t
+	return &copied
+}
+
+func copyFloat(value *float64) *float64 {
+	if value == nil {
+		return nil
+	}
+
+	copied := *value
+	return &copied
+}
+
+func copyInt(value *int) *int {
+	if value == nil {
+		return nil
+	}
+
+	copied := *value
+	return &copied
+}
+
+func copyAgent(agent Agent) Agent {
+	metadata := map[string]string{}
+
+	for key, value := range agent.Metadata {
+		metadata[key] = value
+	}
+
+	agent.Metadata = metadata
+	agent.TokenSalt = copyBytes(agent.TokenSalt)
+	agent.TokenHash = copyBytes(agent.TokenHash)
+	agent.PreviousTokenSalt = copyBytes(agent.PreviousTokenSalt)
+	agent.PreviousTokenHash = copyBytes(agent.PreviousTokenHash)
+	agent.LastSeen = copyTime(agent.LastSeen)
+	agent.OfflineSince = copyTime(agent.OfflineSince)
+
+	return agent
+}
+
+func copyVariable(variable Variable) Variable {
+	variable.MinValue = copyFloat(variable.MinValue)
+	variable.MaxValue = copyFloat(variable.MaxValue)
+	variable.MaxStep = copyFloat(variable.MaxStep)
+	variable.RawDataDays = copyInt(variable.RawDataDays)
+	variable.OutOfRange = variable.OutOfRangePolicy()
+
+	return variable
+}
+
+func copyUser(user User) User {
+	user.PasswordSalt = copyBytes(user.PasswordSalt)
+	user.PasswordHash = copyBytes(user.PasswordHash)
+
+	return user
+}
+
+// agent returns the agent with the given ID, and false if there is no such agent or it has been deleted.
+func (d *MemoryDatabase) agent(agentID int) (Agent, bool) {
+	agent, ok := d.store.agents[agentID]
+
+	if !ok {
+		return Agent{}, false
+	}
+
+	if _, deleted := d.store.agentsDeleted[agentID]; deleted {
+		return Agent{}, false
+	}
+
+	return copyAgent(agent), true
+}
+
+// sortedAgents returns the agents that have not been deleted for which include returns true, in order of ID.
+func (d *MemoryDatabase) sortedAgents(include func(Agent) bool) []Agent {
+	agents := []Agent{}
+
+	for agentID := range d.store.agents {
+		if agent, ok := d.agent(agentID); ok && include(agent) {
+			agents = append(agents, agent)
+		}
+	}
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].AgentID < agents[j].AgentID })
+
+	return agents
+}
+
+func (d *MemoryDatabase) CreateAgent(agent *Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.users[agent.OwnerUserID]; !ok {
+		return fmt.Errorf("Cannot find user with ID %d.", agent.OwnerUserID)
+	}
+
+	agent.AgentID = d.store.agentIDs.next()
+	d.set(d.store.agents, agent.AgentID, copyAgent(*agent))
+
+	return nil
+}
+
+// UpdateAgent saves the name, metadata and offline interval of an existing agent.
+func (d *MemoryDatabase) UpdateAgent(agent Agent) error {
+	return d.updateAgent(agent.AgentID, func(existing *Agent) {
+		existing.Name = agent.Name
+		existing.Metadata = agent.Metadata
+		existing.OfflineAfter = agent.OfflineAfter
+	})
+}
+
+// UpdateAgentToken saves the current and previous tokens of an existing agent.
+func (d *MemoryDatabase) UpdateAgentToken(agent Agent) error {
+	return d.updateAgent(agent.AgentID, func(existing *Agent) {
+		existing.TokenIterations = agent.TokenIterations
+		existing.TokenSalt = agent.TokenSalt
+		existing.TokenHash = agent.TokenHash
+		existing.PreviousTokenIterations = agent.PreviousTokenIterations
+		existing.PreviousTokenSalt = agent.PreviousTokenSalt
+		existing.PreviousTokenHash = agent.PreviousTokenHash
+		existing.PreviousTokenExpires = agent.PreviousTokenExpires
+	})
+}
+
+// UpdateAgentLastSeen records that the agent was seen at the given time, unless it has already been seen since then.
+func (d *MemoryDatabase) UpdateAgentLastSeen(agentID int, seen time.Time) error {
+	return d.updateAgent(agentID, func(existing *Agent) {
+		if existing.LastSeen == nil || existing.LastSeen.Before(seen) {
+			existing.LastSeen = &seen
+		}
+	})
+}
+
+// updateAgent calls update with the agent with the given ID, and then saves the agent.
+func (d *MemoryDatabase) updateAgent(agentID int, update func(existing *Agent)) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	agent, ok := d.agent(agentID)
+
+	if !ok {
+		return fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	update(&agent)
+	d.set(d.store.agents, agentID, copyAgent(agent))
+
+	return nil
+}
+
+// DeleteAgent removes an agent and all of the data it has reported.
+func (d *MemoryDatabase) DeleteAgent(agentID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.agents[agentID]; !ok {
+		return fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	for key := range d.store.data {
+		if key.AgentID == agentID {
+			d.set(d.store.data, key, nil)
+			d.set(d.store.dataSequenceNumbers, key, nil)
+		}
+	}
+
+	for key := range d.store.rollups {
+		if key.AgentID == agentID {
+			d.set(d.store.rollups, key, nil)
+		}
+	}
+
+	for correctionID, correction := range d.store.dataCorrections {
+		if correction.AgentID == agentID {
+			d.set(d.store.dataCorrections, correctionID, nil)
+		}
+	}
+
+	for ruleID, rule := range d.store.alertRules {
+		if rule.AgentID == agentID {
+			d.deleteAlertRule(ruleID)
+		}
+	}
+
+	if _, deleted := d.store.agentsDeleted[agentID]; deleted {
+		d.set(d.store.agentsDeleted, agentID, nil)
+	}
+
+	d.set(d.store.agents, agentID, nil)
+
+	return nil
+}
+
+// SoftDeleteAgent hides an agent from all other queries, but keeps it and the data it has reported in the database.
+func (d *MemoryDatabase) SoftDeleteAgent(agentID int, deleted time.Time) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.agent(agentID); !ok {
+		return fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	d.set(d.store.agentsDeleted, agentID, deleted)
+
+	return nil
+}
+
+// MarkAgentsOffline marks each agent that has not been seen within its offline interval as offline, and returns them.
+// Agents that have never been seen are measured from when they were created.
+func (d *MemoryDatabase) MarkAgentsOffline(now time.Time) ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	agents := d.sortedAgents(func(agent Agent) bool {
+		if agent.OfflineSince != nil || agent.OfflineAfter <= 0 {
+			return false
+		}
+
+		lastSeen := agent.Created
+
+		if agent.LastSeen != nil {
+			lastSeen = *agent.LastSeen
+		}
+
+		return lastSeen.Add(time.Duration(agent.OfflineAfter)).Before(now)
+	})
+
+	for i := range agents {
+		offlineSince := now
+		agents[i].OfflineSince = &offlineSince
+		d.set(d.store.agents, agents[i].AgentID, copyAgent(agents[i]))
+	}
+
+	return agents, nil
+}
+
+// MarkAgentsOnline clears the offline flag of each agent that has been seen since it was marked as offline, and returns them.
+func (d *MemoryDatabase) MarkAgentsOnline() ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	agents := d.sortedAgents(func(agent Agent) bool {
+		return agent.OfflineSince != nil && agent.LastSeen != nil && agent.LastSeen.After(*agent.OfflineSince)
+	})
+
+	for i := range agents {
+		agents[i].OfflineSince = nil
+		d.set(d.store.agents, agents[i].AgentID, copyAgent(agents[i]))
+	}
+
+	return agents, nil
+}
+
+// GetAllAgents returns the agents that match filter, regardless of who owns them.
+func (d *MemoryDatabase) GetAllAgents(filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents(func(Agent) bool { return true }, filter), nil
+}
+
+// GetAgentsForUser returns the agents owned by the given user that match filter.
+func (d *MemoryDatabase) GetAgentsForUser(userID int, filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents(func(agent Agent) bool { return agent.OwnerUserID == userID }, filter), nil
+}
+
+func (d *MemoryDatabase) queryAgents(include func(Agent) bool, filter AgentFilter) []Agent {
+	var agents []Agent
+	name := strings.ToLower(filter.Name)
+
+	d.read(func() {
+		agents = d.sortedAgents(func(agent Agent) bool {
+			return include(agent) && strings.Contains(strings.ToLower(agent.Name), name)
+		})
+	})
+
+	if filter.Offset >= len(agents) {
+		return []Agent{}
+	}
+
+	agents = agents[filter.Offset:]
+
+	if filter.Limit > 0 && filter.Limit < len(agents) {
+		agents = agents[:filter.Limit]
+	}
+
+	return agents
+}
+
+func (d *MemoryDatabase) CheckAgentIDExists(agentID int) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	_, ok := d.agent(agentID)
+
+	return ok, nil
+}
+
+func (d *MemoryDatabase) GetAgentByID(agentID int) (Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Agent{}, err
+	}
+
+	agent, ok := d.agent(agentID)
+
+	if !ok {
+		return Agent{}, fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	return agent, nil
+}
+
+func (d *MemoryDatabase) CreateVariable(variable *Variable) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	for _, existing := range d.store.variables {
+		if existing.Name == variable.Name {
+			return fmt.Errorf("There is already a variable with name '%s'.", variable.Name)
+		}
+	}
+
+	variable.VariableID = d.store.variableIDs.next()
+	d.set(d.store.variables, variable.VariableID, copyVariable(*variable))
+
+	return nil
+}
+
+// UpdateVariableLimits replaces the limits of a variable, and returns false if the variable does not exist.
+func (d *MemoryDatabase) UpdateVariableLimits(variableID int, limits VariableLimits) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	variable, ok := d.store.variables[variableID]
+
+	if !ok {
+		return false, nil
+	}
+
+	variable.VariableLimits = limits
+	d.set(d.store.variables, variableID, copyVariable(variable))
+
+	return true, nil
+}
+
+// UpdateVariableRetention replaces the retention policy of a variable, and returns false if the variable does not exist.
+func (d *MemoryDatabase) UpdateVariableRetention(variableID int, retention VariableRetention) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	variable, ok := d.store.variables[variableID]
+
+	if !ok {
+		return false, nil
+	}
+
+	variable.VariableRetention = retention
+	d.set(d.store.variables, variableID, copyVariable(variable))
+
+	return true, nil
+}
+
+// GetAllVariables returns every variable, in order of ID.
+func (d *MemoryDatabase) GetAllVariables() ([]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return []Variable{}, err
+	}
+
+	variables := []Variable{}
+
+	for _, variable := range d.store.variables {
+		variables = append(variables, copyVariable(variable))
+	}
+
+	sort.Slice(variables, func(i, j int) bool { return variables[i].VariableID < variables[j].VariableID })
+
+	return variables, nil
+}
+
+func (d *MemoryDatabase) GetVariableIDForName(name string) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	for _, variable := range d.store.variables {
+		if variable.Name == name {
+			return variable.VariableID, nil
+		}
+	}
+
+	return -1, fmt.Errorf("Cannot find variable with name '%s'.", name)
+}
+
+// GetVariablesByName returns the variables with any of the given names, keyed by name. Names that do not match a
+// variable are left out.
+func (d *MemoryDatabase) GetVariablesByName(names []string) (map[string]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	variables := map[string]Variable{}
+
+	for _, variable := range d.store.variables {
+		if containsString(names, variable.Name) {
+			variables[variable.Name] = copyVariable(variable)
+		}
+	}
+
+	return variables, nil
+}
+
+func (d *MemoryDatabase) GetVariableByID(variableID int) (Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Variable{}, err
+	}
+
+	variable, ok := d.store.variables[variableID]
+
+	if !ok {
+		return Variable{}, fmt.Errorf("Cannot find variable with ID %d.", variableID)
+	}
+
+	return copyVariable(variable), nil
+}
+
+func (d *MemoryDatabase) GetVariablesForAgent(agentID int) ([]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return []Variable{}, err
+	}
+
+	variableIDs := map[int]bool{}
+
+	for key := range d.store.data {
+		if key.AgentID == agentID {
+			variableIDs[key.VariableID] = true
+		}
+	}
+
+	variables := []Variable{}
+
+	for variableID := range variableIDs {
+		variables = append(variables, copyVariable(d.store.variables[variableID]))
+	}
+
+	sort.Slice(variables, func(i, j int) bool { return variables[i].VariableID < variables[j].VariableID })
+
+	return variables, nil
+}
+
+// AddDataPoint saves a data point, and returns true if there was already a value for the same agent, variable and time.
+// The existing value is kept unless conflictPolicy is conflictPolicyOverwrite.
+func (d *MemoryDatabase) AddDataPoint(dataPoint DataPoint, conflictPolicy string) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	if conflictPolicy != conflictPolicyReject && conflictPolicy != conflictPolicyIgnore && conflictPolicy != conflictPolicyOverwrite {
+		return false, fmt.Errorf("Unknown conflict policy '%s'.", conflictPolicy)
+	}
+
+	if _, ok := d.store.agents[dataPoint.AgentID]; !ok {
+		return false, fmt.Errorf("Cannot find agent with ID %d.", dataPoint.AgentID)
+	}
+
+	if _, ok := d.store.variables[dataPoint.VariableID]; !ok {
+		return false, fmt.Errorf("Cannot find variable with ID %d.", dataPoint.VariableID)
+	}
+
+	key := dataPoint.key()
+	_, existed := d.store.data[key]
+
+	if !existed || conflictPolicy == conflictPolicyOverwrite {
+		dataPoint.Quality = dataPoint.QualityFlag()
+		d.set(d.store.data, key, dataPoint)
+		d.set(d.store.dataSequenceNumbers, key, d.store.dataSequence.next())
+	}
+
+	return existed, nil
+}
+
+// AddDataPoints saves each point in turn, as there are no round trips to save by doing anything else.
+func (d *MemoryDatabase) AddDataPoints(dataPoints []DataPoint, conflictPolicy string) ([]bool, error) {
+	existed := make([]bool, len(dataPoints))
+
+	for i, dataPoint := range dataPoints {
+		var err error
+
+		if existed[i], err = d.AddDataPoint(dataPoint, conflictPolicy); err != nil {
+			return nil, err
+		}
+	}
+
+	return existed, nil
+}
+
+// GetPreviousValue returns the latest good value for the agent and variable from before the given time, and false if
+// there is none.
+func (d *MemoryDatabase) GetPreviousValue(agentID int, variableID int, before time.Time) (float64, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, false, err
+	}
+
+	var previous *DataPoint
+
+	for key, point := range d.store.data {
+		if key.AgentID != agentID || key.VariableID != variableID || !point.Time.Before(before) || point.Quality != dataQualityGood {
+			continue
+		}
+
+		if previous == nil || point.Time.After(previous.Time) {
+			found := point
+			previous = &found
+		}
+	}
+
+	if previous == nil {
+		return 0, false, nil
+	}
+
+	return previous.Value, true, nil
+}
+
+// queryData returns the points for the agent and any of the variables in the range given, ordered by time and then
+// variable ID.
+func (d *MemoryDatabase) queryData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool) []DataPoint {
+	points := []DataPoint{}
+
+	d.read(func() {
+		for key, point := range d.store.data {
+			if key.AgentID != agentID || !containsInt(variableIDs, key.VariableID) || point.Time.Before(fromDate) || point.Time.After(toDate) {
+				continue
+			}
+
+			if !includeFlagged && point.Quality != dataQualityGood && point.Quality != dataQualityCorrected {
+				continue
+			}
+
+			points = append(points, point)
+		}
+	})
+
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].Time.Equal(points[j].Time) {
+			return points[i].Time.Before(points[j].Time)
+		}
+
+		return points[i].VariableID < points[j].VariableID
+	})
+
+	return points
+}
+
+// GetData returns the points for the agent and variable in the range given. Where the points have been rolled up, the
+// average of each hourly rollup is given in their place.
+func (d *MemoryDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool) (map[string]float64, error) {
+	m := map[string]float64{}
+
+	for _, rollup := range d.queryRollups(agentID, variableID, rollupResolutionHourly, fromDate, toDate) {
+		m[rollup.Time.In(time.UTC).Format(time.RFC3339)] = rollup.Avg
+	}
+
+	for _, point := range d.queryData(agentID, []int{variableID}, fromDate, toDate, includeFlagged) {
+		m[point.Time.In(time.UTC).Format(time.RFC3339)] = point.Value
+	}
+
+	return m, nil
+}
+
+// GetAggregatedData groups points into buckets of the given interval (aligned to the Unix epoch) and
+// returns the aggregate of each bucket, keyed by the start time of the bucket. Points that have been rolled up are
+// included using the rollups that suit the interval best.
+func (d *MemoryDatabase) GetAggregatedData(agentID int, variableID int, fromDate time.Time, toDate time.Time, interval time.Duration, aggregate string, includeFlagged bool) (map[string]float64, error) {
+	return aggregateData(interval, aggregate, func(callback func(DataRollup)) error {
+		rollups := d.queryRollups(agentID, variableID, rollupResolutionFor(interval), fromDate, toDate)
+
+		for _, point := range d.queryData(agentID, []int{variableID}, fromDate, toDate, includeFlagged) {
+			rollups = append(rollups, rollupOfValue(point.Time, point.Value))
+		}
+
+		sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Time.Before(rollups[j].Time) })
+
+		for _, rollup := range rollups {
+			callback(rollup)
+		}
+
+		return nil
+	})
+}
+
+// queryRollups returns the rollups of the given resolution for the agent and variable that start in the range given,
+// in order of time.
+func (d *MemoryDatabase) queryRollups(agentID int, variableID int, resolution time.Duration, fromDate time.Time, toDate time.Time) []DataRollup {
+	rollups := []DataRollup{}
+
+	d.read(func() {
+		for key, rollup := range d.store.rollups {
+			if key.Resolution == resolution && key.AgentID == agentID && key.VariableID == variableID &&
+				!rollup.Time.Before(fromDate) && !rollup.Time.After(toDate) {
+				rollups = append(rollups, rollup)
+			}
+		}
+	})
+
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Time.Before(rollups[j].Time) })
+
+	return rollups
+}
+
+// RollUpData summarises the variable's good data from before the given time into hourly and daily rollups, adding to
+// any rollups already made for the same periods, and then removes the data. Suspect and bad points are kept, because
+// they are not in the rollups and could still be corrected. It returns the number of points removed.
+func (d *MemoryDatabase) RollUpData(variableID int, before time.Time) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	points := []DataPoint{}
+
+	for key, point := range d.store.data {
+		if key.VariableID == variableID && point.Time.Before(before) && (point.Quality == dataQualityGood || point.Quality == dataQualityCorrected) {
+			points = append(points, point)
+			d.set(d.store.data, key, nil)
+			d.set(d.store.dataSequenceNumbers, key, nil)
+		}
+	}
+
+	for _, resolution := range rollupResolutions {
+		for _, rollup := range rollUpPoints(points, resolution) {
+			key := memoryRollupKey{resolution, dataPointKey{rollup.AgentID, rollup.VariableID, rollup.Time.UnixNano()}}
+
+			if existing, ok := d.store.rollups[key]; ok {
+				rollup = existing.Merge(rollup)
+			}
+
+			d.set(d.store.rollups, key, rollup)
+		}
+	}
+
+	return len(points), nil
+}
+
+// StreamData calls callback with each data point for the given variables in turn, ordered by time and then variable ID.
+// If callback returns an error, no further points are given and the error is returned. The points are found before
+// callback is first called, so that a slow callback does not hold up other sessions.
+func (d *MemoryDatabase) StreamData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) error {
+	for _, point := range d.queryData(agentID, variableIDs, fromDate, toDate, includeFlagged) {
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetLastDataSequenceNumber returns the highest sequence number given to the agent's data, or 0 if there is none.
+func (d *MemoryDatabase) GetLastDataSequenceNumber(agentID int) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	last := 0
+
+	for key, sequenceNumber := range d.store.dataSequenceNumbers {
+		if key.AgentID == agentID && sequenceNumber > last {
+			last = sequenceNumber
+		}
+	}
+
+	return last, nil
+}
+
+// StreamDataSavedAfter calls callback with each of the agent's points that was saved after the point with the given
+// sequence number, in the order they were saved, stopping after limit points. Points are given a new sequence number
+// when they are overwritten.
+func (d *MemoryDatabase) StreamDataSavedAfter(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	points := []DataPoint{}
+
+	for key, saved := range d.store.dataSequenceNumbers {
+		if key.AgentID == agentID && saved > sequenceNumber {
+			point := d.store.data[key]
+			point.SequenceNumber = saved
+			points = append(points, point)
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].SequenceNumber < points[j].SequenceNumber })
+
+	if len(points) > limit {
+		points = points[:limit]
+	}
+
+	for _, point := range points {
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDataPoint returns the point for the agent and variable at the given time, and false if there is none. Nothing
+// else can change the point until the end of the transaction, as each transaction holds the database's write lock.
+func (d *MemoryDatabase) GetDataPoint(agentID int, variableID int, t time.Time) (DataPoint, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return DataPoint{}, false, err
+	}
+
+	point, ok := d.store.data[dataPointKey{agentID, variableID, t.UnixNano()}]
+
+	return point, ok, nil
+}
+
+// GetLatestData returns the most recent data point for each variable the agent has reported, keyed by variable ID.
+func (d *MemoryDatabase) GetLatestData(agentID int) (map[int]DataPoint, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	m := map[int]DataPoint{}
+
+	for key, point := range d.store.data {
+		if key.AgentID != agentID {
+			continue
+		}
+
+		if latest, ok := m[key.VariableID]; !ok || point.Time.After(latest.Time) {
+			m[key.VariableID] = point
+		}
+	}
+
+	return m, nil
+}
+
+// AddDataCorrection records a change made by hand to a data point.
+func (d *MemoryDatabase) AddDataCorrection(correction *DataCorrection) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	correction.CorrectionID = d.store.correctionIDs.next()
+	d.set(d.store.dataCorrections, correction.CorrectionID, *correction)
+
+	return nil
+}
+
+// GetDataCorrections returns every change made by hand to the agent's data, most recent first.
+func (d *MemoryDatabase) GetDataCorrections(agentID int) ([]DataCorrection, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	corrections := []DataCorrection{}
+
+	for _, correction := range d.store.dataCorrections {
+		if correction.AgentID == agentID {
+			corrections = append(corrections, correction)
+		}
+	}
+
+	sort.Slice(corrections, func(i, j int) bool {
+		if !corrections[i].Created.Equal(corrections[j].Created) {
+			return corrections[i].Created.After(corrections[j].Created)
+		}
+
+		return corrections[i].CorrectionID > corrections[j].CorrectionID
+	})
+
+	return corrections, nil
+}
+
+func (d *MemoryDatabase) CreateUser(user *User) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	for _, existing := range d.store.users {
+		if existing.Email == user.Email {
+			return fmt.Errorf("There is already a user with email '%s'.", user.Email)
+		}
+	}
+
+	user.UserID = d.store.userIDs.next()
+	d.set(d.store.users, user.UserID, copyUser(*user))
+
+	return nil
+}
+
+func (d *MemoryDatabase) GetUserByEmail(email string) (User, error) {
+	var user User
+	found := false
+
+	d.read(func() {
+		for _, existing := range d.store.users {
+			if existing.Email == email {
+				user, found = copyUser(existing), true
+			}
+		}
+	})
+
+	if !found {
+		return User{}, fmt.Errorf("Cannot find user with email '%s'.", email)
+	}
+
+	return user, nil
+}
+
+func (d *MemoryDatabase) GetUserByID(userID int) (User, error) {
+	var user User
+	found := false
+
+	d.read(func() {
+		if existing, ok := d.store.users[userID]; ok {
+			user, found = copyUser(existing), true
+		}
+	})
+
+	if !found {
+		return User{}, fmt.Errorf("Cannot find user with ID %d.", userID)
+	}
+
+	return user, nil
+}
+
+func (d *MemoryDatabase) CreateSession(session *Session) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.users[session.UserID]; !ok {
+		return fmt.Errorf("Cannot find user with ID %d.", session.UserID)
+	}
+
+	session.SessionID = d.store.sessionIDs.next()
+
+	saved := *session
+	saved.TokenHash = copyBytes(session.TokenHash)
+	d.set(d.store.sessions, session.SessionID, saved)
+
+	return nil
+}
+
+func (d *MemoryDatabase) GetSessionByTokenHash(tokenHash []byte) (Session, error) {
+	var session Session
+	found := false
+
+	d.read(func() {
+		for _, existing := range d.store.sessions {
+			if bytes.Equal(existing.TokenHash, tokenHash) {
+				session, found = existing, true
+			}
+		}
+	})
+
+	if !found {
+		return Session{}, errors.New("Cannot find session with the given token.")
+	}
+
+	session.TokenHash = copyBytes(session.TokenHash)
+
+	return session, nil
+}
+
+func (d *MemoryDatabase) DeleteSession(sessionID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.sessions[sessionID]; ok {
+		d.set(d.store.sessions, sessionID, nil)
+	}
+
+	return nil
+}
+
+func (d *MemoryDatabase) CreateAlertRule(rule *AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.agents[rule.AgentID]; !ok {
+		return fmt.Errorf("Cannot find agent with ID %d.", rule.AgentID)
+	}
+
+	if _, ok := d.store.variables[rule.VariableID]; !ok {
+		return fmt.Errorf("Cannot find variable with ID %d.", rule.VariableID)
+	}
+
+	rule.RuleID = d.store.ruleIDs.next()
+	d.set(d.store.alertRules, rule.RuleID, *rule)
+
+	return nil
+}
+
+// GetAlertRulesForAgent returns the agent's alert rules. Concurrent requests evaluate the rules one after the other,
+// as each transaction holds the database's write lock.
+func (d *MemoryDatabase) GetAlertRulesForAgent(agentID int) ([]AlertRule, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rules := []AlertRule{}
+
+	for _, rule := range d.store.alertRules {
+		if rule.AgentID == agentID {
+			rule.Variable = d.store.variables[rule.VariableID].Name
+			rules = append(rules, rule)
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].RuleID < rules[j].RuleID })
+
+	return rules, nil
+}
+
+// UpdateAlertRuleState saves the state of an existing alert rule. The rest of the rule is left unchanged.
+func (d *MemoryDatabase) UpdateAlertRuleState(rule AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	existing, ok := d.store.alertRules[rule.RuleID]
+
+	if !ok {
+		return fmt.Errorf("Cannot find alert rule with ID %d.", rule.RuleID)
+	}
+
+	existing.State = rule.State
+	existing.PendingSince = rule.PendingSince
+	existing.LastValue = rule.LastValue
+	existing.LastTime = rule.LastTime
+	d.set(d.store.alertRules, rule.RuleID, existing)
+
+	return nil
+}
+
+// DeleteAlertRule removes an alert rule and its history.
+func (d *MemoryDatabase) DeleteAlertRule(ruleID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.alertRules[ruleID]; !ok {
+		return fmt.Errorf("Cannot find alert rule with ID %d.", ruleID)
+	}
+
+	d.deleteAlertRule(ruleID)
+
+	return nil
+}
+
+func (d *MemoryDatabase) deleteAlertRule(ruleID int) {
+	for historyID, entry := range d.store.alertHistory {
+		if entry.RuleID == ruleID {
+			d.set(d.store.alertHistory, historyID, nil)
+		}
+	}
+
+	d.set(d.store.alertRules, ruleID, nil)
+}
+
+func (d *MemoryDatabase) AddAlertHistory(entry *AlertHistoryEntry) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, ok := d.store.alertRules[entry.RuleID]; !ok {
+		return fmt.Errorf("Cannot find alert rule with ID %d.", entry.RuleID)
+	}
+
+	entry.HistoryID = d.store.historyIDs.next()
+	d.set(d.store.alertHistory, entry.HistoryID, *entry)
+
+	return nil
+}
+
+// GetAlertHistory returns the history of an alert rule, most recent first.
+func (d *MemoryDatabase) GetAlertHistory(ruleID int) ([]AlertHistoryEntry, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	history := []AlertHistoryEntry{}
+
+	for _, entry := range d.store.alertHistory {
+		if entry.RuleID == ruleID {
+			history = append(history, entry)
+		}
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		if !history[i].Time.Equal(history[j].Time) {
+			return history[i].Time.After(history[j].Time)
+		}
+
+		return history[i].HistoryID > history[j].HistoryID
+	})
+
+	return history, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemoryDatabase", func() {
+	var db Database
+
+	BeforeEach(func() {
+		var err error
+		db, err = connectToDatabase("memory:")
+
+		if err != nil {
+			Fail("Cannot open test database: " + err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		db.Close()
+	})
+
+	Describe("connectToMemoryDatabase", func() {
+		It("returns a new database each time when no name is given", func() {
+			other, err := connectToMemoryDatabase("")
+			Expect(err).To(BeNil())
+			defer other.Close()
+
+			insertFixtures(db, User{UserID: 3001, Email: "blah@blah.com"})
+
+			_, err = other.GetUserByID(3001)
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("returns databases that share the same data when given the same name", func() {
+			first, err := connectToMemoryDatabase("test")
+			Expect(err).To(BeNil())
+			defer first.Close()
+
+			second, err := connectToMemoryDatabase("test")
+			Expect(err).To(BeNil())
+			defer second.Close()
+
+			insertFixtures(first, User{UserID: 3001, Email: "blah@blah.com"})
+
+			user, err := second.GetUserByID(3001)
+			Expect(err).To(BeNil())
+			Expect(user.Email).To(Equal("blah@blah.com"))
+		})
+
+		It("discards a named database once every connection to it has been closed", func() {
+			first, err := connectToMemoryDatabase("test")
+			Expect(err).To(BeNil())
+
+			insertFixtures(first, User{UserID: 3001, Email: "blah@blah.com"})
+			first.Close()
+
+			second, err := connectToMemoryDatabase("test")
+			Expect(err).To(BeNil())
+			defer second.Close()
+
+			_, err = second.GetUserByID(3001)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("NewSession", func() {
+		It("returns a database that waits for a transaction in progress in another session to finish before beginning its own", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+
+			began := make(chan error, 1)
+			session := db.NewSession()
+
+			go func() {
+				began <- session.BeginTransaction()
+			}()
+
+			Consistently(began).ShouldNot(Receive())
+			Expect(db.RollbackTransaction()).To(BeNil())
+			Eventually(began).Should(Receive(BeNil()))
+			Expect(session.RollbackTransaction()).To(BeNil())
+		})
+	})
+
+	Describe("insertFixtures", func() {
+		It("does not hand out the IDs of fixtures to rows created afterwards", func() {
+			insertFixtures(db, User{UserID: 3001, Email: "blah@blah.com"})
+
+			user := User{Email: "other@blah.com"}
+			Expect(db.BeginTransaction()).To(BeNil())
+			Expect(db.CreateUser(&user)).To(Succeed())
+			Expect(db.CommitTransaction()).To(BeNil())
+
+			Expect(user.UserID).To(Equal(3002))
+		})
+	})
+
+	describeDatabaseBehaviour(databaseTestBackend{
+		database:       func() Database { return db },
+		createTestData: func(db Database) { insertFixtures(db, testDataFixtures()...) },
+	})
+})
+
+// testDataFixtures returns the data created by CreateTestData, for use with insertFixtures.
+func testDataFixtures() []interface{} {
+	return []interface{}{
+		User{UserID: 3001, Email: "blah@blah.com", Created: time.Date(2015, 3, 30, 1, 58, 0, 0, time.UTC)},
+		Agent{AgentID: 1001, Name: "First agent", OwnerUserID: 3001, TokenIterations: 12301, TokenSalt: []byte("salt1001"), TokenHash: []byte("hash1001"), Created: time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)},
+		Agent{AgentID: 1002, Name: "Second agent", OwnerUserID: 3001, TokenIterations: 12302, TokenSalt: []byte("salt1002"), TokenHash: []byte("hash1002"), Created: time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC)},
+		Variable{VariableID: 2001, Name: "distance", Units: "metres", DisplayDecimalPlaces: 2, Created: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+		Variable{VariableID: 2002, Name: "humidity", Units: "%", DisplayDecimalPlaces: 2, Created: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1001, VariableID: 2001, Value: 100, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1001, VariableID: 2002, Value: 101, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1001, VariableID: 2002, Value: 103, Time: time.Date(2015, 4, 7, 15, 1, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1001, VariableID: 2002, Value: 104, Time: time.Date(2015, 4, 7, 15, 2, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1001, VariableID: 2002, Value: 105, Time: time.Date(2015, 4, 7, 15, 3, 0, 0, time.UTC)},
+		DataPoint{AgentID: 1002, VariableID: 2001, Value: 102, Time: time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)},
+	}
+}
+
+// insertFixtures adds users, agents, variables and data points to an in-memory database with the IDs they are given,
+// the way tests for SQL databases insert rows directly. Rows with no creation time are created now.
+func insertFixtures(db Database, fixtures ...interface{}) {
+	store := db.(*MemoryDatabase).store
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	useID := func(sequence *memorySequence, id int) {
+		if id > sequence.last {
+			sequence.last = id
+		}
+	}
+
+	created := func(t time.Time) time.Time {
+		if t.IsZero() {
+			return time.Now()
+		}
+
+		return t
+	}
+
+	for _, fixture := range fixtures {
+		switch fixture := fixture.(type) {
+		case User:
+			fixture.Created = created(fixture.Created)
+			store.users[fixture.UserID] = copyUser(fixture)
+			useID(&store.userIDs, fixture.UserID)
+		case Agent:
+			fixture.Created = created(fixture.Created)
+			store.agents[fixture.AgentID] = copyAgent(fixture)
+			useID(&store.agentIDs, fixture.AgentID)
+		case Variable:
+			fixture.Created = created(fixture.Created)
+			store.variables[fixture.VariableID] = copyVariable(fixture)
+			useID(&store.variableIDs, fixture.VariableID)
+		case DataPoint:
+			fixture.Quality = fixture.QualityFlag()
+			store.data[fixture.key()] = fixture
+			store.dataSequenceNumbers[fixture.key()] = store.dataSequence.next()
+		default:
+			panic(fmt.Sprintf("Cannot insert fixture of type %T.", fixture))
+		}
+	}
+}
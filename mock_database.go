@@ -49,6 +49,16 @@ func (_mr *_MockDatabaseRecorder) Close() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Close")
 }
 
+func (_m *MockDatabase) NewSession() Database {
+	ret := _m.ctrl.Call(_m, "NewSession")
+	ret0, _ := ret[0].(Database)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) NewSession() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NewSession")
+}
+
 func (_m *MockDatabase) BeginTransaction() error {
 	ret := _m.ctrl.Call(_m, "BeginTransaction")
 	ret0, _ := ret[0].(error)
@@ -119,15 +129,98 @@ func (_mr *_MockDatabaseRecorder) CreateAgent(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateAgent", arg0)
 }
 
-func (_m *MockDatabase) GetAllAgents() ([]Agent, error) {
-	ret := _m.ctrl.Call(_m, "GetAllAgents")
+func (_m *MockDatabase) GetAllAgents(filter AgentFilter) ([]Agent, error) {
+	ret := _m.ctrl.Call(_m, "GetAllAgents", filter)
+	ret0, _ := ret[0].([]Agent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAllAgents(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAllAgents", arg0)
+}
+
+func (_m *MockDatabase) GetAgentsForUser(userID int, filter AgentFilter) ([]Agent, error) {
+	ret := _m.ctrl.Call(_m, "GetAgentsForUser", userID, filter)
+	ret0, _ := ret[0].([]Agent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAgentsForUser(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAgentsForUser", arg0, arg1)
+}
+
+func (_m *MockDatabase) UpdateAgent(agent Agent) error {
+	ret := _m.ctrl.Call(_m, "UpdateAgent", agent)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) UpdateAgent(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAgent", arg0)
+}
+
+func (_m *MockDatabase) UpdateAgentToken(agent Agent) error {
+	ret := _m.ctrl.Call(_m, "UpdateAgentToken", agent)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) UpdateAgentToken(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAgentToken", arg0)
+}
+
+func (_m *MockDatabase) DeleteAgent(agentID int) error {
+	ret := _m.ctrl.Call(_m, "DeleteAgent", agentID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) DeleteAgent(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteAgent", arg0)
+}
+
+func (_m *MockDatabase) SoftDeleteAgent(agentID int, deleted time.Time) error {
+	ret := _m.ctrl.Call(_m, "SoftDeleteAgent", agentID, deleted)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) SoftDeleteAgent(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SoftDeleteAgent", arg0, arg1)
+}
+
+func (_m *MockDatabase) UpdateAgentLastSeen(agentID int, seen time.Time) error {
+	ret := _m.ctrl.Call(_m, "UpdateAgentLastSeen", agentID, seen)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) UpdateAgentLastSeen(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAgentLastSeen", arg0, arg1)
+}
+
+func (_m *MockDatabase) MarkAgentsOffline(now time.Time) ([]Agent, error) {
+	ret := _m.ctrl.Call(_m, "MarkAgentsOffline", now)
 	ret0, _ := ret[0].([]Agent)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockDatabaseRecorder) GetAllAgents() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAllAgents")
+func (_mr *_MockDatabaseRecorder) MarkAgentsOffline(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MarkAgentsOffline", arg0)
+}
+
+func (_m *MockDatabase) MarkAgentsOnline() ([]Agent, error) {
+	ret := _m.ctrl.Call(_m, "MarkAgentsOnline")
+	ret0, _ := ret[0].([]Agent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) MarkAgentsOnline() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "MarkAgentsOnline")
 }
 
 func (_m *MockDatabase) CreateVariable(variable *Variable) error {
@@ -140,14 +233,59 @@ func (_mr *_MockDatabaseRecorder) CreateVariable(arg0 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateVariable", arg0)
 }
 
-func (_m *MockDatabase) AddDataPoint(dataPoint DataPoint) error {
-	ret := _m.ctrl.Call(_m, "AddDataPoint", dataPoint)
-	ret0, _ := ret[0].(error)
-	return ret0
+func (_m *MockDatabase) UpdateVariableLimits(variableID int, limits VariableLimits) (bool, error) {
+	ret := _m.ctrl.Call(_m, "UpdateVariableLimits", variableID, limits)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) UpdateVariableLimits(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateVariableLimits", arg0, arg1)
+}
+
+func (_m *MockDatabase) UpdateVariableRetention(variableID int, retention VariableRetention) (bool, error) {
+	ret := _m.ctrl.Call(_m, "UpdateVariableRetention", variableID, retention)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-func (_mr *_MockDatabaseRecorder) AddDataPoint(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddDataPoint", arg0)
+func (_mr *_MockDatabaseRecorder) UpdateVariableRetention(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateVariableRetention", arg0, arg1)
+}
+
+func (_m *MockDatabase) GetAllVariables() ([]Variable, error) {
+	ret := _m.ctrl.Call(_m, "GetAllVariables")
+	ret0, _ := ret[0].([]Variable)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAllVariables() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAllVariables")
+}
+
+func (_m *MockDatabase) AddDataPoint(dataPoint DataPoint, conflictPolicy string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "AddDataPoint", dataPoint, conflictPolicy)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) AddDataPoint(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddDataPoint", arg0, arg1)
+}
+
+func (_m *MockDatabase) AddDataPoints(dataPoints []DataPoint, conflictPolicy string) ([]bool, error) {
+	ret := _m.ctrl.Call(_m, "AddDataPoints", dataPoints, conflictPolicy)
+	ret0, _ := ret[0].([]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) AddDataPoints(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddDataPoints", arg0, arg1)
 }
 
 func (_m *MockDatabase) CheckAgentIDExists(agentID int) (bool, error) {
@@ -172,15 +310,135 @@ func (_mr *_MockDatabaseRecorder) GetVariableIDForName(arg0 interface{}) *gomock
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetVariableIDForName", arg0)
 }
 
-func (_m *MockDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time) (map[string]float64, error) {
-	ret := _m.ctrl.Call(_m, "GetData", agentID, variableID, fromDate, toDate)
+func (_m *MockDatabase) GetVariablesByName(names []string) (map[string]Variable, error) {
+	ret := _m.ctrl.Call(_m, "GetVariablesByName", names)
+	ret0, _ := ret[0].(map[string]Variable)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetVariablesByName(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetVariablesByName", arg0)
+}
+
+func (_m *MockDatabase) GetPreviousValue(agentID int, variableID int, before time.Time) (float64, bool, error) {
+	ret := _m.ctrl.Call(_m, "GetPreviousValue", agentID, variableID, before)
+	ret0, _ := ret[0].(float64)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockDatabaseRecorder) GetPreviousValue(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetPreviousValue", arg0, arg1, arg2)
+}
+
+func (_m *MockDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool) (map[string]float64, error) {
+	ret := _m.ctrl.Call(_m, "GetData", agentID, variableID, fromDate, toDate, includeFlagged)
 	ret0, _ := ret[0].(map[string]float64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockDatabaseRecorder) GetData(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetData", arg0, arg1, arg2, arg3)
+func (_mr *_MockDatabaseRecorder) GetData(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetData", arg0, arg1, arg2, arg3, arg4)
+}
+
+func (_m *MockDatabase) GetAggregatedData(agentID int, variableID int, fromDate time.Time, toDate time.Time, interval time.Duration, aggregate string, includeFlagged bool) (map[string]float64, error) {
+	ret := _m.ctrl.Call(_m, "GetAggregatedData", agentID, variableID, fromDate, toDate, interval, aggregate, includeFlagged)
+	ret0, _ := ret[0].(map[string]float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAggregatedData(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAggregatedData", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+func (_m *MockDatabase) GetLatestData(agentID int) (map[int]DataPoint, error) {
+	ret := _m.ctrl.Call(_m, "GetLatestData", agentID)
+	ret0, _ := ret[0].(map[int]DataPoint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetLatestData(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetLatestData", arg0)
+}
+
+func (_m *MockDatabase) StreamData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) error {
+	ret := _m.ctrl.Call(_m, "StreamData", agentID, variableIDs, fromDate, toDate, includeFlagged, callback)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) StreamData(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "StreamData", arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+func (_m *MockDatabase) GetLastDataSequenceNumber(agentID int) (int, error) {
+	ret := _m.ctrl.Call(_m, "GetLastDataSequenceNumber", agentID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetLastDataSequenceNumber(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetLastDataSequenceNumber", arg0)
+}
+
+func (_m *MockDatabase) StreamDataSavedAfter(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) error {
+	ret := _m.ctrl.Call(_m, "StreamDataSavedAfter", agentID, sequenceNumber, limit, callback)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) StreamDataSavedAfter(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "StreamDataSavedAfter", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockDatabase) GetDataPoint(agentID int, variableID int, t time.Time) (DataPoint, bool, error) {
+	ret := _m.ctrl.Call(_m, "GetDataPoint", agentID, variableID, t)
+	ret0, _ := ret[0].(DataPoint)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockDatabaseRecorder) GetDataPoint(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDataPoint", arg0, arg1, arg2)
+}
+
+func (_m *MockDatabase) AddDataCorrection(correction *DataCorrection) error {
+	ret := _m.ctrl.Call(_m, "AddDataCorrection", correction)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) AddDataCorrection(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddDataCorrection", arg0)
+}
+
+func (_m *MockDatabase) GetDataCorrections(agentID int) ([]DataCorrection, error) {
+	ret := _m.ctrl.Call(_m, "GetDataCorrections", agentID)
+	ret0, _ := ret[0].([]DataCorrection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetDataCorrections(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDataCorrections", arg0)
+}
+
+func (_m *MockDatabase) RollUpData(variableID int, before time.Time) (int, error) {
+	ret := _m.ctrl.Call(_m, "RollUpData", variableID, before)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) RollUpData(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RollUpData", arg0, arg1)
 }
 
 func (_m *MockDatabase) GetVariableByID(variableID int) (Variable, error) {
@@ -236,3 +494,107 @@ func (_m *MockDatabase) GetUserByEmail(email string) (User, error) {
 func (_mr *_MockDatabaseRecorder) GetUserByEmail(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetUserByEmail", arg0)
 }
+
+func (_m *MockDatabase) GetUserByID(userID int) (User, error) {
+	ret := _m.ctrl.Call(_m, "GetUserByID", userID)
+	ret0, _ := ret[0].(User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetUserByID(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetUserByID", arg0)
+}
+
+func (_m *MockDatabase) CreateSession(session *Session) error {
+	ret := _m.ctrl.Call(_m, "CreateSession", session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) CreateSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateSession", arg0)
+}
+
+func (_m *MockDatabase) GetSessionByTokenHash(tokenHash []byte) (Session, error) {
+	ret := _m.ctrl.Call(_m, "GetSessionByTokenHash", tokenHash)
+	ret0, _ := ret[0].(Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetSessionByTokenHash(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetSessionByTokenHash", arg0)
+}
+
+func (_m *MockDatabase) DeleteSession(sessionID int) error {
+	ret := _m.ctrl.Call(_m, "DeleteSession", sessionID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) DeleteSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteSession", arg0)
+}
+
+func (_m *MockDatabase) CreateAlertRule(rule *AlertRule) error {
+	ret := _m.ctrl.Call(_m, "CreateAlertRule", rule)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) CreateAlertRule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateAlertRule", arg0)
+}
+
+func (_m *MockDatabase) GetAlertRulesForAgent(agentID int) ([]AlertRule, error) {
+	ret := _m.ctrl.Call(_m, "GetAlertRulesForAgent", agentID)
+	ret0, _ := ret[0].([]AlertRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAlertRulesForAgent(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAlertRulesForAgent", arg0)
+}
+
+func (_m *MockDatabase) UpdateAlertRuleState(rule AlertRule) error {
+	ret := _m.ctrl.Call(_m, "UpdateAlertRuleState", rule)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) UpdateAlertRuleState(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAlertRuleState", arg0)
+}
+
+func (_m *MockDatabase) DeleteAlertRule(ruleID int) error {
+	ret := _m.ctrl.Call(_m, "DeleteAlertRule", ruleID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) DeleteAlertRule(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteAlertRule", arg0)
+}
+
+func (_m *MockDatabase) AddAlertHistory(entry *AlertHistoryEntry) error {
+	ret := _m.ctrl.Call(_m, "AddAlertHistory", entry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDatabaseRecorder) AddAlertHistory(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddAlertHistory", arg0)
+}
+
+func (_m *MockDatabase) GetAlertHistory(ruleID int) ([]AlertHistoryEntry, error) {
+	ret := _m.ctrl.Call(_m, "GetAlertHistory", ruleID)
+	ret0, _ := ret[0].([]AlertHistoryEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDatabaseRecorder) GetAlertHistory(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAlertHistory", arg0)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/martini-contrib/render"
+)
+
+// mqttAccessWrite is the access type the broker sends to check whether a client can publish to a topic.
+const mqttAccessWrite = 2
+
+// MQTTAuthRequest is sent by the broker to check a client's credentials or access to a topic, in the format used by the
+// HTTP backend of mosquitto-go-auth. Agents connect with their agent ID as the username and their token as the password,
+// so that they never need to include the token in the messages they publish.
+type MQTTAuthRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	ClientID string `json:"clientid" form:"clientid"`
+	Topic    string `json:"topic" form:"topic"`
+	Access   int    `json:"acc" form:"acc"`
+}
+
+// authenticateMQTTUser accepts the bridge's own credentials, or an agent ID and its token. The broker only asks when a
+// client connects, rather than for every message.
+func authenticateMQTTUser(render render.Render, request MQTTAuthRequest, config Config, db Database, log *logrus.Entry) {
+	if isMQTTBridgeUser(request.Username, config) {
+		if subtle.ConstantTimeCompare([]byte(request.Password), []byte(config.MQTTPassword)) == 1 {
+			render.Status(http.StatusOK)
+		} else {
+			log.Error("MQTT authentication failed because the bridge's password is incorrect.")
+			render.Status(http.StatusForbidden)
+		}
+
+		return
+	}
+
+	agentID, err := strconv.Atoi(request.Username)
+
+	if err != nil {
+		log.WithError(err).Error("MQTT authentication failed because the username is not an agent ID.")
+		render.Status(http.StatusForbidden)
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	if _, ok, err := verifyAgentToken(db, agentID, request.Password, log); err != nil {
+		render.Error(http.StatusInternalServerError)
+		return
+	} else if !ok {
+		render.Status(http.StatusForbidden)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(http.StatusOK)
+}
+
+// checkMQTTSuperuser lets the bridge use every topic.
+func checkMQTTSuperuser(render render.Render, request MQTTAuthRequest, config Config) {
+	if isMQTTBridgeUser(request.Username, config) {
+		render.Status(http.StatusOK)
+	} else {
+		render.Status(http.StatusForbidden)
+	}
+}
+
+// checkMQTTAccess only lets an agent publish to its own topics, in the format <prefix>/<agent ID>/<variable>, so the
+// bridge can trust the agent ID in the topic of each message it receives. Agents cannot read other agents' readings.
+func checkMQTTAccess(render render.Render, request MQTTAuthRequest, config Config) {
+	if isMQTTBridgeUser(request.Username, config) {
+		render.Status(http.StatusOK)
+		return
+	}
+
+	if _, err := strconv.Atoi(request.Username); err != nil {
+		render.Status(http.StatusForbidden)
+		return
+	}
+
+	prefix := config.MQTTTopicPrefix + "/" + request.Username + "/"
+	variable := strings.TrimPrefix(request.Topic, prefix)
+
+	if request.Access == mqttAccessWrite && strings.HasPrefix(request.Topic, prefix) && variable != "" && !strings.ContainsAny(variable, "/+#") {
+		render.Status(http.StatusOK)
+	} else {
+		render.Status(http.StatusForbidden)
+	}
+}
+
+// isMQTTBridgeUser returns true if username is the bridge's. A bridge username without a password is ignored, as
+// otherwise any client that knew the username could connect as the bridge and use every topic.
+func isMQTTBridgeUser(username string, config Config) bool {
+	return config.MQTTUsername != "" && config.MQTTPassword != "" && username == config.MQTTUsername
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+)
+
+var _ = Describe("MQTT authentication", func() {
+	var mockController *gomock.Controller
+	var render *MockRender
+	var db *MockDatabase
+	var logger *logrus.Entry
+
+	config := Config{MQTTUsername: "bridge", MQTTPassword: "secret", MQTTTopicPrefix: "weather"}
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		render = NewMockRender(mockController)
+		db = NewMockDatabase(mockController)
+		logger = logrus.NewEntry(logrus.StandardLogger())
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	Describe("authenticateMQTTUser", func() {
+		It("accepts the bridge's credentials", func() {
+			render.EXPECT().Status(http.StatusOK)
+
+			authenticateMQTTUser(render, MQTTAuthRequest{Username: "bridge", Password: "secret"}, config, db, logger)
+		})
+
+		It("rejects the bridge's username with the wrong password", func() {
+			render.EXPECT().Status(http.StatusForbidden)
+
+			authenticateMQTTUser(render, MQTTAuthRequest{Username: "bridge", Password: "wrong"}, config, db, logger)
+		})
+
+		Context("when an agent connects", func() {
+			var agent Agent
+
+			BeforeEach(func() {
+				agent = Agent{AgentID: 1004}
+				agent.SetToken("thetoken")
+			})
+
+			It("accepts the agent's ID and token, and records that the agent was seen", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1004).Return(true, nil),
+					db.EXPECT().GetAgentByID(1004).Return(agent, nil),
+					db.EXPECT().UpdateAgentLastSeen(1004, gomock.Any()),
+					db.EXPECT().CommitTransaction(),
+					render.EXPECT().Status(http.StatusOK),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				authenticateMQTTUser(render, MQTTAuthRequest{Username: "1004", Password: "thetoken"}, config, db, logger)
+			})
+
+			It("rejects an incorrect token", func() {
+				gomock.InOrder(
+					db.EXPECT().BeginTransaction(),
+					db.EXPECT().CheckAgentIDExists(1004).Return(true, nil),
+					db.EXPECT().GetAgentByID(1004).Return(agent, nil),
+					render.EXPECT().Status(http.StatusForbidden),
+					db.EXPECT().RollbackUncommittedTransaction(),
+				)
+
+				authenticateMQTTUser(render, MQTTAuthRequest{Username: "1004", Password: "wrong"}, config, db, logger)
+			})
+
+			It("rejects a username that is not an agent ID", func() {
+				render.EXPECT().Status(http.StatusForbidden)
+
+				authenticateMQTTUser(render, MQTTAuthRequest{Username: "someone", Password: "thetoken"}, config, db, logger)
+			})
+		})
+
+		It("does not treat an empty username as the bridge if the bridge does not use credentials", func() {
+			render.EXPECT().Status(http.StatusForbidden)
+
+			authenticateMQTTUser(render, MQTTAuthRequest{}, Config{MQTTTopicPrefix: "weather"}, db, logger)
+		})
+
+		It("does not accept the bridge's username if the bridge does not have a password", func() {
+			render.EXPECT().Status(http.StatusForbidden)
+
+			authenticateMQTTUser(render, MQTTAuthRequest{Username: "bridge"}, Config{MQTTUsername: "bridge", MQTTTopicPrefix: "weather"}, db, logger)
+		})
+	})
+
+	Describe("checkMQTTSuperuser", func() {
+		DescribeTable("only lets the bridge use every topic", func(username string, expectedStatus int) {
+			render.EXPECT().Status(expectedStatus)
+
+			checkMQTTSuperuser(render, MQTTAuthRequest{Username: username}, config)
+		},
+			Entry("the bridge", "bridge", http.StatusOK),
+			Entry("an agent", "1004", http.StatusForbidden),
+		)
+
+		It("does not let the bridge's username use every topic if the bridge does not have a password", func() {
+			render.EXPECT().Status(http.StatusForbidden)
+
+			checkMQTTSuperuser(render, MQTTAuthRequest{Username: "bridge"}, Config{MQTTUsername: "bridge", MQTTTopicPrefix: "weather"})
+		})
+	})
+
+	Describe("checkMQTTAccess", func() {
+		DescribeTable("only lets an agent publish to its own topics", func(username string, topic string, access int, expectedStatus int) {
+			render.EXPECT().Status(expectedStatus)
+
+			checkMQTTAccess(render, MQTTAuthRequest{Username: username, Topic: topic, Access: access}, config)
+		},
+			Entry("publishing a reading", "1004", "weather/1004/temperature", mqttAccessWrite, http.StatusOK),
+			Entry("publishing as another agent", "1004", "weather/1005/temperature", mqttAccessWrite, http.StatusForbidden),
+			Entry("publishing to an agent ID that starts with the agent's", "100", "weather/1004/temperature", mqttAccessWrite, http.StatusForbidden),
+			Entry("publishing without a variable", "1004", "weather/1004/", mqttAccessWrite, http.StatusForbidden),
+			Entry("publishing to a topic with more levels", "1004", "weather/1004/temperature/extra", mqttAccessWrite, http.StatusForbidden),
+			Entry("publishing to a topic with a different prefix", "1004", "other/1004/temperature", mqttAccessWrite, http.StatusForbidden),
+			Entry("reading its own topics", "1004", "weather/1004/temperature", 1, http.StatusForbidden),
+			Entry("subscribing to every agent's readings", "1004", "weather/#", 4, http.StatusForbidden),
+			Entry("a username that is not an agent ID", "weather", "weather/weather/temperature", mqttAccessWrite, http.StatusForbidden),
+			Entry("the bridge subscribing to every agent's readings", "bridge", "weather/+/+", 4, http.StatusOK),
+		)
+	})
+})
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttQoS = 1
+const mqttTimeout = 10 * time.Second
+const mqttConnectRetryInterval = 30 * time.Second
+const mqttDisconnectQuiesceMilliseconds = 250
+
+// MQTTReading is the payload of a message published by an agent. The broker authenticates agents when they connect
+// and only lets each publish to its own topics (see mqtt_auth.go), so the message does not need to include the token.
+type MQTTReading struct {
+	Value *float64  `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// MQTTBridge subscribes to the topics agents publish readings to, in the format <prefix>/<agent ID>/<variable>, and
+// saves each reading as it arrives.
+type MQTTBridge struct {
+	client        mqtt.Client
+	db            Database
+	hub           *DataHub
+	notifier      *AlertNotifier
+	prefix        string
+	retryInterval time.Duration
+	stop          chan struct{}
+	stopped       chan struct{}
+}
+
+// NewMQTTBridge connects to the broker given in config in the background, and subscribes to readings once connected.
+// The broker being unavailable does not stop the service: the bridge keeps trying to connect until it is closed. db is
+// used to create a new session for each message, so it can be shared.
+func NewMQTTBridge(config Config, db Database, hub *DataHub, notifier *AlertNotifier) *MQTTBridge {
+	return newMQTTBridge(config, db, hub, notifier, mqttConnectRetryInterval)
+}
+
+func newMQTTBridge(config Config, db Database, hub *DataHub, notifier *AlertNotifier, retryInterval time.Duration) *MQTTBridge {
+	b := &MQTTBridge{
+		db:            db,
+		hub:           hub,
+		notifier:      notifier,
+		prefix:        config.MQTTTopicPrefix,
+		retryInterval: retryInterval,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.MQTTBrokerURL).
+		SetClientID(config.MQTTClientID).
+		SetUsername(config.MQTTUsername).
+		SetPassword(config.MQTTPassword).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logrus.WithError(err).Error("Lost connection to MQTT broker, reconnecting.")
+		}).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			// The broker forgets our subscription whenever the connection is lost, so subscribe again each time we connect.
+			b.subscribe(client)
+		})
+
+	b.client = mqtt.NewClient(options)
+
+	go b.connect()
+
+	return b
+}
+
+// connect tries to connect to the broker until it succeeds or the bridge is closed. Once connected, the client
+// reconnects by itself whenever the connection is lost.
+func (b *MQTTBridge) connect() {
+	defer close(b.stopped)
+
+	for {
+		token := b.client.Connect()
+
+		if token.Wait() && token.Error() == nil {
+			logrus.Info("Connected to MQTT broker.")
+			return
+		}
+
+		logrus.WithError(token.Error()).Errorf("Could not connect to MQTT broker, trying again in %v.", b.retryInterval)
+
+		select {
+		case <-time.After(b.retryInterval):
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Close stops trying to connect to the broker, or disconnects from it after waiting briefly for any message being
+// handled.
+func (b *MQTTBridge) Close() {
+	close(b.stop)
+	<-b.stopped
+
+	b.client.Disconnect(mqttDisconnectQuiesceMilliseconds)
+}
+
+func (b *MQTTBridge) subscribe(client mqtt.Client) {
+	token := client.Subscribe(b.prefix+"/+/+", mqttQoS, func(_ mqtt.Client, message mqtt.Message) {
+		if err := b.saveReading(message.Topic(), message.Payload()); err != nil {
+			logrus.WithError(err).WithField("topic", message.Topic()).Error("Could not save reading received over MQTT.")
+		}
+	})
+
+	if token.Wait() && token.Error() != nil {
+		logrus.WithError(token.Error()).Error("Could not subscribe to MQTT topics.")
+	}
+}
+
+func (b *MQTTBridge) saveReading(topic string, payload []byte) error {
+	agentID, variable, err := b.parseTopic(topic)
+
+	if err != nil {
+		return err
+	}
+
+	reading := MQTTReading{}
+
+	if err := json.Unmarshal(payload, &reading); err != nil {
+		return fmt.Errorf("Cannot parse message: %v", err.Error())
+	}
+
+	if reading.Value == nil {
+		return errors.New("Message must include a value.")
+	}
+
+	if reading.Time.IsZero() {
+		reading.Time = time.Now().UTC()
+	}
+
+	log := logrus.WithFields(logrus.Fields{"topic": topic, "agentId": agentID})
+
+	// Messages are handled while requests are, so they need a session of their own.
+	db := b.db.NewSession()
+
+	if err := db.BeginTransaction(); err != nil {
+		return err
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	// The broker only lets an agent publish to its own topics, so the agent ID in the topic can be trusted.
+	if exists, err := db.CheckAgentIDExists(agentID); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	agent, err := db.GetAgentByID(agentID)
+
+	if err != nil {
+		return err
+	}
+
+	// A reading shows that the agent is still running, even if it is rejected later on.
+	if err := db.UpdateAgentLastSeen(agentID, time.Now()); err != nil {
+		return err
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		return err
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		return err
+	}
+
+	// Brokers redeliver messages that were not acknowledged, so a reading that has already been saved is overwritten.
+	data := PostDataPoints{Time: reading.Time, OnConflict: conflictPolicyOverwrite, Data: []PostDataPoint{{Variable: variable, Value: *reading.Value}}}
+
+	switch status, result := saveDataPoints(data, agent, db, b.hub, b.notifier, log); status {
+	case http.StatusCreated:
+		return nil
+	case http.StatusInternalServerError:
+		return errors.New("Could not save reading.")
+	default:
+		return errors.New(firstDataPointMessage(result))
+	}
+}
+
+func (b *MQTTBridge) parseTopic(topic string) (int, string, error) {
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+
+	if !strings.HasPrefix(topic, b.prefix+"/") || len(parts) != 2 || parts[1] == "" {
+		return 0, "", fmt.Errorf("Topic '%v' is not in the format '%v/<agent ID>/<variable>'.", topic, b.prefix)
+	}
+
+	agentID, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return 0, "", fmt.Errorf("Agent ID '%v' is not an integer.", parts[0])
+	}
+
+	return agentID, parts[1], nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MQTT bridge", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var hub *DataHub
+	var notifier *AlertNotifier
+	var agent Agent
+
+	dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		hub = NewDataHub()
+		notifier = NewAlertNotifier(nil)
+
+		agent = Agent{AgentID: 1004}
+	})
+
+	AfterEach(func() {
+		notifier.Close()
+		mockController.Finish()
+	})
+
+	expectReadingSaved := func(value float64, readingTime time.Time) {
+		gomock.InOrder(
+			db.EXPECT().NewSession().Return(db),
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().CheckAgentIDExists(1004).Return(true, nil),
+			db.EXPECT().GetAgentByID(1004).Return(agent, nil),
+			db.EXPECT().UpdateAgentLastSeen(1004, gomock.Any()),
+			db.EXPECT().CommitTransaction(),
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+			db.EXPECT().AddDataPoints([]DataPoint{{AgentID: 1004, VariableID: 12, Value: value, Time: readingTime}}, "overwrite").Return([]bool{false}, nil),
+			db.EXPECT().GetAlertRulesForAgent(1004).Return([]AlertRule{}, nil),
+			db.EXPECT().GetLastDataSequenceNumber(1004).Return(77, nil),
+			db.EXPECT().CommitTransaction(),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+	}
+
+	Describe("saveReading", func() {
+		var bridge *MQTTBridge
+
+		BeforeEach(func() {
+			bridge = &MQTTBridge{db: db, hub: hub, notifier: notifier, prefix: "weather"}
+		})
+
+		It("saves the reading for the agent and variable given in the topic", func() {
+			expectReadingSaved(21.5, dataTime)
+
+			Expect(bridge.saveReading("weather/1004/temperature", []byte(`{"value":21.5,"time":"2015-05-06T10:15:30Z"}`))).To(Succeed())
+		})
+
+		It("saves the reading at the time it was received if the message does not include a time", func() {
+			before := time.Now()
+
+			gomock.InOrder(
+				db.EXPECT().NewSession().Return(db),
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(1004).Return(true, nil),
+				db.EXPECT().GetAgentByID(1004).Return(agent, nil),
+				db.EXPECT().UpdateAgentLastSeen(1004, gomock.Any()),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{"temperature": {VariableID: 12}}, nil),
+				db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Do(func(points []DataPoint, _ string) {
+					Expect(points[0].Time).To(BeTemporally(">=", before))
+					Expect(points[0].Time).To(BeTemporally("<=", time.Now()))
+				}).Return([]bool{false}, nil),
+				db.EXPECT().GetAlertRulesForAgent(1004).Return([]AlertRule{}, nil),
+				db.EXPECT().GetLastDataSequenceNumber(1004).Return(77, nil),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			Expect(bridge.saveReading("weather/1004/temperature", []byte(`{"value":21.5}`))).To(Succeed())
+		})
+
+		It("does not save the reading if the agent does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().NewSession().Return(db),
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(1004).Return(false, nil),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			Expect(bridge.saveReading("weather/1004/temperature", []byte(`{"value":21.5}`))).To(MatchError("Cannot find agent with ID 1004."))
+		})
+
+		It("returns an error if the variable does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().NewSession().Return(db),
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().CheckAgentIDExists(1004).Return(true, nil),
+				db.EXPECT().GetAgentByID(1004).Return(agent, nil),
+				db.EXPECT().UpdateAgentLastSeen(1004, gomock.Any()),
+				db.EXPECT().CommitTransaction(),
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{"nothing"}).Return(map[string]Variable{}, nil),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			Expect(bridge.saveReading("weather/1004/nothing", []byte(`{"value":21.5}`))).To(MatchError("Could not find variable with name 'nothing'."))
+		})
+
+		DescribeTable("returns an error without saving anything if the message is invalid",
+			func(topic string, payload string, expectedError string) {
+				Expect(bridge.saveReading(topic, []byte(payload))).To(MatchError(expectedError))
+			},
+			Entry("because the topic has the wrong prefix", "other/1004/temperature", `{"value":21.5}`, "Topic 'other/1004/temperature' is not in the format 'weather/<agent ID>/<variable>'."),
+			Entry("because the topic has too many levels", "weather/1004/temperature/extra", `{"value":21.5}`, "Topic 'weather/1004/temperature/extra' is not in the format 'weather/<agent ID>/<variable>'."),
+			Entry("because the agent ID is not an integer", "weather/roof/temperature", `{"value":21.5}`, "Agent ID 'roof' is not an integer."),
+			Entry("because the payload is not JSON", "weather/1004/temperature", `21.5`, "Cannot parse message: json: cannot unmarshal number into Go value of type main.MQTTReading"),
+			Entry("because the payload does not include a value", "weather/1004/temperature", `{}`, "Message must include a value."),
+		)
+	})
+
+	Context("when connected to a broker", func() {
+		var broker *testMQTTBroker
+		var bridge *MQTTBridge
+		var subscriptions []string
+
+		BeforeEach(func() {
+			broker = newTestMQTTBroker()
+
+			bridge = NewMQTTBridge(Config{
+				MQTTBrokerURL:   broker.URL(),
+				MQTTClientID:    "test-client",
+				MQTTUsername:    "bridge",
+				MQTTPassword:    "secret",
+				MQTTTopicPrefix: "weather",
+			}, db, hub, notifier)
+
+			Eventually(broker.subscribed).Should(Receive(&subscriptions))
+		})
+
+		AfterEach(func() {
+			bridge.Close()
+			broker.Close()
+		})
+
+		It("connects with the configured client ID and credentials, and subscribes to every agent's readings", func() {
+			var connect *packets.ConnectPacket
+			Expect(broker.connects).To(Receive(&connect))
+			Expect(connect.ClientIdentifier).To(Equal("test-client"))
+			Expect(connect.Username).To(Equal("bridge"))
+			Expect(string(connect.Password)).To(Equal("secret"))
+
+			Expect(subscriptions).To(Equal([]string{"weather/+/+"}))
+		})
+
+		It("saves readings as they are published and publishes them to subscribers", func() {
+			events, unsubscribe := hub.Subscribe(1004)
+			defer unsubscribe()
+
+			expectReadingSaved(21.5, dataTime)
+
+			broker.Publish("weather/1004/temperature", `{"value":21.5,"time":"2015-05-06T10:15:30Z"}`)
+
+			Eventually(events).Should(Receive(Equal(DataEvent{
+				ID:      77,
+				AgentID: 1004,
+				Points:  []DataEventPoint{{VariableID: 12, Variable: "temperature", Time: dataTime, Value: 21.5}},
+			})))
+		})
+
+		It("subscribes again after reconnecting to the broker", func() {
+			broker.DisconnectAll()
+			Eventually(broker.subscribed, 5*time.Second).Should(Receive(Equal([]string{"weather/+/+"})))
+
+			events, unsubscribe := hub.Subscribe(1004)
+			defer unsubscribe()
+
+			expectReadingSaved(22, dataTime)
+
+			broker.Publish("weather/1004/temperature", `{"value":22,"time":"2015-05-06T10:15:30Z"}`)
+
+			Eventually(events).Should(Receive())
+		})
+	})
+
+	Context("when the broker is unavailable", func() {
+		var address string
+		var bridge *MQTTBridge
+
+		BeforeEach(func() {
+			unavailable := newTestMQTTBroker()
+			address = unavailable.listener.Addr().String()
+			unavailable.Close()
+
+			config := Config{MQTTBrokerURL: "tcp://" + address, MQTTClientID: "test-client", MQTTTopicPrefix: "weather"}
+			bridge = newMQTTBridge(config, db, hub, notifier, 50*time.Millisecond)
+		})
+
+		It("keeps trying to connect until the broker is available", func() {
+			time.Sleep(100 * time.Millisecond)
+
+			broker := newTestMQTTBrokerOn(address)
+			defer broker.Close()
+
+			Eventually(broker.subscribed, 5*time.Second).Should(Receive(Equal([]string{"weather/+/+"})))
+
+			bridge.Close()
+		})
+
+		It("stops trying to connect when closed", func() {
+			bridge.Close()
+
+			broker := newTestMQTTBrokerOn(address)
+			defer broker.Close()
+
+			Consistently(broker.connects, 200*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
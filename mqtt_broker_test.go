@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	. "github.com/onsi/gomega"
+)
+
+// testMQTTBroker is just enough of an MQTT broker to test against: it accepts any client, and delivers each message
+// published with Publish to every client subscribed to a matching topic.
+type testMQTTBroker struct {
+	listener      net.Listener
+	lock          sync.Mutex
+	subscriptions map[net.Conn][]string
+	connects      chan *packets.ConnectPacket
+	subscribed    chan []string
+}
+
+func newTestMQTTBroker() *testMQTTBroker {
+	return newTestMQTTBrokerOn("127.0.0.1:0")
+}
+
+func newTestMQTTBrokerOn(address string) *testMQTTBroker {
+	listener, err := net.Listen("tcp", address)
+	Expect(err).To(BeNil())
+
+	b := &testMQTTBroker{
+		listener:      listener,
+		subscriptions: map[net.Conn][]string{},
+		connects:      make(chan *packets.ConnectPacket, 10),
+		subscribed:    make(chan []string, 10),
+	}
+
+	go b.accept()
+
+	return b
+}
+
+func (b *testMQTTBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Publish sends a message to every subscribed client.
+func (b *testMQTTBroker) Publish(topic string, payload string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	message := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	message.TopicName = topic
+	message.Payload = []byte(payload)
+
+	for conn, filters := range b.subscriptions {
+		for _, filter := range filters {
+			if testMQTTTopicMatches(filter, topic) {
+				message.Write(conn)
+				break
+			}
+		}
+	}
+}
+
+// DisconnectAll drops the connection to every client, as if the broker had restarted.
+func (b *testMQTTBroker) DisconnectAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for conn := range b.subscriptions {
+		conn.Close()
+		delete(b.subscriptions, conn)
+	}
+}
+
+func (b *testMQTTBroker) Close() {
+	b.listener.Close()
+	b.DisconnectAll()
+}
+
+func (b *testMQTTBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		b.lock.Lock()
+		b.subscriptions[conn] = []string{}
+		b.lock.Unlock()
+
+		go b.serve(conn)
+	}
+}
+
+func (b *testMQTTBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.connects <- p
+			b.write(conn, packets.NewControlPacket(packets.Connack))
+
+		case *packets.SubscribePacket:
+			b.lock.Lock()
+			b.subscriptions[conn] = append(b.subscriptions[conn], p.Topics...)
+			b.lock.Unlock()
+
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			b.write(conn, ack)
+			b.subscribed <- p.Topics
+
+		case *packets.PingreqPacket:
+			b.write(conn, packets.NewControlPacket(packets.Pingresp))
+
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testMQTTBroker) write(conn net.Conn, packet packets.ControlPacket) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	packet.Write(conn)
+}
+
+func testMQTTTopicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -2,28 +2,52 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/rubenv/sql-migrate"
 )
 
+// aggregateExpressions maps each aggregate supported by GetAggregatedData to the SQL used to compute it from rollups,
+// where each data point is given as a rollup of just that point.
+var aggregateExpressions = map[string]string{
+	"min":   "MIN(min_value)",
+	"max":   "MAX(max_value)",
+	"avg":   "SUM(avg_value * count) / SUM(count)",
+	"sum":   "SUM(avg_value * count)",
+	"count": "SUM(count)",
+	"first": "(ARRAY_AGG(avg_value ORDER BY time ASC))[1]",
+	"last":  "(ARRAY_AGG(avg_value ORDER BY time DESC))[1]",
+}
+
+// rollupTables maps each rollup resolution to the table the rollups are stored in.
+var rollupTables = map[time.Duration]string{
+	rollupResolutionHourly: "data_hourly",
+	rollupResolutionDaily:  "data_daily",
+}
+
 type PostgresDatabase struct {
 	DatabaseHandle     *sql.DB
 	CurrentTransaction *sql.Tx
+	features           *postgresFeatures
 }
 
-func connectToDatabase(dataSourceName string) (Database, error) {
+func connectToPostgresDatabase(dataSourceName string) (Database, error) {
 	db, err := sql.Open("postgres", dataSourceName)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &PostgresDatabase{DatabaseHandle: db}, nil
+	return &PostgresDatabase{DatabaseHandle: db, features: &postgresFeatures{}}, nil
 }
 
+// RunMigrations applies any pending migrations, and then sets up TimescaleDB if the database has the extension.
 func (d *PostgresDatabase) RunMigrations() (int, error) {
 	migrationSource := getMigrationSource()
 
@@ -33,6 +57,10 @@ func (d *PostgresDatabase) RunMigrations() (int, error) {
 		return 0, err
 	}
 
+	if err := d.setUpTimescaleDB(); err != nil {
+		return 0, err
+	}
+
 	return n, nil
 }
 
@@ -40,6 +68,12 @@ func (d *PostgresDatabase) Close() {
 	d.DatabaseHandle.Close()
 }
 
+// NewSession returns a Database that shares this database's connection pool, but has its own transaction state.
+// Sessions should not be closed, as doing so closes the shared connection pool.
+func (d *PostgresDatabase) NewSession() Database {
+	return &PostgresDatabase{DatabaseHandle: d.DatabaseHandle, features: d.features}
+}
+
 func (d *PostgresDatabase) DB() *sql.DB {
 	return d.DatabaseHandle
 }
@@ -102,20 +136,120 @@ func (d *PostgresDatabase) CreateAgent(agent *Agent) error {
 		return err
 	}
 
+	metadata, err := encodeAgentMetadata(agent.Metadata)
+
+	if err != nil {
+		return err
+	}
+
 	row := d.CurrentTransaction.QueryRow(
-		"INSERT INTO agents (name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING agent_id",
+		"INSERT INTO agents (name, owner_user_id, token_iterations, token_salt, token_hash, metadata, offline_after, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING agent_id",
 		agent.Name,
 		agent.OwnerUserID,
 		agent.TokenIterations,
 		agent.TokenSalt,
 		agent.TokenHash,
+		metadata,
+		int64(agent.OfflineAfter),
 		agent.Created)
 
 	return row.Scan(&agent.AgentID)
 }
 
-func (d *PostgresDatabase) GetAllAgents() ([]Agent, error) {
-	rows, err := d.DB().Query("SELECT agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created FROM agents;")
+// UpdateAgent saves the name, metadata and offline interval of an existing agent.
+func (d *PostgresDatabase) UpdateAgent(agent Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	metadata, err := encodeAgentMetadata(agent.Metadata)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET name = $2, metadata = $3, offline_after = $4 WHERE agent_id = $1 AND deleted IS NULL;",
+		agent.AgentID, agent.Name, metadata, int64(agent.OfflineAfter))
+
+	return checkAgentRowAffected(result, err, agent.AgentID)
+}
+
+// DeleteAgent removes an agent and all of the data it has reported.
+func (d *PostgresDatabase) DeleteAgent(agentID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, err := d.CurrentTransaction.Exec("DELETE FROM data WHERE agent_id = $1;", agentID); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("DELETE FROM agents WHERE agent_id = $1;", agentID)
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// SoftDeleteAgent hides an agent from all other queries, but keeps it and the data it has reported in the database.
+func (d *PostgresDatabase) SoftDeleteAgent(agentID int, deleted time.Time) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET deleted = $2 WHERE agent_id = $1 AND deleted IS NULL;", agentID, deleted)
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// UpdateAgentToken saves the current and previous tokens of an existing agent.
+func (d *PostgresDatabase) UpdateAgentToken(agent Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET token_iterations = $2, token_salt = $3, token_hash = $4, "+
+		"previous_token_iterations = $5, previous_token_salt = $6, previous_token_hash = $7, previous_token_expires = $8 "+
+		"WHERE agent_id = $1 AND deleted IS NULL;",
+		agent.AgentID, agent.TokenIterations, agent.TokenSalt, agent.TokenHash,
+		agent.PreviousTokenIterations, agent.PreviousTokenSalt, agent.PreviousTokenHash, nullableTime(agent.PreviousTokenExpires))
+
+	return checkAgentRowAffected(result, err, agent.AgentID)
+}
+
+// UpdateAgentLastSeen records that the agent was seen at the given time, unless it has already been seen since then.
+func (d *PostgresDatabase) UpdateAgentLastSeen(agentID int, seen time.Time) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET last_seen = GREATEST(last_seen, $2) WHERE agent_id = $1 AND deleted IS NULL;", agentID, seen)
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// MarkAgentsOffline marks each agent that has not been seen within its offline interval as offline, and returns them.
+// Agents that have never been seen are measured from when they were created.
+func (d *PostgresDatabase) MarkAgentsOffline(now time.Time) ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	return d.updateAgents("UPDATE agents SET offline_since = $1 WHERE deleted IS NULL AND offline_since IS NULL AND offline_after > 0 "+
+		"AND COALESCE(last_seen, created) + offline_after / 1000 * INTERVAL '1 microsecond' < $1 "+
+		"RETURNING "+agentColumns+";", now)
+}
+
+// MarkAgentsOnline clears the offline flag of each agent that has been seen since it was marked as offline, and returns them.
+func (d *PostgresDatabase) MarkAgentsOnline() ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	return d.updateAgents("UPDATE agents SET offline_since = NULL WHERE deleted IS NULL AND last_seen > offline_since " +
+		"RETURNING " + agentColumns + ";")
+}
+
+func (d *PostgresDatabase) updateAgents(query string, args ...interface{}) ([]Agent, error) {
+	rows, err := d.CurrentTransaction.Query(query, args...)
 
 	if err != nil {
 		return nil, err
@@ -125,9 +259,9 @@ func (d *PostgresDatabase) GetAllAgents() ([]Agent, error) {
 	agents := []Agent{}
 
 	for rows.Next() {
-		agent := Agent{}
+		agent, err := scanAgent(rows)
 
-		if err := rows.Scan(&agent.AgentID, &agent.Name, &agent.OwnerUserID, &agent.TokenIterations, &agent.TokenSalt, &agent.TokenHash, &agent.Created); err != nil {
+		if err != nil {
 			return nil, err
 		}
 
@@ -141,117 +275,188 @@ func (d *PostgresDatabase) GetAllAgents() ([]Agent, error) {
 	return agents, nil
 }
 
-func (d *PostgresDatabase) CreateVariable(variable *Variable) error {
-	if err := d.ensureTransaction(); err != nil {
+func checkAgentRowAffected(result sql.Result, err error, agentID int) error {
+	if err != nil {
 		return err
 	}
 
-	row := d.CurrentTransaction.QueryRow("INSERT INTO variables (name, units, display_decimal_places, created) "+
-		"VALUES ($1, $2, $3, $4) RETURNING variable_id", variable.Name, variable.Units, variable.DisplayDecimalPlaces, variable.Created)
-	return row.Scan(&variable.VariableID)
-}
+	n, err := result.RowsAffected()
 
-func (d *PostgresDatabase) AddDataPoint(dataPoint DataPoint) error {
-	if err := d.ensureTransaction(); err != nil {
+	if err != nil {
 		return err
 	}
 
-	_, err := d.CurrentTransaction.Exec("INSERT INTO data (agent_id, variable_id, time, value) VALUES ($1, $2, $3, $4);", dataPoint.AgentID, dataPoint.VariableID, dataPoint.Time, dataPoint.Value)
-	return err
+	if n == 0 {
+		return fmt.Errorf("Cannot find agent with ID %d.", agentID)
+	}
+
+	return nil
 }
 
-func (d *PostgresDatabase) CheckAgentIDExists(agentID int) (bool, error) {
-	if err := d.ensureTransaction(); err != nil {
-		return false, err
+func encodeAgentMetadata(metadata map[string]string) ([]byte, error) {
+	if metadata == nil {
+		metadata = map[string]string{}
 	}
 
-	row := d.CurrentTransaction.QueryRow("SELECT COUNT(*) FROM agents WHERE agent_id = $1;", agentID)
-	count := 0
+	return json.Marshal(metadata)
+}
 
-	if err := row.Scan(&count); err != nil {
-		return false, err
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
 
-	return (count > 0), nil
+	return &t
 }
 
-func (d *PostgresDatabase) GetVariableIDForName(name string) (int, error) {
-	if err := d.ensureTransaction(); err != nil {
-		return 0, err
-	}
+const agentColumns = "agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, " +
+	"previous_token_iterations, previous_token_salt, previous_token_hash, previous_token_expires, metadata, created, " +
+	"last_seen, offline_after, offline_since"
 
-	rows, err := d.CurrentTransaction.Query("SELECT variable_id FROM variables WHERE name = $1;", name)
+func scanAgent(row scanner) (Agent, error) {
+	agent := Agent{}
+	var metadata []byte
+	var previousTokenExpires *time.Time
+	var offlineAfter int64
 
-	if err != nil {
-		return 0, err
+	if err := row.Scan(&agent.AgentID, &agent.Name, &agent.OwnerUserID, &agent.TokenIterations, &agent.TokenSalt, &agent.TokenHash,
+		&agent.PreviousTokenIterations, &agent.PreviousTokenSalt, &agent.PreviousTokenHash, &previousTokenExpires, &metadata, &agent.Created,
+		&agent.LastSeen, &offlineAfter, &agent.OfflineSince); err != nil {
+		return Agent{}, err
 	}
 
-	defer rows.Close()
+	agent.OfflineAfter = Interval(offlineAfter)
 
-	if !rows.Next() {
-		return -1, fmt.Errorf("Cannot find variable with name '%s'.", name)
+	if previousTokenExpires != nil {
+		agent.PreviousTokenExpires = *previousTokenExpires
 	}
 
-	var variableID int
-	if err := rows.Scan(&variableID); err != nil {
-		return 0, err
+	if err := json.Unmarshal(metadata, &agent.Metadata); err != nil {
+		return Agent{}, err
 	}
 
-	return variableID, nil
+	return agent, nil
 }
 
-func (d *PostgresDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time) (map[string]float64, error) {
-	rows, err := d.DB().Query("SELECT value, time FROM data WHERE agent_id = $1 AND variable_id = $2 AND time >= $3 AND time <= $4;",
-		agentID, variableID, fromDate, toDate)
+// GetAllAgents returns the agents that match filter, regardless of who owns them.
+func (d *PostgresDatabase) GetAllAgents(filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents("", []interface{}{}, filter)
+}
+
+// GetAgentsForUser returns the agents owned by the given user that match filter.
+func (d *PostgresDatabase) GetAgentsForUser(userID int, filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents("owner_user_id = $1 AND ", []interface{}{userID}, filter)
+}
+
+func (d *PostgresDatabase) queryAgents(condition string, args []interface{}, filter AgentFilter) ([]Agent, error) {
+	n := len(args)
+	query := fmt.Sprintf("SELECT "+agentColumns+" FROM agents WHERE %sdeleted IS NULL AND name ILIKE $%d "+
+		"ORDER BY agent_id LIMIT $%d OFFSET $%d;", condition, n+1, n+2, n+3)
+
+	// A NULL limit means there is no limit.
+	var limit *int
+
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+
+	args = append(args, "%"+escapeLikePattern(filter.Name)+"%", limit, filter.Offset)
+	rows, err := d.DB().Query(query, args...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
-	m := map[string]float64{}
+	agents := []Agent{}
 
 	for rows.Next() {
-		var value float64
-		var t time.Time
+		agent, err := scanAgent(rows)
 
-		if err := rows.Scan(&value, &t); err != nil {
+		if err != nil {
 			return nil, err
 		}
 
-		m[t.In(time.UTC).Format(time.RFC3339)] = value
+		agents = append(agents, agent)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return agents, nil
 }
 
-func (d *PostgresDatabase) GetVariableByID(variableID int) (Variable, error) {
+// escapeLikePattern escapes the characters that have special meaning in a LIKE pattern, so that value is matched literally.
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func (d *PostgresDatabase) CreateVariable(variable *Variable) error {
 	if err := d.ensureTransaction(); err != nil {
-		return Variable{}, err
+		return err
 	}
 
-	variable := Variable{}
-	row := d.CurrentTransaction.QueryRow("SELECT variable_id, name, units, display_decimal_places, created FROM variables WHERE variable_id = $1;", variableID)
+	row := d.CurrentTransaction.QueryRow("INSERT INTO variables (name, units, display_decimal_places, created, min_value, max_value, max_step, out_of_range, raw_data_days) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING variable_id", variable.Name, variable.Units, variable.DisplayDecimalPlaces, variable.Created,
+		variable.MinValue, variable.MaxValue, variable.MaxStep, variable.OutOfRangePolicy(), variable.RawDataDays)
+	return row.Scan(&variable.VariableID)
+}
 
-	if err := row.Scan(&variable.VariableID, &variable.Name, &variable.Units, &variable.DisplayDecimalPlaces, &variable.Created); err != nil {
-		return Variable{}, err
+// UpdateVariableLimits replaces the limits of a variable, and returns false if the variable does not exist.
+func (d *PostgresDatabase) UpdateVariableLimits(variableID int, limits VariableLimits) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
 	}
 
-	return variable, nil
+	result, err := d.CurrentTransaction.Exec("UPDATE variables SET min_value = $2, max_value = $3, max_step = $4, out_of_range = $5 WHERE variable_id = $1;",
+		variableID, limits.MinValue, limits.MaxValue, limits.MaxStep, limits.OutOfRangePolicy())
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
 }
 
-func (d *PostgresDatabase) GetVariablesForAgent(agentID int) ([]Variable, error) {
+// UpdateVariableRetention replaces the retention policy of a variable, and returns false if the variable does not exist.
+func (d *PostgresDatabase) UpdateVariableRetention(variableID int, retention VariableRetention) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE variables SET raw_data_days = $2 WHERE variable_id = $1;", variableID, retention.RawDataDays)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetAllVariables returns every variable, in order of ID.
+func (d *PostgresDatabase) GetAllVariables() ([]Variable, error) {
 	if err := d.ensureTransaction(); err != nil {
 		return []Variable{}, err
 	}
 
-	rows, err := d.CurrentTransaction.Query("SELECT variable_id, name, units, display_decimal_places, created FROM variables "+
-		"WHERE variable_id IN (SELECT DISTINCT variable_id FROM data WHERE agent_id = $1);",
-		agentID)
+	rows, err := d.CurrentTransaction.Query("SELECT " + variableColumns + " FROM variables ORDER BY variable_id;")
 
 	if err != nil {
 		return nil, err
@@ -261,10 +466,9 @@ func (d *PostgresDatabase) GetVariablesForAgent(agentID int) ([]Variable, error)
 	variables := []Variable{}
 
 	for rows.Next() {
-		variable := Variable{}
+		variable, err := scanVariable(rows)
 
-		if err := rows.Scan(&variable.VariableID, &variable.Name, &variable.Units,
-			&variable.DisplayDecimalPlaces, &variable.Created); err != nil {
+		if err != nil {
 			return nil, err
 		}
 
@@ -278,61 +482,955 @@ func (d *PostgresDatabase) GetVariablesForAgent(agentID int) ([]Variable, error)
 	return variables, nil
 }
 
-func (d *PostgresDatabase) GetAgentByID(agentID int) (Agent, error) {
-	if err := d.ensureTransaction(); err != nil {
-		return Agent{}, err
+// agentDataLockClass is the first key of the advisory lock that a transaction holds on an agent's data from when it
+// first saves data for the agent until it ends. Transactions saving data for the same agent take turns, so they commit
+// in the order of the sequence numbers they gave the points, and a stream replaying the points saved after a sequence
+// number never misses points from a transaction that committed later than one with higher sequence numbers.
+const agentDataLockClass = 1
+
+// lockAgentData takes the advisory lock on the data of each agent given, in order of agent ID so that two transactions
+// cannot each wait on a lock the other holds.
+func (d *PostgresDatabase) lockAgentData(dataPoints []DataPoint) error {
+	agentIDs := []int{}
+	seen := map[int]bool{}
+
+	for _, point := range dataPoints {
+		if !seen[point.AgentID] {
+			seen[point.AgentID] = true
+			agentIDs = append(agentIDs, point.AgentID)
+		}
 	}
 
-	agent := Agent{}
-	row := d.CurrentTransaction.QueryRow("SELECT agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created FROM agents WHERE agent_id = $1;", agentID)
+	sort.Ints(agentIDs)
 
-	if err := row.Scan(&agent.AgentID, &agent.Name, &agent.OwnerUserID, &agent.TokenIterations, &agent.TokenSalt, &agent.TokenHash, &agent.Created); err != nil {
-		return Agent{}, err
+	for _, agentID := range agentIDs {
+		if _, err := d.CurrentTransaction.Exec("SELECT pg_advisory_xact_lock($1, $2);", agentDataLockClass, agentID); err != nil {
+			return err
+		}
 	}
 
-	return agent, nil
+	return nil
 }
 
-func (d *PostgresDatabase) CreateUser(user *User) error {
+// AddDataPoint saves a data point, and returns true if there was already a value for the same agent, variable and time.
+// The existing value is kept unless conflictPolicy is conflictPolicyOverwrite.
+func (d *PostgresDatabase) AddDataPoint(dataPoint DataPoint, conflictPolicy string) (bool, error) {
 	if err := d.ensureTransaction(); err != nil {
-		return err
+		return false, err
 	}
 
-	row := d.CurrentTransaction.QueryRow(
-		"INSERT INTO users (email, password_iterations, password_salt, password_hash, is_admin, created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING user_id",
-		user.Email,
-		user.PasswordIterations,
-		user.PasswordSalt,
-		user.PasswordHash,
-		user.IsAdmin,
-		user.Created,
-	)
+	if err := d.lockAgentData([]DataPoint{dataPoint}); err != nil {
+		return false, err
+	}
 
-	return row.Scan(&user.UserID)
+	switch conflictPolicy {
+	case conflictPolicyReject, conflictPolicyIgnore:
+		result, err := d.CurrentTransaction.Exec("INSERT INTO data (agent_id, variable_id, time, value, quality) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (agent_id, variable_id, time) DO NOTHING;",
+			dataPoint.AgentID, dataPoint.VariableID, dataPoint.Time, dataPoint.Value, dataPoint.QualityFlag())
+
+		if err != nil {
+			return false, err
+		}
+
+		n, err := result.RowsAffected()
+
+		if err != nil {
+			return false, err
+		}
+
+		return n == 0, nil
+
+	case conflictPolicyOverwrite:
+		// xmax is only non-zero for a row that already existed and has been updated.
+		var inserted bool
+		row := d.CurrentTransaction.QueryRow("INSERT INTO data (agent_id, variable_id, time, value, quality) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (agent_id, variable_id, time) DO UPDATE SET value = EXCLUDED.value, quality = EXCLUDED.quality, sequence_number = EXCLUDED.sequence_number "+
+			"RETURNING xmax = 0;",
+			dataPoint.AgentID, dataPoint.VariableID, dataPoint.Time, dataPoint.Value, dataPoint.QualityFlag())
+
+		if err := row.Scan(&inserted); err != nil {
+			return false, err
+		}
+
+		return !inserted, nil
+
+	default:
+		return false, fmt.Errorf("Unknown conflict policy '%s'.", conflictPolicy)
+	}
 }
 
-func (d *PostgresDatabase) GetUserByEmail(email string) (User, error) {
-	rows, err := d.DB().Query(
-		`SELECT user_id, email, password_iterations, password_salt, password_hash, is_admin, created
-		 FROM users WHERE email = $1;`,
-		email)
+// AddDataPoints saves a batch of data points with the same result as calling AddDataPoint for each in turn, and
+// returns whether there was already a value for each point. The points are copied into a temporary table with COPY,
+// so that they can be added to the data table with a few statements however many points there are.
+func (d *PostgresDatabase) AddDataPoints(dataPoints []DataPoint, conflictPolicy string) ([]bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	onConflict := "DO NOTHING"
+
+	switch conflictPolicy {
+	case conflictPolicyReject, conflictPolicyIgnore:
+	case conflictPolicyOverwrite:
+		onConflict = "DO UPDATE SET value = EXCLUDED.value, quality = EXCLUDED.quality, sequence_number = EXCLUDED.sequence_number"
+	default:
+		return nil, fmt.Errorf("Unknown conflict policy '%s'.", conflictPolicy)
+	}
+
+	points, positions, existed := batchDataPoints(dataPoints, conflictPolicy)
+
+	if len(points) == 0 {
+		return existed, nil
+	}
+
+	if err := d.lockAgentData(points); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.CurrentTransaction.Exec("CREATE TEMPORARY TABLE data_batch (position INT NOT NULL, agent_id INT NOT NULL, variable_id INT NOT NULL, " +
+		"time TIMESTAMP WITH TIME ZONE NOT NULL, value DOUBLE PRECISION NOT NULL, quality VARCHAR(20) NOT NULL) ON COMMIT DROP;"); err != nil {
+		return nil, err
+	}
+
+	statement, err := d.CurrentTransaction.Prepare(pq.CopyIn("data_batch", "position", "agent_id", "variable_id", "time", "value", "quality"))
 
 	if err != nil {
-		return User{}, err
+		return nil, err
+	}
+
+	for i, point := range points {
+		if _, err := statement.Exec(i, point.AgentID, point.VariableID, point.Time, point.Value, point.QualityFlag()); err != nil {
+			statement.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := statement.Exec(); err != nil {
+		statement.Close()
+		return nil, err
+	}
+
+	if err := statement.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT position FROM data_batch JOIN data USING (agent_id, variable_id, time);")
+
+	if err != nil {
+		return nil, err
 	}
 
 	defer rows.Close()
 
-	if !rows.Next() {
-		return User{}, fmt.Errorf("Cannot find user with email '%s'.", email)
+	for rows.Next() {
+		var position int
+
+		if err := rows.Scan(&position); err != nil {
+			return nil, err
+		}
+
+		existed[positions[position]] = true
 	}
 
-	user := User{}
-	if err := rows.Scan(&user.UserID, &user.Email, &user.PasswordIterations, &user.PasswordSalt, &user.PasswordHash, &user.IsAdmin, &user.Created); err != nil {
-		return User{}, err
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return user, nil
+	// The points are given sequence numbers in the order they were given in.
+	if _, err := d.CurrentTransaction.Exec("INSERT INTO data (agent_id, variable_id, time, value, quality) " +
+		"SELECT agent_id, variable_id, time, value, quality FROM data_batch ORDER BY position ON CONFLICT (agent_id, variable_id, time) " + onConflict + ";"); err != nil {
+		return nil, err
+	}
+
+	// The table would be dropped at the end of the transaction anyway, but there may be another batch before then.
+	if _, err := d.CurrentTransaction.Exec("DROP TABLE data_batch;"); err != nil {
+		return nil, err
+	}
+
+	return existed, nil
+}
+
+func (d *PostgresDatabase) CheckAgentIDExists(agentID int) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT COUNT(*) FROM agents WHERE agent_id = $1 AND deleted IS NULL;", agentID)
+	count := 0
+
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return (count > 0), nil
+}
+
+func (d *PostgresDatabase) GetVariableIDForName(name string) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT variable_id FROM variables WHERE name = $1;", name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return -1, fmt.Errorf("Cannot find variable with name '%s'.", name)
+	}
+
+	var variableID int
+	if err := rows.Scan(&variableID); err != nil {
+		return 0, err
+	}
+
+	return variableID, nil
+}
+
+// GetPreviousValue returns the latest good value for the agent and variable from before the given time, and false if
+// there is none.
+func (d *PostgresDatabase) GetPreviousValue(agentID int, variableID int, before time.Time) (float64, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, false, err
+	}
+
+	var value float64
+	row := d.CurrentTransaction.QueryRow("SELECT value FROM data WHERE agent_id = $1 AND variable_id = $2 AND time < $3 AND quality = $4 "+
+		"ORDER BY time DESC LIMIT 1;", agentID, variableID, before, dataQualityGood)
+
+	if err := row.Scan(&value); err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return value, true, nil
+}
+
+// unflaggedDataCondition restricts a query on the data table to points that have not been flagged as suspect or bad.
+const unflaggedDataCondition = " AND quality IN ('" + dataQualityGood + "', '" + dataQualityCorrected + "')"
+
+func flaggedDataCondition(includeFlagged bool) string {
+	if includeFlagged {
+		return ""
+	}
+
+	return unflaggedDataCondition
+}
+
+// GetData returns the points for the agent and variable in the range given. Where the points have been rolled up, the
+// average of each hourly rollup is given in their place.
+func (d *PostgresDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool) (map[string]float64, error) {
+	// Points that arrived after their hour was rolled up come last, so that they are given in preference to the rollup.
+	rows, err := d.DB().Query("SELECT avg_value, time, FALSE AS raw FROM "+rollupTables[rollupResolutionHourly]+
+		" WHERE agent_id = $1 AND variable_id = $2 AND time >= $3 AND time <= $4 "+
+		"UNION ALL SELECT value, time, TRUE AS raw FROM data WHERE agent_id = $1 AND variable_id = $2 AND time >= $3 AND time <= $4"+
+		flaggedDataCondition(includeFlagged)+" ORDER BY raw;",
+		agentID, variableID, fromDate, toDate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	m := map[string]float64{}
+
+	for rows.Next() {
+		var value float64
+		var t time.Time
+		var raw bool
+
+		if err := rows.Scan(&value, &t, &raw); err != nil {
+			return nil, err
+		}
+
+		m[t.In(time.UTC).Format(time.RFC3339)] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GetAggregatedData groups points into buckets of the given interval (aligned to the Unix epoch) and
+// returns the aggregate of each bucket, keyed by the start time of the bucket. Points that have been rolled up are
+// included using the rollups that suit the interval best.
+func (d *PostgresDatabase) GetAggregatedData(agentID int, variableID int, fromDate time.Time, toDate time.Time, interval time.Duration, aggregate string, includeFlagged bool) (map[string]float64, error) {
+	expression, ok := aggregateExpressions[aggregate]
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown aggregate '%s'.", aggregate)
+	}
+
+	if interval < time.Second {
+		return nil, errors.New("Interval must be at least one second.")
+	}
+
+	bucket, err := d.bucketExpression("time", "$5")
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.DB().Query("SELECT "+expression+", "+bucket+" AS bucket FROM ("+
+		"SELECT time, min_value, max_value, avg_value, count FROM "+rollupTables[rollupResolutionFor(interval)]+
+		" WHERE agent_id = $1 AND variable_id = $2 AND time >= $3 AND time <= $4 "+
+		"UNION ALL SELECT time, value, value, value, 1 FROM data WHERE agent_id = $1 AND variable_id = $2 AND time >= $3 AND time <= $4"+
+		flaggedDataCondition(includeFlagged)+") AS rollups GROUP BY bucket;",
+		agentID, variableID, fromDate, toDate, interval.Seconds())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	m := map[string]float64{}
+
+	for rows.Next() {
+		var value float64
+		var t time.Time
+
+		if err := rows.Scan(&value, &t); err != nil {
+			return nil, err
+		}
+
+		m[t.In(time.UTC).Format(time.RFC3339)] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// StreamData calls callback with each data point for the given variables in turn, ordered by time and then variable ID,
+// without loading all of the data points into memory at once. If callback returns an error, no further points are read
+// and the error is returned.
+func (d *PostgresDatabase) StreamData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) error {
+	if len(variableIDs) == 0 {
+		return nil
+	}
+
+	args := []interface{}{agentID, fromDate, toDate}
+	placeholders := make([]string, len(variableIDs))
+
+	for i, variableID := range variableIDs {
+		args = append(args, variableID)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+
+	rows, err := d.DB().Query("SELECT variable_id, time, value, quality FROM data WHERE agent_id = $1 AND time >= $2 AND time <= $3 "+
+		"AND variable_id IN ("+strings.Join(placeholders, ", ")+")"+flaggedDataCondition(includeFlagged)+" ORDER BY time, variable_id;",
+		args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value, &point.Quality); err != nil {
+			return err
+		}
+
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetLastDataSequenceNumber returns the highest sequence number given to the agent's data, or 0 if there is none. After
+// saving data, this is the sequence number of the last point saved, as the transaction holds the lock on the agent's
+// data.
+func (d *PostgresDatabase) GetLastDataSequenceNumber(agentID int) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	var sequenceNumber int
+	row := d.CurrentTransaction.QueryRow("SELECT COALESCE(MAX(sequence_number), 0) FROM data WHERE agent_id = $1;", agentID)
+
+	if err := row.Scan(&sequenceNumber); err != nil {
+		return 0, err
+	}
+
+	return sequenceNumber, nil
+}
+
+// StreamDataSavedAfter calls callback with each of the agent's points that was saved after the point with the given
+// sequence number, in the order they were saved, stopping after limit points. Points are given a new sequence number
+// when they are overwritten.
+func (d *PostgresDatabase) StreamDataSavedAfter(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT variable_id, time, value, quality, sequence_number FROM data WHERE agent_id = $1 AND sequence_number > $2 "+
+		"ORDER BY sequence_number LIMIT $3;", agentID, sequenceNumber, limit)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value, &point.Quality, &point.SequenceNumber); err != nil {
+			return err
+		}
+
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetDataPoint returns the point for the agent and variable at the given time, and false if there is none. The point is
+// locked until the end of the transaction, so that it can be safely updated.
+func (d *PostgresDatabase) GetDataPoint(agentID int, variableID int, t time.Time) (DataPoint, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return DataPoint{}, false, err
+	}
+
+	point := DataPoint{AgentID: agentID, VariableID: variableID}
+	row := d.CurrentTransaction.QueryRow("SELECT time, value, quality FROM data WHERE agent_id = $1 AND variable_id = $2 AND time = $3 FOR UPDATE;",
+		agentID, variableID, t)
+
+	if err := row.Scan(&point.Time, &point.Value, &point.Quality); err == sql.ErrNoRows {
+		return DataPoint{}, false, nil
+	} else if err != nil {
+		return DataPoint{}, false, err
+	}
+
+	return point, true, nil
+}
+
+// AddDataCorrection records a change made by hand to a data point.
+func (d *PostgresDatabase) AddDataCorrection(correction *DataCorrection) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	row := d.CurrentTransaction.QueryRow(
+		"INSERT INTO data_corrections (agent_id, variable_id, time, original_value, original_quality, value, quality, user_id, created) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING correction_id",
+		correction.AgentID,
+		correction.VariableID,
+		correction.Time,
+		correction.OriginalValue,
+		correction.OriginalQuality,
+		correction.Value,
+		correction.Quality,
+		correction.UserID,
+		correction.Created,
+	)
+
+	return row.Scan(&correction.CorrectionID)
+}
+
+// GetDataCorrections returns every change made by hand to the agent's data, most recent first.
+func (d *PostgresDatabase) GetDataCorrections(agentID int) ([]DataCorrection, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT correction_id, agent_id, variable_id, time, original_value, original_quality, value, quality, "+
+		"user_id, created FROM data_corrections WHERE agent_id = $1 ORDER BY created DESC, correction_id DESC;", agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	corrections := []DataCorrection{}
+
+	for rows.Next() {
+		correction := DataCorrection{}
+
+		if err := rows.Scan(&correction.CorrectionID, &correction.AgentID, &correction.VariableID, &correction.Time, &correction.OriginalValue,
+			&correction.OriginalQuality, &correction.Value, &correction.Quality, &correction.UserID, &correction.Created); err != nil {
+			return nil, err
+		}
+
+		corrections = append(corrections, correction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return corrections, nil
+}
+
+// RollUpData summarises the variable's good data from before the given time into hourly and daily rollups, adding to
+// any rollups already made for the same periods, and then removes the data. Suspect and bad points are kept, because
+// they are not in the rollups and could still be corrected. It returns the number of points removed.
+// With TimescaleDB, data within continuousAggregateRefreshWindow of now is kept until the continuous aggregates are no
+// longer refreshed for its time.
+func (d *PostgresDatabase) RollUpData(variableID int, before time.Time) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	timescaleDB, err := d.hasTimescaleDB()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if refreshed := time.Now().Add(-continuousAggregateRefreshWindow); timescaleDB && before.After(refreshed) {
+		before = refreshed
+	}
+
+	// The rollups are made from the rows the DELETE returns, in the same statement, so that points saved or changed while
+	// the data is being rolled up are either rolled up and removed, or left as they are.
+	query := "WITH removed AS (DELETE FROM data WHERE variable_id = $1 AND time < $2" + unflaggedDataCondition +
+		" RETURNING agent_id, variable_id, time, value)"
+	args := []interface{}{variableID, before}
+
+	for _, resolution := range rollupResolutions {
+		table := rollupTables[resolution]
+		args = append(args, resolution.Seconds())
+		bucket, err := d.bucketExpression("time", fmt.Sprintf("$%d", len(args)))
+
+		if err != nil {
+			return 0, err
+		}
+
+		query += ", " + table + "_added AS (INSERT INTO " + table + " (agent_id, variable_id, time, min_value, max_value, avg_value, count) " +
+			"SELECT agent_id, variable_id, " + bucket + " AS bucket, MIN(value), MAX(value), AVG(value), COUNT(*) " +
+			"FROM removed GROUP BY agent_id, variable_id, bucket " +
+			"ON CONFLICT (agent_id, variable_id, time) DO UPDATE SET " +
+			"min_value = LEAST(" + table + ".min_value, EXCLUDED.min_value), " +
+			"max_value = GREATEST(" + table + ".max_value, EXCLUDED.max_value), " +
+			"avg_value = (" + table + ".avg_value * " + table + ".count + EXCLUDED.avg_value * EXCLUDED.count) / (" + table + ".count + EXCLUDED.count), " +
+			"count = " + table + ".count + EXCLUDED.count)"
+	}
+
+	var removed int
+
+	if err := d.CurrentTransaction.QueryRow(query+" SELECT COUNT(*) FROM removed;", args...).Scan(&removed); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// GetLatestData returns the most recent data point for each variable the agent has reported, keyed by variable ID.
+func (d *PostgresDatabase) GetLatestData(agentID int) (map[int]DataPoint, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	// Finding the latest point for each variable in turn uses one index lookup per variable, rather than reading all of
+	// the agent's data.
+	rows, err := d.CurrentTransaction.Query("SELECT variables.variable_id, latest.time, latest.value FROM variables "+
+		"CROSS JOIN LATERAL (SELECT time, value FROM data WHERE agent_id = $1 AND variable_id = variables.variable_id ORDER BY time DESC LIMIT 1) AS latest;",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	m := map[int]DataPoint{}
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value); err != nil {
+			return nil, err
+		}
+
+		m[point.VariableID] = point
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (d *PostgresDatabase) GetVariableByID(variableID int) (Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Variable{}, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT "+variableColumns+" FROM variables WHERE variable_id = $1;", variableID)
+
+	return scanVariable(row)
+}
+
+// GetVariablesByName returns the variables with any of the given names, keyed by name, with a single query. Names that
+// do not match a variable are left out.
+func (d *PostgresDatabase) GetVariablesByName(names []string) (map[string]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT "+variableColumns+" FROM variables WHERE name = ANY($1);", pq.Array(names))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	variables := map[string]Variable{}
+
+	for rows.Next() {
+		variable, err := scanVariable(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables[variable.Name] = variable
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+func (d *PostgresDatabase) GetVariablesForAgent(agentID int) ([]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return []Variable{}, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT "+variableColumns+" FROM variables "+
+		"WHERE variable_id IN (SELECT DISTINCT variable_id FROM data WHERE agent_id = $1);",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	variables := []Variable{}
+
+	for rows.Next() {
+		variable, err := scanVariable(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, variable)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+const variableColumns = "variable_id, name, units, display_decimal_places, created, min_value, max_value, max_step, out_of_range, raw_data_days"
+
+func scanVariable(row scanner) (Variable, error) {
+	variable := Variable{}
+
+	if err := row.Scan(&variable.VariableID, &variable.Name, &variable.Units, &variable.DisplayDecimalPlaces, &variable.Created,
+		&variable.MinValue, &variable.MaxValue, &variable.MaxStep, &variable.OutOfRange, &variable.RawDataDays); err != nil {
+		return Variable{}, err
+	}
+
+	return variable, nil
+}
+
+func (d *PostgresDatabase) GetAgentByID(agentID int) (Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Agent{}, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT "+agentColumns+" FROM agents WHERE agent_id = $1 AND deleted IS NULL;", agentID)
+
+	return scanAgent(row)
+}
+
+func (d *PostgresDatabase) CreateUser(user *User) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	row := d.CurrentTransaction.QueryRow(
+		"INSERT INTO users (email, password_iterations, password_salt, password_hash, is_admin, created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING user_id",
+		user.Email,
+		user.PasswordIterations,
+		user.PasswordSalt,
+		user.PasswordHash,
+		user.IsAdmin,
+		user.Created,
+	)
+
+	return row.Scan(&user.UserID)
+}
+
+func (d *PostgresDatabase) GetUserByEmail(email string) (User, error) {
+	rows, err := d.DB().Query(
+		`SELECT user_id, email, password_iterations, password_salt, password_hash, is_admin, created
+		 FROM users WHERE email = $1;`,
+		email)
+
+	if err != nil {
+		return User{}, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return User{}, fmt.Errorf("Cannot find user with email '%s'.", email)
+	}
+
+	user := User{}
+	if err := rows.Scan(&user.UserID, &user.Email, &user.PasswordIterations, &user.PasswordSalt, &user.PasswordHash, &user.IsAdmin, &user.Created); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (d *PostgresDatabase) GetUserByID(userID int) (User, error) {
+	user := User{}
+	row := d.DB().QueryRow(
+		`SELECT user_id, email, password_iterations, password_salt, password_hash, is_admin, created
+		 FROM users WHERE user_id = $1;`,
+		userID)
+
+	if err := row.Scan(&user.UserID, &user.Email, &user.PasswordIterations, &user.PasswordSalt, &user.PasswordHash, &user.IsAdmin, &user.Created); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (d *PostgresDatabase) CreateSession(session *Session) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	row := d.CurrentTransaction.QueryRow(
+		"INSERT INTO sessions (user_id, token_hash, created, expires) VALUES ($1, $2, $3, $4) RETURNING session_id",
+		session.UserID,
+		session.TokenHash,
+		session.Created,
+		session.Expires,
+	)
+
+	return row.Scan(&session.SessionID)
+}
+
+func (d *PostgresDatabase) GetSessionByTokenHash(tokenHash []byte) (Session, error) {
+	rows, err := d.DB().Query("SELECT session_id, user_id, token_hash, created, expires FROM sessions WHERE token_hash = $1;", tokenHash)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return Session{}, errors.New("Cannot find session with the given token.")
+	}
+
+	session := Session{}
+	if err := rows.Scan(&session.SessionID, &session.UserID, &session.TokenHash, &session.Created, &session.Expires); err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func (d *PostgresDatabase) DeleteSession(sessionID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	_, err := d.CurrentTransaction.Exec("DELETE FROM sessions WHERE session_id = $1;", sessionID)
+	return err
+}
+
+func (d *PostgresDatabase) CreateAlertRule(rule *AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	row := d.CurrentTransaction.QueryRow(
+		"INSERT INTO alert_rules (agent_id, variable_id, condition, threshold, hysteresis, min_duration, webhook_url, created, state) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING rule_id",
+		rule.AgentID,
+		rule.VariableID,
+		rule.Condition,
+		rule.Threshold,
+		rule.Hysteresis,
+		int64(rule.MinDuration),
+		rule.WebhookURL,
+		rule.Created,
+		rule.State,
+	)
+
+	return row.Scan(&rule.RuleID)
+}
+
+// GetAlertRulesForAgent returns the agent's alert rules, and locks them until the end of the transaction so that
+// concurrent requests evaluate the rules one after the other.
+func (d *PostgresDatabase) GetAlertRulesForAgent(agentID int) ([]AlertRule, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query(
+		"SELECT r.rule_id, r.agent_id, r.variable_id, v.name, r.condition, r.threshold, r.hysteresis, r.min_duration, r.webhook_url, "+
+			"r.created, r.state, r.pending_since, r.last_value, r.last_time "+
+			"FROM alert_rules r INNER JOIN variables v ON r.variable_id = v.variable_id "+
+			"WHERE r.agent_id = $1 ORDER BY r.rule_id FOR UPDATE OF r;",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	rules := []AlertRule{}
+
+	for rows.Next() {
+		rule := AlertRule{}
+		var minDuration int64
+		var pendingSince, lastTime *time.Time
+
+		if err := rows.Scan(&rule.RuleID, &rule.AgentID, &rule.VariableID, &rule.Variable, &rule.Condition, &rule.Threshold,
+			&rule.Hysteresis, &minDuration, &rule.WebhookURL, &rule.Created, &rule.State, &pendingSince, &rule.LastValue, &lastTime); err != nil {
+			return nil, err
+		}
+
+		rule.MinDuration = time.Duration(minDuration)
+
+		if pendingSince != nil {
+			rule.PendingSince = *pendingSince
+		}
+
+		if lastTime != nil {
+			rule.LastTime = *lastTime
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// UpdateAlertRuleState saves the state of an existing alert rule. The rest of the rule is left unchanged.
+func (d *PostgresDatabase) UpdateAlertRuleState(rule AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE alert_rules SET state = $2, pending_since = $3, last_value = $4, last_time = $5 WHERE rule_id = $1;",
+		rule.RuleID, rule.State, nullableTime(rule.PendingSince), rule.LastValue, nullableTime(rule.LastTime))
+
+	return checkAlertRuleRowAffected(result, err, rule.RuleID)
+}
+
+// DeleteAlertRule removes an alert rule and its history.
+func (d *PostgresDatabase) DeleteAlertRule(ruleID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("DELETE FROM alert_rules WHERE rule_id = $1;", ruleID)
+
+	return checkAlertRuleRowAffected(result, err, ruleID)
+}
+
+func (d *PostgresDatabase) AddAlertHistory(entry *AlertHistoryEntry) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	row := d.CurrentTransaction.QueryRow(
+		"INSERT INTO alert_history (rule_id, event, value, time, created) VALUES ($1, $2, $3, $4, $5) RETURNING history_id",
+		entry.RuleID,
+		entry.Event,
+		entry.Value,
+		entry.Time,
+		entry.Created,
+	)
+
+	return row.Scan(&entry.HistoryID)
+}
+
+// GetAlertHistory returns the history of an alert rule, most recent first.
+func (d *PostgresDatabase) GetAlertHistory(ruleID int) ([]AlertHistoryEntry, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT history_id, rule_id, event, value, time, created FROM alert_history "+
+		"WHERE rule_id = $1 ORDER BY time DESC, history_id DESC;", ruleID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	history := []AlertHistoryEntry{}
+
+	for rows.Next() {
+		entry := AlertHistoryEntry{}
+
+		if err := rows.Scan(&entry.HistoryID, &entry.RuleID, &entry.Event, &entry.Value, &entry.Time, &entry.Created); err != nil {
+			return nil, err
+		}
+
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func checkAlertRuleRowAffected(result sql.Result, err error, ruleID int) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("Cannot find alert rule with ID %d.", ruleID)
+	}
+
+	return nil
 }
 
 func (d *PostgresDatabase) ensureTransaction() error {
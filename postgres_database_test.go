@@ -9,6 +9,7 @@ import (
 	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -44,6 +45,18 @@ var _ = Describe("PostgresDatabase", func() {
 		})
 	})
 
+	Describe("NewSession", func() {
+		It("returns a database that can have a transaction in progress at the same time", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+			defer db.RollbackTransaction()
+
+			session := db.NewSession()
+			Expect(session.BeginTransaction()).To(BeNil())
+			Expect(session.Transaction()).ToNot(BeIdenticalTo(db.Transaction()))
+			Expect(session.RollbackTransaction()).To(BeNil())
+		})
+	})
+
 	Describe("RunMigrations", func() {
 		It("applies all of the migrations", func() {
 			migrations, _ := getMigrationSource().FindMigrations()
@@ -57,469 +70,169 @@ var _ = Describe("PostgresDatabase", func() {
 			Expect(err).To(BeNil())
 			Expect(actualMigrationCount).To(Equal(expectedMigrationCount))
 		})
-	})
-
-	Describe("BeginTransaction", func() {
-		Context("when there is no active transaction", func() {
-			It("does not return an error", func() {
-				Expect(db.BeginTransaction()).To(BeNil())
-			})
-
-			It("sets Transaction", func() {
-				db.BeginTransaction()
-				Expect(db.Transaction()).ToNot(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
-		})
-
-		Context("when there is already an active transaction", func() {
-			It("returns an error", func() {
-				db.BeginTransaction()
-				Expect(db.BeginTransaction()).ToNot(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
-		})
-	})
-
-	Describe("CommitTransaction", func() {
-		Context("when there is no active transaction", func() {
-			It("returns an error", func() {
-				Expect(db.CommitTransaction()).ToNot(BeNil())
-			})
-		})
 
-		Context("when there is an active transaction", func() {
-			BeforeEach(func() {
-				ExpectSucceeded(db.DB().Exec("CREATE TABLE temp (name VARCHAR(100));"))
-				err := db.BeginTransaction()
-				Expect(err).To(BeNil())
-			})
-
-			It("does not return an error", func() {
-				Expect(db.CommitTransaction()).To(BeNil())
-			})
-
-			It("sets Transaction to nil", func() {
-				db.CommitTransaction()
-				Expect(db.Transaction()).To(BeNil())
-			})
-
-			It("applies changes made to the database", func() {
-				ExpectSucceeded(db.Transaction().Exec("INSERT INTO temp (name) VALUES ('test');"))
-				var count int
-				err := db.Transaction().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
-				Expect(err).To(BeNil())
-				Expect(count).To(Equal(1))
-
-				err = db.CommitTransaction()
-				Expect(err).To(BeNil())
-
-				err = db.DB().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
-				Expect(err).To(BeNil())
-				Expect(count).To(Equal(1))
-			})
-		})
-	})
-
-	Describe("RollbackTransaction", func() {
-		Context("when there is no active transaction", func() {
-			It("returns an error", func() {
-				Expect(db.RollbackTransaction()).ToNot(BeNil())
-			})
-		})
-
-		Context("when there is an active transaction", func() {
-			BeforeEach(func() {
-				ExpectSucceeded(db.DB().Exec("CREATE TABLE temp (name VARCHAR(100));"))
-				err := db.BeginTransaction()
-				Expect(err).To(BeNil())
-			})
-
-			It("does not return an error", func() {
-				Expect(db.RollbackTransaction()).To(BeNil())
-			})
-
-			It("sets Transaction to nil", func() {
-				db.RollbackTransaction()
-				Expect(db.Transaction()).To(BeNil())
-			})
-
-			It("reverts changes made to the database", func() {
-				ExpectSucceeded(db.Transaction().Exec("INSERT INTO temp (name) VALUES ('test');"))
-				var count int
-				err := db.Transaction().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
-				Expect(err).To(BeNil())
-				Expect(count).To(Equal(1))
-
-				err = db.RollbackTransaction()
-				Expect(err).To(BeNil())
-
-				err = db.DB().QueryRow("SELECT COUNT(*) FROM temp;").Scan(&count)
-				Expect(err).To(BeNil())
-				Expect(count).To(Equal(0))
-			})
-		})
-	})
+		It("does not use TimescaleDB if the database does not have the extension", func() {
+			_, err := db.RunMigrations()
+			Expect(err).To(BeNil())
 
-	Describe("RollbackUncommittedTransaction", func() {
-		Context("when there is no active transaction", func() {
-			It("does not return an error", func() {
-				Expect(db.RollbackUncommittedTransaction()).To(BeNil())
-			})
+			timescaleDB, err := db.(*PostgresDatabase).hasTimescaleDB()
+			Expect(err).To(BeNil())
+			Expect(timescaleDB).To(BeFalse())
 		})
 
-		Context("when there is an active transaction", func() {
+		Context("when the database has the TimescaleDB extension", func() {
 			BeforeEach(func() {
-				Expect(db.BeginTransaction()).To(BeNil())
-			})
-
-			It("does not return an error", func() {
-				Expect(db.RollbackUncommittedTransaction()).To(BeNil())
-			})
-
-			It("sets Transaction to nil", func() {
-				db.RollbackUncommittedTransaction()
-				Expect(db.Transaction()).To(BeNil())
-			})
-		})
-	})
-
-	Context("when connected to a database with all migrations applied", func() {
-		BeforeEach(func() {
-			db.RunMigrations()
-
-			CreateTestData(db)
-		})
-
-		Describe("CreateAgent", func() {
-			It("saves new agents to the database", func() {
-				created := time.Now().Round(time.Millisecond)
-				agent := &Agent{Name: "Test agent", OwnerUserID: 3001, Created: created}
-
-				Expect(db.BeginTransaction()).To(BeNil())
-				err := db.CreateAgent(agent)
-				Expect(err).To(BeNil())
-				Expect(agent.AgentID).ToNot(Equal(0))
-
-				Expect(db.CommitTransaction()).To(BeNil())
-
-				var actualName string
-				var actualCreated time.Time
-				var actualOwnerUserId int
-				row := db.DB().QueryRow("SELECT name, owner_user_id, created FROM agents WHERE agent_id = $1", agent.AgentID)
-				err = row.Scan(&actualName, &actualOwnerUserId, &actualCreated)
-
-				Expect(err).To(BeNil())
-				Expect(actualName).To(Equal("Test agent"))
-				Expect(actualCreated).To(BeTemporally("==", created))
-				Expect(actualOwnerUserId).To(Equal(3001))
-			})
-		})
-
-		Describe("GetAllAgents", func() {
-			It("returns an empty list if there are no agents in the database", func() {
-				ExpectSucceeded(db.DB().Exec("DELETE FROM data;"))
-				ExpectSucceeded(db.DB().Exec("DELETE FROM agents;"))
-				agents, err := db.GetAllAgents()
-
-				Expect(err).To(BeNil())
-				Expect(agents).To(BeEmpty())
+				createTimescaleDBExtension(db)
 			})
 
-			It("gets all agents from the database", func() {
-				agents, err := db.GetAllAgents()
-
+			It("converts the data table to a hypertable with continuous aggregates, and only does so once", func() {
+				_, err := db.RunMigrations()
 				Expect(err).To(BeNil())
-				Expect(agents).To(HaveLen(2))
-
-				Expect(agents[0].AgentID).To(Equal(1001))
-				Expect(agents[0].Name).To(Equal("First agent"))
-				Expect(agents[0].OwnerUserID).To(Equal(3001))
-				Expect(agents[0].TokenIterations).To(Equal(12301))
-				Expect(agents[0].TokenSalt).To(Equal([]byte("salt1001")))
-				Expect(agents[0].TokenHash).To(Equal([]byte("hash1001")))
-				Expect(agents[0].Created).To(BeTemporally("==", time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)))
-
-				Expect(agents[1].AgentID).To(Equal(1002))
-				Expect(agents[1].Name).To(Equal("Second agent"))
-				Expect(agents[1].OwnerUserID).To(Equal(3001))
-				Expect(agents[1].TokenIterations).To(Equal(12302))
-				Expect(agents[1].TokenSalt).To(Equal([]byte("salt1002")))
-				Expect(agents[1].TokenHash).To(Equal([]byte("hash1002")))
-				Expect(agents[1].Created).To(BeTemporally("==", time.Date(2015, 2, 16, 20, 0, 0, 0, time.UTC)))
-			})
-		})
-
-		Describe("CheckAgentIDExists", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
+				_, err = db.RunMigrations()
 				Expect(err).To(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
 
-			It("returns true if the agent exists", func() {
-				exists, err := db.CheckAgentIDExists(1002)
+				var hypertables, continuousAggregates int
+				err = db.DB().QueryRow("SELECT COUNT(*) FROM timescaledb_information.hypertables WHERE hypertable_name = 'data' AND compression_enabled;").Scan(&hypertables)
 				Expect(err).To(BeNil())
-				Expect(exists).To(BeTrue())
-			})
+				Expect(hypertables).To(Equal(1))
 
-			It("returns false if the agent does not exist", func() {
-				exists, err := db.CheckAgentIDExists(9001)
+				err = db.DB().QueryRow("SELECT COUNT(*) FROM timescaledb_information.continuous_aggregates WHERE view_name IN ('data_hourly_summary', 'data_daily_summary');").Scan(&continuousAggregates)
 				Expect(err).To(BeNil())
-				Expect(exists).To(BeFalse())
+				Expect(continuousAggregates).To(Equal(2))
 			})
-		})
 
-		Describe("CreateVariable", func() {
-			It("saves new variables to the database", func() {
-				created := time.Now().Round(time.Millisecond)
-				variable := &Variable{Name: "Test variable", Units: "metres (m)", DisplayDecimalPlaces: 2, Created: created}
-
-				Expect(db.BeginTransaction()).To(BeNil())
-				err := db.CreateVariable(variable)
+			It("only refreshes the continuous aggregates for recent data", func() {
+				_, err := db.RunMigrations()
 				Expect(err).To(BeNil())
-				Expect(variable.VariableID).ToNot(Equal(0))
-
-				Expect(db.CommitTransaction()).To(BeNil())
-
-				var actualName, actualUnits string
-				var actualCreated time.Time
-				var actualDisplayDecimalPlaces int
-				row := db.DB().QueryRow("SELECT name, units, display_decimal_places, created "+
-					"FROM variables WHERE variable_id = $1", variable.VariableID)
-				err = row.Scan(&actualName, &actualUnits, &actualDisplayDecimalPlaces, &actualCreated)
 
+				var unboundedPolicies int
+				err = db.DB().QueryRow("SELECT COUNT(*) FROM timescaledb_information.jobs WHERE proc_name = 'policy_refresh_continuous_aggregate' " +
+					"AND config->>'start_offset' IS NULL;").Scan(&unboundedPolicies)
 				Expect(err).To(BeNil())
-				Expect(actualName).To(Equal("Test variable"))
-				Expect(actualUnits).To(Equal("metres (m)"))
-				Expect(actualDisplayDecimalPlaces).To(Equal(2))
-				Expect(actualCreated).To(BeTemporally("==", created))
+				Expect(unboundedPolicies).To(Equal(0))
 			})
-		})
-
-		Describe("AddDataPoint", func() {
-			It("adds the data point to the database", func() {
-				dataTime := time.Now().Round(time.Millisecond)
-				dataPoint := DataPoint{AgentID: 1002, VariableID: 2002, Time: dataTime, Value: 100.67}
 
-				Expect(db.BeginTransaction()).To(BeNil())
-				err := db.AddDataPoint(dataPoint)
+			It("keeps the data the continuous aggregates are still refreshed for when rolling up data", func() {
+				_, err := db.RunMigrations()
 				Expect(err).To(BeNil())
+				CreateTestData(db, func(t time.Time) interface{} { return t })
 
-				Expect(db.CommitTransaction()).To(BeNil())
+				recent := time.Now().Add(-continuousAggregateRefreshWindow).Add(time.Hour)
+				old := time.Now().Add(-continuousAggregateRefreshWindow).Add(-time.Hour)
 
-				var actualAgentID, actualVariableID int
-				var actualValue float64
-				var actualTime time.Time
-				row := db.DB().QueryRow("SELECT agent_id, variable_id, time, value FROM data WHERE agent_id = 1002 AND variable_id = 2002;")
-				err = row.Scan(&actualAgentID, &actualVariableID, &actualTime, &actualValue)
+				Expect(db.BeginTransaction()).To(Succeed())
+				defer db.RollbackUncommittedTransaction()
 
+				_, err = db.AddDataPoints([]DataPoint{
+					{AgentID: 1001, VariableID: 2002, Time: old, Value: 1},
+					{AgentID: 1001, VariableID: 2002, Time: recent, Value: 2},
+				}, conflictPolicyReject)
 				Expect(err).To(BeNil())
-				Expect(actualAgentID).To(Equal(1002))
-				Expect(actualVariableID).To(Equal(2002))
-				Expect(actualTime).To(BeTemporally("==", dataTime))
-				Expect(actualValue).To(Equal(100.67))
-			})
-		})
-
-		Describe("GetVariableIDForName", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
-				Expect(err).To(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
 
-			It("returns the variable ID if the variable exists", func() {
-				id, err := db.GetVariableIDForName("distance")
+				_, err = db.RollUpData(2002, time.Now())
 				Expect(err).To(BeNil())
-				Expect(id).To(Equal(2001))
-			})
 
-			It("returns -1 if the variable does not exist", func() {
-				id, err := db.GetVariableIDForName("temperature")
-				Expect(err).ToNot(BeNil())
-				Expect(id).To(Equal(-1))
-			})
-		})
-
-		Describe("GetData", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
+				_, found, err := db.GetDataPoint(1001, 2002, old)
 				Expect(err).To(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
+				Expect(found).To(BeFalse())
 
-			It("returns the data matching the criteria given", func() {
-				data, err := db.GetData(1001, 2002, time.Date(2015, 4, 7, 15, 0, 30, 0, time.UTC), time.Date(2015, 4, 7, 15, 2, 30, 0, time.UTC))
+				_, found, err = db.GetDataPoint(1001, 2002, recent)
 				Expect(err).To(BeNil())
-				Expect(data).To(HaveLen(2))
-				Expect(data).To(HaveKeyWithValue("2015-04-07T15:01:00Z", float64(103)))
-				Expect(data).To(HaveKeyWithValue("2015-04-07T15:02:00Z", float64(104)))
+				Expect(found).To(BeTrue())
 			})
 		})
+	})
 
-		Describe("GetVariableByID", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
-				Expect(err).To(BeNil())
-			})
-
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
+	Describe("RollUpData", func() {
+		It("rolls up the value points are changed to while the data is being rolled up", func() {
+			_, err := db.RunMigrations()
+			Expect(err).To(BeNil())
+			CreateTestData(db, func(t time.Time) interface{} { return t })
 
-			It("returns the variable if it exists", func() {
-				variable, err := db.GetVariableByID(2001)
-				Expect(err).To(BeNil())
-				Expect(variable.VariableID).To(Equal(2001))
-				Expect(variable.Name).To(Equal("distance"))
-				Expect(variable.Units).To(Equal("metres"))
-				Expect(variable.DisplayDecimalPlaces).To(Equal(2))
-				Expect(variable.Created).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
-			})
+			session := db.NewSession()
+			Expect(session.BeginTransaction()).To(Succeed())
+			defer session.RollbackUncommittedTransaction()
 
-			It("fails if the variable does not exist", func() {
-				variable, err := db.GetVariableByID(9002)
-				Expect(err).ToNot(BeNil())
-				Expect(variable).To(Equal(Variable{}))
-			})
-		})
+			changed := time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)
+			_, err = session.AddDataPoint(DataPoint{AgentID: 1001, VariableID: 2002, Time: changed, Value: 201}, conflictPolicyOverwrite)
+			Expect(err).To(BeNil())
 
-		Describe("GetVariablesForAgent", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
-				Expect(err).To(BeNil())
-			})
+			rolledUp := make(chan int, 1)
 
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
+			go func() {
+				defer GinkgoRecover()
 
-			It("returns the details of every variable associated with the agent", func() {
-				variables, err := db.GetVariablesForAgent(1002)
-				Expect(err).To(BeNil())
-				Expect(variables).To(HaveLen(1))
-				Expect(variables[0].VariableID).To(Equal(2001))
-				Expect(variables[0].Name).To(Equal("distance"))
-				Expect(variables[0].Units).To(Equal("metres"))
-				Expect(variables[0].DisplayDecimalPlaces).To(Equal(2))
-				Expect(variables[0].Created).To(BeTemporally("==", time.Date(2015, 4, 7, 15, 0, 0, 0, time.UTC)))
-			})
-		})
+				Expect(db.BeginTransaction()).To(Succeed())
+				defer db.RollbackUncommittedTransaction()
 
-		Describe("GetAgentByID", func() {
-			BeforeEach(func() {
-				err := db.BeginTransaction()
+				count, err := db.RollUpData(2002, time.Date(2015, 4, 8, 0, 0, 0, 0, time.UTC))
 				Expect(err).To(BeNil())
-			})
+				Expect(db.CommitTransaction()).To(Succeed())
 
-			AfterEach(func() {
-				db.RollbackTransaction()
-			})
+				rolledUp <- count
+			}()
 
-			It("returns the agent if it exists", func() {
-				agent, err := db.GetAgentByID(1001)
-				Expect(err).To(BeNil())
-				Expect(agent.AgentID).To(Equal(1001))
-				Expect(agent.Name).To(Equal("First agent"))
-				Expect(agent.OwnerUserID).To(Equal(3001))
-				Expect(agent.TokenIterations).To(Equal(12301))
-				Expect(agent.TokenSalt).To(Equal([]byte("salt1001")))
-				Expect(agent.TokenHash).To(Equal([]byte("hash1001")))
-				Expect(agent.Created).To(BeTemporally("==", time.Date(2015, 3, 30, 2, 0, 0, 0, time.UTC)))
-			})
+			// The rollup has to wait for the changed point to be committed before it can remove it.
+			Consistently(rolledUp, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(session.CommitTransaction()).To(Succeed())
+			Eventually(rolledUp, 5*time.Second).Should(Receive(Equal(4)))
 
-			It("fails if the variable does not exist", func() {
-				agent, err := db.GetAgentByID(2)
-				Expect(err).ToNot(BeNil())
-				Expect(agent).To(Equal(Agent{}))
-			})
+			data, err := db.GetAggregatedData(1001, 2002, changed, changed.Add(time.Hour), time.Hour, "max", true)
+			Expect(err).To(BeNil())
+			Expect(data).To(Equal(map[string]float64{"2015-04-07T15:00:00Z": 201}))
 		})
+	})
 
-		Describe("CreateUser", func() {
-			It("saves new users to the database", func() {
-				created := time.Now().Round(time.Millisecond)
-				user := &User{
-					UserID:             0,
-					Email:              "test@example.com",
-					PasswordIterations: 1000,
-					PasswordSalt:       []byte("salty"),
-					PasswordHash:       []byte("pass"),
-					IsAdmin:            true,
-					Created:            created,
-				}
-
-				Expect(db.BeginTransaction()).To(BeNil())
-				err := db.CreateUser(user)
-				Expect(err).To(BeNil())
-				Expect(user.UserID).ToNot(Equal(0))
-
-				Expect(db.CommitTransaction()).To(BeNil())
+	backend := databaseTestBackend{
+		database:       func() Database { return db },
+		createTestData: func(db Database) { CreateTestData(db, func(t time.Time) interface{} { return t }) },
+	}
 
-				var actualEmail string
-				var actualPasswordIterations int
-				var actualPasswordSalt []byte
-				var actualPasswordHash []byte
-				var actualIsAdmin bool
-				var actualCreated time.Time
-				row := db.DB().QueryRow("SELECT email, password_iterations, password_salt, password_hash, is_admin, created FROM users WHERE user_id = $1", user.UserID)
-				err = row.Scan(&actualEmail, &actualPasswordIterations, &actualPasswordSalt, &actualPasswordHash, &actualIsAdmin, &actualCreated)
+	describeDatabaseBehaviour(backend)
+	describeSQLDatabaseBehaviour(backend)
 
-				Expect(err).To(BeNil())
-				Expect(actualEmail).To(Equal("test@example.com"))
-				Expect(actualPasswordIterations).To(Equal(1000))
-				Expect(actualPasswordSalt).To(Equal([]byte("salty")))
-				Expect(actualPasswordHash).To(Equal([]byte("pass")))
-				Expect(actualIsAdmin).To(Equal(true))
-				Expect(actualCreated).To(BeTemporally("==", created))
-			})
+	Context("with TimescaleDB", func() {
+		BeforeEach(func() {
+			createTimescaleDBExtension(db)
 		})
 
-		Describe("GetUserByEmail", func() {
-			BeforeEach(func() {
-				ExpectSucceeded(db.DB().Exec(`
-					INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin, created)
-					VALUES (1, 'test@testing.com', 12345, 'salty', 'pass', true, '2015-03-30 12:00:00+0:00');`))
-			})
-
-			It("retrieves the user if they exist", func() {
-				user, err := db.GetUserByEmail("test@testing.com")
-
-				Expect(err).To(BeNil())
-				Expect(user.Email).To(Equal("test@testing.com"))
-				Expect(user.PasswordIterations).To(Equal(12345))
-				Expect(user.PasswordSalt).To(Equal([]byte("salty")))
-				Expect(user.PasswordHash).To(Equal([]byte("pass")))
-				Expect(user.IsAdmin).To(Equal(true))
-				Expect(user.Created).To(BeTemporally("==", time.Date(2015, 3, 30, 12, 0, 0, 0, time.UTC)))
-			})
-
-			It("returns an error if they do not exist", func() {
-				user, err := db.GetUserByEmail("test@example.com")
+		describeDatabaseBehaviour(backend)
+	})
+})
 
-				Expect(err).ToNot(BeNil())
-				Expect(err.Error()).To(HavePrefix("Cannot find user"))
-				Expect(user).To(Equal(User{}))
-			})
-		})
+var _ = Describe("TimescaleDB versions", func() {
+	DescribeTable("parses the major and minor version", func(version string, expected extensionVersion) {
+		Expect(parseExtensionVersion(version)).To(Equal(expected))
+	},
+		Entry("a release", "2.11.2", extensionVersion{2, 11}),
+		Entry("a release without a patch version", "2.2", extensionVersion{2, 2}),
+		Entry("a development version", "2.14-dev", extensionVersion{2, 14}),
+	)
+
+	It("returns an error if the version cannot be parsed", func() {
+		_, err := parseExtensionVersion("latest")
+		Expect(err).To(MatchError("Extension version 'latest' is not valid."))
 	})
+
+	DescribeTable("only compresses data with versions that can change compressed data", func(version extensionVersion, expected bool) {
+		Expect(version.atLeast(compressionTimescaleDBVersion)).To(Equal(expected))
+	},
+		Entry("an earlier major version", extensionVersion{1, 15}, false),
+		Entry("an earlier minor version", extensionVersion{2, 10}, false),
+		Entry("the same version", extensionVersion{2, 11}, true),
+		Entry("a later minor version", extensionVersion{2, 14}, true),
+		Entry("a later major version", extensionVersion{3, 0}, true),
+	)
 })
 
+// createTimescaleDBExtension adds the TimescaleDB extension to the test database, skipping the test if the extension
+// is not available.
+func createTimescaleDBExtension(db Database) {
+	if _, err := db.DB().Exec("CREATE EXTENSION IF NOT EXISTS timescaledb;"); err != nil {
+		Skip("TimescaleDB is not available: " + err.Error())
+	}
+}
+
 func getTestDataSourceName() string {
 	envDataSource := os.Getenv("WEATHER_THINGY_TEST_DATA_SOURCE")
 
@@ -577,17 +290,3 @@ func removeTestDatabase(dataSourceName string, recreate bool) {
 		}
 	}
 }
-
-func CreateTestData(db Database) {
-	ExpectSucceeded(db.DB().Exec("INSERT INTO users (user_id, email, password_iterations, password_salt, password_hash, is_admin, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 3001, "blah@blah.com", 0, []byte{}, []byte{}, false, "2015-03-30 11:58:00+10:00"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1001, "First agent", 3001, 12301, []byte("salt1001"), []byte("hash1001"), "2015-03-30 12:00:00+10:00"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO agents (agent_id, name, owner_user_id, token_iterations, token_salt, token_hash, created) VALUES ($1, $2, $3, $4, $5, $6, $7)", 1002, "Second agent", 3001, 12302, []byte("salt1002"), []byte("hash1002"), "2015-02-17 08:00:00+12:00"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2001, "distance", "metres", 2, "2015-04-07T15:00:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO variables (variable_id, name, units, display_decimal_places, created) VALUES ($1, $2, $3, $4, $5)", 2002, "humidity", "%", 2, "2015-04-07T15:00:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2001, 100, "2015-04-07T15:00:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 101, "2015-04-07T15:00:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 103, "2015-04-07T15:01:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 104, "2015-04-07T15:02:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1001, 2002, 105, "2015-04-07T15:03:00Z"))
-	ExpectSucceeded(db.DB().Exec("INSERT INTO data (agent_id, variable_id, value, time) VALUES ($1, $2, $3, $4)", 1002, 2001, 102, "2015-04-07T15:00:00Z"))
-}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// continuousAggregateRefreshWindow is how far back the continuous aggregates are refreshed. Refreshing a bucket
+// computes it again from the data table alone, so it would be emptied if its data had been rolled up and removed:
+// RollUpData keeps this much raw data whatever the variable's retention period, so that refreshes never see that.
+// Buckets further back are left as they were when the data was removed, and data that arrives for them only changes
+// the rollups. Refreshing older buckets by hand with refresh_continuous_aggregate would empty them.
+const continuousAggregateRefreshWindow = 3 * 24 * time.Hour
+
+// minimumTimescaleDBVersion is the earliest version of TimescaleDB that can be used, as bucketExpression needs the origin
+// argument of time_bucket.
+var minimumTimescaleDBVersion = extensionVersion{2, 2}
+
+// compressionTimescaleDBVersion is the earliest version of TimescaleDB that can change or remove compressed data, which
+// happens when data is corrected, rolled up or its agent is deleted. Data is not compressed with earlier versions.
+var compressionTimescaleDBVersion = extensionVersion{2, 11}
+
+// timescaleDBSetup converts the data table to a hypertable, and creates continuous aggregates that summarise the good
+// data for each hour and day, for use by reporting tools.
+var timescaleDBSetup = []string{
+	"SELECT create_hypertable('data', 'time', migrate_data => TRUE);",
+	continuousAggregateDefinition("data_hourly_summary", "1 hour"),
+	continuousAggregatePolicy("data_hourly_summary", "1 hour"),
+	continuousAggregateDefinition("data_daily_summary", "1 day"),
+	continuousAggregatePolicy("data_daily_summary", "1 day"),
+}
+
+// timescaleDBCompressionSetup compresses data once it is a week old.
+var timescaleDBCompressionSetup = []string{
+	"ALTER TABLE data SET (timescaledb.compress, timescaledb.compress_segmentby = 'agent_id, variable_id', timescaledb.compress_orderby = 'time');",
+	"SELECT add_compression_policy('data', INTERVAL '7 days');",
+}
+
+// extensionVersion is the major and minor version of a PostgreSQL extension.
+type extensionVersion struct {
+	major int
+	minor int
+}
+
+// parseExtensionVersion reads the major and minor version from the version of an extension, such as 2.11.2 or 2.14.0-dev.
+func parseExtensionVersion(version string) (extensionVersion, error) {
+	parts := strings.SplitN(version, ".", 3)
+
+	if len(parts) < 2 {
+		return extensionVersion{}, fmt.Errorf("Extension version '%s' is not valid.", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return extensionVersion{}, fmt.Errorf("Extension version '%s' is not valid.", version)
+	}
+
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+
+	if err != nil {
+		return extensionVersion{}, fmt.Errorf("Extension version '%s' is not valid.", version)
+	}
+
+	return extensionVersion{major, minor}, nil
+}
+
+func (v extensionVersion) atLeast(other extensionVersion) bool {
+	return v.major > other.major || (v.major == other.major && v.minor >= other.minor)
+}
+
+func (v extensionVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// continuousAggregateDefinition returns the SQL that creates a continuous aggregate with the same columns as the rollup
+// tables, summarising the good data for each bucket of the given width.
+func continuousAggregateDefinition(name string, bucketWidth string) string {
+	bucket := "time_bucket(INTERVAL '" + bucketWidth + "', time)"
+
+	return "CREATE MATERIALIZED VIEW " + name + " WITH (timescaledb.continuous, timescaledb.materialized_only = FALSE) AS " +
+		"SELECT agent_id, variable_id, " + bucket + " AS time, MIN(value) AS min_value, MAX(value) AS max_value, AVG(value) AS avg_value, COUNT(*) AS count " +
+		"FROM data WHERE quality IN ('" + dataQualityGood + "', '" + dataQualityCorrected + "') GROUP BY agent_id, variable_id, " + bucket + " WITH NO DATA;"
+}
+
+// continuousAggregatePolicy returns the SQL that refreshes a continuous aggregate with buckets of the given width every
+// bucket, covering continuousAggregateRefreshWindow up until the start of the current bucket.
+func continuousAggregatePolicy(name string, bucketWidth string) string {
+	startOffset := "INTERVAL '" + strconv.Itoa(int(continuousAggregateRefreshWindow/time.Hour)) + " hours'"
+
+	return "SELECT add_continuous_aggregate_policy('" + name + "', start_offset => " + startOffset + ", " +
+		"end_offset => INTERVAL '" + bucketWidth + "', schedule_interval => INTERVAL '" + bucketWidth + "');"
+}
+
+// postgresFeatures records whether a database has the TimescaleDB extension, and which version, and is shared by every
+// session using the same connection pool so that it is only checked once.
+type postgresFeatures struct {
+	lock               sync.Mutex
+	detected           bool
+	timescaleDB        bool
+	timescaleDBVersion extensionVersion
+}
+
+// detectTimescaleDB checks whether the database has the TimescaleDB extension, and which version it has.
+func (d *PostgresDatabase) detectTimescaleDB() (bool, extensionVersion, error) {
+	d.features.lock.Lock()
+	defer d.features.lock.Unlock()
+
+	var version string
+	err := d.DatabaseHandle.QueryRow("SELECT extversion FROM pg_extension WHERE extname = 'timescaledb';").Scan(&version)
+
+	if err == sql.ErrNoRows {
+		d.features.timescaleDB = false
+	} else if err != nil {
+		return false, extensionVersion{}, err
+	} else if d.features.timescaleDBVersion, err = parseExtensionVersion(version); err != nil {
+		return false, extensionVersion{}, err
+	} else {
+		d.features.timescaleDB = true
+	}
+
+	d.features.detected = true
+
+	return d.features.timescaleDB, d.features.timescaleDBVersion, nil
+}
+
+// hasTimescaleDB returns whether the database has the TimescaleDB extension, checking only if it has not been checked
+// already. The service must be restarted to make use of the extension if it is installed while the service is running.
+func (d *PostgresDatabase) hasTimescaleDB() (bool, error) {
+	d.features.lock.Lock()
+	detected, timescaleDB := d.features.detected, d.features.timescaleDB
+	d.features.lock.Unlock()
+
+	if detected {
+		return timescaleDB, nil
+	}
+
+	timescaleDB, _, err := d.detectTimescaleDB()
+
+	return timescaleDB, err
+}
+
+// setUpTimescaleDB makes use of TimescaleDB if the database has the extension and the data table is not already a
+// hypertable. Data is compressed once the version of TimescaleDB can change compressed data, which may be after the
+// data table was converted.
+func (d *PostgresDatabase) setUpTimescaleDB() error {
+	timescaleDB, version, err := d.detectTimescaleDB()
+
+	if err != nil || !timescaleDB {
+		return err
+	}
+
+	if !version.atLeast(minimumTimescaleDBVersion) {
+		return fmt.Errorf("TimescaleDB %v is installed, but TimescaleDB %v or later is needed.", version, minimumTimescaleDBVersion)
+	}
+
+	var converted, compressed bool
+
+	err = d.DatabaseHandle.QueryRow("SELECT compression_enabled FROM timescaledb_information.hypertables WHERE hypertable_name = 'data';").Scan(&compressed)
+
+	if err == nil {
+		converted = true
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	statements := []string{}
+
+	if !converted {
+		statements = append(statements, timescaleDBSetup...)
+	}
+
+	if !compressed && version.atLeast(compressionTimescaleDBVersion) {
+		statements = append(statements, timescaleDBCompressionSetup...)
+	} else if !compressed {
+		logrus.Warnf("Data will not be compressed, as TimescaleDB %v cannot change compressed data. Upgrade to TimescaleDB %v or later to compress data.",
+			version, compressionTimescaleDBVersion)
+	}
+
+	if len(statements) == 0 {
+		return nil
+	}
+
+	tx, err := d.DatabaseHandle.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// bucketExpression returns the SQL that rounds the times in column down to the start of buckets of the length (in
+// seconds) given by parameter, aligned to the Unix epoch. TimescaleDB's time_bucket is used if it is available.
+func (d *PostgresDatabase) bucketExpression(column string, parameter string) (string, error) {
+	timescaleDB, err := d.hasTimescaleDB()
+
+	if err != nil {
+		return "", err
+	}
+
+	if timescaleDB {
+		return "time_bucket(" + parameter + " * INTERVAL '1 second', " + column + ", TIMESTAMPTZ 'epoch')", nil
+	}
+
+	return "TO_TIMESTAMP(FLOOR(EXTRACT(EPOCH FROM " + column + ") / " + parameter + ") * " + parameter + ")", nil
+}
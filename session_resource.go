@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/martini-contrib/render"
+)
+
+const sessionTokenBytes = 32
+const sessionLifetime = 30 * 24 * time.Hour
+
+type Session struct {
+	SessionID int
+	UserID    int
+	TokenHash []byte
+	Created   time.Time
+	Expires   time.Time
+}
+
+type PostSession struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func postSession(r render.Render, postedSession PostSession, db Database, log *logrus.Entry) {
+	user, err := db.GetUserByEmail(postedSession.Email)
+
+	if err != nil || subtle.ConstantTimeCompare(user.ComputePasswordHash(postedSession.Password), user.PasswordHash) != 1 {
+		log.Error("Could not create session because the email address or password do not match any known user.")
+		r.Text(http.StatusUnauthorized, "Email address or password do not match any known user.")
+		return
+	}
+
+	token, err := generateSessionToken()
+
+	if err != nil {
+		log.WithError(err).Error("Could not generate session token.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	session := Session{
+		UserID:    user.UserID,
+		TokenHash: computeSessionTokenHash(token),
+		Created:   now,
+		Expires:   now.Add(sessionLifetime),
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	if err := db.CreateSession(&session); err != nil {
+		log.WithError(err).Error("Could not create new session.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.JSON(http.StatusCreated, map[string]interface{}{
+		"token":   token,
+		"expires": session.Expires,
+	})
+}
+
+// deleteCurrentSession revokes the session token used to authenticate the request. session is nil if the request
+// was authenticated some other way.
+func deleteCurrentSession(r render.Render, session *Session, db Database, log *logrus.Entry) {
+	if session == nil {
+		r.Text(http.StatusBadRequest, "This request was not authenticated with a session token.")
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	if err := db.DeleteSession(session.SessionID); err != nil {
+		log.WithError(err).Error("Could not delete session.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		r.Error(http.StatusInternalServerError)
+		return
+	}
+
+	r.Status(http.StatusNoContent)
+}
+
+func generateSessionToken() (string, error) {
+	b := make([]byte, sessionTokenBytes)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// computeSessionTokenHash uses a single round of SHA-256 rather than computePasswordHash: session tokens are long
+// and random, so they cannot be brute-forced, and hashing them quickly is the point of using a session.
+func computeSessionTokenHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+
+	return hash[:]
+}
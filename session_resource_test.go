@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	"github.com/martini-contrib/binding"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session resource", func() {
+	var mockController *gomock.Controller
+	var render *MockRender
+	var db *MockDatabase
+	var logger *logrus.Entry
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		render = NewMockRender(mockController)
+		db = NewMockDatabase(mockController)
+		logger = logrus.NewEntry(logrus.StandardLogger())
+	})
+
+	AfterEach(func() {
+		mockController.Finish()
+	})
+
+	Describe("computeSessionTokenHash", func() {
+		It("should return a result of the expected size", func() {
+			Expect(computeSessionTokenHash("token")).To(HaveLen(sha256.Size))
+		})
+
+		It("should return different results for different tokens", func() {
+			Expect(computeSessionTokenHash("token1")).ToNot(Equal(computeSessionTokenHash("token2")))
+		})
+	})
+
+	Describe("POST data structure", func() {
+		Describe("validation", func() {
+			It("succeeds if all required properties are set", func() {
+				errors := TestValidation(`{"email":"test@example.com", "password":"password1"}`, PostSession{})
+				Expect(errors).To(BeEmpty())
+			})
+
+			DescribeTable("it fails if the data is invalid", func(body string, missingFieldName string) {
+				errors := TestValidation(body, PostSession{})
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[0].FieldNames).To(Equal([]string{missingFieldName}))
+				Expect(errors[0].Classification).To(Equal(binding.RequiredError))
+			},
+				Entry("because the email property is missing", `{"password":"password1"}`, "email"),
+				Entry("because the password property is missing", `{"email":"test@example.com"}`, "password"),
+			)
+		})
+	})
+
+	Describe("POST request handler", func() {
+		user := User{UserID: 1019, Email: "test@example.com"}
+		user.SetPassword("password1")
+
+		It("saves a new session and returns its token", func() {
+			var createdSession Session
+
+			createCall := db.EXPECT().CreateSession(gomock.Any()).Do(func(session *Session) error {
+				Expect(session.UserID).To(Equal(user.UserID))
+				Expect(session.TokenHash).To(HaveLen(sha256.Size))
+				Expect(session.Created).To(BeTemporally("~", time.Now(), time.Minute))
+				Expect(session.Expires).To(BeTemporally("==", session.Created.Add(sessionLifetime)))
+
+				session.SessionID = 2001
+				createdSession = *session
+
+				return nil
+			})
+
+			jsonCall := render.EXPECT().JSON(http.StatusCreated, gomock.Any()).Do(func(status int, value interface{}) {
+				Expect(value).To(HaveKeyWithValue("expires", createdSession.Expires))
+
+				token := value.(map[string]interface{})["token"].(string)
+				Expect(computeSessionTokenHash(token)).To(Equal(createdSession.TokenHash))
+			})
+
+			gomock.InOrder(
+				db.EXPECT().GetUserByEmail("test@example.com").Return(user, nil),
+				db.EXPECT().BeginTransaction(),
+				createCall,
+				db.EXPECT().CommitTransaction(),
+				jsonCall,
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			postSession(render, PostSession{Email: "test@example.com", Password: "password1"}, db, logger)
+		})
+
+		It("returns HTTP 401 if the password is incorrect", func() {
+			gomock.InOrder(
+				db.EXPECT().GetUserByEmail("test@example.com").Return(user, nil),
+				render.EXPECT().Text(http.StatusUnauthorized, "Email address or password do not match any known user."),
+			)
+
+			postSession(render, PostSession{Email: "test@example.com", Password: "wrong"}, db, logger)
+		})
+
+		It("returns HTTP 401 if the user does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().GetUserByEmail("test@example.com").Return(User{}, errors.New("Cannot find user with email 'test@example.com'.")),
+				render.EXPECT().Text(http.StatusUnauthorized, "Email address or password do not match any known user."),
+			)
+
+			postSession(render, PostSession{Email: "test@example.com", Password: "password1"}, db, logger)
+		})
+	})
+
+	Describe("DELETE current request handler", func() {
+		It("deletes the session used to authenticate the request", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().DeleteSession(2001),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Status(http.StatusNoContent),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			deleteCurrentSession(render, &Session{SessionID: 2001}, db, logger)
+		})
+
+		It("returns HTTP 400 if the request was not authenticated with a session", func() {
+			render.EXPECT().Text(http.StatusBadRequest, gomock.Any())
+
+			deleteCurrentSession(render, nil, db, logger)
+		})
+	})
+})
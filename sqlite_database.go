@@ -0,0 +1,1421 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rubenv/sql-migrate"
+)
+
+// sqliteTimeFormat is the format times are stored in. SQLite has no time type, so times are stored as text, always in
+// UTC and with the same number of digits so that comparing the text of two times compares the times themselves.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// sqliteConnectionOptions are added to the path of every SQLite database. SQLite only enforces foreign keys if asked to
+// for each connection. Transactions take the write lock as soon as they begin, so that concurrent transactions wait for
+// each other instead of failing part way through, and WAL mode lets reads outside of a transaction continue meanwhile.
+const sqliteConnectionOptions = "_foreign_keys=1&_busy_timeout=10000&_txlock=immediate&_journal_mode=WAL"
+
+type SQLiteDatabase struct {
+	DatabaseHandle     *sql.DB
+	CurrentTransaction *sql.Tx
+}
+
+// connectToSQLiteDatabase opens the SQLite database at path, creating it if it does not exist.
+func connectToSQLiteDatabase(path string) (Database, error) {
+	separator := "?"
+
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite3", path+separator+sqliteConnectionOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteDatabase{DatabaseHandle: db}, nil
+}
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func nullableSQLiteTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+
+	formatted := sqliteTime(t)
+	return &formatted
+}
+
+// sqliteBlob returns value, or an empty slice if value is nil, as nil would otherwise be stored as NULL.
+func sqliteBlob(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+
+	return value
+}
+
+func (d *SQLiteDatabase) RunMigrations() (int, error) {
+	migrationSource := getSQLiteMigrationSource()
+
+	n, err := migrate.Exec(d.DatabaseHandle, "sqlite3", migrationSource, migrate.Up)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (d *SQLiteDatabase) Close() {
+	d.DatabaseHandle.Close()
+}
+
+// NewSession returns a Database that shares this database's connection pool, but has its own transaction state.
+// Sessions should not be closed, as doing so closes the shared connection pool.
+func (d *SQLiteDatabase) NewSession() Database {
+	return &SQLiteDatabase{DatabaseHandle: d.DatabaseHandle}
+}
+
+func (d *SQLiteDatabase) DB() *sql.DB {
+	return d.DatabaseHandle
+}
+
+func (d *SQLiteDatabase) Transaction() *sql.Tx {
+	return d.CurrentTransaction
+}
+
+func (d *SQLiteDatabase) BeginTransaction() error {
+	if d.CurrentTransaction != nil {
+		return errors.New("Cannot call BeginTransaction when there is already a transaction in progress.")
+	}
+
+	tx, err := d.DatabaseHandle.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	d.CurrentTransaction = tx
+	return nil
+}
+
+func (d *SQLiteDatabase) CommitTransaction() error {
+	if d.CurrentTransaction == nil {
+		return errors.New("Cannot call CommitTransaction when there is no transaction in progress.")
+	}
+
+	if err := d.CurrentTransaction.Commit(); err != nil {
+		return err
+	}
+
+	d.CurrentTransaction = nil
+	return nil
+}
+
+func (d *SQLiteDatabase) RollbackTransaction() error {
+	if d.CurrentTransaction == nil {
+		return errors.New("Cannot call RollbackTransaction when there is no transaction in progress.")
+	}
+
+	if err := d.CurrentTransaction.Rollback(); err != nil {
+		return err
+	}
+
+	d.CurrentTransaction = nil
+	return nil
+}
+
+func (d *SQLiteDatabase) RollbackUncommittedTransaction() error {
+	if d.CurrentTransaction == nil {
+		return nil
+	}
+
+	return d.RollbackTransaction()
+}
+
+// insert runs an INSERT statement in the current transaction and returns the ID of the new row.
+func (d *SQLiteDatabase) insert(query string, args ...interface{}) (int, error) {
+	result, err := d.CurrentTransaction.Exec(query, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (d *SQLiteDatabase) CreateAgent(agent *Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	metadata, err := encodeAgentMetadata(agent.Metadata)
+
+	if err != nil {
+		return err
+	}
+
+	agent.AgentID, err = d.insert(
+		"INSERT INTO agents (name, owner_user_id, token_iterations, token_salt, token_hash, metadata, offline_after, created) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8);",
+		agent.Name,
+		agent.OwnerUserID,
+		agent.TokenIterations,
+		sqliteBlob(agent.TokenSalt),
+		sqliteBlob(agent.TokenHash),
+		string(metadata),
+		int64(agent.OfflineAfter),
+		sqliteTime(agent.Created))
+
+	return err
+}
+
+// UpdateAgent saves the name, metadata and offline interval of an existing agent.
+func (d *SQLiteDatabase) UpdateAgent(agent Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	metadata, err := encodeAgentMetadata(agent.Metadata)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET name = ?2, metadata = ?3, offline_after = ?4 WHERE agent_id = ?1 AND deleted IS NULL;",
+		agent.AgentID, agent.Name, string(metadata), int64(agent.OfflineAfter))
+
+	return checkAgentRowAffected(result, err, agent.AgentID)
+}
+
+// DeleteAgent removes an agent and all of the data it has reported.
+func (d *SQLiteDatabase) DeleteAgent(agentID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	if _, err := d.CurrentTransaction.Exec("DELETE FROM data WHERE agent_id = ?1;", agentID); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("DELETE FROM agents WHERE agent_id = ?1;", agentID)
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// SoftDeleteAgent hides an agent from all other queries, but keeps it and the data it has reported in the database.
+func (d *SQLiteDatabase) SoftDeleteAgent(agentID int, deleted time.Time) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET deleted = ?2 WHERE agent_id = ?1 AND deleted IS NULL;", agentID, sqliteTime(deleted))
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// UpdateAgentToken saves the current and previous tokens of an existing agent.
+func (d *SQLiteDatabase) UpdateAgentToken(agent Agent) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET token_iterations = ?2, token_salt = ?3, token_hash = ?4, "+
+		"previous_token_iterations = ?5, previous_token_salt = ?6, previous_token_hash = ?7, previous_token_expires = ?8 "+
+		"WHERE agent_id = ?1 AND deleted IS NULL;",
+		agent.AgentID, agent.TokenIterations, sqliteBlob(agent.TokenSalt), sqliteBlob(agent.TokenHash),
+		agent.PreviousTokenIterations, sqliteBlob(agent.PreviousTokenSalt), sqliteBlob(agent.PreviousTokenHash), nullableSQLiteTime(agent.PreviousTokenExpires))
+
+	return checkAgentRowAffected(result, err, agent.AgentID)
+}
+
+// UpdateAgentLastSeen records that the agent was seen at the given time, unless it has already been seen since then.
+func (d *SQLiteDatabase) UpdateAgentLastSeen(agentID int, seen time.Time) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE agents SET last_seen = MAX(COALESCE(last_seen, ?2), ?2) WHERE agent_id = ?1 AND deleted IS NULL;",
+		agentID, sqliteTime(seen))
+
+	return checkAgentRowAffected(result, err, agentID)
+}
+
+// MarkAgentsOffline marks each agent that has not been seen within its offline interval as offline, and returns them.
+// Agents that have never been seen are measured from when they were created.
+func (d *SQLiteDatabase) MarkAgentsOffline(now time.Time) ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	// SQLite cannot add an interval to a time, so the agents that have gone offline are found here instead.
+	candidates, err := d.selectAgents("SELECT " + agentColumns + " FROM agents WHERE deleted IS NULL AND offline_since IS NULL AND offline_after > 0;")
+
+	if err != nil {
+		return nil, err
+	}
+
+	agents := []Agent{}
+
+	for _, agent := range candidates {
+		lastSeen := agent.Created
+
+		if agent.LastSeen != nil {
+			lastSeen = *agent.LastSeen
+		}
+
+		if !lastSeen.Add(time.Duration(agent.OfflineAfter)).Before(now) {
+			continue
+		}
+
+		if _, err := d.CurrentTransaction.Exec("UPDATE agents SET offline_since = ?2 WHERE agent_id = ?1;", agent.AgentID, sqliteTime(now)); err != nil {
+			return nil, err
+		}
+
+		offlineSince := now
+		agent.OfflineSince = &offlineSince
+		agents = append(agents, agent)
+	}
+
+	return agents, nil
+}
+
+// MarkAgentsOnline clears the offline flag of each agent that has been seen since it was marked as offline, and returns them.
+func (d *SQLiteDatabase) MarkAgentsOnline() ([]Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	const condition = " WHERE deleted IS NULL AND last_seen > offline_since;"
+	agents, err := d.selectAgents("SELECT " + agentColumns + " FROM agents" + condition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.CurrentTransaction.Exec("UPDATE agents SET offline_since = NULL" + condition); err != nil {
+		return nil, err
+	}
+
+	for i := range agents {
+		agents[i].OfflineSince = nil
+	}
+
+	return agents, nil
+}
+
+func (d *SQLiteDatabase) selectAgents(query string, args ...interface{}) ([]Agent, error) {
+	rows, err := d.CurrentTransaction.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	agents := []Agent{}
+
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		agents = append(agents, agent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return agents, nil
+}
+
+// GetAllAgents returns the agents that match filter, regardless of who owns them.
+func (d *SQLiteDatabase) GetAllAgents(filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents("", []interface{}{}, filter)
+}
+
+// GetAgentsForUser returns the agents owned by the given user that match filter.
+func (d *SQLiteDatabase) GetAgentsForUser(userID int, filter AgentFilter) ([]Agent, error) {
+	return d.queryAgents("owner_user_id = ?1 AND ", []interface{}{userID}, filter)
+}
+
+func (d *SQLiteDatabase) queryAgents(condition string, args []interface{}, filter AgentFilter) ([]Agent, error) {
+	n := len(args)
+	query := fmt.Sprintf("SELECT "+agentColumns+" FROM agents WHERE %sdeleted IS NULL AND name LIKE ?%d ESCAPE '\\' "+
+		"ORDER BY agent_id LIMIT ?%d OFFSET ?%d;", condition, n+1, n+2, n+3)
+
+	// A negative limit means there is no limit.
+	limit := -1
+
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	args = append(args, "%"+escapeLikePattern(filter.Name)+"%", limit, filter.Offset)
+	rows, err := d.DB().Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	agents := []Agent{}
+
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		agents = append(agents, agent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return agents, nil
+}
+
+func (d *SQLiteDatabase) CreateVariable(variable *Variable) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	variable.VariableID, err = d.insert("INSERT INTO variables (name, units, display_decimal_places, created, min_value, max_value, max_step, out_of_range, raw_data_days) "+
+		"VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9);", variable.Name, variable.Units, variable.DisplayDecimalPlaces, sqliteTime(variable.Created),
+		variable.MinValue, variable.MaxValue, variable.MaxStep, variable.OutOfRangePolicy(), variable.RawDataDays)
+
+	return err
+}
+
+// UpdateVariableLimits replaces the limits of a variable, and returns false if the variable does not exist.
+func (d *SQLiteDatabase) UpdateVariableLimits(variableID int, limits VariableLimits) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE variables SET min_value = ?2, max_value = ?3, max_step = ?4, out_of_range = ?5 WHERE variable_id = ?1;",
+		variableID, limits.MinValue, limits.MaxValue, limits.MaxStep, limits.OutOfRangePolicy())
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// UpdateVariableRetention replaces the retention policy of a variable, and returns false if the variable does not exist.
+func (d *SQLiteDatabase) UpdateVariableRetention(variableID int, retention VariableRetention) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE variables SET raw_data_days = ?2 WHERE variable_id = ?1;", variableID, retention.RawDataDays)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetAllVariables returns every variable, in order of ID.
+func (d *SQLiteDatabase) GetAllVariables() ([]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return []Variable{}, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT " + variableColumns + " FROM variables ORDER BY variable_id;")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	variables := []Variable{}
+
+	for rows.Next() {
+		variable, err := scanVariable(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, variable)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+// nextDataSequenceNumbers reserves count sequence numbers for data points, and returns the first of them. SQLite has no
+// sequences, so the last number given out is kept in a table. Each transaction holds the database's write lock once it
+// has saved anything, so transactions commit in the order of the sequence numbers they were given.
+func (d *SQLiteDatabase) nextDataSequenceNumbers(count int) (int, error) {
+	if _, err := d.CurrentTransaction.Exec("UPDATE data_sequence_number SET last_value = last_value + ?1;", count); err != nil {
+		return 0, err
+	}
+
+	var last int
+
+	if err := d.CurrentTransaction.QueryRow("SELECT last_value FROM data_sequence_number;").Scan(&last); err != nil {
+		return 0, err
+	}
+
+	return last - count + 1, nil
+}
+
+// AddDataPoint saves a data point, and returns true if there was already a value for the same agent, variable and time.
+// The existing value is kept unless conflictPolicy is conflictPolicyOverwrite.
+func (d *SQLiteDatabase) AddDataPoint(dataPoint DataPoint, conflictPolicy string) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	if conflictPolicy != conflictPolicyReject && conflictPolicy != conflictPolicyIgnore && conflictPolicy != conflictPolicyOverwrite {
+		return false, fmt.Errorf("Unknown conflict policy '%s'.", conflictPolicy)
+	}
+
+	sequenceNumber, err := d.nextDataSequenceNumbers(1)
+
+	if err != nil {
+		return false, err
+	}
+
+	args := []interface{}{dataPoint.AgentID, dataPoint.VariableID, sqliteTime(dataPoint.Time), dataPoint.Value, dataPoint.QualityFlag(), sequenceNumber}
+	result, err := d.CurrentTransaction.Exec("INSERT INTO data (agent_id, variable_id, time, value, quality, sequence_number) VALUES (?1, ?2, ?3, ?4, ?5, ?6) "+
+		"ON CONFLICT (agent_id, variable_id, time) DO NOTHING;", args...)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	existed := n == 0
+
+	if existed && conflictPolicy == conflictPolicyOverwrite {
+		if _, err := d.CurrentTransaction.Exec("UPDATE data SET value = ?4, quality = ?5, sequence_number = ?6 WHERE agent_id = ?1 AND variable_id = ?2 AND time = ?3;", args...); err != nil {
+			return false, err
+		}
+	}
+
+	return existed, nil
+}
+
+// sqliteBatchSize is the number of points AddDataPoints saves with each statement, which keeps the number of
+// parameters in each statement (six for each point) within the limit of 999 that older versions of SQLite have.
+const sqliteBatchSize = 150
+
+// AddDataPoints saves a batch of data points with the same result as calling AddDataPoint for each in turn, and
+// returns whether there was already a value for each point. SQLite runs in the same process, so there are no round
+// trips to save: instead, the statements that look up each point and save the points in groups are prepared once and
+// reused for the whole batch.
+func (d *SQLiteDatabase) AddDataPoints(dataPoints []DataPoint, conflictPolicy string) ([]bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	onConflict := "DO NOTHING"
+
+	switch conflictPolicy {
+	case conflictPolicyReject, conflictPolicyIgnore:
+	case conflictPolicyOverwrite:
+		onConflict = "DO UPDATE SET value = excluded.value, quality = excluded.quality, sequence_number = excluded.sequence_number"
+	default:
+		return nil, fmt.Errorf("Unknown conflict policy '%s'.", conflictPolicy)
+	}
+
+	points, positions, existed := batchDataPoints(dataPoints, conflictPolicy)
+
+	if len(points) == 0 {
+		return existed, nil
+	}
+
+	lookup, err := d.CurrentTransaction.Prepare("SELECT EXISTS (SELECT 1 FROM data WHERE agent_id = ?1 AND variable_id = ?2 AND time = ?3);")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer lookup.Close()
+	times := make([]string, len(points))
+
+	for i, point := range points {
+		var found bool
+		times[i] = sqliteTime(point.Time)
+
+		if err := lookup.QueryRow(point.AgentID, point.VariableID, times[i]).Scan(&found); err != nil {
+			return nil, err
+		}
+
+		if found {
+			existed[positions[i]] = true
+		}
+	}
+
+	firstSequenceNumber, err := d.nextDataSequenceNumbers(len(points))
+
+	if err != nil {
+		return nil, err
+	}
+
+	inserts := map[int]*sql.Stmt{}
+
+	defer func() {
+		for _, insert := range inserts {
+			insert.Close()
+		}
+	}()
+
+	for start := 0; start < len(points); start += sqliteBatchSize {
+		chunk := points[start:]
+
+		if len(chunk) > sqliteBatchSize {
+			chunk = chunk[:sqliteBatchSize]
+		}
+
+		insert, ok := inserts[len(chunk)]
+
+		if !ok {
+			values := make([]string, len(chunk))
+
+			for i := range chunk {
+				values[i] = fmt.Sprintf("(?%d, ?%d, ?%d, ?%d, ?%d, ?%d)", 6*i+1, 6*i+2, 6*i+3, 6*i+4, 6*i+5, 6*i+6)
+			}
+
+			if insert, err = d.CurrentTransaction.Prepare("INSERT INTO data (agent_id, variable_id, time, value, quality, sequence_number) VALUES " +
+				strings.Join(values, ", ") + " ON CONFLICT (agent_id, variable_id, time) " + onConflict + ";"); err != nil {
+				return nil, err
+			}
+
+			inserts[len(chunk)] = insert
+		}
+
+		args := make([]interface{}, 0, 6*len(chunk))
+
+		for i, point := range chunk {
+			args = append(args, point.AgentID, point.VariableID, times[start+i], point.Value, point.QualityFlag(), firstSequenceNumber+start+i)
+		}
+
+		if _, err := insert.Exec(args...); err != nil {
+			return nil, err
+		}
+	}
+
+	return existed, nil
+}
+
+func (d *SQLiteDatabase) CheckAgentIDExists(agentID int) (bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return false, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT COUNT(*) FROM agents WHERE agent_id = ?1 AND deleted IS NULL;", agentID)
+	count := 0
+
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return (count > 0), nil
+}
+
+func (d *SQLiteDatabase) GetVariableIDForName(name string) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	var variableID int
+	row := d.CurrentTransaction.QueryRow("SELECT variable_id FROM variables WHERE name = ?1;", name)
+
+	if err := row.Scan(&variableID); err == sql.ErrNoRows {
+		return -1, fmt.Errorf("Cannot find variable with name '%s'.", name)
+	} else if err != nil {
+		return 0, err
+	}
+
+	return variableID, nil
+}
+
+// GetPreviousValue returns the latest good value for the agent and variable from before the given time, and false if
+// there is none.
+func (d *SQLiteDatabase) GetPreviousValue(agentID int, variableID int, before time.Time) (float64, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, false, err
+	}
+
+	var value float64
+	row := d.CurrentTransaction.QueryRow("SELECT value FROM data WHERE agent_id = ?1 AND variable_id = ?2 AND time < ?3 AND quality = ?4 "+
+		"ORDER BY time DESC LIMIT 1;", agentID, variableID, sqliteTime(before), dataQualityGood)
+
+	if err := row.Scan(&value); err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return value, true, nil
+}
+
+// GetData returns the points for the agent and variable in the range given. Where the points have been rolled up, the
+// average of each hourly rollup is given in their place.
+func (d *SQLiteDatabase) GetData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool) (map[string]float64, error) {
+	rollups, err := d.queryRollups(agentID, variableID, rollupResolutionHourly, fromDate, toDate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]float64{}
+
+	for _, rollup := range rollups {
+		m[rollup.Time.In(time.UTC).Format(time.RFC3339)] = rollup.Avg
+	}
+
+	err = d.queryData(agentID, variableID, fromDate, toDate, includeFlagged, func(t time.Time, value float64) {
+		m[t.In(time.UTC).Format(time.RFC3339)] = value
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GetAggregatedData groups points into buckets of the given interval (aligned to the Unix epoch) and
+// returns the aggregate of each bucket, keyed by the start time of the bucket. Points that have been rolled up are
+// included using the rollups that suit the interval best.
+func (d *SQLiteDatabase) GetAggregatedData(agentID int, variableID int, fromDate time.Time, toDate time.Time, interval time.Duration, aggregate string, includeFlagged bool) (map[string]float64, error) {
+	// SQLite has no way to round a time down to a bucket, so the points are grouped here instead.
+	return aggregateData(interval, aggregate, func(callback func(DataRollup)) error {
+		rollups, err := d.queryRollups(agentID, variableID, rollupResolutionFor(interval), fromDate, toDate)
+
+		if err != nil {
+			return err
+		}
+
+		err = d.queryData(agentID, variableID, fromDate, toDate, includeFlagged, func(t time.Time, value float64) {
+			rollups = append(rollups, rollupOfValue(t, value))
+		})
+
+		if err != nil {
+			return err
+		}
+
+		sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Time.Before(rollups[j].Time) })
+
+		for _, rollup := range rollups {
+			callback(rollup)
+		}
+
+		return nil
+	})
+}
+
+// queryRollups returns the rollups of the given resolution for the agent and variable that start in the range given,
+// in order of time.
+func (d *SQLiteDatabase) queryRollups(agentID int, variableID int, resolution time.Duration, fromDate time.Time, toDate time.Time) ([]DataRollup, error) {
+	rows, err := d.DB().Query("SELECT time, min_value, max_value, avg_value, count FROM "+rollupTables[resolution]+
+		" WHERE agent_id = ?1 AND variable_id = ?2 AND time >= ?3 AND time <= ?4 ORDER BY time;",
+		agentID, variableID, sqliteTime(fromDate), sqliteTime(toDate))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	rollups := []DataRollup{}
+
+	for rows.Next() {
+		rollup := DataRollup{AgentID: agentID, VariableID: variableID}
+
+		if err := rows.Scan(&rollup.Time, &rollup.Min, &rollup.Max, &rollup.Avg, &rollup.Count); err != nil {
+			return nil, err
+		}
+
+		rollups = append(rollups, rollup)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// RollUpData summarises the variable's good data from before the given time into hourly and daily rollups, adding to
+// any rollups already made for the same periods, and then removes the data. Suspect and bad points are kept, because
+// they are not in the rollups and could still be corrected. It returns the number of points removed.
+func (d *SQLiteDatabase) RollUpData(variableID int, before time.Time) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT agent_id, time, value, quality FROM data WHERE variable_id = ?1 AND time < ?2"+unflaggedDataCondition+";",
+		variableID, sqliteTime(before))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+	points := []DataPoint{}
+
+	for rows.Next() {
+		point := DataPoint{VariableID: variableID}
+
+		if err := rows.Scan(&point.AgentID, &point.Time, &point.Value, &point.Quality); err != nil {
+			return 0, err
+		}
+
+		points = append(points, point)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, resolution := range rollupResolutions {
+		table := rollupTables[resolution]
+
+		for _, rollup := range rollUpPoints(points, resolution) {
+			_, err := d.CurrentTransaction.Exec("INSERT INTO "+table+" (agent_id, variable_id, time, min_value, max_value, avg_value, count) "+
+				"VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7) ON CONFLICT (agent_id, variable_id, time) DO UPDATE SET "+
+				"min_value = MIN(min_value, excluded.min_value), "+
+				"max_value = MAX(max_value, excluded.max_value), "+
+				"avg_value = (avg_value * count + excluded.avg_value * excluded.count) / (count + excluded.count), "+
+				"count = count + excluded.count;",
+				rollup.AgentID, rollup.VariableID, sqliteTime(rollup.Time), rollup.Min, rollup.Max, rollup.Avg, rollup.Count)
+
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	result, err := d.CurrentTransaction.Exec("DELETE FROM data WHERE variable_id = ?1 AND time < ?2"+unflaggedDataCondition+";", variableID, sqliteTime(before))
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
+
+// queryData calls callback with the time and value of each point for the agent and variable in the range given, in
+// order of time.
+func (d *SQLiteDatabase) queryData(agentID int, variableID int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(time.Time, float64)) error {
+	rows, err := d.DB().Query("SELECT value, time FROM data WHERE agent_id = ?1 AND variable_id = ?2 AND time >= ?3 AND time <= ?4"+
+		flaggedDataCondition(includeFlagged)+" ORDER BY time;",
+		agentID, variableID, sqliteTime(fromDate), sqliteTime(toDate))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var value float64
+		var t time.Time
+
+		if err := rows.Scan(&value, &t); err != nil {
+			return err
+		}
+
+		callback(t, value)
+	}
+
+	return rows.Err()
+}
+
+// StreamData calls callback with each data point for the given variables in turn, ordered by time and then variable ID,
+// without loading all of the data points into memory at once. If callback returns an error, no further points are read
+// and the error is returned.
+func (d *SQLiteDatabase) StreamData(agentID int, variableIDs []int, fromDate time.Time, toDate time.Time, includeFlagged bool, callback func(DataPoint) error) error {
+	if len(variableIDs) == 0 {
+		return nil
+	}
+
+	args := []interface{}{agentID, sqliteTime(fromDate), sqliteTime(toDate)}
+	placeholders := make([]string, len(variableIDs))
+
+	for i, variableID := range variableIDs {
+		args = append(args, variableID)
+		placeholders[i] = fmt.Sprintf("?%d", len(args))
+	}
+
+	rows, err := d.DB().Query("SELECT variable_id, time, value, quality FROM data WHERE agent_id = ?1 AND time >= ?2 AND time <= ?3 "+
+		"AND variable_id IN ("+strings.Join(placeholders, ", ")+")"+flaggedDataCondition(includeFlagged)+" ORDER BY time, variable_id;",
+		args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value, &point.Quality); err != nil {
+			return err
+		}
+
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetLastDataSequenceNumber returns the highest sequence number given to the agent's data, or 0 if there is none.
+func (d *SQLiteDatabase) GetLastDataSequenceNumber(agentID int) (int, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return 0, err
+	}
+
+	var sequenceNumber int
+	row := d.CurrentTransaction.QueryRow("SELECT COALESCE(MAX(sequence_number), 0) FROM data WHERE agent_id = ?1;", agentID)
+
+	if err := row.Scan(&sequenceNumber); err != nil {
+		return 0, err
+	}
+
+	return sequenceNumber, nil
+}
+
+// StreamDataSavedAfter calls callback with each of the agent's points that was saved after the point with the given
+// sequence number, in the order they were saved, stopping after limit points. Points are given a new sequence number
+// when they are overwritten.
+func (d *SQLiteDatabase) StreamDataSavedAfter(agentID int, sequenceNumber int, limit int, callback func(DataPoint) error) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT variable_id, time, value, quality, sequence_number FROM data WHERE agent_id = ?1 AND sequence_number > ?2 "+
+		"ORDER BY sequence_number LIMIT ?3;", agentID, sequenceNumber, limit)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value, &point.Quality, &point.SequenceNumber); err != nil {
+			return err
+		}
+
+		if err := callback(point); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetDataPoint returns the point for the agent and variable at the given time, and false if there is none. Nothing
+// else can change the point until the end of the transaction, as each transaction holds the database's write lock.
+func (d *SQLiteDatabase) GetDataPoint(agentID int, variableID int, t time.Time) (DataPoint, bool, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return DataPoint{}, false, err
+	}
+
+	point := DataPoint{AgentID: agentID, VariableID: variableID}
+	row := d.CurrentTransaction.QueryRow("SELECT time, value, quality FROM data WHERE agent_id = ?1 AND variable_id = ?2 AND time = ?3;",
+		agentID, variableID, sqliteTime(t))
+
+	if err := row.Scan(&point.Time, &point.Value, &point.Quality); err == sql.ErrNoRows {
+		return DataPoint{}, false, nil
+	} else if err != nil {
+		return DataPoint{}, false, err
+	}
+
+	return point, true, nil
+}
+
+// AddDataCorrection records a change made by hand to a data point.
+func (d *SQLiteDatabase) AddDataCorrection(correction *DataCorrection) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	correction.CorrectionID, err = d.insert(
+		"INSERT INTO data_corrections (agent_id, variable_id, time, original_value, original_quality, value, quality, user_id, created) "+
+			"VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9);",
+		correction.AgentID,
+		correction.VariableID,
+		sqliteTime(correction.Time),
+		correction.OriginalValue,
+		correction.OriginalQuality,
+		correction.Value,
+		correction.Quality,
+		correction.UserID,
+		sqliteTime(correction.Created),
+	)
+
+	return err
+}
+
+// GetDataCorrections returns every change made by hand to the agent's data, most recent first.
+func (d *SQLiteDatabase) GetDataCorrections(agentID int) ([]DataCorrection, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT correction_id, agent_id, variable_id, time, original_value, original_quality, value, quality, "+
+		"user_id, created FROM data_corrections WHERE agent_id = ?1 ORDER BY created DESC, correction_id DESC;", agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	corrections := []DataCorrection{}
+
+	for rows.Next() {
+		correction := DataCorrection{}
+
+		if err := rows.Scan(&correction.CorrectionID, &correction.AgentID, &correction.VariableID, &correction.Time, &correction.OriginalValue,
+			&correction.OriginalQuality, &correction.Value, &correction.Quality, &correction.UserID, &correction.Created); err != nil {
+			return nil, err
+		}
+
+		corrections = append(corrections, correction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return corrections, nil
+}
+
+// GetLatestData returns the most recent data point for each variable the agent has reported, keyed by variable ID.
+func (d *SQLiteDatabase) GetLatestData(agentID int) (map[int]DataPoint, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	// Finding the latest point for each variable in turn uses one index lookup per variable, rather than reading all of
+	// the agent's data. CROSS JOIN stops SQLite from reordering the tables and reading the agent's data first.
+	rows, err := d.CurrentTransaction.Query("SELECT data.variable_id, data.time, data.value FROM variables "+
+		"CROSS JOIN data ON data.agent_id = ?1 AND data.variable_id = variables.variable_id "+
+		"AND data.time = (SELECT MAX(time) FROM data WHERE agent_id = ?1 AND variable_id = variables.variable_id);",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	m := map[int]DataPoint{}
+
+	for rows.Next() {
+		point := DataPoint{AgentID: agentID}
+
+		if err := rows.Scan(&point.VariableID, &point.Time, &point.Value); err != nil {
+			return nil, err
+		}
+
+		m[point.VariableID] = point
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (d *SQLiteDatabase) GetVariableByID(variableID int) (Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Variable{}, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT "+variableColumns+" FROM variables WHERE variable_id = ?1;", variableID)
+
+	return scanVariable(row)
+}
+
+// GetVariablesByName returns the variables with any of the given names, keyed by name, with a single query. Names that
+// do not match a variable are left out.
+func (d *SQLiteDatabase) GetVariablesByName(names []string) (map[string]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	variables := map[string]Variable{}
+
+	if len(names) == 0 {
+		return variables, nil
+	}
+
+	args := make([]interface{}, len(names))
+	placeholders := make([]string, len(names))
+
+	for i, name := range names {
+		args[i] = name
+		placeholders[i] = fmt.Sprintf("?%d", i+1)
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT "+variableColumns+" FROM variables WHERE name IN ("+strings.Join(placeholders, ", ")+");", args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		variable, err := scanVariable(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables[variable.Name] = variable
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+func (d *SQLiteDatabase) GetVariablesForAgent(agentID int) ([]Variable, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return []Variable{}, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT "+variableColumns+" FROM variables "+
+		"WHERE variable_id IN (SELECT DISTINCT variable_id FROM data WHERE agent_id = ?1);",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	variables := []Variable{}
+
+	for rows.Next() {
+		variable, err := scanVariable(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, variable)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+func (d *SQLiteDatabase) GetAgentByID(agentID int) (Agent, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return Agent{}, err
+	}
+
+	row := d.CurrentTransaction.QueryRow("SELECT "+agentColumns+" FROM agents WHERE agent_id = ?1 AND deleted IS NULL;", agentID)
+
+	return scanAgent(row)
+}
+
+func (d *SQLiteDatabase) CreateUser(user *User) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	user.UserID, err = d.insert(
+		"INSERT INTO users (email, password_iterations, password_salt, password_hash, is_admin, created) VALUES (?1, ?2, ?3, ?4, ?5, ?6);",
+		user.Email,
+		user.PasswordIterations,
+		sqliteBlob(user.PasswordSalt),
+		sqliteBlob(user.PasswordHash),
+		user.IsAdmin,
+		sqliteTime(user.Created),
+	)
+
+	return err
+}
+
+func (d *SQLiteDatabase) GetUserByEmail(email string) (User, error) {
+	user := User{}
+	row := d.DB().QueryRow(
+		`SELECT user_id, email, password_iterations, password_salt, password_hash, is_admin, created
+		 FROM users WHERE email = ?1;`,
+		email)
+
+	if err := row.Scan(&user.UserID, &user.Email, &user.PasswordIterations, &user.PasswordSalt, &user.PasswordHash, &user.IsAdmin, &user.Created); err == sql.ErrNoRows {
+		return User{}, fmt.Errorf("Cannot find user with email '%s'.", email)
+	} else if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (d *SQLiteDatabase) GetUserByID(userID int) (User, error) {
+	user := User{}
+	row := d.DB().QueryRow(
+		`SELECT user_id, email, password_iterations, password_salt, password_hash, is_admin, created
+		 FROM users WHERE user_id = ?1;`,
+		userID)
+
+	if err := row.Scan(&user.UserID, &user.Email, &user.PasswordIterations, &user.PasswordSalt, &user.PasswordHash, &user.IsAdmin, &user.Created); err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (d *SQLiteDatabase) CreateSession(session *Session) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	session.SessionID, err = d.insert(
+		"INSERT INTO sessions (user_id, token_hash, created, expires) VALUES (?1, ?2, ?3, ?4);",
+		session.UserID,
+		sqliteBlob(session.TokenHash),
+		sqliteTime(session.Created),
+		sqliteTime(session.Expires),
+	)
+
+	return err
+}
+
+func (d *SQLiteDatabase) GetSessionByTokenHash(tokenHash []byte) (Session, error) {
+	session := Session{}
+	row := d.DB().QueryRow("SELECT session_id, user_id, token_hash, created, expires FROM sessions WHERE token_hash = ?1;", tokenHash)
+
+	if err := row.Scan(&session.SessionID, &session.UserID, &session.TokenHash, &session.Created, &session.Expires); err == sql.ErrNoRows {
+		return Session{}, errors.New("Cannot find session with the given token.")
+	} else if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+func (d *SQLiteDatabase) DeleteSession(sessionID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	_, err := d.CurrentTransaction.Exec("DELETE FROM sessions WHERE session_id = ?1;", sessionID)
+	return err
+}
+
+func (d *SQLiteDatabase) CreateAlertRule(rule *AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	rule.RuleID, err = d.insert(
+		"INSERT INTO alert_rules (agent_id, variable_id, condition, threshold, hysteresis, min_duration, webhook_url, created, state) "+
+			"VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9);",
+		rule.AgentID,
+		rule.VariableID,
+		rule.Condition,
+		rule.Threshold,
+		rule.Hysteresis,
+		int64(rule.MinDuration),
+		rule.WebhookURL,
+		sqliteTime(rule.Created),
+		rule.State,
+	)
+
+	return err
+}
+
+// GetAlertRulesForAgent returns the agent's alert rules. Concurrent requests evaluate the rules one after the other,
+// as each transaction holds the database's write lock.
+func (d *SQLiteDatabase) GetAlertRulesForAgent(agentID int) ([]AlertRule, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query(
+		"SELECT r.rule_id, r.agent_id, r.variable_id, v.name, r.condition, r.threshold, r.hysteresis, r.min_duration, r.webhook_url, "+
+			"r.created, r.state, r.pending_since, r.last_value, r.last_time "+
+			"FROM alert_rules r INNER JOIN variables v ON r.variable_id = v.variable_id "+
+			"WHERE r.agent_id = ?1 ORDER BY r.rule_id;",
+		agentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	rules := []AlertRule{}
+
+	for rows.Next() {
+		rule := AlertRule{}
+		var minDuration int64
+		var pendingSince, lastTime *time.Time
+
+		if err := rows.Scan(&rule.RuleID, &rule.AgentID, &rule.VariableID, &rule.Variable, &rule.Condition, &rule.Threshold,
+			&rule.Hysteresis, &minDuration, &rule.WebhookURL, &rule.Created, &rule.State, &pendingSince, &rule.LastValue, &lastTime); err != nil {
+			return nil, err
+		}
+
+		rule.MinDuration = time.Duration(minDuration)
+
+		if pendingSince != nil {
+			rule.PendingSince = *pendingSince
+		}
+
+		if lastTime != nil {
+			rule.LastTime = *lastTime
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// UpdateAlertRuleState saves the state of an existing alert rule. The rest of the rule is left unchanged.
+func (d *SQLiteDatabase) UpdateAlertRuleState(rule AlertRule) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("UPDATE alert_rules SET state = ?2, pending_since = ?3, last_value = ?4, last_time = ?5 WHERE rule_id = ?1;",
+		rule.RuleID, rule.State, nullableSQLiteTime(rule.PendingSince), rule.LastValue, nullableSQLiteTime(rule.LastTime))
+
+	return checkAlertRuleRowAffected(result, err, rule.RuleID)
+}
+
+// DeleteAlertRule removes an alert rule and its history.
+func (d *SQLiteDatabase) DeleteAlertRule(ruleID int) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	result, err := d.CurrentTransaction.Exec("DELETE FROM alert_rules WHERE rule_id = ?1;", ruleID)
+
+	return checkAlertRuleRowAffected(result, err, ruleID)
+}
+
+func (d *SQLiteDatabase) AddAlertHistory(entry *AlertHistoryEntry) error {
+	if err := d.ensureTransaction(); err != nil {
+		return err
+	}
+
+	var err error
+	entry.HistoryID, err = d.insert(
+		"INSERT INTO alert_history (rule_id, event, value, time, created) VALUES (?1, ?2, ?3, ?4, ?5);",
+		entry.RuleID,
+		entry.Event,
+		entry.Value,
+		sqliteTime(entry.Time),
+		sqliteTime(entry.Created),
+	)
+
+	return err
+}
+
+// GetAlertHistory returns the history of an alert rule, most recent first.
+func (d *SQLiteDatabase) GetAlertHistory(ruleID int) ([]AlertHistoryEntry, error) {
+	if err := d.ensureTransaction(); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.CurrentTransaction.Query("SELECT history_id, rule_id, event, value, time, created FROM alert_history "+
+		"WHERE rule_id = ?1 ORDER BY time DESC, history_id DESC;", ruleID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	history := []AlertHistoryEntry{}
+
+	for rows.Next() {
+		entry := AlertHistoryEntry{}
+
+		if err := rows.Scan(&entry.HistoryID, &entry.RuleID, &entry.Event, &entry.Value, &entry.Time, &entry.Created); err != nil {
+			return nil, err
+		}
+
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (d *SQLiteDatabase) ensureTransaction() error {
+	if d.CurrentTransaction == nil {
+		return errors.New("An active transaction is required to call this method.")
+	}
+
+	return nil
+}
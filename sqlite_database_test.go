@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQLiteDatabase", func() {
+	var directory string
+	var db Database
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "weather-thingy-data-service-test")
+		Expect(err).To(BeNil())
+
+		db, err = connectToDatabase("sqlite:" + filepath.Join(directory, "test.db"))
+
+		if err != nil {
+			Fail("Cannot open test database: " + err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(directory)
+	})
+
+	Describe("NewSession", func() {
+		It("returns a database that waits for a transaction in progress in another session to finish before beginning its own", func() {
+			Expect(db.BeginTransaction()).To(BeNil())
+
+			began := make(chan error, 1)
+			session := db.NewSession()
+
+			go func() {
+				began <- session.BeginTransaction()
+			}()
+
+			Consistently(began).ShouldNot(Receive())
+			Expect(db.RollbackTransaction()).To(BeNil())
+			Eventually(began).Should(Receive(BeNil()))
+			Expect(session.RollbackTransaction()).To(BeNil())
+		})
+	})
+
+	Describe("RunMigrations", func() {
+		It("applies all of the migrations", func() {
+			migrations, _ := getSQLiteMigrationSource().FindMigrations()
+			expectedMigrationCount := len(migrations)
+
+			_, err := db.RunMigrations()
+			Expect(err).To(BeNil())
+
+			var actualMigrationCount int
+			err = db.DB().QueryRow("SELECT COUNT(*) FROM gorp_migrations;").Scan(&actualMigrationCount)
+			Expect(err).To(BeNil())
+			Expect(actualMigrationCount).To(Equal(expectedMigrationCount))
+		})
+	})
+
+	backend := databaseTestBackend{
+		database:       func() Database { return db },
+		createTestData: func(db Database) { CreateTestData(db, func(t time.Time) interface{} { return sqliteTime(t) }) },
+	}
+
+	describeDatabaseBehaviour(backend)
+	describeSQLDatabaseBehaviour(backend)
+})
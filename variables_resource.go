@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,8 +19,34 @@ type Variable struct {
 	Units                string    `json:"units" binding:"required"`
 	DisplayDecimalPlaces int       `json:"displayDecimalPlaces"`
 	Created              time.Time `json:"created"`
+	VariableLimits
+	VariableRetention
 }
 
+// VariableLimits are the bounds a plausible value for a variable falls within, so that readings from a broken sensor
+// can be caught when they are saved. MaxStep is the largest change allowed from the agent's previous value.
+// Values outside the limits are handled according to OutOfRange.
+type VariableLimits struct {
+	MinValue   *float64 `json:"minValue,omitempty"`
+	MaxValue   *float64 `json:"maxValue,omitempty"`
+	MaxStep    *float64 `json:"maxStep,omitempty"`
+	OutOfRange string   `json:"outOfRange,omitempty"`
+}
+
+// VariableRetention is how long data for a variable is kept at full resolution before it is rolled up into hourly and
+// daily summaries. If RawDataDays is not set, the service's default is used.
+type VariableRetention struct {
+	RawDataDays *int `json:"rawDataDays,omitempty"`
+}
+
+const (
+	outOfRangePolicyReject = "reject"
+	outOfRangePolicyDrop   = "drop"
+	outOfRangePolicyFlag   = "flag"
+)
+
+var outOfRangePolicies = []string{outOfRangePolicyReject, outOfRangePolicyDrop, outOfRangePolicyFlag}
+
 func postVariable(render render.Render, variable Variable, db Database, log *logrus.Entry) {
 	if err := db.BeginTransaction(); err != nil {
 		log.WithError(err).Error("Could not begin database transaction.")
@@ -42,7 +73,80 @@ func postVariable(render render.Render, variable Variable, db Database, log *log
 	render.JSON(http.StatusCreated, map[string]interface{}{"id": variable.VariableID})
 }
 
-func (variable Variable) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+// putVariableLimits replaces all of the limits for a variable, so a limit that is not given is removed.
+func putVariableLimits(render render.Render, limits VariableLimits, params martini.Params, db Database, log *logrus.Entry) {
+	variableID, err := strconv.Atoi(params["variable_id"])
+
+	if err != nil {
+		render.Text(http.StatusNotFound, "Invalid variable ID.")
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	limits.OutOfRange = limits.OutOfRangePolicy()
+
+	if exists, err := db.UpdateVariableLimits(variableID, limits); err != nil {
+		log.WithError(err).Error("Could not update variable limits.")
+		render.Error(http.StatusInternalServerError)
+		return
+	} else if !exists {
+		render.Text(http.StatusNotFound, "Variable does not exist.")
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(http.StatusOK, limits)
+}
+
+// putVariableRetention replaces the retention policy for a variable, so if the number of days is not given the
+// service's default is used.
+func putVariableRetention(render render.Render, retention VariableRetention, params martini.Params, db Database, log *logrus.Entry) {
+	variableID, err := strconv.Atoi(params["variable_id"])
+
+	if err != nil {
+		render.Text(http.StatusNotFound, "Invalid variable ID.")
+		return
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	if exists, err := db.UpdateVariableRetention(variableID, retention); err != nil {
+		log.WithError(err).Error("Could not update variable retention.")
+		render.Error(http.StatusInternalServerError)
+		return
+	} else if !exists {
+		render.Text(http.StatusNotFound, "Variable does not exist.")
+		return
+	}
+
+	if err := db.CommitTransaction(); err != nil {
+		log.WithError(err).Error("Could not commit transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(http.StatusOK, retention)
+}
+
+func (variable Variable) Validate(errors binding.Errors, req *http.Request) binding.Errors {
 	if variable.DisplayDecimalPlaces < 0 {
 		errors = append(errors, binding.Error{
 			FieldNames:     []string{"displayDecimalPlaces"},
@@ -51,5 +155,82 @@ func (variable Variable) Validate(errors binding.Errors, _ *http.Request) bindin
 		})
 	}
 
+	errors = variable.VariableLimits.Validate(errors, req)
+
+	return variable.VariableRetention.Validate(errors, req)
+}
+
+// OutOfRangePolicy returns the policy to use for values outside the limits, which defaults to rejecting the request.
+func (limits VariableLimits) OutOfRangePolicy() string {
+	if limits.OutOfRange == "" {
+		return outOfRangePolicyReject
+	}
+
+	return limits.OutOfRange
+}
+
+// Check returns a description of why value is outside the limits, or an empty string if it is within them. previous
+// is the agent's previous value for the variable, if it has one.
+func (limits VariableLimits) Check(value float64, previous *float64) string {
+	switch {
+	case limits.MinValue != nil && value < *limits.MinValue:
+		return fmt.Sprintf("Value %v is less than the minimum of %v.", value, *limits.MinValue)
+	case limits.MaxValue != nil && value > *limits.MaxValue:
+		return fmt.Sprintf("Value %v is greater than the maximum of %v.", value, *limits.MaxValue)
+	case limits.MaxStep != nil && previous != nil && math.Abs(value-*previous) > *limits.MaxStep:
+		return fmt.Sprintf("Value %v changed by more than %v from the previous value of %v.", value, *limits.MaxStep, *previous)
+	default:
+		return ""
+	}
+}
+
+func (limits VariableLimits) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	if limits.MinValue != nil && limits.MaxValue != nil && *limits.MinValue > *limits.MaxValue {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"minValue", "maxValue"},
+			Classification: "OutOfRangeError",
+			Message:        "minValue must not be greater than maxValue.",
+		})
+	}
+
+	if limits.MaxStep != nil && *limits.MaxStep <= 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"maxStep"},
+			Classification: "OutOfRangeError",
+			Message:        "maxStep must be positive.",
+		})
+	}
+
+	if !containsString(outOfRangePolicies, limits.OutOfRangePolicy()) {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"outOfRange"},
+			Classification: "InvalidValue",
+			Message:        fmt.Sprintf("outOfRange must be one of: %v.", strings.Join(outOfRangePolicies, ", ")),
+		})
+	}
+
+	return errors
+}
+
+// RawDataDaysOrDefault returns the number of days to keep data for the variable at full resolution, where 0 means
+// forever.
+func (retention VariableRetention) RawDataDaysOrDefault(defaultDays int) int {
+	if retention.RawDataDays == nil {
+		return defaultDays
+	}
+
+	return *retention.RawDataDays
+}
+
+func (retention VariableRetention) Validate(errors binding.Errors, _ *http.Request) binding.Errors {
+	// 0 is allowed, and means the variable's data is kept at full resolution forever, whatever the default is.
+	if retention.RawDataDays != nil && *retention.RawDataDays < 0 {
+		errors = append(errors, binding.Error{
+			FieldNames:     []string{"rawDataDays"},
+			Classification: "OutOfRangeError",
+			Message:        "rawDataDays must not be negative.",
+		})
+	}
+
 	return errors
 }
@@ -6,6 +6,7 @@ import (
 
 	"net/http"
 
+	"github.com/go-martini/martini"
 	"github.com/golang/mock/gomock"
 	"github.com/martini-contrib/binding"
 	. "github.com/onsi/ginkgo"
@@ -39,6 +40,21 @@ var _ = Describe("Variables resource", func() {
 			Expect(string(bytes)).To(MatchJSON(`{"id":1039,"name":"Distance to floor","units":"metres (m)","displayDecimalPlaces":1,"created":"2015-03-26T14:35:00Z"}`))
 		})
 
+		It("can be serialised to JSON with limits", func() {
+			minValue, maxValue := 0.0, 3.0
+			variable := Variable{
+				VariableID:     1039,
+				Name:           "Distance to floor",
+				Units:          "metres (m)",
+				Created:        time.Date(2015, 3, 26, 14, 35, 0, 0, time.UTC),
+				VariableLimits: VariableLimits{MinValue: &minValue, MaxValue: &maxValue, OutOfRange: "flag"},
+			}
+
+			bytes, err := json.Marshal(variable)
+			Expect(err).To(BeNil())
+			Expect(string(bytes)).To(MatchJSON(`{"id":1039,"name":"Distance to floor","units":"metres (m)","displayDecimalPlaces":0,"created":"2015-03-26T14:35:00Z","minValue":0,"maxValue":3,"outOfRange":"flag"}`))
+		})
+
 		It("can be deserialised from JSON", func() {
 			jsonString := `{"id":1039,"name":"Distance to floor","units":"metres (m)","created":"2015-03-26T14:35:00Z"}`
 			var variable Variable
@@ -74,7 +90,26 @@ var _ = Describe("Variables resource", func() {
 				Entry("because the displayDecimalPlaces property is a decimal number", `{"name":"Distance", "units":"metres (m)", "displayDecimalPlaces":2.5}`, binding.DeserializationError),
 				Entry("because the displayDecimalPlaces property is not a number", `{"name":"Distance", "units":"metres (m)", "displayDecimalPlaces":"abc"}`, binding.DeserializationError),
 				Entry("because the displayDecimalPlaces property is negative", `{"name":"Distance", "units":"metres (m)", "displayDecimalPlaces":-2}`, "OutOfRangeError", "displayDecimalPlaces"),
+				Entry("because the minValue property is greater than the maxValue property", `{"name":"Distance", "units":"metres (m)", "minValue":10, "maxValue":5}`, "OutOfRangeError", "minValue", "maxValue"),
+				Entry("because the maxStep property is zero", `{"name":"Distance", "units":"metres (m)", "maxStep":0}`, "OutOfRangeError", "maxStep"),
+				Entry("because the outOfRange property is not a valid policy", `{"name":"Distance", "units":"metres (m)", "outOfRange":"ignore"}`, "InvalidValue", "outOfRange"),
+				Entry("because the rawDataDays property is negative", `{"name":"Distance", "units":"metres (m)", "rawDataDays":-1}`, "OutOfRangeError", "rawDataDays"),
 			)
+
+			It("succeeds if the limits are set to valid values", func() {
+				errors := TestValidation(`{"name":"Distance", "units":"metres (m)", "minValue":0, "maxValue":10, "maxStep":2.5, "outOfRange":"drop"}`, Variable{})
+				Expect(errors).To(BeEmpty())
+			})
+
+			It("succeeds if the retention policy is set to a valid value", func() {
+				errors := TestValidation(`{"name":"Distance", "units":"metres (m)", "rawDataDays":30}`, Variable{})
+				Expect(errors).To(BeEmpty())
+			})
+
+			It("succeeds if the retention policy is set to keep data forever", func() {
+				errors := TestValidation(`{"name":"Distance", "units":"metres (m)", "rawDataDays":0}`, Variable{})
+				Expect(errors).To(BeEmpty())
+			})
 		})
 	})
 
@@ -112,4 +147,88 @@ var _ = Describe("Variables resource", func() {
 			postVariable(render, variable, db, nil)
 		})
 	})
+
+	Describe("PUT limits request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+		maxValue := 60.0
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+		})
+
+		It("replaces the limits of the variable and returns them", func() {
+			limits := VariableLimits{MaxValue: &maxValue, OutOfRange: "reject"}
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().UpdateVariableLimits(1019, limits).Return(true, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().JSON(http.StatusOK, limits),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			putVariableLimits(render, VariableLimits{MaxValue: &maxValue}, martini.Params{"variable_id": "1019"}, db, nil)
+		})
+
+		It("returns HTTP 404 response if the variable does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().UpdateVariableLimits(1019, gomock.Any()).Return(false, nil),
+				render.EXPECT().Text(http.StatusNotFound, "Variable does not exist."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			putVariableLimits(render, VariableLimits{MaxValue: &maxValue}, martini.Params{"variable_id": "1019"}, db, nil)
+		})
+
+		It("returns HTTP 404 response if the variable ID is not an integer", func() {
+			render.EXPECT().Text(http.StatusNotFound, "Invalid variable ID.")
+
+			putVariableLimits(render, VariableLimits{}, martini.Params{"variable_id": "abc"}, db, nil)
+		})
+	})
+
+	Describe("PUT retention request handler", func() {
+		var db *MockDatabase
+		var render *MockRender
+		rawDataDays := 30
+
+		BeforeEach(func() {
+			db = NewMockDatabase(mockController)
+			render = NewMockRender(mockController)
+		})
+
+		It("replaces the retention policy of the variable and returns it", func() {
+			retention := VariableRetention{RawDataDays: &rawDataDays}
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().UpdateVariableRetention(1019, retention).Return(true, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().JSON(http.StatusOK, retention),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			putVariableRetention(render, retention, martini.Params{"variable_id": "1019"}, db, nil)
+		})
+
+		It("returns HTTP 404 response if the variable does not exist", func() {
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().UpdateVariableRetention(1019, gomock.Any()).Return(false, nil),
+				render.EXPECT().Text(http.StatusNotFound, "Variable does not exist."),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			putVariableRetention(render, VariableRetention{RawDataDays: &rawDataDays}, martini.Params{"variable_id": "1019"}, db, nil)
+		})
+
+		It("returns HTTP 404 response if the variable ID is not an integer", func() {
+			render.EXPECT().Text(http.StatusNotFound, "Invalid variable ID.")
+
+			putVariableRetention(render, VariableRetention{}, martini.Params{"variable_id": "abc"}, db, nil)
+		})
+	})
 })
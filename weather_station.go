@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/martini-contrib/render"
+)
+
+// weatherStationMissingValue is sent by some stations in place of a reading from a sensor they do not have.
+const weatherStationMissingValue = -9999
+
+const weatherStationTimeFormat = "2006-01-02 15:04:05"
+
+// weatherStationField describes how to convert a field uploaded by a consumer weather station to a value for one of
+// our variables. Stations upload in imperial units, so convert is used to change the value to metric units.
+type weatherStationField struct {
+	name     string
+	variable string
+	convert  func(float64) float64
+}
+
+// weatherStationFields covers both the Weather Underground and Ecowitt protocols, which use the same names for most
+// fields.
+var weatherStationFields = []weatherStationField{
+	{"tempf", "temperature", fahrenheitToCelsius},
+	{"tempinf", "indoorTemperature", fahrenheitToCelsius},
+	{"dewptf", "dewPoint", fahrenheitToCelsius},
+	{"windchillf", "windChill", fahrenheitToCelsius},
+	{"humidity", "humidity", nil},
+	{"humidityin", "indoorHumidity", nil},
+	// Weather Underground's pressure is relative to sea level, as is Ecowitt's relative pressure.
+	{"baromin", "pressure", inchesOfMercuryToHectopascals},
+	{"baromrelin", "pressure", inchesOfMercuryToHectopascals},
+	{"baromabsin", "absolutePressure", inchesOfMercuryToHectopascals},
+	{"windspeedmph", "windSpeed", milesPerHourToKilometresPerHour},
+	{"windgustmph", "windGust", milesPerHourToKilometresPerHour},
+	{"winddir", "windDirection", nil},
+	{"rainin", "hourlyRain", inchesToMillimetres},
+	{"rainratein", "rainRate", inchesToMillimetres},
+	{"dailyrainin", "dailyRain", inchesToMillimetres},
+	{"solarradiation", "solarRadiation", nil},
+	{"UV", "uvIndex", nil},
+	{"uv", "uvIndex", nil},
+}
+
+func fahrenheitToCelsius(value float64) float64 {
+	return (value - 32) * 5 / 9
+}
+
+func inchesOfMercuryToHectopascals(value float64) float64 {
+	return value * 33.8639
+}
+
+func milesPerHourToKilometresPerHour(value float64) float64 {
+	return value * 1.609344
+}
+
+func inchesToMillimetres(value float64) float64 {
+	return value * 25.4
+}
+
+// uploadWeatherStationData accepts data from consumer weather stations that can only upload using the Weather
+// Underground (GET /weatherstation/updateweatherstation.php) or Ecowitt (POST /data/report) protocols. Stations send
+// every reading they have, so readings for variables that do not exist are skipped rather than rejecting the upload.
+//
+// Ecowitt stations don't send an agent ID or token of their own, so they must be set up to upload to a customised
+// server in the Ecowitt protocol, with a path that includes them in the query string, eg.
+// /data/report?ID=1004&PASSWORD=<token>.
+func uploadWeatherStationData(render render.Render, req *http.Request, agent Agent, db Database, hub *DataHub, notifier *AlertNotifier, log *logrus.Entry) {
+	dataTime, err := parseWeatherStationTime(req.Form.Get("dateutc"))
+
+	if err != nil {
+		render.Text(http.StatusBadRequest, "Cannot parse dateutc value.")
+		return
+	}
+
+	points := []PostDataPoint{}
+	readVariables := map[string]bool{}
+
+	for _, field := range weatherStationFields {
+		raw := req.Form.Get(field.name)
+
+		// Some fields are for the same variable, and only the first one sent is kept.
+		if raw == "" || readVariables[field.variable] {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(raw, 64)
+
+		if err != nil {
+			render.Text(http.StatusBadRequest, fmt.Sprintf("Cannot parse value '%v' of field '%v'.", raw, field.name))
+			return
+		}
+
+		if value == weatherStationMissingValue {
+			continue
+		}
+
+		if field.convert != nil {
+			value = field.convert(value)
+		}
+
+		points = append(points, PostDataPoint{Variable: field.variable, Value: value})
+		readVariables[field.variable] = true
+	}
+
+	if err := db.BeginTransaction(); err != nil {
+		log.WithError(err).Error("Could not begin database transaction.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	defer db.RollbackUncommittedTransaction()
+
+	variables, err := db.GetVariablesByName(PostDataPoints{Data: points}.VariableNames())
+
+	if err != nil {
+		log.WithError(err).Error("Could not get variables.")
+		render.Error(http.StatusInternalServerError)
+		return
+	}
+
+	data := PostDataPoints{Time: dataTime, OnConflict: conflictPolicyOverwrite, Data: []PostDataPoint{}}
+
+	for _, point := range points {
+		if _, found := variables[point.Variable]; found {
+			data.Data = append(data.Data, point)
+		}
+	}
+
+	if len(data.Data) == 0 {
+		render.Text(http.StatusBadRequest, "Must include at least one reading for a variable that exists.")
+		return
+	}
+
+	status, result := saveDataPoints(data, agent, db, hub, notifier, log)
+
+	switch status {
+	case http.StatusCreated:
+		// Weather Underground stations expect this exact response.
+		render.Text(http.StatusOK, "success")
+	case http.StatusInternalServerError:
+		render.Error(status)
+	default:
+		render.Text(status, firstDataPointMessage(result))
+	}
+}
+
+// parseWeatherStationTime parses a time in the format '2015-05-06 10:15:30' in UTC. Weather Underground stations
+// without a clock send 'now' instead, in which case the data is recorded at the time it is received.
+func parseWeatherStationTime(raw string) (time.Time, error) {
+	if raw == "" || raw == "now" {
+		return time.Now().UTC(), nil
+	}
+
+	return time.Parse(weatherStationTimeFormat, raw)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Weather station upload", func() {
+	var mockController *gomock.Controller
+	var db *MockDatabase
+	var render *MockRender
+	var hub *DataHub
+	var notifier *AlertNotifier
+
+	agent := Agent{AgentID: 10}
+	dataTime := time.Date(2015, 5, 6, 10, 15, 30, 0, time.UTC)
+
+	BeforeEach(func() {
+		mockController = gomock.NewController(GinkgoT())
+		db = NewMockDatabase(mockController)
+		render = NewMockRender(mockController)
+		hub = NewDataHub()
+		notifier = NewAlertNotifier(nil)
+	})
+
+	AfterEach(func() {
+		notifier.Close()
+		mockController.Finish()
+	})
+
+	var makeRequest = func(req *http.Request) {
+		Expect(req.ParseForm()).To(Succeed())
+
+		uploadWeatherStationData(render, req, agent, db, hub, notifier, logrus.NewEntry(logrus.StandardLogger()))
+	}
+
+	var weatherUndergroundRequest = func(query string) *http.Request {
+		req, err := http.NewRequest("GET", "/weatherstation/updateweatherstation.php?ID=10&PASSWORD=thetoken&action=updateraw&"+query, nil)
+		Expect(err).To(BeNil())
+
+		return req
+	}
+
+	DescribeTable("converts each field to the matching variable in metric units",
+		func(field string, value string, expectedVariable string, expectedValue float64) {
+			var saved DataPoint
+
+			gomock.InOrder(
+				db.EXPECT().BeginTransaction(),
+				db.EXPECT().GetVariablesByName([]string{expectedVariable}).Return(map[string]Variable{expectedVariable: {VariableID: 12}}, nil).Times(2),
+				db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Do(func(points []DataPoint, _ string) {
+					saved = points[0]
+				}).Return([]bool{false}, nil),
+				db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+				db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+				db.EXPECT().CommitTransaction(),
+				render.EXPECT().Text(http.StatusOK, "success"),
+				db.EXPECT().RollbackUncommittedTransaction(),
+			)
+
+			makeRequest(weatherUndergroundRequest("dateutc=2015-05-06+10%3A15%3A30&" + field + "=" + value))
+
+			Expect(saved.AgentID).To(Equal(agent.AgentID))
+			Expect(saved.VariableID).To(Equal(12))
+			Expect(saved.Time).To(Equal(dataTime))
+			Expect(saved.Value).To(BeNumerically("~", expectedValue, 0.0001))
+		},
+		Entry("outdoor temperature", "tempf", "71.6", "temperature", 22.0),
+		Entry("indoor temperature", "tempinf", "32", "indoorTemperature", 0.0),
+		Entry("dew point", "dewptf", "50", "dewPoint", 10.0),
+		Entry("wind chill", "windchillf", "-4", "windChill", -20.0),
+		Entry("outdoor humidity", "humidity", "65", "humidity", 65.0),
+		Entry("indoor humidity", "humidityin", "40", "indoorHumidity", 40.0),
+		Entry("Weather Underground pressure", "baromin", "29.92", "pressure", 1013.2079),
+		Entry("Ecowitt relative pressure", "baromrelin", "29.92", "pressure", 1013.2079),
+		Entry("Ecowitt absolute pressure", "baromabsin", "29.5", "absolutePressure", 998.9851),
+		Entry("wind speed", "windspeedmph", "10", "windSpeed", 16.09344),
+		Entry("wind gust", "windgustmph", "25", "windGust", 40.2336),
+		Entry("wind direction", "winddir", "270", "windDirection", 270.0),
+		Entry("rain in the last hour", "rainin", "0.1", "hourlyRain", 2.54),
+		Entry("rain rate", "rainratein", "0.5", "rainRate", 12.7),
+		Entry("rain today", "dailyrainin", "1.25", "dailyRain", 31.75),
+		Entry("solar radiation", "solarradiation", "523.4", "solarRadiation", 523.4),
+		Entry("Weather Underground UV index", "UV", "6", "uvIndex", 6.0),
+		Entry("Ecowitt UV index", "uv", "7", "uvIndex", 7.0),
+	)
+
+	It("only saves the first of the fields for the same variable", func() {
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"pressure"}).Return(map[string]Variable{"pressure": {VariableID: 12}}, nil).Times(2),
+			db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Do(func(points []DataPoint, _ string) {
+				Expect(points).To(HaveLen(1))
+				Expect(points[0].Value).To(BeNumerically("~", 1013.2079, 0.0001))
+			}).Return([]bool{false}, nil),
+			db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+			db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+			db.EXPECT().CommitTransaction(),
+			render.EXPECT().Text(http.StatusOK, "success"),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(weatherUndergroundRequest("dateutc=2015-05-06+10%3A15%3A30&baromin=29.92&baromrelin=29.5"))
+	})
+
+	It("saves data uploaded as a form by Ecowitt stations", func() {
+		form := url.Values{
+			"PASSKEY":     {"ABCDEF0123456789"},
+			"stationtype": {"GW1000_V1.6.1"},
+			"dateutc":     {"2015-05-06 10:15:30"},
+			"tempf":       {"50"},
+			"humidity":    {"65"},
+		}
+
+		req, err := http.NewRequest("POST", "/data/report?ID=10&PASSWORD=thetoken", strings.NewReader(form.Encode()))
+		Expect(err).To(BeNil())
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"temperature", "humidity"}).Return(map[string]Variable{"temperature": {VariableID: 12}, "humidity": {VariableID: 13}}, nil).Times(2),
+			db.EXPECT().AddDataPoints([]DataPoint{
+				{AgentID: agent.AgentID, VariableID: 12, Value: 10, Time: dataTime},
+				{AgentID: agent.AgentID, VariableID: 13, Value: 65, Time: dataTime},
+			}, "overwrite").Return([]bool{false, false}, nil),
+			db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+			db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+			db.EXPECT().CommitTransaction(),
+			render.EXPECT().Text(http.StatusOK, "success"),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(req)
+	})
+
+	It("saves data at the time it is received if the time is given as 'now'", func() {
+		before := time.Now()
+
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"humidity"}).Return(map[string]Variable{"humidity": {VariableID: 13}}, nil).Times(2),
+			db.EXPECT().AddDataPoints(gomock.Any(), "overwrite").Do(func(points []DataPoint, _ string) {
+				Expect(points[0].Time).To(BeTemporally(">=", before))
+				Expect(points[0].Time).To(BeTemporally("<=", time.Now()))
+			}).Return([]bool{false}, nil),
+			db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+			db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+			db.EXPECT().CommitTransaction(),
+			render.EXPECT().Text(http.StatusOK, "success"),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(weatherUndergroundRequest("dateutc=now&humidity=65"))
+	})
+
+	It("skips readings for variables that do not exist and readings from sensors the station does not have", func() {
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"temperature", "humidity"}).Return(map[string]Variable{"humidity": {VariableID: 13}}, nil),
+			db.EXPECT().GetVariablesByName([]string{"humidity"}).Return(map[string]Variable{"humidity": {VariableID: 13}}, nil),
+			db.EXPECT().AddDataPoints([]DataPoint{{AgentID: agent.AgentID, VariableID: 13, Value: 65, Time: dataTime}}, "overwrite").Return([]bool{false}, nil),
+			db.EXPECT().GetAlertRulesForAgent(agent.AgentID).Return([]AlertRule{}, nil),
+			db.EXPECT().GetLastDataSequenceNumber(agent.AgentID).Return(5, nil),
+			db.EXPECT().CommitTransaction(),
+			render.EXPECT().Text(http.StatusOK, "success"),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(weatherUndergroundRequest("dateutc=2015-05-06+10%3A15%3A30&tempf=71.6&humidity=65&windspeedmph=-9999"))
+	})
+
+	It("returns HTTP 400 response if none of the readings are for variables that exist", func() {
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(map[string]Variable{}, nil),
+			render.EXPECT().Text(http.StatusBadRequest, "Must include at least one reading for a variable that exists."),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(weatherUndergroundRequest("dateutc=now&tempf=71.6"))
+	})
+
+	It("returns HTTP 500 response if a variable cannot be looked up", func() {
+		gomock.InOrder(
+			db.EXPECT().BeginTransaction(),
+			db.EXPECT().GetVariablesByName([]string{"temperature"}).Return(nil, errors.New("Something went wrong.")),
+			render.EXPECT().Error(http.StatusInternalServerError),
+			db.EXPECT().RollbackUncommittedTransaction(),
+		)
+
+		makeRequest(weatherUndergroundRequest("dateutc=now&tempf=71.6"))
+	})
+
+	DescribeTable("returns HTTP 400 response without saving anything if the request is invalid",
+		func(query string, expectedMessage string) {
+			render.EXPECT().Text(http.StatusBadRequest, expectedMessage)
+
+			makeRequest(weatherUndergroundRequest(query))
+		},
+		Entry("because the time cannot be parsed", "dateutc=yesterday&tempf=71.6", "Cannot parse dateutc value."),
+		Entry("because a value cannot be parsed", "dateutc=now&tempf=warm", "Cannot parse value 'warm' of field 'tempf'."),
+	)
+})